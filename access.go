@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// AccessPolicy limits which namespaces and objects the tools read. It is set
+// from the environment and enforced by every tool, over MCP and HTTP alike:
+//
+//	ALLOWED_NAMESPACES      comma-separated globs; when set, only matching namespaces are served
+//	DENIED_NAMESPACES       comma-separated globs; denied even if allowed
+//	EXCLUDE_LABEL_SELECTOR  label selector of objects the tools never report on
+type AccessPolicy struct {
+	Allowed []string `json:"allowed_namespaces,omitempty"`
+	Denied  []string `json:"denied_namespaces,omitempty"`
+	Exclude string   `json:"exclude_label_selector,omitempty"`
+
+	exclude labels.Selector
+	// scope narrows the policy to an authenticated HTTP caller's namespaces
+	scope  []string
+	scoped bool
+}
+
+// AccessDeniedError is returned when a request names a namespace or object the access policy blocks
+type AccessDeniedError struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Reason    string
+}
+
+func (e *AccessDeniedError) Error() string {
+	if e.Kind == "Namespace" {
+		return fmt.Sprintf("access policy denies namespace %q: %s", e.Namespace, e.Reason)
+	}
+	return fmt.Sprintf("access policy excludes %s %s/%s: %s", e.Kind, e.Namespace, e.Name, e.Reason)
+}
+
+// systemNamespaces are hidden from cluster-wide results unless asked for
+var systemNamespaces = []string{"kube-*"}
+
+// newAccessPolicyFromEnv builds the access policy from the environment
+func newAccessPolicyFromEnv() (*AccessPolicy, error) {
+	return newAccessPolicy(splitList(os.Getenv("ALLOWED_NAMESPACES")), splitList(os.Getenv("DENIED_NAMESPACES")),
+		os.Getenv("EXCLUDE_LABEL_SELECTOR"))
+}
+
+func newAccessPolicy(allowed, denied []string, exclude string) (*AccessPolicy, error) {
+	for _, glob := range append(append([]string{}, allowed...), denied...) {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid namespace glob %q: %w", glob, err)
+		}
+	}
+	policy := &AccessPolicy{Allowed: allowed, Denied: denied, Exclude: exclude}
+	if exclude != "" {
+		selector, err := labels.Parse(exclude)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EXCLUDE_LABEL_SELECTOR: %w", err)
+		}
+		policy.exclude = selector
+	}
+	return policy, nil
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// matchGlob returns the first glob that matches name
+func matchGlob(globs []string, name string) (string, bool) {
+	for _, glob := range globs {
+		if matched, _ := path.Match(glob, name); matched {
+			return glob, true
+		}
+	}
+	return "", false
+}
+
+func isSystemNamespace(namespace string) bool {
+	_, system := matchGlob(systemNamespaces, namespace)
+	return system
+}
+
+// checkNamespace returns an AccessDeniedError if the policy blocks a namespace.
+// An empty namespace or "all" means cluster-wide and is filtered per object instead.
+func (a *AccessPolicy) checkNamespace(namespace string) error {
+	if a == nil || namespace == "" || namespace == "all" {
+		return nil
+	}
+	if glob, denied := matchGlob(a.Denied, namespace); denied {
+		return &AccessDeniedError{Kind: "Namespace", Namespace: namespace,
+			Reason: fmt.Sprintf("matches DENIED_NAMESPACES %q", glob)}
+	}
+	if len(a.Allowed) > 0 {
+		if _, allowed := matchGlob(a.Allowed, namespace); !allowed {
+			return &AccessDeniedError{Kind: "Namespace", Namespace: namespace,
+				Reason: fmt.Sprintf("not in ALLOWED_NAMESPACES %s", strings.Join(a.Allowed, ","))}
+		}
+	}
+	if a.scoped {
+		if _, inScope := matchGlob(a.scope, namespace); !inScope {
+			return &AccessDeniedError{Kind: "Namespace", Namespace: namespace,
+				Reason: fmt.Sprintf("outside the caller's namespace scope %s", strings.Join(a.scope, ","))}
+		}
+	}
+	return nil
+}
+
+// withScope returns a copy of the policy that also limits namespaces to the
+// scope globs. A nil scope leaves the policy unchanged.
+func (a *AccessPolicy) withScope(scope []string) *AccessPolicy {
+	if scope == nil {
+		return a
+	}
+	scoped := &AccessPolicy{}
+	if a != nil {
+		*scoped = *a
+	}
+	scoped.scope, scoped.scoped = scope, true
+	return scoped
+}
+
+// checkObject returns an AccessDeniedError if the policy blocks an object's namespace or labels
+func (a *AccessPolicy) checkObject(kind, namespace, name string, objectLabels map[string]string) error {
+	if err := a.checkNamespace(namespace); err != nil {
+		return err
+	}
+	if a.excludes(objectLabels) {
+		return &AccessDeniedError{Kind: kind, Namespace: namespace, Name: name,
+			Reason: fmt.Sprintf("matches EXCLUDE_LABEL_SELECTOR %q", a.Exclude)}
+	}
+	return nil
+}
+
+// excludes reports whether labels match the exclusion selector
+func (a *AccessPolicy) excludes(objectLabels map[string]string) bool {
+	return a != nil && a.exclude != nil && a.exclude.Matches(labels.Set(objectLabels))
+}
+
+// allows reports whether an object found by a cluster-wide or namespace listing may be reported
+func (a *AccessPolicy) allows(namespace string, objectLabels map[string]string) bool {
+	return a.checkNamespace(namespace) == nil && !a.excludes(objectLabels)
+}
+
+// listed reports whether a pod belongs in a listing: allowed by the policy,
+// and outside the system namespaces unless includeSystem is set
+func (a *AccessPolicy) listed(pod *corev1.Pod, includeSystem bool) bool {
+	if !includeSystem && isSystemNamespace(pod.Namespace) {
+		return false
+	}
+	return a.allows(pod.Namespace, pod.Labels)
+}
+
+// filterPods drops pods the policy does not allow
+func (a *AccessPolicy) filterPods(pods []corev1.Pod) []corev1.Pod {
+	if a == nil {
+		return pods
+	}
+	allowed := make([]corev1.Pod, 0, len(pods))
+	for _, pod := range pods {
+		if a.allows(pod.Namespace, pod.Labels) {
+			allowed = append(allowed, pod)
+		}
+	}
+	return allowed
+}
+
+// readOnlyTransport refuses every request that could modify the cluster. The
+// only writes the tools make are access reviews, which the API server never persists.
+type readOnlyTransport struct {
+	next http.RoundTripper
+}
+
+func (t *readOnlyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return t.next.RoundTrip(req)
+	case http.MethodPost:
+		if strings.Contains(req.URL.Path, "/apis/authorization.k8s.io/") &&
+			(strings.HasSuffix(req.URL.Path, "/selfsubjectaccessreviews") || strings.HasSuffix(req.URL.Path, "/subjectaccessreviews")) {
+			return t.next.RoundTrip(req)
+		}
+	}
+	return nil, fmt.Errorf("read-only guardrail: refusing %s %s", req.Method, req.URL.Path)
+}
+
+// checkPod enforces the access policy on a pod named in a request. The pod is
+// only fetched when its labels matter, i.e. when an exclusion selector is set.
+func (s *K8sDiagnosticsServer) checkPod(ctx context.Context, namespace, name string) error {
+	if err := s.access.checkNamespace(namespace); err != nil {
+		return err
+	}
+	if s.access == nil || s.access.exclude == nil {
+		return nil
+	}
+	pod, err := s.clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	return s.access.checkObject("Pod", namespace, name, pod.Labels)
+}
+
+// excludedPodNames lists the pods of a namespace matching the exclusion selector,
+// for filtering results that only carry pod names, such as Prometheus series
+func (s *K8sDiagnosticsServer) excludedPodNames(ctx context.Context, namespace string) (map[string]bool, error) {
+	if s.access == nil || s.access.exclude == nil {
+		return nil, nil
+	}
+	pods, err := s.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: s.access.Exclude})
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(pods.Items))
+	for _, pod := range pods.Items {
+		names[pod.Name] = true
+	}
+	return names, nil
+}
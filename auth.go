@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// systemGroupPrefix marks the groups Kubernetes reserves for its own identities,
+// such as system:masters
+const systemGroupPrefix = "system:"
+
+// checkSystemGroups rejects reserved groups asserted by OIDC claims or client
+// certificates. Impersonating system:masters would bypass RBAC entirely, so
+// only the groups listed in AUTH_ALLOWED_SYSTEM_GROUPS are accepted.
+func checkSystemGroups(groups, allowed []string) error {
+	for _, group := range groups {
+		if strings.HasPrefix(group, systemGroupPrefix) && !containsString(allowed, group) {
+			return fmt.Errorf("group %q is reserved for Kubernetes system identities (allow it with AUTH_ALLOWED_SYSTEM_GROUPS)", group)
+		}
+	}
+	return nil
+}
+
+// checkSystemUser rejects reserved usernames such as system:kube-controller-manager
+// or system:serviceaccount:kube-system:<name>, which would be impersonated with
+// that component's RBAC. Only those in AUTH_ALLOWED_SYSTEM_USERS are accepted.
+func checkSystemUser(user string, allowed []string) error {
+	if strings.HasPrefix(user, systemGroupPrefix) && !containsString(allowed, user) {
+		return fmt.Errorf("user %q is reserved for Kubernetes system identities (allow it with AUTH_ALLOWED_SYSTEM_USERS)", user)
+	}
+	return nil
+}
+
+// certAuthenticator maps a verified TLS client certificate to an identity the
+// way kube-apiserver does: the common name is the user, organizations are groups
+type certAuthenticator struct {
+	allowedSystemUsers  []string
+	allowedSystemGroups []string
+}
+
+func (a certAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, errNoCredentials
+	}
+	subject := r.TLS.VerifiedChains[0][0].Subject
+	if subject.CommonName == "" {
+		return nil, fmt.Errorf("client certificate has no common name")
+	}
+	if err := checkSystemUser(subject.CommonName, a.allowedSystemUsers); err != nil {
+		return nil, fmt.Errorf("client certificate: %w", err)
+	}
+	if err := checkSystemGroups(subject.Organization, a.allowedSystemGroups); err != nil {
+		return nil, fmt.Errorf("client certificate: %w", err)
+	}
+	return &Identity{User: subject.CommonName, Groups: subject.Organization}, nil
+}
+
+// Scope grants callers matching any of its users or groups access to tools and
+// namespaces. Tools and namespaces are globs; leaving either empty allows all.
+// A named scope can also be assigned to individual static tokens.
+type Scope struct {
+	Name       string   `json:"name,omitempty"`
+	Users      []string `json:"users,omitempty"`
+	Groups     []string `json:"groups,omitempty"`
+	Tools      []string `json:"tools,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// CallerScope is what one authenticated caller may call. A nil scope, or a nil
+// list, is unrestricted.
+type CallerScope struct {
+	Tools      []string
+	Namespaces []string
+}
+
+// Scopes holds the AUTH_SCOPES_FILE entries
+type Scopes struct {
+	Scopes []Scope `json:"scopes"`
+}
+
+// newScopesFromEnv loads AUTH_SCOPES_FILE, returning nil when it is not set
+func newScopesFromEnv() (*Scopes, error) {
+	file := os.Getenv("AUTH_SCOPES_FILE")
+	if file == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scopes file: %w", err)
+	}
+	var scopes Scopes
+	if err := yaml.UnmarshalStrict(data, &scopes); err != nil {
+		return nil, fmt.Errorf("failed to parse scopes file %s: %w", file, err)
+	}
+	names := map[string]bool{}
+	for i, scope := range scopes.Scopes {
+		if scope.Name == "" && len(scope.Users) == 0 && len(scope.Groups) == 0 {
+			return nil, fmt.Errorf("scopes file %s: entry %d has no name, users or groups", file, i+1)
+		}
+		if scope.Name != "" {
+			if names[scope.Name] {
+				return nil, fmt.Errorf("scopes file %s: entry %d: duplicate name %q", file, i+1, scope.Name)
+			}
+			names[scope.Name] = true
+		}
+		for _, glob := range append(append([]string{}, scope.Tools...), scope.Namespaces...) {
+			if _, err := path.Match(glob, ""); err != nil {
+				return nil, fmt.Errorf("scopes file %s: entry %d: invalid glob %q", file, i+1, glob)
+			}
+		}
+	}
+	return &scopes, nil
+}
+
+// resolve merges the entries matching an identity. Without a scopes file every
+// caller is unrestricted; with one, a caller no entry matches may call nothing.
+// A token pinned to a named scope gets exactly that entry.
+func (s *Scopes) resolve(identity Identity) *CallerScope {
+	if s == nil {
+		return nil
+	}
+	resolved := &CallerScope{Tools: []string{}, Namespaces: []string{}}
+	allTools, allNamespaces := false, false
+	for _, scope := range s.Scopes {
+		if !scope.selects(identity) {
+			continue
+		}
+		if len(scope.Tools) == 0 {
+			allTools = true
+		}
+		if len(scope.Namespaces) == 0 {
+			allNamespaces = true
+		}
+		resolved.Tools = append(resolved.Tools, scope.Tools...)
+		resolved.Namespaces = append(resolved.Namespaces, scope.Namespaces...)
+	}
+	if allTools {
+		resolved.Tools = nil
+	}
+	if allNamespaces {
+		resolved.Namespaces = nil
+	}
+	return resolved
+}
+
+// selects reports whether the entry applies to an identity: by name for a
+// token pinned to a scope, by user or group otherwise
+func (s Scope) selects(identity Identity) bool {
+	if identity.Scope != "" {
+		return s.Name == identity.Scope
+	}
+	return s.matches(identity)
+}
+
+func (s Scope) matches(identity Identity) bool {
+	for _, user := range s.Users {
+		if user == identity.User {
+			return true
+		}
+	}
+	for _, group := range s.Groups {
+		for _, member := range identity.Groups {
+			if group == member {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkTokenScopes verifies that every scope named in the token file exists
+func (s *Scopes) checkTokenScopes(authenticators []Authenticator) error {
+	for _, auth := range authenticators {
+		tokens, ok := auth.(*tokenFileAuthenticator)
+		if !ok {
+			continue
+		}
+		for _, identity := range tokens.tokens {
+			if identity.Scope == "" {
+				continue
+			}
+			if s == nil {
+				return fmt.Errorf("token file pins user %s to scope %q, but AUTH_SCOPES_FILE is not set", identity.User, identity.Scope)
+			}
+			if !s.hasScope(identity.Scope) {
+				return fmt.Errorf("token file pins user %s to scope %q, which AUTH_SCOPES_FILE does not define", identity.User, identity.Scope)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Scopes) hasScope(name string) bool {
+	for _, scope := range s.Scopes {
+		if scope.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsTool reports whether the caller may call a tool
+func (c *CallerScope) allowsTool(tool string) bool {
+	if c == nil || c.Tools == nil {
+		return true
+	}
+	_, allowed := matchGlob(c.Tools, tool)
+	return allowed
+}
+
+// namespaces returns the caller's namespace globs, nil when unrestricted
+func (c *CallerScope) namespaces() []string {
+	if c == nil {
+		return nil
+	}
+	return c.Namespaces
+}
+
+// describe summarizes a scope for logs and error messages
+func (c *CallerScope) describe() string {
+	if c == nil {
+		return "unrestricted"
+	}
+	list := func(globs []string) string {
+		if globs == nil {
+			return "all"
+		}
+		if len(globs) == 0 {
+			return "none"
+		}
+		return strings.Join(globs, ",")
+	}
+	return fmt.Sprintf("tools: %s; namespaces: %s", list(c.Tools), list(c.Namespaces))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTokenScopes(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tokens.csv")
+	tokens := "broad,ci-bot,1,ci\n" + `narrow,ci-bot,1,ci,ci-readonly` + "\n"
+	if err := os.WriteFile(file, []byte(tokens), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	auth, err := newTokenFileAuthenticator(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scopes := &Scopes{Scopes: []Scope{
+		{Groups: []string{"ci"}, Tools: []string{"*"}, Namespaces: []string{"staging", "production"}},
+		{Name: "ci-readonly", Tools: []string{"list_pods"}, Namespaces: []string{"staging"}},
+	}}
+	if err := scopes.checkTokenScopes([]Authenticator{auth}); err != nil {
+		t.Fatalf("checkTokenScopes: %v", err)
+	}
+
+	identityFor := func(token string) Identity {
+		r := httptest.NewRequest("POST", "/list_pods", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		identity, err := auth.Authenticate(r)
+		if err != nil {
+			t.Fatalf("authenticating %s: %v", token, err)
+		}
+		return *identity
+	}
+	broad, narrow := identityFor("broad"), identityFor("narrow")
+	if narrow.Scope != "ci-readonly" || broad.Scope != "" {
+		t.Fatalf("expected only the narrow token to be pinned, got %+v and %+v", broad, narrow)
+	}
+
+	broadScope, narrowScope := scopes.resolve(broad), scopes.resolve(narrow)
+	if !broadScope.allowsTool("audit_security") {
+		t.Errorf("expected the group scope for the broad token, got %s", broadScope.describe())
+	}
+	if narrowScope.allowsTool("audit_security") || !narrowScope.allowsTool("list_pods") {
+		t.Errorf("expected only the pinned scope for the narrow token, got %s", narrowScope.describe())
+	}
+
+	// Same user and groups, different scopes: the cached servers must differ
+	s := &K8sDiagnosticsServer{callers: &callerServers{}}
+	broadServer, err := s.forCaller(broad, broadScope, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	narrowServer, err := s.forCaller(narrow, narrowScope, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if broadServer == narrowServer {
+		t.Fatal("tokens with different scopes share a cached server")
+	}
+	if err := narrowServer.access.checkNamespace("production"); err == nil {
+		t.Error("the pinned token's server allows namespace production")
+	}
+
+	if err := (&Scopes{}).checkTokenScopes([]Authenticator{auth}); err == nil {
+		t.Error("expected an error for a token pinned to an undefined scope")
+	}
+	if err := (*Scopes)(nil).checkTokenScopes([]Authenticator{auth}); err == nil {
+		t.Error("expected an error for a pinned token without AUTH_SCOPES_FILE")
+	}
+}
+
+func TestCertAuthenticatorRejectsSystemGroups(t *testing.T) {
+	request := func(organizations ...string) *http.Request {
+		r := httptest.NewRequest("POST", "/list_pods", nil)
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: "alice", Organization: organizations}}
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		return r
+	}
+	auth := certAuthenticator{allowedSystemGroups: []string{"system:monitoring"}}
+
+	if identity, err := auth.Authenticate(request("dev")); err != nil || identity.Groups[0] != "dev" {
+		t.Errorf("expected group dev, got %+v, %v", identity, err)
+	}
+	if _, err := auth.Authenticate(request("dev", "system:masters")); err == nil {
+		t.Error("expected system:masters to be rejected")
+	}
+	if _, err := auth.Authenticate(request("system:monitoring")); err != nil {
+		t.Errorf("expected an allowed system group to pass: %v", err)
+	}
+}
+
+func TestCertAuthenticatorRejectsSystemUsers(t *testing.T) {
+	request := func(commonName string) *http.Request {
+		r := httptest.NewRequest("POST", "/list_pods", nil)
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		return r
+	}
+	auth := certAuthenticator{allowedSystemUsers: []string{"system:monitoring"}}
+
+	if identity, err := auth.Authenticate(request("alice")); err != nil || identity.User != "alice" {
+		t.Errorf("expected user alice, got %+v, %v", identity, err)
+	}
+	for _, user := range []string{"system:kube-controller-manager", "system:serviceaccount:kube-system:admin"} {
+		if _, err := auth.Authenticate(request(user)); err == nil {
+			t.Errorf("expected %s to be rejected", user)
+		}
+	}
+	if _, err := auth.Authenticate(request("system:monitoring")); err != nil {
+		t.Errorf("expected an allowed system user to pass: %v", err)
+	}
+}
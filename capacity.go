@@ -0,0 +1,525 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// capacityResources are the node resources the capacity analysis tracks
+var capacityResources = []corev1.ResourceName{
+	corev1.ResourceCPU,
+	corev1.ResourceMemory,
+	corev1.ResourcePods,
+	corev1.ResourceEphemeralStorage,
+}
+
+// maxSimulatedReplicas bounds the what-if, which places replicas one at a time
+const maxSimulatedReplicas = 1000
+
+// ResourceCapacity is allocatable vs. requested vs. limited for one resource
+type ResourceCapacity struct {
+	Allocatable      string   `json:"allocatable"`
+	Requested        string   `json:"requested"`
+	Limited          string   `json:"limited"`
+	Free             string   `json:"free"`
+	RequestedPercent *float64 `json:"requested_percent,omitempty"`
+	LimitedPercent   *float64 `json:"limited_percent,omitempty"`
+}
+
+// NodeCapacity is the capacity of one node
+type NodeCapacity struct {
+	Name        string                      `json:"name"`
+	Ready       bool                        `json:"ready"`
+	Schedulable bool                        `json:"schedulable"`
+	Taints      []string                    `json:"taints,omitempty"`
+	PodCount    int                         `json:"pod_count"`
+	Resources   map[string]ResourceCapacity `json:"resources"`
+}
+
+// Fragmentation shows how free capacity is spread across schedulable nodes.
+// LargestFree is the biggest request of this resource a single new pod can get.
+type Fragmentation struct {
+	TotalFree       string  `json:"total_free"`
+	LargestFree     string  `json:"largest_free"`
+	LargestFreeNode string  `json:"largest_free_node,omitempty"`
+	Percent         float64 `json:"fragmentation_percent"`
+}
+
+// CapacityTotals is the cluster-wide part of a capacity analysis
+type CapacityTotals struct {
+	Totals          map[string]ResourceCapacity `json:"totals"`
+	OvercommitRatio map[string]float64          `json:"overcommit_ratio"`
+	Fragmentation   map[string]Fragmentation    `json:"fragmentation"`
+}
+
+// ClusterCapacity is the cluster_capacity result
+type ClusterCapacity struct {
+	NodeCount int `json:"node_count"`
+	CapacityTotals
+	Nodes    []NodeCapacity    `json:"nodes"`
+	WhatIf   *SchedulingWhatIf `json:"what_if,omitempty"`
+	Findings []Finding         `json:"findings"`
+	Summary  *FindingSummary   `json:"summary,omitempty"`
+}
+
+// SchedulingWhatIf answers "can N more replicas of this deployment be scheduled"
+type SchedulingWhatIf struct {
+	Deployment     string            `json:"deployment"`
+	Namespace      string            `json:"namespace"`
+	Replicas       int               `json:"replicas"`
+	PerPod         map[string]string `json:"per_pod_requests"`
+	Schedulable    int               `json:"schedulable_replicas"`
+	Fits           bool              `json:"fits"`
+	Placements     map[string]int    `json:"placements"`
+	ExcludedNodes  map[string]string `json:"excluded_nodes,omitempty"`
+	QuotaBlockedBy []string          `json:"quota_blocked_by,omitempty"`
+	Explanation    string            `json:"explanation"`
+	NotSimulated   []string          `json:"not_simulated,omitempty"`
+}
+
+// nodeAmounts tracks milli-values per resource for one node
+type nodeAmounts struct {
+	allocatable, requested, limited map[corev1.ResourceName]int64
+	pods                            int
+}
+
+func newNodeAmounts(node corev1.Node) *nodeAmounts {
+	a := &nodeAmounts{
+		allocatable: map[corev1.ResourceName]int64{},
+		requested:   map[corev1.ResourceName]int64{},
+		limited:     map[corev1.ResourceName]int64{},
+	}
+	for _, name := range capacityResources {
+		a.allocatable[name] = quantityMillis(node.Status.Allocatable, name)
+	}
+	return a
+}
+
+func (a *nodeAmounts) addPod(pod corev1.Pod) {
+	requests, limits := podResources(pod.Spec, nil)
+	addResources(requests, pod.Spec.Overhead)
+	addResources(limits, pod.Spec.Overhead)
+	for _, name := range capacityResources {
+		a.requested[name] += quantityMillis(requests, name)
+		a.limited[name] += quantityMillis(limits, name)
+	}
+	a.requested[corev1.ResourcePods] += 1000
+	a.limited[corev1.ResourcePods] += 1000
+	a.pods++
+}
+
+func (a *nodeAmounts) free(name corev1.ResourceName) int64 {
+	if free := a.allocatable[name] - a.requested[name]; free > 0 {
+		return free
+	}
+	return 0
+}
+
+// formatAmount renders a milli-value of a resource in kubectl notation
+func formatAmount(name corev1.ResourceName, millis int64) string {
+	switch name {
+	case corev1.ResourceCPU:
+		return formatMillis(millis)
+	case corev1.ResourcePods:
+		return fmt.Sprint(millis / 1000)
+	default:
+		return formatBytes(millis / 1000)
+	}
+}
+
+func resourceCapacity(name corev1.ResourceName, allocatable, requested, limited int64) ResourceCapacity {
+	free := allocatable - requested
+	if free < 0 {
+		free = 0
+	}
+	return ResourceCapacity{
+		Allocatable:      formatAmount(name, allocatable),
+		Requested:        formatAmount(name, requested),
+		Limited:          formatAmount(name, limited),
+		Free:             formatAmount(name, free),
+		RequestedPercent: percentOf(requested, allocatable),
+		LimitedPercent:   percentOf(limited, allocatable),
+	}
+}
+
+// nodeSchedulable reports whether new pods can land on a node at all
+func nodeSchedulable(node corev1.Node) (bool, bool) {
+	ready := false
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			ready = condition.Status == corev1.ConditionTrue
+		}
+	}
+	return ready, ready && !node.Spec.Unschedulable
+}
+
+// clusterCapacity analyzes allocatable vs. requested resources per node and
+// cluster-wide. With a deployment it also simulates scheduling more replicas.
+func (s *K8sDiagnosticsServer) clusterCapacity(ctx context.Context, namespace, deploymentName string, replicas int) (*ClusterCapacity, error) {
+	if err := s.access.checkNamespace(namespace); err != nil {
+		return nil, err
+	}
+	// Node totals count every pod, including those the access policy hides;
+	// only aggregates are reported for them
+	nodes, err := s.clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pods, err := s.clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	amounts := make(map[string]*nodeAmounts, len(nodes.Items))
+	for _, node := range nodes.Items {
+		amounts[node.Name] = newNodeAmounts(node)
+	}
+	for _, pod := range pods.Items {
+		// Finished pods no longer hold their requests
+		if pod.Spec.NodeName == "" || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if a, ok := amounts[pod.Spec.NodeName]; ok {
+			a.addPod(pod)
+		}
+	}
+
+	result := &ClusterCapacity{
+		NodeCount: len(nodes.Items),
+		CapacityTotals: CapacityTotals{
+			Totals:          map[string]ResourceCapacity{},
+			OvercommitRatio: map[string]float64{},
+			Fragmentation:   map[string]Fragmentation{},
+		},
+		Nodes:    []NodeCapacity{},
+		Findings: []Finding{},
+	}
+
+	totalAllocatable := map[corev1.ResourceName]int64{}
+	totalRequested := map[corev1.ResourceName]int64{}
+	totalLimited := map[corev1.ResourceName]int64{}
+	freeTotal := map[corev1.ResourceName]int64{}
+	largestFree := map[corev1.ResourceName]int64{}
+	largestFreeNode := map[corev1.ResourceName]string{}
+	// Nodes are shared by every namespace, so only the default threshold applies
+	warningPercent := s.policy.Thresholds("").NodeCapacityPercent
+
+	for _, node := range nodes.Items {
+		a := amounts[node.Name]
+		ready, schedulable := nodeSchedulable(node)
+		nc := NodeCapacity{
+			Name:        node.Name,
+			Ready:       ready,
+			Schedulable: schedulable,
+			PodCount:    a.pods,
+			Resources:   map[string]ResourceCapacity{},
+		}
+		for _, taint := range node.Spec.Taints {
+			nc.Taints = append(nc.Taints, fmt.Sprintf("%s=%s:%s", taint.Key, taint.Value, taint.Effect))
+		}
+
+		for _, name := range capacityResources {
+			nc.Resources[string(name)] = resourceCapacity(name, a.allocatable[name], a.requested[name], a.limited[name])
+			totalAllocatable[name] += a.allocatable[name]
+			totalRequested[name] += a.requested[name]
+			totalLimited[name] += a.limited[name]
+
+			if schedulable {
+				free := a.free(name)
+				freeTotal[name] += free
+				if free > largestFree[name] {
+					largestFree[name] = free
+					largestFreeNode[name] = node.Name
+				}
+			}
+
+			if pct := percentOf(a.requested[name], a.allocatable[name]); pct != nil && *pct >= warningPercent && schedulable {
+				result.Findings = append(result.Findings, newFinding(findingNodeCapacityHigh,
+					ObjectRef{Kind: "Node", Name: node.Name},
+					fmt.Sprintf("Node %s has %.1f%% of allocatable %s requested (%s of %s)",
+						node.Name, *pct, name, formatAmount(name, a.requested[name]), formatAmount(name, a.allocatable[name])),
+					map[string]string{"resource": string(name)}))
+			}
+		}
+		result.Nodes = append(result.Nodes, nc)
+	}
+	sort.Slice(result.Nodes, func(i, j int) bool { return result.Nodes[i].Name < result.Nodes[j].Name })
+
+	clusterRef := ObjectRef{Kind: "Cluster", Name: "cluster"}
+	for _, name := range capacityResources {
+		result.Totals[string(name)] = resourceCapacity(name, totalAllocatable[name], totalRequested[name], totalLimited[name])
+
+		frag := Fragmentation{
+			TotalFree:       formatAmount(name, freeTotal[name]),
+			LargestFree:     formatAmount(name, largestFree[name]),
+			LargestFreeNode: largestFreeNode[name],
+		}
+		if freeTotal[name] > 0 {
+			frag.Percent = math.Round((1-float64(largestFree[name])/float64(freeTotal[name]))*1000) / 10
+		}
+		result.Fragmentation[string(name)] = frag
+
+		if name == corev1.ResourceCPU || name == corev1.ResourceMemory {
+			// Containers without limits can't be counted, so there is no ratio when none are set
+			if totalAllocatable[name] > 0 && totalLimited[name] > 0 {
+				ratio := math.Round(float64(totalLimited[name])/float64(totalAllocatable[name])*100) / 100
+				result.OvercommitRatio[string(name)] = ratio
+				if name == corev1.ResourceMemory && ratio > 1 {
+					result.Findings = append(result.Findings, newFinding(findingMemoryOvercommitted, clusterRef,
+						fmt.Sprintf("Memory limits are %.2fx cluster allocatable memory: pods can be OOM killed or evicted when they use their limits at the same time", ratio),
+						map[string]string{"limited": formatAmount(name, totalLimited[name]), "allocatable": formatAmount(name, totalAllocatable[name])}))
+				}
+			}
+		}
+	}
+
+	if deploymentName != "" {
+		whatIf, err := s.simulateReplicas(ctx, namespace, deploymentName, replicas, nodes.Items, amounts)
+		if err != nil {
+			return nil, err
+		}
+		result.WhatIf = whatIf
+		if !whatIf.Fits {
+			result.Findings = append(result.Findings, newFinding(findingReplicasUnschedulable,
+				ObjectRef{Kind: "Deployment", Namespace: namespace, Name: deploymentName},
+				fmt.Sprintf("Deployment %s/%s: %s", namespace, deploymentName, whatIf.Explanation), nil))
+		}
+	}
+
+	sortFindings(result.Findings)
+	result.Summary = summarizeFindings(result.Findings)
+
+	return result, nil
+}
+
+// simulateReplicas places replicas one at a time on the feasible node with
+// the most free CPU, like the default scheduler's spreading behavior
+func (s *K8sDiagnosticsServer) simulateReplicas(ctx context.Context, namespace, name string, replicas int, nodes []corev1.Node, amounts map[string]*nodeAmounts) (*SchedulingWhatIf, error) {
+	deployment, err := s.clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.access.checkObject("Deployment", namespace, name, deployment.Labels); err != nil {
+		return nil, err
+	}
+	limitRanges, err := s.clientset.CoreV1().LimitRanges(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	spec := deployment.Spec.Template.Spec
+	requests, limits := podResources(spec, limitRanges.Items)
+	addResources(requests, spec.Overhead)
+
+	whatIf := &SchedulingWhatIf{
+		Deployment:    name,
+		Namespace:     namespace,
+		Replicas:      replicas,
+		PerPod:        map[string]string{},
+		Placements:    map[string]int{},
+		ExcludedNodes: map[string]string{},
+	}
+	perPod := map[corev1.ResourceName]int64{corev1.ResourcePods: 1000}
+	for _, res := range capacityResources {
+		if res != corev1.ResourcePods {
+			perPod[res] = quantityMillis(requests, res)
+		}
+		whatIf.PerPod[string(res)] = formatAmount(res, perPod[res])
+	}
+
+	// Free capacity of every node the pod may run on
+	free := map[string]map[corev1.ResourceName]int64{}
+	for _, node := range nodes {
+		if _, schedulable := nodeSchedulable(node); !schedulable {
+			whatIf.ExcludedNodes[node.Name] = "not ready or cordoned"
+			continue
+		}
+		if reason := nodeExcludes(node, spec); reason != "" {
+			whatIf.ExcludedNodes[node.Name] = reason
+			continue
+		}
+		free[node.Name] = map[corev1.ResourceName]int64{}
+		for _, res := range capacityResources {
+			free[node.Name][res] = amounts[node.Name].free(res)
+		}
+	}
+
+	for i := 0; i < replicas; i++ {
+		best := ""
+		for node, f := range free {
+			fits := true
+			for res, need := range perPod {
+				if need > f[res] {
+					fits = false
+					break
+				}
+			}
+			if fits && (best == "" || f[corev1.ResourceCPU] > free[best][corev1.ResourceCPU] ||
+				(f[corev1.ResourceCPU] == free[best][corev1.ResourceCPU] && node < best)) {
+				best = node
+			}
+		}
+		if best == "" {
+			break
+		}
+		for res, need := range perPod {
+			free[best][res] -= need
+		}
+		whatIf.Placements[best]++
+		whatIf.Schedulable++
+	}
+
+	_, whatIf.QuotaBlockedBy = quotaShortfall(requests, limits, replicas, s.namespaceQuotas(ctx, namespace))
+	whatIf.Fits = whatIf.Schedulable == replicas && len(whatIf.QuotaBlockedBy) == 0
+
+	if spec.Affinity != nil && (spec.Affinity.PodAffinity != nil || spec.Affinity.PodAntiAffinity != nil) {
+		whatIf.NotSimulated = append(whatIf.NotSimulated, "pod affinity/anti-affinity")
+	}
+	if len(spec.TopologySpreadConstraints) > 0 {
+		whatIf.NotSimulated = append(whatIf.NotSimulated, "topology spread constraints")
+	}
+
+	switch {
+	case whatIf.Fits:
+		whatIf.Explanation = fmt.Sprintf("%d more replica(s) fit on %d node(s)", replicas, len(whatIf.Placements))
+	case whatIf.Schedulable < replicas:
+		whatIf.Explanation = fmt.Sprintf("only %d of %d more replica(s) fit: %s", whatIf.Schedulable, replicas,
+			insufficientResources(perPod, free))
+		if len(whatIf.QuotaBlockedBy) > 0 {
+			whatIf.Explanation += fmt.Sprintf("; the namespace quota is also exceeded (%s)", strings.Join(whatIf.QuotaBlockedBy, "; "))
+		}
+	default:
+		whatIf.Explanation = fmt.Sprintf("%d more replica(s) fit on nodes but exceed the namespace quota (%s)",
+			replicas, strings.Join(whatIf.QuotaBlockedBy, "; "))
+	}
+
+	return whatIf, nil
+}
+
+// namespaceQuotas lists the quotas of a namespace, or none if they can't be read
+func (s *K8sDiagnosticsServer) namespaceQuotas(ctx context.Context, namespace string) []corev1.ResourceQuota {
+	quotas, err := s.clientset.CoreV1().ResourceQuotas(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil
+	}
+	return quotas.Items
+}
+
+// insufficientResources names the resources that no remaining node has enough of
+func insufficientResources(perPod map[corev1.ResourceName]int64, free map[string]map[corev1.ResourceName]int64) string {
+	if len(free) == 0 {
+		return "no node matches the pod's node selector, affinity and tolerations"
+	}
+	var short []string
+	for _, res := range capacityResources {
+		largest := int64(0)
+		for _, f := range free {
+			if f[res] > largest {
+				largest = f[res]
+			}
+		}
+		if perPod[res] > largest {
+			short = append(short, fmt.Sprintf("insufficient %s (needs %s, largest free %s)",
+				res, formatAmount(res, perPod[res]), formatAmount(res, largest)))
+		}
+	}
+	if len(short) == 0 {
+		return "no single node has enough of every resource at once"
+	}
+	return strings.Join(short, ", ")
+}
+
+// nodeExcludes returns why a pod spec can't run on a node based on its node
+// selector, required node affinity and taints, or "" if it can
+func nodeExcludes(node corev1.Node, spec corev1.PodSpec) string {
+	for key, value := range spec.NodeSelector {
+		if node.Labels[key] != value {
+			return fmt.Sprintf("node selector %s=%s does not match", key, value)
+		}
+	}
+
+	if spec.Affinity != nil && spec.Affinity.NodeAffinity != nil &&
+		spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		matched := false
+		for _, term := range spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+			if nodeSelectorTermMatches(node, term) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return "required node affinity does not match"
+		}
+	}
+
+	for _, taint := range node.Spec.Taints {
+		if taint.Effect == corev1.TaintEffectPreferNoSchedule {
+			continue
+		}
+		tolerated := false
+		for _, toleration := range spec.Tolerations {
+			if toleration.ToleratesTaint(&taint) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return fmt.Sprintf("taint %s=%s:%s not tolerated", taint.Key, taint.Value, taint.Effect)
+		}
+	}
+	return ""
+}
+
+func nodeSelectorTermMatches(node corev1.Node, term corev1.NodeSelectorTerm) bool {
+	for _, expr := range term.MatchExpressions {
+		value, exists := node.Labels[expr.Key]
+		switch expr.Operator {
+		case corev1.NodeSelectorOpIn:
+			if !exists || !containsString(expr.Values, value) {
+				return false
+			}
+		case corev1.NodeSelectorOpNotIn:
+			if exists && containsString(expr.Values, value) {
+				return false
+			}
+		case corev1.NodeSelectorOpExists:
+			if !exists {
+				return false
+			}
+		case corev1.NodeSelectorOpDoesNotExist:
+			if exists {
+				return false
+			}
+		case corev1.NodeSelectorOpGt, corev1.NodeSelectorOpLt:
+			n, err1 := resource.ParseQuantity(value)
+			if !exists || err1 != nil || len(expr.Values) != 1 {
+				return false
+			}
+			bound, err2 := resource.ParseQuantity(expr.Values[0])
+			if err2 != nil {
+				return false
+			}
+			if expr.Operator == corev1.NodeSelectorOpGt && n.Cmp(bound) <= 0 ||
+				expr.Operator == corev1.NodeSelectorOpLt && n.Cmp(bound) >= 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
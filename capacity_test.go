@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestClusterCapacityNodeWarningThreshold(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("4"), corev1.ResourcePods: resource.MustParse("110")},
+			Conditions:  []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	pod := testPod("shop", "web-1", "10.0.0.1", nil)
+	pod.Spec.NodeName = "node-a"
+	pod.Spec.Containers = []corev1.Container{{Name: "app", Resources: testResources("3", "", "", "")}}
+	policy, err := parsePolicy([]byte("defaults:\n  node_capacity_percent: 70\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		policy *PolicyStore
+		want   bool
+	}{
+		{name: "default threshold", want: false},
+		{name: "lowered threshold", policy: &PolicyStore{policy: policy}, want: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &K8sDiagnosticsServer{clientset: fake.NewSimpleClientset(node, pod), policy: tt.policy}
+			result, err := s.clusterCapacity(context.Background(), "", "", 0)
+			if err != nil {
+				t.Fatalf("clusterCapacity: %v", err)
+			}
+			got := false
+			for _, finding := range result.Findings {
+				got = got || finding.ID == findingNodeCapacityHigh.ID
+			}
+			if got != tt.want {
+				t.Errorf("expected node capacity finding %v at 75%% CPU requested, got %+v", tt.want, result.Findings)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// configRef is one reference from a pod spec to a ConfigMap or Secret, or to one of its keys
+type configRef struct {
+	kind      string
+	name      string
+	key       string
+	source    string
+	container string
+	optional  bool
+}
+
+// configObject holds the key names of a ConfigMap or Secret. Values are never read into it.
+type configObject struct {
+	found bool
+	keys  map[string]bool
+}
+
+// checkConfigRefs verifies every ConfigMap and Secret a pod references through env,
+// envFrom, configMap/secret volumes and projected volumes. Only key names are reported.
+func (s *K8sDiagnosticsServer) checkConfigRefs(ctx context.Context, pod *corev1.Pod) []Finding {
+	findings := []Finding{}
+	objects := map[string]*configObject{}
+	reported := map[string]bool{}
+
+	lookup := func(kind, name string) *configObject {
+		cacheKey := kind + "/" + name
+		if object, ok := objects[cacheKey]; ok {
+			return object
+		}
+		var object *configObject
+		var err error
+		switch kind {
+		case "ConfigMap":
+			var cm *corev1.ConfigMap
+			cm, err = s.clientset.CoreV1().ConfigMaps(pod.Namespace).Get(ctx, name, metav1.GetOptions{})
+			if err == nil {
+				object = &configObject{found: true, keys: map[string]bool{}}
+				for key := range cm.Data {
+					object.keys[key] = true
+				}
+				for key := range cm.BinaryData {
+					object.keys[key] = true
+				}
+			}
+		case "Secret":
+			var secret *corev1.Secret
+			secret, err = s.clientset.CoreV1().Secrets(pod.Namespace).Get(ctx, name, metav1.GetOptions{})
+			if err == nil {
+				object = &configObject{found: true, keys: map[string]bool{}}
+				for key := range secret.Data {
+					object.keys[key] = true
+				}
+			}
+		}
+		if apierrors.IsNotFound(err) {
+			object = &configObject{}
+		}
+		// Other errors (e.g. no permission to read secrets) leave the reference unchecked
+		noteOmitted(ctx, kind+" "+pod.Namespace+"/"+name+" reference check", err)
+		objects[cacheKey] = object
+		return object
+	}
+
+	for _, ref := range podConfigRefs(pod) {
+		object := lookup(ref.kind, ref.name)
+		if object == nil {
+			continue
+		}
+		objectRef := ObjectRef{Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name, Container: ref.container}
+		evidence := map[string]string{
+			"kind":     ref.kind,
+			"name":     ref.name,
+			"source":   ref.source,
+			"optional": fmt.Sprint(ref.optional),
+		}
+
+		switch {
+		case !object.found:
+			// Volumes reference the object once and then each key; report the object once
+			missingKey := ref.kind + "/" + ref.name + "/" + ref.source
+			if reported[missingKey] {
+				continue
+			}
+			reported[missingKey] = true
+			def := findingConfigRefMissing
+			message := fmt.Sprintf("%s %s/%s referenced by %s does not exist", ref.kind, pod.Namespace, ref.name, ref.source)
+			if ref.optional {
+				def = findingConfigOptionalRefMissing
+				message += " (optional, so the pod starts without it)"
+			}
+			findings = append(findings, newFinding(def, objectRef, message, evidence))
+		case ref.key != "" && !object.keys[ref.key]:
+			evidence["key"] = ref.key
+			evidence["available_keys"] = strings.Join(sortedBoolKeys(object.keys), ",")
+			def := findingConfigKeyMissing
+			message := fmt.Sprintf("%s %s/%s has no key %q, referenced by %s", ref.kind, pod.Namespace, ref.name, ref.key, ref.source)
+			if ref.optional {
+				def = findingConfigOptionalRefMissing
+				message += " (optional, so the pod starts without it)"
+			}
+			findings = append(findings, newFinding(def, objectRef, message, evidence))
+		}
+	}
+
+	return findings
+}
+
+// podConfigRefs lists the ConfigMap and Secret references in a pod spec
+func podConfigRefs(pod *corev1.Pod) []configRef {
+	var refs []configRef
+
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, container := range containers {
+		for _, env := range container.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			source := fmt.Sprintf("container %s env %s", container.Name, env.Name)
+			if ref := env.ValueFrom.ConfigMapKeyRef; ref != nil {
+				refs = append(refs, configRef{"ConfigMap", ref.Name, ref.Key, source, container.Name, isOptional(ref.Optional)})
+			}
+			if ref := env.ValueFrom.SecretKeyRef; ref != nil {
+				refs = append(refs, configRef{"Secret", ref.Name, ref.Key, source, container.Name, isOptional(ref.Optional)})
+			}
+		}
+		for _, envFrom := range container.EnvFrom {
+			source := fmt.Sprintf("container %s envFrom", container.Name)
+			if ref := envFrom.ConfigMapRef; ref != nil {
+				refs = append(refs, configRef{"ConfigMap", ref.Name, "", source, container.Name, isOptional(ref.Optional)})
+			}
+			if ref := envFrom.SecretRef; ref != nil {
+				refs = append(refs, configRef{"Secret", ref.Name, "", source, container.Name, isOptional(ref.Optional)})
+			}
+		}
+	}
+
+	for _, volume := range pod.Spec.Volumes {
+		source := fmt.Sprintf("volume %s", volume.Name)
+		switch {
+		case volume.ConfigMap != nil:
+			refs = append(refs, keyedRefs("ConfigMap", volume.ConfigMap.Name, volume.ConfigMap.Items, source, isOptional(volume.ConfigMap.Optional))...)
+		case volume.Secret != nil:
+			refs = append(refs, keyedRefs("Secret", volume.Secret.SecretName, volume.Secret.Items, source, isOptional(volume.Secret.Optional))...)
+		case volume.Projected != nil:
+			source += " (projected)"
+			for _, projection := range volume.Projected.Sources {
+				if cm := projection.ConfigMap; cm != nil {
+					refs = append(refs, keyedRefs("ConfigMap", cm.Name, cm.Items, source, isOptional(cm.Optional))...)
+				}
+				if secret := projection.Secret; secret != nil {
+					refs = append(refs, keyedRefs("Secret", secret.Name, secret.Items, source, isOptional(secret.Optional))...)
+				}
+			}
+		}
+	}
+
+	return refs
+}
+
+// keyedRefs references the object itself and each key a volume projects from it
+func keyedRefs(kind, name string, items []corev1.KeyToPath, source string, optional bool) []configRef {
+	refs := []configRef{{kind: kind, name: name, source: source, optional: optional}}
+	for _, item := range items {
+		refs = append(refs, configRef{kind: kind, name: name, key: item.Key, source: source, optional: optional})
+	}
+	return refs
+}
+
+func isOptional(optional *bool) bool {
+	return optional != nil && *optional
+}
+
+func sortedBoolKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testSecretValue = "hunter2-do-not-leak"
+
+func configRefObjects() []runtime.Object {
+	return []runtime.Object{
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "settings"},
+			Data:       map[string]string{"LOG_LEVEL": "debug", "REGION": "eu"},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "db"},
+			Data:       map[string][]byte{"username": []byte("shop"), "password": []byte(testSecretValue)},
+		},
+	}
+}
+
+func configRefPod(container corev1.Container, volumes ...corev1.Volume) *corev1.Pod {
+	container.Name = "app"
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web-0"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{container}, Volumes: volumes},
+	}
+}
+
+func TestCheckConfigRefs(t *testing.T) {
+	optional := true
+	tests := []struct {
+		name       string
+		pod        *corev1.Pod
+		wantID     string
+		wantSource string
+		wantKey    string
+	}{
+		{
+			name: "present refs",
+			pod: configRefPod(corev1.Container{
+				Env: []corev1.EnvVar{{Name: "DB_PASSWORD", ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "db"}, Key: "password"},
+				}}},
+				EnvFrom: []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "settings"}}}},
+			}),
+		},
+		{
+			name: "missing required envFrom ref",
+			pod: configRefPod(corev1.Container{
+				EnvFrom: []corev1.EnvFromSource{{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "feature-flags"}}}},
+			}),
+			wantID:     findingConfigRefMissing.ID,
+			wantSource: "container app envFrom",
+		},
+		{
+			name: "missing optional envFrom ref",
+			pod: configRefPod(corev1.Container{
+				EnvFrom: []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "overrides"}, Optional: &optional}}},
+			}),
+			wantID:     findingConfigOptionalRefMissing.ID,
+			wantSource: "container app envFrom",
+		},
+		{
+			name: "missing key in env",
+			pod: configRefPod(corev1.Container{
+				Env: []corev1.EnvVar{{Name: "DB_TOKEN", ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "db"}, Key: "token"},
+				}}},
+			}),
+			wantID:     findingConfigKeyMissing.ID,
+			wantSource: "container app env DB_TOKEN",
+			wantKey:    "token",
+		},
+		{
+			name: "missing optional key in env",
+			pod: configRefPod(corev1.Container{
+				Env: []corev1.EnvVar{{Name: "TRACING", ValueFrom: &corev1.EnvVarSource{
+					ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "settings"}, Key: "TRACING", Optional: &optional},
+				}}},
+			}),
+			wantID:     findingConfigOptionalRefMissing.ID,
+			wantSource: "container app env TRACING",
+			wantKey:    "TRACING",
+		},
+		{
+			name: "missing key in volume items",
+			pod: configRefPod(corev1.Container{}, corev1.Volume{Name: "config", VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "settings"},
+					Items:                []corev1.KeyToPath{{Key: "REGION", Path: "region"}, {Key: "app.yaml", Path: "app.yaml"}},
+				},
+			}}),
+			wantID:     findingConfigKeyMissing.ID,
+			wantSource: "volume config",
+			wantKey:    "app.yaml",
+		},
+		{
+			name: "missing key in projected volume",
+			pod: configRefPod(corev1.Container{}, corev1.Volume{Name: "creds", VolumeSource: corev1.VolumeSource{
+				Projected: &corev1.ProjectedVolumeSource{Sources: []corev1.VolumeProjection{{
+					Secret: &corev1.SecretProjection{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "db"},
+						Items:                []corev1.KeyToPath{{Key: "ca.crt", Path: "ca.crt"}},
+					},
+				}}},
+			}}),
+			wantID:     findingConfigKeyMissing.ID,
+			wantSource: "volume creds (projected)",
+			wantKey:    "ca.crt",
+		},
+		{
+			name: "missing volume object reported once",
+			pod: configRefPod(corev1.Container{}, corev1.Volume{Name: "tls", VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: "web-tls",
+					Items:      []corev1.KeyToPath{{Key: "tls.crt", Path: "tls.crt"}, {Key: "tls.key", Path: "tls.key"}},
+				},
+			}}),
+			wantID:     findingConfigRefMissing.ID,
+			wantSource: "volume tls",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &K8sDiagnosticsServer{clientset: fake.NewSimpleClientset(configRefObjects()...)}
+			findings := s.checkConfigRefs(context.Background(), tt.pod)
+
+			if tt.wantID == "" {
+				if len(findings) != 0 {
+					t.Fatalf("expected no findings, got %+v", findings)
+				}
+				return
+			}
+			if len(findings) != 1 {
+				t.Fatalf("expected 1 finding, got %+v", findings)
+			}
+			finding := findings[0]
+			if finding.ID != tt.wantID || finding.Evidence["source"] != tt.wantSource || finding.Evidence["key"] != tt.wantKey {
+				t.Errorf("got %s from %q key %q, want %s from %q key %q",
+					finding.ID, finding.Evidence["source"], finding.Evidence["key"], tt.wantID, tt.wantSource, tt.wantKey)
+			}
+			if wantContainer := strings.HasPrefix(tt.wantSource, "container app"); wantContainer != (finding.Object.Container == "app") {
+				t.Errorf("finding points at container %q for source %q", finding.Object.Container, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestCheckConfigRefsNeverReportsSecretValues(t *testing.T) {
+	pod := configRefPod(corev1.Container{
+		Env: []corev1.EnvVar{{Name: "DB_TOKEN", ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "db"}, Key: "token"},
+		}}},
+	}, corev1.Volume{Name: "creds", VolumeSource: corev1.VolumeSource{
+		Secret: &corev1.SecretVolumeSource{SecretName: "db", Items: []corev1.KeyToPath{{Key: "ca.crt", Path: "ca.crt"}}},
+	}})
+	s := &K8sDiagnosticsServer{clientset: fake.NewSimpleClientset(configRefObjects()...)}
+
+	findings := s.checkConfigRefs(context.Background(), pod)
+	if len(findings) != 2 {
+		t.Fatalf("expected 2 findings, got %+v", findings)
+	}
+	for _, finding := range findings {
+		if got := finding.Evidence["available_keys"]; got != "password,username" {
+			t.Errorf("available_keys = %q, want the key names", got)
+		}
+		text := finding.Message
+		for key, value := range finding.Evidence {
+			text += " " + key + "=" + value
+		}
+		if strings.Contains(text, testSecretValue) {
+			t.Errorf("finding %s leaks a Secret value: %s", finding.ID, text)
+		}
+	}
+}
+
+func TestCheckConfigRefsNotesUnreadableSecrets(t *testing.T) {
+	clientset := fake.NewSimpleClientset(configRefObjects()...)
+	clientset.PrependReactor(forbidGet("secrets", "db"))
+	s := &K8sDiagnosticsServer{clientset: clientset}
+	pod := configRefPod(corev1.Container{
+		Env: []corev1.EnvVar{{Name: "DB_TOKEN", ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "db"}, Key: "token"},
+		}}},
+	})
+
+	ctx, omitted := withOmissions(context.Background())
+	if findings := s.checkConfigRefs(ctx, pod); len(findings) != 0 {
+		t.Errorf("an unreadable Secret should leave the reference unchecked, got %+v", findings)
+	}
+	if got := omitted.list(); len(got) != 1 || !strings.Contains(got[0], "Secret shop/db reference check") {
+		t.Errorf("expected the Secret check to be noted as omitted, got %v", got)
+	}
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"sort"
+)
+
+// Severity of a finding
+type Severity string
+
+const (
+	SeverityCritical Severity = "critical"
+	SeverityWarning  Severity = "warning"
+	SeverityInfo     Severity = "info"
+)
+
+func (s Severity) rank() int {
+	switch s {
+	case SeverityCritical:
+		return 0
+	case SeverityWarning:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Finding categories
+const (
+	CategoryScheduling   = "scheduling"
+	CategoryImage        = "image"
+	CategoryResources    = "resources"
+	CategoryProbes       = "probes"
+	CategoryStability    = "stability"
+	CategoryAvailability = "availability"
+	CategoryNodes        = "nodes"
+	CategoryNetwork      = "network"
+	CategorySecurity     = "security"
+	CategoryStorage      = "storage"
+	CategoryConfig       = "configuration"
+	CategoryApplication  = "application"
+	CategoryCustom       = "custom"
+)
+
+// ObjectRef identifies the object a finding is about
+type ObjectRef struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Container string `json:"container,omitempty"`
+}
+
+// Remediation is a suggested fix shared by every finding of the same kind
+type Remediation struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+}
+
+// Finding is a single diagnosed problem with a stable ID
+type Finding struct {
+	ID          string            `json:"id"`
+	Severity    Severity          `json:"severity"`
+	Category    string            `json:"category"`
+	Object      ObjectRef         `json:"object"`
+	Message     string            `json:"message"`
+	Evidence    map[string]string `json:"evidence,omitempty"`
+	Remediation *Remediation      `json:"remediation,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+}
+
+// findingDef describes a built-in check. The ID is stable across releases
+// so findings can be tracked, filtered and suppressed.
+type findingDef struct {
+	ID          string
+	Severity    Severity
+	Category    string
+	Remediation string
+}
+
+// Built-in checks
+var (
+	findingHighRestarts = findingDef{"pod-high-restarts", SeverityWarning, CategoryStability,
+		"Check container logs and resource limits"}
+	findingContainerNotReady = findingDef{"container-not-ready", SeverityWarning, CategoryAvailability, ""}
+	findingImagePull         = findingDef{"container-image-pull-failed", SeverityCritical, CategoryImage,
+		"Check image name, registry credentials, and network connectivity"}
+	findingCrashLoop = findingDef{"container-crash-loop", SeverityCritical, CategoryStability,
+		"Check application logs and startup configuration"}
+	findingContainerWaiting = findingDef{"container-waiting", SeverityWarning, CategoryStability, ""}
+	findingNoResources      = findingDef{"container-no-resources", SeverityWarning, CategoryResources,
+		"Set appropriate resource requests and limits"}
+	findingCPUThrottled = findingDef{"container-cpu-throttled", SeverityWarning, CategoryResources,
+		"Raise or remove the CPU limit"}
+	findingMemoryNearLimit = findingDef{"container-memory-near-limit", SeverityWarning, CategoryResources,
+		"Raise the memory limit or reduce the working set before the container is OOM killed"}
+	findingOOMKilled = findingDef{"container-oom-killed", SeverityCritical, CategoryResources,
+		"Raise the memory limit if the workload needs more memory, or look for a memory leak"}
+
+	findingNodeNotReady = findingDef{"node-not-ready", SeverityCritical, CategoryNodes,
+		"Check kubelet status, node conditions and node events"}
+	findingUnhealthyNodes = findingDef{"cluster-unhealthy-nodes", SeverityCritical, CategoryNodes,
+		"Investigate unhealthy nodes and consider cordoning and draining them"}
+	findingManyProblemPods = findingDef{"cluster-many-problem-pods", SeverityWarning, CategoryStability,
+		"Investigate cluster resource constraints"}
+	findingHighProblemRate = findingDef{"cluster-high-problem-rate", SeverityWarning, CategoryStability,
+		"Consider cluster-wide investigation"}
+
+	findingLogOutOfMemory = findingDef{"log-out-of-memory", SeverityCritical, CategoryResources,
+		"Consider increasing memory limits or optimizing application memory usage"}
+	findingLogConnectionRefused = findingDef{"log-connection-refused", SeverityWarning, CategoryNetwork,
+		"Check network policies, service configurations, and target service availability (diagnose_service checks selectors, endpoints and target ports)"}
+	findingLogPermissionDenied = findingDef{"log-permission-denied", SeverityWarning, CategorySecurity,
+		"Review RBAC permissions (analyze_rbac resolves a service account's effective permissions) and file system permissions"}
+	findingLogTimeout = findingDef{"log-timeout", SeverityWarning, CategoryNetwork,
+		"Check network connectivity and increase timeout values if appropriate"}
+	findingLogKilled = findingDef{"log-killed", SeverityCritical, CategoryResources,
+		"Pod may have been killed due to resource limits (OOMKilled) - check resource usage"}
+	findingLogSegfault = findingDef{"log-segmentation-fault", SeverityCritical, CategoryApplication,
+		"Application crash detected - review application code and dependencies"}
+	findingLogHighErrorRate = findingDef{"log-high-error-rate", SeverityWarning, CategoryApplication,
+		"High error rate detected - consider reviewing application stability"}
+	findingLogManyWarnings = findingDef{"log-many-warnings", SeverityInfo, CategoryApplication,
+		"Multiple warnings detected - review application configuration"}
+
+	findingWorkloadNoLimits = findingDef{"workload-no-limits", SeverityWarning, CategoryResources,
+		"Set resource limits on every container"}
+	findingWorkloadNoRequests = findingDef{"workload-no-requests", SeverityWarning, CategoryResources,
+		"Set resource requests on every container so the scheduler can place pods correctly"}
+	findingSingleReplica = findingDef{"workload-single-replica", SeverityWarning, CategoryAvailability,
+		"Run at least 2 replicas for high availability"}
+	findingNoLivenessProbe = findingDef{"workload-no-liveness-probe", SeverityInfo, CategoryProbes,
+		"Add a liveness probe so hung containers are restarted"}
+	findingNoReadinessProbe = findingDef{"workload-no-readiness-probe", SeverityWarning, CategoryProbes,
+		"Add a readiness probe so traffic only reaches ready pods"}
+	findingOverProvisioned = findingDef{"container-overprovisioned", SeverityInfo, CategoryResources,
+		"Lower requests to the recommended values to free cluster capacity"}
+	findingUnderProvisioned = findingDef{"container-underprovisioned", SeverityWarning, CategoryResources,
+		"Raise requests and limits to the recommended values to avoid throttling, eviction or OOM kills"}
+
+	findingQuotaExceeded = findingDef{"quota-exceeded", SeverityCritical, CategoryResources,
+		"Raise the ResourceQuota, lower per-pod requests, or scale down other workloads in the namespace"}
+	findingQuotaNearLimit = findingDef{"quota-near-limit", SeverityWarning, CategoryResources,
+		"Raise the ResourceQuota before the next rollout or scale-up is rejected"}
+	findingRolloutBlocked = findingDef{"rollout-blocked-by-quota", SeverityWarning, CategoryResources,
+		"Free quota, lower maxSurge, or raise the quota so surge pods can be created"}
+	findingLimitRangeDefaulted = findingDef{"limitrange-defaulted-resources", SeverityInfo, CategoryResources,
+		"Set explicit requests and limits in the pod template instead of relying on LimitRange defaults"}
+
+	findingNodeCapacityHigh = findingDef{"node-capacity-high", SeverityWarning, CategoryNodes,
+		"Add nodes or rebalance workloads; new pods needing this resource may not fit on the node"}
+	findingMemoryOvercommitted = findingDef{"cluster-memory-overcommitted", SeverityWarning, CategoryResources,
+		"Bring memory limits closer to requests or add capacity so limits are backed by real memory"}
+	findingReplicasUnschedulable = findingDef{"whatif-unschedulable", SeverityWarning, CategoryScheduling,
+		"Add nodes, lower per-pod requests, or raise the namespace quota before scaling up"}
+
+	findingServiceSelectorMismatch = findingDef{"service-selector-no-match", SeverityCritical, CategoryNetwork,
+		"Align the service selector with the pod template labels"}
+	findingServiceNoSelector = findingDef{"service-no-selector", SeverityInfo, CategoryNetwork,
+		"Add a selector, or make sure the EndpointSlices for this service are maintained"}
+	findingServiceNoEndpointSlices = findingDef{"service-no-endpointslices", SeverityWarning, CategoryNetwork,
+		"Check that kube-controller-manager is running and can write EndpointSlices"}
+	findingServiceNoReadyEndpoints = findingDef{"service-no-ready-endpoints", SeverityCritical, CategoryNetwork,
+		"Fix the readiness of the backing pods; check their readiness probes and logs"}
+	findingServiceTargetPortMismatch = findingDef{"service-targetport-mismatch", SeverityCritical, CategoryNetwork,
+		"Set targetPort to a container port name or number the pods actually declare"}
+	findingServiceTargetPortUndeclared = findingDef{"service-targetport-undeclared", SeverityWarning, CategoryNetwork,
+		"Check that targetPort is the port the application listens on and declare it in the container ports"}
+	findingServiceProtocolMismatch = findingDef{"service-protocol-mismatch", SeverityWarning, CategoryNetwork,
+		"Use the same protocol on the service port and the container port"}
+	findingServiceExternalNameQuirk = findingDef{"service-externalname-misconfigured", SeverityWarning, CategoryNetwork,
+		"Point externalName at a DNS name and connect to the external port directly"}
+	findingServiceHeadlessQuirk = findingDef{"service-headless-quirk", SeverityInfo, CategoryNetwork,
+		"Clients of headless services connect to pod IPs directly; use the container port"}
+
+	findingNetworkPolicyDenied = findingDef{"networkpolicy-denied", SeverityWarning, CategoryNetwork,
+		"Add an ingress or egress rule that allows this peer and port to one of the listed policies"}
+
+	findingIngressClassMismatch = findingDef{"ingress-class-mismatch", SeverityCritical, CategoryNetwork,
+		"Reference an IngressClass or GatewayClass that exists and is accepted by a running controller"}
+	findingIngressNoAddress = findingDef{"ingress-no-address", SeverityInfo, CategoryNetwork,
+		"Check that the ingress controller for this class is running and watching the namespace"}
+	findingRouteOverlap = findingDef{"route-rule-overlap", SeverityWarning, CategoryNetwork,
+		"Give each host/path a single owner, or merge the overlapping rules"}
+	findingRouteBackendMissing = findingDef{"route-backend-missing", SeverityCritical, CategoryNetwork,
+		"Create the backend service or fix the service name in the route"}
+	findingRouteBackendPortMissing = findingDef{"route-backend-port-missing", SeverityCritical, CategoryNetwork,
+		"Reference a port name or number the backend service exposes"}
+	findingRouteBackendUnhealthy = findingDef{"route-backend-unhealthy", SeverityCritical, CategoryNetwork,
+		"Run diagnose_service on the backend to find why it has no ready endpoints"}
+	findingRouteBackendNotPermitted = findingDef{"route-backend-not-permitted", SeverityCritical, CategoryNetwork,
+		"Create a ReferenceGrant in the backend namespace that allows HTTPRoutes from the route's namespace"}
+	findingRouteTLSSecretMissing = findingDef{"route-tls-secret-missing", SeverityCritical, CategorySecurity,
+		"Create the TLS secret (e.g. with cert-manager) or fix the secret name"}
+	findingRouteTLSSecretInvalid = findingDef{"route-tls-secret-invalid", SeverityWarning, CategorySecurity,
+		"Store the certificate and key as tls.crt and tls.key in a kubernetes.io/tls secret"}
+	findingHTTPRouteParentMissing = findingDef{"httproute-parent-missing", SeverityCritical, CategoryNetwork,
+		"Point parentRefs at an existing Gateway and listener"}
+	findingHTTPRouteNotAccepted = findingDef{"httproute-not-accepted", SeverityCritical, CategoryNetwork,
+		"Check the Gateway's allowedRoutes, listener hostnames and the gateway controller logs"}
+	findingHTTPRouteRefsUnresolved = findingDef{"httproute-refs-unresolved", SeverityCritical, CategoryNetwork,
+		"Fix the backendRefs named in the condition message"}
+
+	findingStoragePVCMissing = findingDef{"storage-pvc-missing", SeverityCritical, CategoryStorage,
+		"Create the PersistentVolumeClaim or fix the claimName in the pod spec"}
+	findingStoragePVCPending = findingDef{"storage-pvc-pending", SeverityWarning, CategoryStorage,
+		"Check the claim's events and that a PV or provisioner can satisfy its size, access modes and class"}
+	findingStorageClassMissing = findingDef{"storage-class-missing", SeverityCritical, CategoryStorage,
+		"Create the StorageClass, set storageClassName to an existing one, or mark a default StorageClass"}
+	findingStorageProvisioningFailed = findingDef{"storage-provisioning-failed", SeverityCritical, CategoryStorage,
+		"Check the CSI provisioner logs, its credentials and the StorageClass parameters"}
+	findingStorageTopologyConflict = findingDef{"storage-topology-conflict", SeverityCritical, CategoryStorage,
+		"Schedule the pod in the volume's zone, or use a WaitForFirstConsumer StorageClass and allowedTopologies that match the nodes"}
+	findingStorageMultiAttach = findingDef{"storage-multi-attach", SeverityCritical, CategoryStorage,
+		"Run the pods on the same node, use a ReadWriteMany volume, or give each replica its own claim (StatefulSet volumeClaimTemplates)"}
+	findingStorageMountFailed = findingDef{"storage-mount-failed", SeverityCritical, CategoryStorage,
+		"Check the kubelet and CSI node plugin logs on the pod's node"}
+	findingStorageAttachFailed = findingDef{"storage-attach-failed", SeverityCritical, CategoryStorage,
+		"Check the CSI controller logs and the VolumeAttachment; detach the volume from its previous node if it is stuck"}
+	findingStoragePVUnavailable = findingDef{"storage-pv-unavailable", SeverityCritical, CategoryStorage,
+		"Restore the PersistentVolume or recreate the claim; check the reclaim policy of the old volume"}
+	findingStorageResizePending = findingDef{"storage-resize-pending", SeverityInfo, CategoryStorage,
+		"Check that the StorageClass allows volume expansion; some drivers finish the resize only when the pod restarts"}
+
+	findingConfigRefMissing = findingDef{"config-ref-missing", SeverityCritical, CategoryConfig,
+		"Create the ConfigMap or Secret in the pod's namespace, fix the name, or mark the reference optional"}
+	findingConfigKeyMissing = findingDef{"config-key-missing", SeverityCritical, CategoryConfig,
+		"Add the key to the ConfigMap or Secret, or reference one of its existing keys"}
+	findingConfigOptionalRefMissing = findingDef{"config-optional-ref-missing", SeverityInfo, CategoryConfig,
+		"Check that the container works without this optional value"}
+
+	findingImageNotFound = findingDef{"image-not-found", SeverityCritical, CategoryImage,
+		"Check the image name and tag for typos and that the image was pushed to this registry"}
+	findingImageManifestUnknown = findingDef{"image-manifest-unknown", SeverityCritical, CategoryImage,
+		"Push the tag or digest, or reference one that exists in the repository"}
+	findingImagePullUnauthorized = findingDef{"image-pull-unauthorized", SeverityCritical, CategoryImage,
+		"Add an imagePullSecret with valid credentials for the registry to the pod or its service account"}
+	findingImagePullRateLimited = findingDef{"image-pull-rate-limited", SeverityWarning, CategoryImage,
+		"Authenticate pulls, use a registry mirror or pull-through cache, or use imagePullPolicy IfNotPresent"}
+	findingImageRegistryTLS = findingDef{"image-registry-tls", SeverityCritical, CategoryImage,
+		"Install the registry's CA certificate in the container runtime's trust store on the nodes"}
+	findingImageRegistryDNS = findingDef{"image-registry-dns", SeverityCritical, CategoryImage,
+		"Check the registry host name and the nodes' DNS configuration"}
+	findingImageRegistryUnreachable = findingDef{"image-registry-unreachable", SeverityCritical, CategoryImage,
+		"Check egress from the nodes to the registry (firewalls, proxies, NAT)"}
+	findingImagePlatformMismatch = findingDef{"image-platform-mismatch", SeverityCritical, CategoryImage,
+		"Build a multi-arch image, or schedule the pod on nodes with a matching kubernetes.io/arch"}
+	findingImagePullUnknown = findingDef{"image-pull-unknown", SeverityWarning, CategoryImage,
+		"Check the pull error in the pod events"}
+	findingPullSecretMissing = findingDef{"image-pull-secret-missing", SeverityCritical, CategoryImage,
+		"Create the image pull secret in the pod's namespace or remove the reference"}
+	findingPullSecretInvalid = findingDef{"image-pull-secret-invalid", SeverityWarning, CategoryImage,
+		"Recreate the secret with kubectl create secret docker-registry"}
+	findingPullSecretNoAuth = findingDef{"image-pull-secret-no-auth", SeverityWarning, CategoryImage,
+		"Add credentials for the image's registry host to one of the pull secrets"}
+
+	findingLivenessFailing = findingDef{"probe-liveness-failing", SeverityCritical, CategoryProbes,
+		"Check the endpoint the liveness probe hits; every failure past failureThreshold restarts the container"}
+	findingReadinessFailing = findingDef{"probe-readiness-failing", SeverityWarning, CategoryProbes,
+		"Check the endpoint the readiness probe hits; the pod receives no service traffic while it fails"}
+	findingStartupFailing = findingDef{"probe-startup-failing", SeverityCritical, CategoryProbes,
+		"Check application startup logs, or raise the startup probe's failureThreshold"}
+	findingProbeWarning = findingDef{"probe-warning", SeverityInfo, CategoryProbes,
+		"Check the probe's target; warnings (e.g. redirects) don't fail the probe yet"}
+	findingProbePortUnknown = findingDef{"probe-port-unknown", SeverityCritical, CategoryProbes,
+		"Use a port name the container declares, or the port number"}
+	findingProbePortUndeclared = findingDef{"probe-port-undeclared", SeverityWarning, CategoryProbes,
+		"Point the probe at the port the application listens on"}
+	findingProbeSharedEndpoint = findingDef{"probe-liveness-shares-readiness", SeverityWarning, CategoryProbes,
+		"Give the liveness probe a cheap endpoint that only checks the process itself, not its dependencies"}
+	findingProbeTooEarly = findingDef{"probe-fails-before-startup", SeverityWarning, CategoryProbes,
+		"Add a startupProbe, or raise initialDelaySeconds/failureThreshold above the observed startup time"}
+	findingProbeNoStartupProbe = findingDef{"probe-no-startup-probe", SeverityInfo, CategoryProbes,
+		"Add a startupProbe so slow starts don't count against the liveness probe"}
+
+	findingPSSNotEnforced = findingDef{"pss-namespace-not-enforced", SeverityInfo, CategorySecurity,
+		"Label the namespace pod-security.kubernetes.io/enforce=baseline (or restricted) once its workloads comply"}
+	findingPSSInvalidLabel = findingDef{"pss-namespace-invalid-label", SeverityWarning, CategorySecurity,
+		"Use privileged, baseline or restricted as the label value"}
+	findingPSSPrivileged = findingDef{"pss-privileged", SeverityWarning, CategorySecurity,
+		"Remove securityContext.privileged, or grant only the specific capabilities the container needs"}
+	findingPSSHostNamespaces = findingDef{"pss-host-namespaces", SeverityWarning, CategorySecurity,
+		"Remove hostNetwork/hostPID/hostIPC and hostPort; expose the container through a Service instead"}
+	findingPSSHostPath = findingDef{"pss-host-path", SeverityWarning, CategorySecurity,
+		"Replace the hostPath volume with a PersistentVolumeClaim, emptyDir, ConfigMap or Secret"}
+	findingPSSCapabilities = findingDef{"pss-capabilities", SeverityWarning, CategorySecurity,
+		"Remove the capability from securityContext.capabilities.add; restricted only allows NET_BIND_SERVICE"}
+	findingPSSSeccomp = findingDef{"pss-seccomp", SeverityWarning, CategorySecurity,
+		"Set securityContext.seccompProfile.type to RuntimeDefault (or Localhost) on the pod or container"}
+	findingPSSHostProcess = findingDef{"pss-host-process", SeverityWarning, CategorySecurity,
+		"Remove windowsOptions.hostProcess; HostProcess containers have full access to the Windows host"}
+	findingPSSSELinux = findingDef{"pss-selinux", SeverityWarning, CategorySecurity,
+		"Remove seLinuxOptions.user and role, and leave type unset or use container_t, container_init_t, container_kvm_t or container_engine_t"}
+	findingPSSAppArmor = findingDef{"pss-apparmor", SeverityWarning, CategorySecurity,
+		"Use the RuntimeDefault or a Localhost AppArmor profile instead of Unconfined"}
+	findingPSSProcMount = findingDef{"pss-proc-mount", SeverityWarning, CategorySecurity,
+		"Remove securityContext.procMount or set it to Default"}
+	findingPSSSysctls = findingDef{"pss-unsafe-sysctls", SeverityWarning, CategorySecurity,
+		"Remove the sysctl, or run the workload in a namespace and on nodes that allow it"}
+	findingPSSVolumeTypes = findingDef{"pss-volume-type", SeverityInfo, CategorySecurity,
+		"Use configMap, csi, downwardAPI, emptyDir, ephemeral, persistentVolumeClaim, projected or secret volumes"}
+	findingPSSPrivilegeEscalation = findingDef{"pss-privilege-escalation", SeverityInfo, CategorySecurity,
+		"Set securityContext.allowPrivilegeEscalation: false"}
+	findingPSSDropCapabilities = findingDef{"pss-capabilities-not-dropped", SeverityInfo, CategorySecurity,
+		"Set securityContext.capabilities.drop: [\"ALL\"]"}
+	findingPSSRunAsNonRoot = findingDef{"pss-run-as-root", SeverityInfo, CategorySecurity,
+		"Set securityContext.runAsNonRoot: true and a non-zero runAsUser, and build the image with a non-root USER"}
+	findingWritableRootFilesystem = findingDef{"security-writable-root-filesystem", SeverityInfo, CategorySecurity,
+		"Set securityContext.readOnlyRootFilesystem: true and mount an emptyDir for paths the application writes"}
+
+	findingRBACServiceAccountMissing = findingDef{"rbac-serviceaccount-missing", SeverityWarning, CategorySecurity,
+		"Create the service account or fix serviceAccountName in the pod spec"}
+	findingRBACRoleMissing = findingDef{"rbac-role-missing", SeverityWarning, CategorySecurity,
+		"Create the referenced Role/ClusterRole or delete the dangling binding"}
+	findingRBACClusterAdmin = findingDef{"rbac-cluster-admin", SeverityCritical, CategorySecurity,
+		"Replace the cluster-admin binding with a Role that grants only the verbs and resources the workload uses"}
+	findingRBACWildcard = findingDef{"rbac-wildcard", SeverityWarning, CategorySecurity,
+		"List the verbs and resources explicitly instead of \"*\"; wildcards also cover resources added later"}
+	findingRBACSecretsRead = findingDef{"rbac-secrets-read", SeverityWarning, CategorySecurity,
+		"Restrict secret access with resourceNames, or to the namespace that holds the secrets the workload needs"}
+
+	findingPermissionMissing = findingDef{"permission-missing", SeverityWarning, CategorySecurity,
+		"Bind the server's service account to the ClusterRole in cluster_role, or grant the missing verbs"}
+)
+
+// newFinding builds a finding from a built-in check
+func newFinding(def findingDef, object ObjectRef, message string, evidence map[string]string) Finding {
+	finding := Finding{
+		ID:       def.ID,
+		Severity: def.Severity,
+		Category: def.Category,
+		Object:   object,
+		Message:  message,
+		Evidence: evidence,
+	}
+	if def.Remediation != "" {
+		finding.Remediation = &Remediation{ID: def.ID, Description: def.Remediation}
+	}
+	return finding
+}
+
+// sortFindings orders findings by severity, keeping detection order within a severity
+func sortFindings(findings []Finding) {
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Severity.rank() < findings[j].Severity.rank()
+	})
+}
+
+// issuesAndSuggestions renders findings as the legacy issues/suggestions lists,
+// with duplicate suggestions removed
+func issuesAndSuggestions(findings []Finding) ([]string, []string) {
+	issues := []string{}
+	suggestions := []string{}
+	seen := make(map[string]bool)
+
+	for _, finding := range findings {
+		issues = append(issues, finding.Message)
+		if finding.Remediation != nil && !seen[finding.Remediation.Description] {
+			seen[finding.Remediation.Description] = true
+			suggestions = append(suggestions, finding.Remediation.Description)
+		}
+	}
+	return issues, suggestions
+}
+
+// FindingSummary is a severity-sorted rollup of a set of findings
+type FindingSummary struct {
+	Total           int            `json:"total"`
+	Critical        int            `json:"critical"`
+	Warning         int            `json:"warning"`
+	Info            int            `json:"info"`
+	HighestSeverity Severity       `json:"highest_severity,omitempty"`
+	Categories      map[string]int `json:"categories"`
+	TopFindings     []string       `json:"top_findings"`
+	Remediations    []Remediation  `json:"remediations"`
+}
+
+const maxTopFindings = 5
+
+// summarizeFindings rolls up one or more finding lists. Top findings and
+// remediations are listed most severe first, each remediation once.
+func summarizeFindings(lists ...[]Finding) *FindingSummary {
+	var all []Finding
+	for _, list := range lists {
+		all = append(all, list...)
+	}
+	sortFindings(all)
+
+	summary := &FindingSummary{
+		Total:        len(all),
+		Categories:   make(map[string]int),
+		TopFindings:  []string{},
+		Remediations: []Remediation{},
+	}
+
+	seen := make(map[string]bool)
+	for _, finding := range all {
+		switch finding.Severity {
+		case SeverityCritical:
+			summary.Critical++
+		case SeverityWarning:
+			summary.Warning++
+		default:
+			summary.Info++
+		}
+		summary.Categories[finding.Category]++
+
+		if len(summary.TopFindings) < maxTopFindings {
+			summary.TopFindings = append(summary.TopFindings,
+				string(finding.Severity)+": "+finding.Message)
+		}
+
+		if finding.Remediation != nil && !seen[finding.Remediation.ID] {
+			seen[finding.Remediation.ID] = true
+			summary.Remediations = append(summary.Remediations, *finding.Remediation)
+		}
+	}
+
+	if len(all) > 0 {
+		summary.HighestSeverity = all[0].Severity
+	}
+
+	return summary
+}
+
+// podFindings collects the findings of a list of pod diagnostics
+func podFindings(pods []PodDiagnostic) []Finding {
+	var findings []Finding
+	for _, pod := range pods {
+		findings = append(findings, pod.Findings...)
+	}
+	return findings
+}
@@ -2,22 +2,35 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
-	"path/filepath"
-
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type HTTPServer struct {
-	diagnostics *K8sDiagnosticsServer
-	demoMode    bool
+	diagnostics    *K8sDiagnosticsServer
+	demoMode       bool
+	authenticators []Authenticator
+	scopes         *Scopes
+	impersonate    bool
+	tlsConfig      *tls.Config
+	timeouts       *ToolTimeouts
+	metrics        *ServerMetrics
+	readiness      readiness
 }
 
 func NewHTTPServer() (*HTTPServer, error) {
@@ -28,37 +41,176 @@ func NewHTTPServer() (*HTTPServer, error) {
 
 	if demoMode {
 		log.Println("Running in DEMO mode with mock data")
-		diagnostics = &K8sDiagnosticsServer{} // Empty for demo mode
+		policy, err := newPolicyStoreFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		diagnostics = &K8sDiagnosticsServer{policy: policy, callers: &callerServers{}} // No cluster access in demo mode
 	} else {
 		log.Println("Running in REAL mode with Kubernetes cluster")
 		diagnostics, err = NewK8sDiagnosticsServer()
 		if err != nil {
 			return nil, err
 		}
+		diagnostics.logPermissionPreflight()
 	}
 
-	return &HTTPServer{
+	server := &HTTPServer{
 		diagnostics: diagnostics,
 		demoMode:    demoMode,
-	}, nil
+		metrics:     getServerMetrics(),
+		// Impersonation is on by default once callers authenticate; demo mode has no cluster
+		impersonate: !demoMode && os.Getenv("IMPERSONATE_CALLERS") != "false",
+	}
+	server.tlsConfig, err = newTLSConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	server.timeouts, err = newToolTimeoutsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	server.metrics.watchCaches(diagnostics)
+	server.authenticators, err = newAuthenticatorsFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure authentication: %w", err)
+	}
+	server.scopes, err = newScopesFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if err := server.scopes.checkTokenScopes(server.authenticators); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(server.authenticators) == 0 && server.scopes != nil:
+		return nil, fmt.Errorf("AUTH_SCOPES_FILE needs an authentication method (AUTH_TOKEN_FILE, OIDC_ISSUER_URL or TLS_CLIENT_CA_FILE)")
+	case len(server.authenticators) == 0:
+		log.Println("WARNING: HTTP mode has no authentication; anyone who can reach the port can call every tool")
+	case server.impersonate:
+		log.Println("Impersonating authenticated callers; Kubernetes RBAC applies per caller")
+	}
+	return server, nil
+}
+
+// tool wraps a tool handler with authentication, its timeout and metrics
+func (s *HTTPServer) tool(handler http.HandlerFunc) http.HandlerFunc {
+	return s.authorize(s.withTimeout(s.instrument(handler)))
+}
+
+type callerKey struct{}
+
+// authorize authenticates the caller, checks the tool against their scope and
+// serves the request with a diagnostics server for them. Rejected calls are
+// counted here, since instrument only sees the calls that get through. Without
+// configured authenticators it is a no-op.
+func (s *HTTPServer) authorize(next http.HandlerFunc) http.HandlerFunc {
+	if len(s.authenticators) == 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		tool := strings.TrimPrefix(r.URL.Path, "/")
+		identity, err := authenticate(s.authenticators, r)
+		if err != nil {
+			unauthorized(w, err)
+			s.metrics.observe(tool, OutcomeUnauthorized, time.Since(start))
+			return
+		}
+
+		scope := s.scopes.resolve(*identity)
+		if !scope.allowsTool(tool) {
+			http.Error(w, fmt.Sprintf("Forbidden: %s may not call %s (scope %s)", identity.User, tool, scope.describe()), http.StatusForbidden)
+			s.metrics.observe(tool, OutcomeForbidden, time.Since(start))
+			return
+		}
+
+		diagnostics, err := s.diagnostics.forCaller(*identity, scope, s.impersonate)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to set up clients for %s: %v", identity.User, err), http.StatusInternalServerError)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, diagnostics)))
+	}
+}
+
+// authenticated requires valid credentials for a handler that is not a tool,
+// such as /metrics. Without configured authenticators it is a no-op.
+func (s *HTTPServer) authenticated(next http.Handler) http.Handler {
+	if len(s.authenticators) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := authenticate(s.authenticators, r); err != nil {
+			unauthorized(w, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// unauthorized answers a request whose credentials are missing or invalid
+func unauthorized(w http.ResponseWriter, err error) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="k8s-diagnostics"`)
+	if errors.Is(err, errNoCredentials) {
+		http.Error(w, "Unauthorized: missing or unknown credentials", http.StatusUnauthorized)
+	} else {
+		http.Error(w, fmt.Sprintf("Unauthorized: %v", err), http.StatusUnauthorized)
+	}
+}
+
+// diagnosticsFor returns the diagnostics server for a request: the caller's
+// scoped, possibly impersonating server when callers authenticate, the shared one otherwise
+func (s *HTTPServer) diagnosticsFor(r *http.Request) *K8sDiagnosticsServer {
+	if diagnostics, ok := r.Context().Value(callerKey{}).(*K8sDiagnosticsServer); ok {
+		return diagnostics
+	}
+	return s.diagnostics
+}
+
+// errorStatus maps a tool error to an HTTP status: access policy denials and
+// RBAC denials of an impersonated caller are 403 Forbidden, everything else is
+// a server error
+func errorStatus(err error) int {
+	var denied *AccessDeniedError
+	if errors.As(err, &denied) || apierrors.IsForbidden(err) {
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
 }
 
 // Mock data for demo mode
 func (s *HTTPServer) getMockPodDiagnostic() *PodDiagnostic {
+	ref := ObjectRef{Kind: "Pod", Namespace: "default", Name: "demo-app-pod", Container: "app-container"}
+	findings := []Finding{
+		newFinding(findingHighRestarts, ref,
+			"Container has restarted 2 times in the last hour",
+			map[string]string{"restart_count": "2"}),
+		{
+			ID:       "container-memory-pressure",
+			Severity: SeverityWarning,
+			Category: CategoryResources,
+			Object:   ref,
+			Message:  "Memory usage is at 85% of limit",
+			Evidence: map[string]string{"memory_usage_percent": "85"},
+			Remediation: &Remediation{
+				ID:          "container-memory-pressure",
+				Description: "Consider increasing memory limits",
+			},
+		},
+	}
+	issues, suggestions := issuesAndSuggestions(findings)
+
 	return &PodDiagnostic{
 		Name:         "demo-app-pod",
 		Namespace:    "default",
 		Status:       "Running",
 		RestartCount: 2,
-		Issues: []string{
-			"Container has restarted 2 times in the last hour",
-			"Memory usage is at 85% of limit",
-		},
-		Suggestions: []string{
-			"Check application logs for errors",
-			"Consider increasing memory limits",
-			"Review resource requests",
-		},
+		Issues:       issues,
+		Suggestions:  suggestions,
+		Findings:     findings,
+		Summary:      summarizeFindings(findings),
 		Events: []string{
 			"Pod scheduled successfully",
 			"Container started",
@@ -75,12 +227,19 @@ func (s *HTTPServer) getMockPodDiagnostic() *PodDiagnostic {
 }
 
 func (s *HTTPServer) getMockClusterHealth() *ClusterHealth {
+	findings := []Finding{
+		newFinding(findingNodeNotReady, ObjectRef{Kind: "Node", Name: "demo-node-3"},
+			"Node demo-node-3 is not ready",
+			map[string]string{"condition": "Ready=Unknown: NodeStatusUnknown"}),
+	}
+	pod := s.getMockPodDiagnostic()
+
 	return &ClusterHealth{
 		NodeCount:      3,
 		HealthyNodes:   2,
 		NamespaceCount: 5,
 		PodIssues: []PodDiagnostic{
-			*s.getMockPodDiagnostic(),
+			*pod,
 		},
 		ResourceUsage: map[string]interface{}{
 			"cpu_usage_percent":    65,
@@ -92,11 +251,141 @@ func (s *HTTPServer) getMockClusterHealth() *ClusterHealth {
 			"Review pod resource requests and limits",
 			"Monitor node health more frequently",
 		},
+		Capacity:  &s.getMockClusterCapacity("", 0).CapacityTotals,
+		Findings:  findings,
+		Summary:   summarizeFindings(findings, pod.Findings),
 		Timestamp: time.Now(),
 	}
 }
 
+// getMockPodList is the demo list_pods result
+func (s *HTTPServer) getMockPodList(namespace string) *PodListResult {
+	pods := []PodStatusInfo{
+		{Name: "demo-app-pod", Namespace: "default", Status: "Running", Ready: "1/1", Restarts: 2, Age: "2h30m"},
+		{Name: "demo-db-pod", Namespace: "default", Status: "Running", Ready: "1/1", Restarts: 0, Age: "1h45m"},
+		{Name: "demo-web-pod", Namespace: "default", Status: "Pending", Ready: "0/1", Restarts: 0, Age: "5m"},
+	}
+	findings := []Finding{
+		newFinding(findingImagePull, ObjectRef{Kind: "Pod", Namespace: "default", Name: "demo-web-pod", Container: "web"},
+			"Container web is waiting: ImagePullBackOff", map[string]string{"reason": "ImagePullBackOff"}),
+		newFinding(findingContainerNotReady, ObjectRef{Kind: "Pod", Namespace: "default", Name: "demo-web-pod", Container: "web"},
+			"Container web is not ready", nil),
+	}
+	return &PodListResult{
+		Namespace: namespace,
+		PodCount:  len(pods),
+		Pods:      pods,
+		Findings:  findings,
+		Summary:   summarizeFindings(findings),
+	}
+}
+
+func (s *HTTPServer) getMockClusterCapacity(deployment string, replicas int) *ClusterCapacity {
+	// Amounts are in milli-units, like nodeAmounts
+	const giMillis = 1024 * 1024 * 1024 * 1000
+	nodes := []struct {
+		name               string
+		cpuReq, cpuLim     int64
+		memReq, memLim     int64
+		pods, storageUsed  int64
+		ready, schedulable bool
+	}{
+		{"demo-node-1", 3700, 6000, 13 * giMillis, 20 * giMillis, 38, 10 * giMillis, true, true},
+		{"demo-node-2", 2100, 4000, 9 * giMillis, 18 * giMillis, 24, 6 * giMillis, true, true},
+		{"demo-node-3", 1200, 2000, 4 * giMillis, 6 * giMillis, 12, 3 * giMillis, false, false},
+	}
+	allocatable := map[corev1.ResourceName]int64{
+		corev1.ResourceCPU:              3860,
+		corev1.ResourceMemory:           15 * giMillis,
+		corev1.ResourcePods:             110 * 1000,
+		corev1.ResourceEphemeralStorage: 95 * giMillis,
+	}
+
+	result := &ClusterCapacity{
+		NodeCount: len(nodes),
+		CapacityTotals: CapacityTotals{
+			Totals:          map[string]ResourceCapacity{},
+			OvercommitRatio: map[string]float64{},
+			Fragmentation:   map[string]Fragmentation{},
+		},
+		Nodes: []NodeCapacity{},
+	}
+	totalRequested := map[corev1.ResourceName]int64{}
+	totalLimited := map[corev1.ResourceName]int64{}
+	for _, n := range nodes {
+		requested := map[corev1.ResourceName]int64{
+			corev1.ResourceCPU: n.cpuReq, corev1.ResourceMemory: n.memReq,
+			corev1.ResourcePods: n.pods * 1000, corev1.ResourceEphemeralStorage: n.storageUsed,
+		}
+		limited := map[corev1.ResourceName]int64{
+			corev1.ResourceCPU: n.cpuLim, corev1.ResourceMemory: n.memLim,
+			corev1.ResourcePods: n.pods * 1000, corev1.ResourceEphemeralStorage: n.storageUsed,
+		}
+		node := NodeCapacity{Name: n.name, Ready: n.ready, Schedulable: n.schedulable, PodCount: int(n.pods), Resources: map[string]ResourceCapacity{}}
+		for _, name := range capacityResources {
+			node.Resources[string(name)] = resourceCapacity(name, allocatable[name], requested[name], limited[name])
+			totalRequested[name] += requested[name]
+			totalLimited[name] += limited[name]
+		}
+		result.Nodes = append(result.Nodes, node)
+	}
+	for _, name := range capacityResources {
+		total := allocatable[name] * int64(len(nodes))
+		result.Totals[string(name)] = resourceCapacity(name, total, totalRequested[name], totalLimited[name])
+	}
+	result.OvercommitRatio["cpu"] = 1.04
+	result.OvercommitRatio["memory"] = 0.98
+	result.Fragmentation["cpu"] = Fragmentation{TotalFree: "1920m", LargestFree: "1760m", LargestFreeNode: "demo-node-2", Percent: 8.3}
+	result.Fragmentation["memory"] = Fragmentation{TotalFree: "8Gi", LargestFree: "6Gi", LargestFreeNode: "demo-node-2", Percent: 25}
+	result.Fragmentation["pods"] = Fragmentation{TotalFree: "158", LargestFree: "86", LargestFreeNode: "demo-node-2", Percent: 45.6}
+	result.Fragmentation["ephemeral-storage"] = Fragmentation{TotalFree: "174Gi", LargestFree: "89Gi", LargestFreeNode: "demo-node-2", Percent: 48.9}
+
+	result.Findings = []Finding{
+		newFinding(findingNodeCapacityHigh, ObjectRef{Kind: "Node", Name: "demo-node-1"},
+			"Node demo-node-1 has 95.9% of allocatable cpu requested (3700m of 3860m)",
+			map[string]string{"resource": "cpu"}),
+	}
+
+	if deployment != "" {
+		fits := replicas <= 3
+		whatIf := &SchedulingWhatIf{
+			Deployment:    deployment,
+			Namespace:     "default",
+			Replicas:      replicas,
+			PerPod:        map[string]string{"cpu": "500m", "memory": "2Gi", "pods": "1", "ephemeral-storage": "0"},
+			Fits:          fits,
+			Placements:    map[string]int{},
+			ExcludedNodes: map[string]string{"demo-node-3": "not ready or cordoned"},
+		}
+		// demo-node-2 has room for three pods of this size
+		whatIf.Schedulable = replicas
+		if !fits {
+			whatIf.Schedulable = 3
+		}
+		whatIf.Placements["demo-node-2"] = whatIf.Schedulable
+		if fits {
+			whatIf.Explanation = fmt.Sprintf("%d more replica(s) fit on %d node(s)", replicas, len(whatIf.Placements))
+		} else {
+			whatIf.Explanation = fmt.Sprintf("only 3 of %d more replica(s) fit: insufficient memory (needs 2Gi, largest free 0)", replicas)
+			result.Findings = append(result.Findings, newFinding(findingReplicasUnschedulable,
+				ObjectRef{Kind: "Deployment", Namespace: "default", Name: deployment},
+				fmt.Sprintf("Deployment default/%s: %s", deployment, whatIf.Explanation), nil))
+		}
+		result.WhatIf = whatIf
+	}
+
+	sortFindings(result.Findings)
+	result.Summary = summarizeFindings(result.Findings)
+	return result
+}
+
 func (s *HTTPServer) getMockLogAnalysis() *LogAnalysis {
+	findings := []Finding{
+		newFinding(findingLogTimeout, ObjectRef{Kind: "Pod", Namespace: "default", Name: "demo-app-pod"},
+			`Log contains "timeout" (1 lines)`,
+			map[string]string{"matches": "1", "sample_line": "ERROR: Database connection timeout"}),
+	}
+
 	return &LogAnalysis{
 		PodName:   "demo-app-pod",
 		Namespace: "default",
@@ -113,6 +402,8 @@ func (s *HTTPServer) getMockLogAnalysis() *LogAnalysis {
 		},
 		ErrorCount:   2,
 		WarningCount: 1,
+		Findings:     findings,
+		Summary:      summarizeFindings(findings),
 	}
 }
 
@@ -123,8 +414,9 @@ func (s *HTTPServer) handleDiagnosePod(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		Namespace string `json:"namespace"`
-		PodName   string `json:"pod_name"`
+		Namespace      string `json:"namespace"`
+		PodName        string `json:"pod_name"`
+		IncludeMetrics *bool  `json:"include_metrics"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -140,6 +432,7 @@ func (s *HTTPServer) handleDiagnosePod(w http.ResponseWriter, r *http.Request) {
 	if req.Namespace == "" {
 		req.Namespace = "default"
 	}
+	includeMetrics := req.IncludeMetrics == nil || *req.IncludeMetrics
 
 	var result *PodDiagnostic
 	var err error
@@ -148,14 +441,26 @@ func (s *HTTPServer) handleDiagnosePod(w http.ResponseWriter, r *http.Request) {
 		result = s.getMockPodDiagnostic()
 		result.Name = req.PodName
 		result.Namespace = req.Namespace
+		if includeMetrics {
+			result.Metrics = &PodMetricsSummary{
+				Window: "1h",
+				Metrics: []MetricValue{
+					{Template: "container_restarts", Description: "Container restarts during the window (kube-state-metrics)", Container: "app-container", Value: 3, Unit: "count"},
+					{Template: "memory_vs_limit", Description: "Peak memory working set during the window relative to the memory limit", Container: "app-container", Value: 0.97, Unit: "ratio"},
+					{Template: "http_5xx_rate", Description: "Share of HTTP requests answered with a 5xx status", Value: 0.042, Unit: "ratio"},
+				},
+			}
+		}
 	} else {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-		result, err = s.diagnostics.diagnosePod(ctx, req.Namespace, req.PodName)
+		ctx := r.Context()
+		result, err = s.diagnosticsFor(r).diagnosePod(ctx, req.Namespace, req.PodName)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to diagnose pod: %v", err), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Failed to diagnose pod: %v", err), errorStatus(err))
 			return
 		}
+		if includeMetrics {
+			result.Metrics = s.diagnosticsFor(r).podMetricsSummary(ctx, req.Namespace, req.PodName)
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -174,11 +479,10 @@ func (s *HTTPServer) handleAnalyzeClusterHealth(w http.ResponseWriter, r *http.R
 	if s.demoMode {
 		result = s.getMockClusterHealth()
 	} else {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-		result, err = s.diagnostics.analyzeClusterHealth(ctx)
+		ctx := r.Context()
+		result, err = s.diagnosticsFor(r).analyzeClusterHealth(ctx)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Cluster health analysis failed: %v", err), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Cluster health analysis failed: %v", err), errorStatus(err))
 			return
 		}
 	}
@@ -227,11 +531,10 @@ func (s *HTTPServer) handleAnalyzePodLogs(w http.ResponseWriter, r *http.Request
 		result.Namespace = req.Namespace
 		result.LogLines = req.Lines
 	} else {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-		result, err = s.diagnostics.analyzePodLogs(ctx, req.Namespace, req.PodName, req.Container, int64(req.Lines))
+		ctx := r.Context()
+		result, err = s.diagnosticsFor(r).analyzePodLogs(ctx, req.Namespace, req.PodName, req.Container, int64(req.Lines))
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Log analysis failed: %v", err), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Log analysis failed: %v", err), errorStatus(err))
 			return
 		}
 	}
@@ -260,106 +563,18 @@ func (s *HTTPServer) handleListPods(w http.ResponseWriter, r *http.Request) {
 		req.Namespace = "default"
 	}
 
-	var result map[string]interface{}
+	var result *PodListResult
+	var err error
 
 	if s.demoMode {
-		// Mock pod list
-		pods := []map[string]interface{}{
-			{
-				"name":      "demo-app-pod",
-				"namespace": "default",
-				"status":    "Running",
-				"ready":     "1/1",
-				"restarts":  2,
-				"age":       "2h30m",
-			},
-			{
-				"name":      "demo-db-pod",
-				"namespace": "default",
-				"status":    "Running",
-				"ready":     "1/1",
-				"restarts":  0,
-				"age":       "1h45m",
-			},
-			{
-				"name":      "demo-web-pod",
-				"namespace": "default",
-				"status":    "Pending",
-				"ready":     "0/1",
-				"restarts":  0,
-				"age":       "5m",
-			},
-		}
-
-		result = map[string]interface{}{
-			"namespace": req.Namespace,
-			"pod_count": len(pods),
-			"pods":      pods,
-		}
+		result = s.getMockPodList(req.Namespace)
 	} else {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-
-		var pods *corev1.PodList
-		var err error
-
-		if req.Namespace == "all" || req.ShowSystem {
-			pods, err = s.diagnostics.clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
-		} else {
-			pods, err = s.diagnostics.clientset.CoreV1().Pods(req.Namespace).List(ctx, metav1.ListOptions{})
-		}
-
+		ctx := r.Context()
+		result, err = s.diagnosticsFor(r).listPods(ctx, req.Namespace, req.ShowSystem)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to list pods: %v", err), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Failed to list pods: %v", err), errorStatus(err))
 			return
 		}
-
-		type PodInfo struct {
-			Name      string `json:"name"`
-			Namespace string `json:"namespace"`
-			Status    string `json:"status"`
-			Ready     string `json:"ready"`
-			Restarts  int32  `json:"restarts"`
-			Age       string `json:"age"`
-		}
-
-		var podList []PodInfo
-		for _, pod := range pods.Items {
-			// Skip system namespaces unless explicitly requested
-			if !req.ShowSystem && (filepath.HasPrefix(pod.Namespace, "kube-") ||
-				pod.Namespace == "kube-system" || pod.Namespace == "kube-public" ||
-				pod.Namespace == "kube-node-lease") {
-				continue
-			}
-
-			readyCount := 0
-			totalCount := len(pod.Status.ContainerStatuses)
-			restarts := int32(0)
-
-			for _, cs := range pod.Status.ContainerStatuses {
-				if cs.Ready {
-					readyCount++
-				}
-				restarts += cs.RestartCount
-			}
-
-			age := time.Since(pod.CreationTimestamp.Time).Truncate(time.Second).String()
-
-			podList = append(podList, PodInfo{
-				Name:      pod.Name,
-				Namespace: pod.Namespace,
-				Status:    string(pod.Status.Phase),
-				Ready:     fmt.Sprintf("%d/%d", readyCount, totalCount),
-				Restarts:  restarts,
-				Age:       age,
-			})
-		}
-
-		result = map[string]interface{}{
-			"namespace": req.Namespace,
-			"pod_count": len(podList),
-			"pods":      podList,
-		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -395,11 +610,10 @@ func (s *HTTPServer) handleFindProblematicPods(w http.ResponseWriter, r *http.Re
 			*s.getMockPodDiagnostic(),
 		}
 	} else {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-		result, err = s.diagnostics.findProblematicPods(ctx, req.Namespace, req.Criteria)
+		ctx := r.Context()
+		result, err = s.diagnosticsFor(r).findProblematicPods(ctx, req.Namespace, req.Criteria)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to find problematic pods: %v", err), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Failed to find problematic pods: %v", err), errorStatus(err))
 			return
 		}
 	}
@@ -409,6 +623,7 @@ func (s *HTTPServer) handleFindProblematicPods(w http.ResponseWriter, r *http.Re
 		"namespace":        req.Namespace,
 		"problem_count":    len(result),
 		"problematic_pods": result,
+		"summary":          summarizeFindings(podFindings(result)),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -432,57 +647,93 @@ func (s *HTTPServer) handleGetResourceUsage(w http.ResponseWriter, r *http.Reque
 	}
 
 	if req.SortBy == "" {
-		req.SortBy = "restarts"
+		req.SortBy = SortByRestarts
 	}
 
-	var result []PodResourceInfo
+	// Validate the sort key up front so a typo is a client error
+	if err := sortResourceInfo(nil, req.SortBy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var result *ResourceUsageReport
 	var err error
 
 	if s.demoMode {
 		// Mock resource usage
-		result = []PodResourceInfo{
-			{
-				Name:              "demo-app-pod",
-				Namespace:         "default",
-				CPURequest:        "100m",
-				MemoryRequest:     "128Mi",
-				CPULimit:          "200m",
-				MemoryLimit:       "256Mi",
-				RestartCount:      2,
-				Status:            "Running",
-				HasResourceIssues: true,
+		app := PodResourceInfo{
+			Name:      "demo-app-pod",
+			Namespace: "default",
+			ResourceAmounts: ResourceAmounts{
+				CPURequestMillis:   100,
+				CPULimitMillis:     200,
+				CPUUsageMillis:     150,
+				MemoryRequestBytes: 128 << 20,
+				MemoryLimitBytes:   256 << 20,
+				MemoryUsageBytes:   240 << 20,
 			},
-			{
-				Name:              "demo-db-pod",
-				Namespace:         "default",
-				CPURequest:        "500m",
-				MemoryRequest:     "512Mi",
-				CPULimit:          "1000m",
-				MemoryLimit:       "1Gi",
-				RestartCount:      0,
-				Status:            "Running",
-				HasResourceIssues: false,
+			RestartCount:      2,
+			Status:            "Running",
+			HasResourceIssues: true,
+			MetricsAvailable:  true,
+		}
+		db := PodResourceInfo{
+			Name:      "demo-db-pod",
+			Namespace: "default",
+			ResourceAmounts: ResourceAmounts{
+				CPURequestMillis:   500,
+				CPULimitMillis:     1000,
+				CPUUsageMillis:     120,
+				MemoryRequestBytes: 512 << 20,
+				MemoryLimitBytes:   1 << 30,
+				MemoryUsageBytes:   300 << 20,
 			},
+			RestartCount:      0,
+			Status:            "Running",
+			HasResourceIssues: false,
+			MetricsAvailable:  true,
+		}
+		pods := []PodResourceInfo{app, db}
+		throttling := []float64{38.5, 0.4}
+		findings := []Finding{}
+		for i := range pods {
+			pods[i].setPercentages()
+			c := ContainerResourceInfo{
+				Name:                 "app-container",
+				ResourceAmounts:      pods[i].ResourceAmounts,
+				RestartCount:         pods[i].RestartCount,
+				CPUThrottlingPercent: &throttling[i],
+			}
+			ref := ObjectRef{Kind: "Pod", Namespace: pods[i].Namespace, Name: pods[i].Name, Container: c.Name}
+			c.Findings = containerResourceFindings(ref, corev1.Container{Name: c.Name, Resources: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceCPU: *resource.NewMilliQuantity(c.CPULimitMillis, resource.DecimalSI)},
+			}}, nil, c, DefaultThresholds())
+			pods[i].Containers = []ContainerResourceInfo{c}
+			findings = append(findings, c.Findings...)
+		}
+		sortResourceInfo(pods, req.SortBy)
+		sortFindings(findings)
+		result = &ResourceUsageReport{
+			Namespace:        req.Namespace,
+			SortBy:           req.SortBy,
+			PodCount:         len(pods),
+			MetricsAvailable: true,
+			PressureSource:   PressureSourceKubelet,
+			ResourceUsage:    pods,
+			Findings:         findings,
+			Summary:          summarizeFindings(findings),
 		}
 	} else {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-		result, err = s.diagnostics.getResourceUsage(ctx, req.Namespace, req.SortBy)
+		ctx := r.Context()
+		result, err = s.diagnosticsFor(r).getResourceUsage(ctx, req.Namespace, req.SortBy)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Resource usage analysis failed: %v", err), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Resource usage analysis failed: %v", err), errorStatus(err))
 			return
 		}
 	}
 
-	response := map[string]interface{}{
-		"namespace":      req.Namespace,
-		"sort_by":        req.SortBy,
-		"pod_count":      len(result),
-		"resource_usage": result,
-	}
-
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(result)
 }
 
 func (s *HTTPServer) handleQuickTriage(w http.ResponseWriter, r *http.Request) {
@@ -495,15 +746,17 @@ func (s *HTTPServer) handleQuickTriage(w http.ResponseWriter, r *http.Request) {
 
 	if s.demoMode {
 		// Mock triage data
+		clusterHealth := s.getMockClusterHealth()
 		response = map[string]interface{}{
 			"timestamp":      time.Now(),
-			"cluster_health": s.getMockClusterHealth(),
+			"cluster_health": clusterHealth,
 			"critical_pods": []PodDiagnostic{
 				*s.getMockPodDiagnostic(),
 			},
 			"restarting_pods": []PodDiagnostic{
 				*s.getMockPodDiagnostic(),
 			},
+			"summary": clusterHealth.Summary,
 			"immediate_actions": []string{
 				"Check critical/failing pods first",
 				"Investigate high restart count pods",
@@ -512,27 +765,26 @@ func (s *HTTPServer) handleQuickTriage(w http.ResponseWriter, r *http.Request) {
 			},
 		}
 	} else {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
+		ctx := r.Context()
 
 		// Get cluster health
-		clusterHealth, err := s.diagnostics.analyzeClusterHealth(ctx)
+		clusterHealth, err := s.diagnosticsFor(r).analyzeClusterHealth(ctx)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Cluster health check failed: %v", err), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Cluster health check failed: %v", err), errorStatus(err))
 			return
 		}
 
 		// Find critical issues
-		criticalPods, err := s.diagnostics.findProblematicPods(ctx, "", "failing")
+		criticalPods, err := s.diagnosticsFor(r).findProblematicPods(ctx, "", "failing")
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to find critical pods: %v", err), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Failed to find critical pods: %v", err), errorStatus(err))
 			return
 		}
 
 		// Find high restart pods
-		restartingPods, err := s.diagnostics.findProblematicPods(ctx, "", "restarting")
+		restartingPods, err := s.diagnosticsFor(r).findProblematicPods(ctx, "", "restarting")
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to find restarting pods: %v", err), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Failed to find restarting pods: %v", err), errorStatus(err))
 			return
 		}
 
@@ -541,6 +793,7 @@ func (s *HTTPServer) handleQuickTriage(w http.ResponseWriter, r *http.Request) {
 			"cluster_health":  clusterHealth,
 			"critical_pods":   criticalPods,
 			"restarting_pods": restartingPods,
+			"summary":         clusterHealth.Summary,
 			"immediate_actions": []string{
 				"Check critical/failing pods first",
 				"Investigate high restart count pods",
@@ -573,24 +826,63 @@ func (s *HTTPServer) handleGetWorkloadRecommendations(w http.ResponseWriter, r *
 		req.Namespace = "default"
 	}
 
-	var result []string
+	var result *WorkloadRecommendations
 	var err error
 
 	if s.demoMode {
 		// Mock recommendations
-		result = []string{
-			"Set resource requests and limits for all containers",
-			"Configure health checks (liveness and readiness probes)",
-			"Use multiple replicas for high availability",
-			"Implement proper logging and monitoring",
-			"Review security policies and RBAC",
+		ref := ObjectRef{Kind: "Deployment", Namespace: req.Namespace, Name: "demo-app"}
+		containerRef := ref
+		containerRef.Container = "app-container"
+		findings := []Finding{
+			newFinding(findingWorkloadNoLimits, ref,
+				fmt.Sprintf("Deployment %s/demo-app should have resource limits", req.Namespace), nil),
+			newFinding(findingSingleReplica, ref,
+				fmt.Sprintf("Deployment %s/demo-app has only 1 replica - consider scaling for HA", req.Namespace), nil),
+			newFinding(findingNoReadinessProbe, containerRef,
+				fmt.Sprintf("Container app-container in deployment %s/demo-app missing readiness probe", req.Namespace), nil),
+			newFinding(findingNoLivenessProbe, containerRef,
+				fmt.Sprintf("Container app-container in deployment %s/demo-app missing liveness probe", req.Namespace), nil),
+		}
+		replicas := int32(1)
+		deployment := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "demo-app", Namespace: req.Namespace},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+				Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+					Name: "app-container",
+					Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+						corev1.ResourceCPU:    resource.MustParse("500m"),
+						corev1.ResourceMemory: resource.MustParse("512Mi"),
+					}},
+				}}}},
+			},
+		}
+		rightSizing, finding := rightSizeContainer(deployment, 0, &usageStats{
+			Samples:      1440,
+			Window:       24 * time.Hour,
+			CPUP50Millis: 40,
+			CPUP95Millis: 85,
+			CPUMaxMillis: 210,
+			MemP50Bytes:  180 << 20,
+			MemP95Bytes:  205 << 20,
+			MemMaxBytes:  230 << 20,
+		}, "metrics-server samples")
+		findings = append(findings, *finding)
+		sortFindings(findings)
+		recommendations, _ := issuesAndSuggestions(findings)
+		result = &WorkloadRecommendations{
+			Namespace:       req.Namespace,
+			Recommendations: recommendations,
+			Findings:        findings,
+			Summary:         summarizeFindings(findings),
+			RightSizing:     []RightSizingRecommendation{rightSizing},
 		}
 	} else {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-		result, err = s.diagnostics.getWorkloadRecommendations(ctx, req.Namespace)
+		ctx := r.Context()
+		result, err = s.diagnosticsFor(r).getWorkloadRecommendations(ctx, req.Namespace)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Recommendation generation failed: %v", err), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Recommendation generation failed: %v", err), errorStatus(err))
 			return
 		}
 	}
@@ -633,11 +925,10 @@ func (s *HTTPServer) handleSearchPods(w http.ResponseWriter, r *http.Request) {
 			result = []PodDiagnostic{}
 		}
 	} else {
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-		result, err = s.diagnostics.searchPods(ctx, req.Pattern, req.Namespace)
+		ctx := r.Context()
+		result, err = s.diagnosticsFor(r).searchPods(ctx, req.Pattern, req.Namespace)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Pod search failed: %v", err), http.StatusInternalServerError)
+			http.Error(w, fmt.Sprintf("Pod search failed: %v", err), errorStatus(err))
 			return
 		}
 	}
@@ -647,12 +938,94 @@ func (s *HTTPServer) handleSearchPods(w http.ResponseWriter, r *http.Request) {
 		"namespace":      req.Namespace,
 		"matches_found":  len(result),
 		"matching_pods":  result,
+		"summary":        summarizeFindings(podFindings(result)),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
+func (s *HTTPServer) handleEvaluateRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Namespace string `json:"namespace"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.Namespace == "" {
+		req.Namespace = "default"
+	}
+
+	var result *RuleEvaluation
+	var err error
+
+	if s.demoMode {
+		result = &RuleEvaluation{
+			Namespace:        req.Namespace,
+			RulesLoaded:      2,
+			ObjectsEvaluated: 3,
+			Findings: []Finding{
+				{
+					ID:       "no-latest-tag",
+					Severity: SeverityWarning,
+					Category: CategoryImage,
+					Object:   ObjectRef{Kind: RuleKindDeployment, Namespace: req.Namespace, Name: "demo-app"},
+					Message:  "Deployment demo-app uses an image with the :latest tag",
+					Remediation: &Remediation{
+						ID:          "no-latest-tag",
+						Description: "Pin images to an immutable tag or digest",
+					},
+					Tags: []string{"images", "reliability"},
+				},
+			},
+		}
+		result.Summary = summarizeFindings(result.Findings)
+	} else {
+		ctx := r.Context()
+		result, err = s.diagnosticsFor(r).evaluateRules(ctx, req.Namespace)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Rule evaluation failed: %v", err), errorStatus(err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *HTTPServer) handleGetPolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Namespace string `json:"namespace"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	result, err := s.diagnosticsFor(r).getPolicy(req.Namespace)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get policy: %v", err), errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
 func (s *HTTPServer) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	response := map[string]interface{}{
@@ -663,6 +1036,24 @@ func (s *HTTPServer) handleHealth(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// handleLivez reports that the process is up and serving; it never checks dependencies
+func (s *HTTPServer) handleLivez(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz reports whether the server can do useful work: it fails while
+// draining for shutdown and when the Kubernetes API is unreachable
+func (s *HTTPServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	if err := s.checkReady(r.Context()); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "not ready: %v\n", err)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
 func main() {
 	fmt.Println(">>> Starting k8s-diagnostics-mcp-server-http main()")
 	// Check if we should run as HTTP server or MCP server
@@ -675,30 +1066,778 @@ func main() {
 	}
 }
 
-func runHTTPServer() {
-	server, err := NewHTTPServer()
+func (s *HTTPServer) handleQueryMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req MetricsQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.Template == "" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"templates": listMetricTemplates()})
+		return
+	}
+
+	template, err := findMetricTemplate(req.Template)
 	if err != nil {
-		log.Fatalf("Failed to create HTTP server: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	window, err := parseMetricsWindow(req.Window)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Namespace == "" {
+		req.Namespace = "default"
 	}
 
-	// Set up routes
-	http.HandleFunc("/diagnose_pod", server.handleDiagnosePod)
-	http.HandleFunc("/analyze_cluster_health", server.handleAnalyzeClusterHealth)
-	http.HandleFunc("/analyze_pod_logs", server.handleAnalyzePodLogs)
-	http.HandleFunc("/list_pods", server.handleListPods)
-	http.HandleFunc("/find_problematic_pods", server.handleFindProblematicPods)
-	http.HandleFunc("/get_resource_usage", server.handleGetResourceUsage)
-	http.HandleFunc("/quick_triage", server.handleQuickTriage)
-	http.HandleFunc("/get_workload_recommendations", server.handleGetWorkloadRecommendations)
-	http.HandleFunc("/search_pods", server.handleSearchPods)
-	http.HandleFunc("/health", server.handleHealth)
+	var result *MetricsQueryResult
 
-	// Get port from environment or default to 8080
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	if s.demoMode {
+		pods := []string{"demo-app-7d4b9c8f5-x2k9p"}
+		if req.Pod != "" {
+			pods = []string{req.Pod}
+		}
+		result = &MetricsQueryResult{
+			Template:    template.Name,
+			Description: template.Description,
+			Unit:        template.Unit,
+			Namespace:   req.Namespace,
+			Pod:         req.Pod,
+			Workload:    req.Workload,
+			Window:      promDuration(window),
+			Query:       template.render(promSelector(req.Namespace, pods), window),
+			Series: []MetricSeries{
+				{Labels: map[string]string{"pod": pods[0], "container": "app-container"}, Value: 0.42},
+			},
+		}
+	} else {
+		ctx := r.Context()
+		result, err = s.diagnosticsFor(r).queryMetrics(ctx, req)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Metrics query failed: %v", err), errorStatus(err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *HTTPServer) handleNamespaceCapacity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Namespace string `json:"namespace"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.Namespace == "" {
+		req.Namespace = "default"
 	}
 
-	log.Printf("Starting HTTP server on port %s (Demo Mode: %v)", port, server.demoMode)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	var result *NamespaceCapacity
+	var err error
+
+	if s.demoMode {
+		usedPercent := 92.5
+		podsPercent := 80.0
+		event := `pods "demo-app-7d4b9c8f5-x2k9p" is forbidden: exceeded quota: compute, requested: requests.cpu=500m, used: requests.cpu=3700m, limited: requests.cpu=4`
+		result = &NamespaceCapacity{
+			Namespace: req.Namespace,
+			Quotas: []QuotaReport{{
+				Name: "compute",
+				Resources: []QuotaResource{
+					{Resource: "pods", Used: "8", Hard: "10", Remaining: "2", UsedPercent: &podsPercent},
+					{Resource: "requests.cpu", Used: "3700m", Hard: "4", Remaining: "300m", UsedPercent: &usedPercent},
+				},
+			}},
+			LimitRanges: []LimitRangeReport{{
+				Name: "defaults",
+				Limits: []LimitRangeItem{
+					{Type: "Container", Resource: "cpu", Default: "500m", DefaultRequest: "250m"},
+					{Type: "Container", Resource: "memory", Default: "512Mi", DefaultRequest: "256Mi"},
+				},
+			}},
+			DefaultedContainers: []DefaultedContainer{},
+			Rollouts: []RolloutHeadroom{{
+				Deployment:  "demo-app",
+				Replicas:    3,
+				SurgePods:   1,
+				PerPod:      map[string]string{"pods": "1", "requests.cpu": "500m"},
+				SurgeNeeds:  map[string]string{"pods": "1", "requests.cpu": "500m"},
+				Fits:        false,
+				BlockedBy:   []string{"compute/requests.cpu: needs 500m, 300m left"},
+				Explanation: "1 surge pod(s) would exceed the quota (compute/requests.cpu: needs 500m, 300m left); the rollout stalls with FailedCreate events",
+			}},
+			QuotaEvents: []string{"ReplicaSet demo-app-7d4b9c8f5: " + event},
+			Findings: []Finding{
+				newFinding(findingQuotaExceeded, ObjectRef{Kind: "ReplicaSet", Namespace: req.Namespace, Name: "demo-app-7d4b9c8f5"},
+					fmt.Sprintf("ReplicaSet %s/demo-app-7d4b9c8f5 cannot create pods: %s", req.Namespace, event), nil),
+				newFinding(findingQuotaNearLimit, ObjectRef{Kind: "ResourceQuota", Namespace: req.Namespace, Name: "compute"},
+					fmt.Sprintf("ResourceQuota %s/compute: requests.cpu is 92.5%% used (3700m of 4)", req.Namespace), nil),
+				newFinding(findingRolloutBlocked, ObjectRef{Kind: "Deployment", Namespace: req.Namespace, Name: "demo-app"},
+					fmt.Sprintf("Deployment %s/demo-app: 1 surge pod(s) would exceed the quota (compute/requests.cpu: needs 500m, 300m left); the rollout stalls with FailedCreate events", req.Namespace), nil),
+			},
+		}
+		result.Summary = summarizeFindings(result.Findings)
+	} else {
+		ctx := r.Context()
+		result, err = s.diagnosticsFor(r).namespaceCapacity(ctx, req.Namespace)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Namespace capacity analysis failed: %v", err), errorStatus(err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *HTTPServer) handleClusterCapacity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Namespace  string `json:"namespace"`
+		Deployment string `json:"deployment"`
+		Replicas   int    `json:"replicas"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.Namespace == "" {
+		req.Namespace = "default"
+	}
+
+	if req.Replicas <= 0 {
+		req.Replicas = 1
+	}
+	if req.Replicas > maxSimulatedReplicas {
+		http.Error(w, fmt.Sprintf("replicas must be at most %d", maxSimulatedReplicas), http.StatusBadRequest)
+		return
+	}
+
+	var result *ClusterCapacity
+	var err error
+
+	if s.demoMode {
+		result = s.getMockClusterCapacity(req.Deployment, req.Replicas)
+	} else {
+		ctx := r.Context()
+		result, err = s.diagnosticsFor(r).clusterCapacity(ctx, req.Namespace, req.Deployment, req.Replicas)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Cluster capacity analysis failed: %v", err), errorStatus(err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *HTTPServer) getMockServiceDiagnostic(namespace, name string) *ServiceDiagnostic {
+	ref := ObjectRef{Kind: "Service", Namespace: namespace, Name: name}
+	findings := []Finding{
+		newFinding(findingServiceTargetPortMismatch, ref,
+			fmt.Sprintf(`Service %s/%s port http/80 (targetPort web): no container port named "web" in 2 pod(s), so they get no endpoint for this port`, namespace, name),
+			map[string]string{"pods": "demo-app-7d4b9c8f5-x2k9p, demo-app-7d4b9c8f5-q8w4n"}),
+		newFinding(findingServiceNoReadyEndpoints, ref,
+			fmt.Sprintf("Service %s/%s has no ready endpoints: connections are refused or time out (not ready: demo-app-7d4b9c8f5-q8w4n; check their readiness probes)", namespace, name),
+			map[string]string{"matching_pods": "2", "ready_pods": "1", "not_ready_pods": "demo-app-7d4b9c8f5-q8w4n"}),
+	}
+	sortFindings(findings)
+
+	return &ServiceDiagnostic{
+		Name:         name,
+		Namespace:    namespace,
+		Type:         "ClusterIP",
+		ClusterIP:    "10.96.14.21",
+		Selector:     map[string]string{"app": "demo-app"},
+		MatchingPods: []string{"demo-app-7d4b9c8f5-x2k9p", "demo-app-7d4b9c8f5-q8w4n"},
+		ReadyPods:    1,
+		Ports: []ServicePortCheck{
+			{Name: "http", Port: 80, TargetPort: "web", Protocol: "TCP", ResolvedPods: 0},
+		},
+		EndpointSlices: []EndpointSliceSummary{
+			{Name: name + "-h7k2m", AddressType: "IPv4", Ports: []string{}, Ready: 0, NotReady: 1},
+		},
+		Findings: findings,
+		Summary:  summarizeFindings(findings),
+	}
+}
+
+func (s *HTTPServer) handleDiagnoseService(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Namespace   string `json:"namespace"`
+		ServiceName string `json:"service_name"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.ServiceName == "" {
+		http.Error(w, "service_name is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.Namespace == "" {
+		req.Namespace = "default"
+	}
+
+	var result *ServiceDiagnostic
+	var err error
+
+	if s.demoMode {
+		result = s.getMockServiceDiagnostic(req.Namespace, req.ServiceName)
+	} else {
+		ctx := r.Context()
+		result, err = s.diagnosticsFor(r).diagnoseService(ctx, req.Namespace, req.ServiceName)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Service diagnosis failed: %v", err), errorStatus(err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *HTTPServer) getMockNetworkPath(req NetworkPathRequest) *NetworkPathResult {
+	source := fmt.Sprintf("%s/%s", req.SourceNamespace, req.SourcePod)
+	ingress := DirectionVerdict{
+		Isolated: true,
+		Policies: []PolicyEvaluation{
+			{Policy: req.DestinationNamespace + "/allow-frontend", RulesChecked: 1},
+			{Policy: req.DestinationNamespace + "/default-deny", RulesChecked: 0},
+		},
+		Explanation: fmt.Sprintf("ingress to demo-api-6f8d7-k2x9p is denied: %s/allow-frontend, %s/default-deny select the pod but no rule matches this peer and port",
+			req.DestinationNamespace, req.DestinationNamespace),
+	}
+	check := NetworkPathCheck{
+		DestinationPod: "demo-api-6f8d7-k2x9p",
+		DestinationIP:  "10.244.1.17",
+		Port:           8080,
+		Egress: DirectionVerdict{
+			Allowed:     true,
+			Policies:    []PolicyEvaluation{},
+			Explanation: fmt.Sprintf("no NetworkPolicy selects %s for egress, so all traffic is allowed", req.SourcePod),
+		},
+		Ingress: ingress,
+	}
+	findings := []Finding{
+		newFinding(findingNetworkPolicyDenied, ObjectRef{Kind: "Pod", Namespace: req.DestinationNamespace, Name: check.DestinationPod},
+			fmt.Sprintf("%s -> %s/%s port 8080/TCP: %s", source, req.DestinationNamespace, check.DestinationPod, ingress.Explanation),
+			map[string]string{"policies": fmt.Sprintf("%s/allow-frontend, %s/default-deny", req.DestinationNamespace, req.DestinationNamespace)}),
+	}
+
+	destination := fmt.Sprintf("%s/%s", req.DestinationNamespace, req.DestinationPod)
+	if req.DestinationService != "" {
+		destination = fmt.Sprintf("service %s/%s", req.DestinationNamespace, req.DestinationService)
+	}
+	return &NetworkPathResult{
+		Source:      source,
+		Destination: destination,
+		Protocol:    "TCP",
+		Allowed:     false,
+		Paths:       []NetworkPathCheck{check},
+		Explanation: fmt.Sprintf("NetworkPolicies deny %s to %s", source, destination),
+		Findings:    findings,
+		Summary:     summarizeFindings(findings),
+	}
+}
+
+func (s *HTTPServer) handleCheckNetworkPath(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req NetworkPathRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.SourcePod == "" {
+		http.Error(w, "source_pod is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.SourceNamespace == "" {
+		req.SourceNamespace = "default"
+	}
+
+	if req.DestinationNamespace == "" {
+		req.DestinationNamespace = req.SourceNamespace
+	}
+
+	var result *NetworkPathResult
+	var err error
+
+	if s.demoMode {
+		result = s.getMockNetworkPath(req)
+	} else {
+		ctx := r.Context()
+		result, err = s.diagnosticsFor(r).checkNetworkPath(ctx, req)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Network path check failed: %v", err), errorStatus(err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *HTTPServer) getMockIngressDiagnostic(namespace string) *IngressDiagnostic {
+	ingressRef := ObjectRef{Kind: "Ingress", Namespace: namespace, Name: "demo-app"}
+	routeRef := ObjectRef{Kind: "HTTPRoute", Namespace: namespace, Name: "demo-api"}
+	findings := []Finding{
+		newFinding(findingRouteTLSSecretMissing, ingressRef,
+			fmt.Sprintf("Ingress %s/demo-app references TLS secret %s/demo-app-tls, which does not exist: the controller serves a default certificate or drops HTTPS", namespace, namespace),
+			map[string]string{"secret": "demo-app-tls"}),
+		newFinding(findingRouteBackendUnhealthy, routeRef,
+			fmt.Sprintf("HTTPRoute %s/demo-api api.demo.example.com/v1: service %s/demo-api has no ready endpoints, requests get 503s (see diagnose_service)", namespace, namespace),
+			map[string]string{"service": "demo-api"}),
+		newFinding(findingRouteOverlap, ingressRef,
+			fmt.Sprintf("Ingress %s/demo-app rule demo.example.com/ is also defined by staging/demo-app: the controller picks one and the other is silently ignored", namespace),
+			map[string]string{"host": "demo.example.com", "path": "/"}),
+	}
+	sortFindings(findings)
+
+	return &IngressDiagnostic{
+		Namespace: namespace,
+		Ingresses: []IngressReport{{
+			Name:      "demo-app",
+			Class:     "nginx",
+			Addresses: []string{"203.0.113.10"},
+			TLS:       []TLSReport{{Secret: "demo-app-tls", Hosts: []string{"demo.example.com"}, Status: "missing"}},
+			Routes: []RouteBackend{
+				{Host: "demo.example.com", Path: "/", Service: namespace + "/demo-app", Port: "80", ReadyEndpoints: 3, Verdict: BackendHealthy},
+			},
+		}},
+		HTTPRoutes: []HTTPRouteReport{{
+			Name:      "demo-api",
+			Hostnames: []string{"api.demo.example.com"},
+			Parents: []RouteParent{
+				{Gateway: "infra/public", GatewayClass: "istio", Found: true, Accepted: "True", ResolvedRefs: "True"},
+			},
+			Routes: []RouteBackend{
+				{Host: "api.demo.example.com", Path: "/v1", Service: namespace + "/demo-api", Port: "8080", ReadyEndpoints: 0, Verdict: BackendNoReadyEndpoints},
+			},
+		}},
+		GatewayAPI: GatewayAPIAvailable,
+		Findings:   findings,
+		Summary:    summarizeFindings(findings),
+	}
+}
+
+func (s *HTTPServer) handleDiagnoseIngress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.Namespace == "" {
+		req.Namespace = "default"
+	}
+
+	var result *IngressDiagnostic
+	var err error
+
+	if s.demoMode {
+		result = s.getMockIngressDiagnostic(req.Namespace)
+	} else {
+		ctx := r.Context()
+		result, err = s.diagnosticsFor(r).diagnoseIngress(ctx, req.Namespace, req.Name)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Ingress diagnosis failed: %v", err), errorStatus(err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *HTTPServer) getMockStorageDiagnostic(namespace, podName string) *StorageDiagnostic {
+	claimRef := ObjectRef{Kind: "PersistentVolumeClaim", Namespace: namespace, Name: "data-" + podName}
+	podRef := ObjectRef{Kind: "Pod", Namespace: namespace, Name: podName}
+	findings := []Finding{
+		newFinding(findingStorageMultiAttach, claimRef,
+			fmt.Sprintf("PVC %s/data-%s is ReadWriteOnce but is also used by demo-app-6d4cf56db6-x8k2p on worker-2: only one node (or pod) can mount it", namespace, podName),
+			map[string]string{"node": "worker-1"}),
+		newFinding(findingStorageAttachFailed, podRef,
+			fmt.Sprintf("Pod %s/%s: FailedAttachVolume: Multi-Attach error for volume \"pvc-3f2a\" Volume is already used by pod(s) demo-app-6d4cf56db6-x8k2p", namespace, podName),
+			map[string]string{"count": "12"}),
+	}
+	sortFindings(findings)
+
+	return &StorageDiagnostic{
+		Pod:       podName,
+		Namespace: namespace,
+		Node:      "worker-1",
+		Volumes: []VolumeReport{{
+			Volume:           "data",
+			Claim:            "data-" + podName,
+			ClaimPhase:       "Bound",
+			AccessModes:      []string{"ReadWriteOnce"},
+			Requested:        "10Gi",
+			Capacity:         "10Gi",
+			PersistentVolume: "pvc-3f2a",
+			VolumePhase:      "Bound",
+			StorageClass:     "standard",
+			Provisioner:      "ebs.csi.aws.com",
+			BindingMode:      "WaitForFirstConsumer",
+			Attachments:      []AttachmentReport{{Name: "csi-8b1e", Node: "worker-2", Attached: true}},
+			SharedWith:       []string{"demo-app-6d4cf56db6-x8k2p"},
+		}},
+		Events: []string{
+			"FailedAttachVolume: Multi-Attach error for volume \"pvc-3f2a\" Volume is already used by pod(s) demo-app-6d4cf56db6-x8k2p",
+		},
+		Findings: findings,
+		Summary:  summarizeFindings(findings),
+	}
+}
+
+func (s *HTTPServer) handleDiagnoseStorage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Namespace string `json:"namespace"`
+		PodName   string `json:"pod_name"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.PodName == "" {
+		http.Error(w, "pod_name is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.Namespace == "" {
+		req.Namespace = "default"
+	}
+
+	var result *StorageDiagnostic
+	var err error
+
+	if s.demoMode {
+		result = s.getMockStorageDiagnostic(req.Namespace, req.PodName)
+	} else {
+		ctx := r.Context()
+		result, err = s.diagnosticsFor(r).diagnoseStorage(ctx, req.Namespace, req.PodName)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Storage diagnosis failed: %v", err), errorStatus(err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *HTTPServer) getMockSecurityAudit(namespace string) *SecurityAudit {
+	ref := ObjectRef{Kind: "Deployment", Namespace: namespace, Name: "log-collector", Container: "collector"}
+	findings := []Finding{
+		newFinding(findingPSSNotEnforced, ObjectRef{Kind: "Namespace", Name: namespace},
+			fmt.Sprintf("Namespace %s does not enforce a Pod Security Standards level (violations are only warned about or audited)", namespace), nil),
+		newFinding(findingPSSHostPath, ref,
+			fmt.Sprintf("Deployment %s/log-collector: volume varlog mounts host path /var/log", namespace),
+			map[string]string{"field": "spec.volumes[varlog].hostPath", "level": PSSBaseline}),
+		newFinding(findingPSSPrivileged, ref,
+			fmt.Sprintf("Deployment %s/log-collector: container collector is privileged", namespace),
+			map[string]string{"field": "spec.containers[collector].securityContext.privileged", "level": PSSBaseline}),
+		newFinding(findingPSSRunAsNonRoot, ObjectRef{Kind: "Deployment", Namespace: namespace, Name: "demo-app", Container: "app"},
+			fmt.Sprintf("Deployment %s/demo-app: container app does not set runAsNonRoot: true", namespace),
+			map[string]string{"field": "spec.containers[app].securityContext.runAsNonRoot", "level": PSSRestricted}),
+	}
+	sortFindings(findings)
+
+	return &SecurityAudit{
+		Namespace:   namespace,
+		PodSecurity: NamespacePodSecurity{Warn: PSSRestricted},
+		Workloads: []WorkloadSecurity{
+			{Kind: "Deployment", Name: "demo-app", Level: PSSBaseline, Violations: 1},
+			{Kind: "Deployment", Name: "log-collector", Level: PSSPrivileged, Violations: 6},
+		},
+		Findings: findings,
+		Summary:  summarizeFindings(findings),
+	}
+}
+
+func (s *HTTPServer) handleAuditSecurity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Namespace string `json:"namespace"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.Namespace == "" {
+		req.Namespace = "default"
+	}
+
+	var result *SecurityAudit
+	var err error
+
+	if s.demoMode {
+		result = s.getMockSecurityAudit(req.Namespace)
+	} else {
+		ctx := r.Context()
+		result, err = s.diagnosticsFor(r).auditSecurity(ctx, req.Namespace)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Security audit failed: %v", err), errorStatus(err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *HTTPServer) getMockRBACAnalysis(namespace, serviceAccount string, check *AccessCheckRequest) *RBACAnalysis {
+	ref := ObjectRef{Kind: "ServiceAccount", Namespace: namespace, Name: serviceAccount}
+	rules := []EffectiveRule{
+		{Scope: namespace, Verbs: []string{"get", "list", "watch"}, APIGroups: []string{""}, Resources: []string{"pods", "pods/log", "secrets"},
+			Via: fmt.Sprintf("RoleBinding %s/%s-reader -> Role pod-reader", namespace, serviceAccount)},
+		{Scope: clusterScope, Verbs: []string{"*"}, APIGroups: []string{"apps"}, Resources: []string{"deployments"},
+			Via: "ClusterRoleBinding deploy-bot -> ClusterRole deployment-manager"},
+	}
+	findings := []Finding{
+		newFinding(findingRBACWildcard, ref,
+			"Wildcard rule * deployments (apps) in all namespaces (ClusterRoleBinding deploy-bot -> ClusterRole deployment-manager)",
+			map[string]string{"via": rules[1].Via, "scope": clusterScope, "rule": ruleString(rules[1])}),
+		newFinding(findingRBACSecretsRead, ref,
+			fmt.Sprintf("Can read all secrets in namespace %s (%s)", namespace, rules[0].Via),
+			map[string]string{"via": rules[0].Via, "scope": namespace, "rule": ruleString(rules[0])}),
+	}
+	findings[0].Severity = SeverityCritical
+	sortFindings(findings)
+
+	result := &RBACAnalysis{
+		ServiceAccount: serviceAccount,
+		Namespace:      namespace,
+		Exists:         true,
+		Bindings: []RBACBinding{
+			{Kind: "ClusterRoleBinding", Name: "deploy-bot", RoleKind: "ClusterRole", Role: "deployment-manager", Subject: "ServiceAccount " + serviceAccount},
+			{Kind: "RoleBinding", Name: serviceAccount + "-reader", Namespace: namespace, RoleKind: "Role", Role: "pod-reader", Subject: "ServiceAccount " + serviceAccount},
+		},
+		Rules:    rules,
+		Findings: findings,
+		Summary:  summarizeFindings(findings),
+	}
+	if check != nil {
+		local := check.withDefaults(namespace)
+		matched := evaluateAccess(rules, local)
+		result.Check = &AccessCheck{AccessCheckRequest: local, Allowed: matched != nil, LocalAllowed: matched != nil, Method: "local", MatchedRule: matched}
+	}
+	return result
+}
+
+func (s *HTTPServer) handleAnalyzeRBAC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Namespace      string              `json:"namespace"`
+		ServiceAccount string              `json:"service_account"`
+		Check          *AccessCheckRequest `json:"check"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if req.ServiceAccount == "" {
+		http.Error(w, "service_account is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.Check != nil && (req.Check.Verb == "" || req.Check.Resource == "") {
+		http.Error(w, "check.verb and check.resource are required", http.StatusBadRequest)
+		return
+	}
+
+	if req.Namespace == "" {
+		req.Namespace = "default"
+	}
+
+	var result *RBACAnalysis
+	var err error
+
+	if s.demoMode {
+		result = s.getMockRBACAnalysis(req.Namespace, req.ServiceAccount, req.Check)
+	} else {
+		ctx := r.Context()
+		result, err = s.diagnosticsFor(r).analyzeRBAC(ctx, req.Namespace, req.ServiceAccount, req.Check)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("RBAC analysis failed: %v", err), errorStatus(err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *HTTPServer) getMockPermissionReport() *PermissionReport {
+	// A typical read-only role missing kubelet proxy access and VolumeAttachments
+	denied := map[string]bool{"nodes/proxy": true, "volumeattachments": true}
+	checks := []PermissionCheck{}
+	for _, permission := range requiredPermissions {
+		check := PermissionCheck{
+			Group:    permission.Group,
+			Resource: permission.Resource,
+			Verb:     permission.Verb,
+			Allowed:  !denied[permission.Resource],
+			Tools:    permission.Tools,
+		}
+		if check.Allowed {
+			check.Reason = `RBAC: allowed by ClusterRoleBinding "k8s-diagnostics-mcp"`
+		}
+		checks = append(checks, check)
+	}
+	report, _ := permissionReport(checks)
+	return report
+}
+
+func (s *HTTPServer) handleCheckPermissions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var result *PermissionReport
+	var err error
+
+	if s.demoMode {
+		result = s.getMockPermissionReport()
+	} else {
+		ctx := r.Context()
+		result, err = s.diagnosticsFor(r).checkPermissions(ctx)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Permission check failed: %v", err), errorStatus(err))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func runHTTPServer() {
+	server, err := NewHTTPServer()
+	if err != nil {
+		log.Fatalf("Failed to create HTTP server: %v", err)
+	}
+
+	// Set up routes on a dedicated mux so nothing registered on
+	// http.DefaultServeMux by a dependency is exposed
+	mux := http.NewServeMux()
+	mux.HandleFunc("/diagnose_pod", server.tool(server.handleDiagnosePod))
+	mux.HandleFunc("/analyze_cluster_health", server.tool(server.handleAnalyzeClusterHealth))
+	mux.HandleFunc("/analyze_pod_logs", server.tool(server.handleAnalyzePodLogs))
+	mux.HandleFunc("/list_pods", server.tool(server.handleListPods))
+	mux.HandleFunc("/find_problematic_pods", server.tool(server.handleFindProblematicPods))
+	mux.HandleFunc("/get_resource_usage", server.tool(server.handleGetResourceUsage))
+	mux.HandleFunc("/quick_triage", server.tool(server.handleQuickTriage))
+	mux.HandleFunc("/get_workload_recommendations", server.tool(server.handleGetWorkloadRecommendations))
+	mux.HandleFunc("/search_pods", server.tool(server.handleSearchPods))
+	mux.HandleFunc("/get_policy", server.tool(server.handleGetPolicy))
+	mux.HandleFunc("/evaluate_rules", server.tool(server.handleEvaluateRules))
+	mux.HandleFunc("/query_metrics", server.tool(server.handleQueryMetrics))
+	mux.HandleFunc("/namespace_capacity", server.tool(server.handleNamespaceCapacity))
+	mux.HandleFunc("/cluster_capacity", server.tool(server.handleClusterCapacity))
+	mux.HandleFunc("/diagnose_service", server.tool(server.handleDiagnoseService))
+	mux.HandleFunc("/check_network_path", server.tool(server.handleCheckNetworkPath))
+	mux.HandleFunc("/diagnose_ingress", server.tool(server.handleDiagnoseIngress))
+	mux.HandleFunc("/diagnose_storage", server.tool(server.handleDiagnoseStorage))
+	mux.HandleFunc("/audit_security", server.tool(server.handleAuditSecurity))
+	mux.HandleFunc("/analyze_rbac", server.tool(server.handleAnalyzeRBAC))
+	mux.HandleFunc("/check_permissions", server.tool(server.handleCheckPermissions))
+	mux.HandleFunc("/health", server.handleHealth)
+	mux.HandleFunc("/livez", server.handleLivez)
+	mux.HandleFunc("/readyz", server.handleReadyz)
+	if !serveMetricsFromEnv(server.metrics) {
+		// On the main port /metrics needs the same credentials as the tools
+		mux.Handle("/metrics", server.authenticated(server.metrics.handler()))
+	}
+
+	// Get port from environment or default to 8080
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	httpServer, err := newServerFromEnv(":"+port, mux, server.tlsConfig)
+	if err != nil {
+		log.Fatalf("Failed to configure HTTP server: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	scheme := "HTTP"
+	if server.tlsConfig != nil {
+		scheme = "HTTPS"
+	}
+	log.Printf("Starting %s server on port %s (Demo Mode: %v)", scheme, port, server.demoMode)
+	if err := server.serveUntilSignal(ctx, httpServer); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAuthorizeCountsRejectedCalls(t *testing.T) {
+	s := &HTTPServer{
+		authenticators: []Authenticator{&tokenFileAuthenticator{tokens: map[string]Identity{
+			"dash": {User: "dashboard"},
+		}}},
+		scopes:  &Scopes{Scopes: []Scope{{Users: []string{"dashboard"}, Tools: []string{"list_pods"}}}},
+		metrics: getServerMetrics(),
+	}
+	handler := s.authorize(func(w http.ResponseWriter, r *http.Request) {})
+	count := func(outcome string) float64 {
+		return testutil.ToFloat64(s.metrics.toolCalls.WithLabelValues("audit_security", outcome))
+	}
+	unauthorized, forbidden := count(OutcomeUnauthorized), count(OutcomeForbidden)
+
+	call := func(token string) int {
+		r := httptest.NewRequest("POST", "/audit_security", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Code
+	}
+	if code := call(""); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without credentials, got %d", code)
+	}
+	if code := call("dash"); code != http.StatusForbidden {
+		t.Errorf("expected 403 outside the scope, got %d", code)
+	}
+	if got := count(OutcomeUnauthorized) - unauthorized; got != 1 {
+		t.Errorf("expected one unauthorized call counted, got %v", got)
+	}
+	if got := count(OutcomeForbidden) - forbidden; got != 1 {
+		t.Errorf("expected one forbidden call counted, got %v", got)
+	}
+}
+
+func TestMetricsEndpointRequiresCredentials(t *testing.T) {
+	s := &HTTPServer{
+		authenticators: []Authenticator{&tokenFileAuthenticator{tokens: map[string]Identity{"scraper": {User: "prometheus"}}}},
+		metrics:        getServerMetrics(),
+	}
+	handler := s.authenticated(s.metrics.handler())
+
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without credentials, got %d", w.Code)
+	}
+
+	r = httptest.NewRequest("GET", "/metrics", nil)
+	r.Header.Set("Authorization", "Bearer scraper")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("expected 200 with credentials, got %d", w.Code)
+	}
+}
+
+func TestClusterCapacityRejectsTooManyReplicas(t *testing.T) {
+	s := &HTTPServer{demoMode: true}
+	call := func(body string) int {
+		r := httptest.NewRequest("POST", "/cluster_capacity", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		s.handleClusterCapacity(w, r)
+		return w.Code
+	}
+	if code := call(`{"deployment": "web", "replicas": 2000000000}`); code != http.StatusBadRequest {
+		t.Errorf("expected 400 above the replica cap, got %d", code)
+	}
+	if code := call(`{"deployment": "web", "replicas": 3}`); code != http.StatusOK {
+		t.Errorf("expected 200 within the cap, got %d", code)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Image pull failure causes, parsed from kubelet and container runtime messages
+const (
+	PullCauseNotFound         = "not-found"
+	PullCauseManifestUnknown  = "manifest-unknown"
+	PullCauseUnauthorized     = "unauthorized"
+	PullCauseRateLimited      = "rate-limited"
+	PullCauseTLS              = "tls"
+	PullCauseDNS              = "dns"
+	PullCauseNetwork          = "network"
+	PullCausePlatformMismatch = "platform-mismatch"
+	PullCauseUnknown          = "unknown"
+)
+
+// pullCausePatterns are matched in order against the lower-cased error message;
+// more specific messages come before the generic "not found". Registries answer
+// a pull of a private image without credentials with "pull access denied,
+// repository does not exist or may require authorization", so those phrases
+// count as unauthorized before "repository does not exist" is considered.
+var pullCausePatterns = []struct {
+	cause    string
+	patterns []string
+}{
+	{PullCausePlatformMismatch, []string{"no match for platform", "exec format error"}},
+	{PullCauseRateLimited, []string{"toomanyrequests", "rate limit", "429 too many requests"}},
+	{PullCauseManifestUnknown, []string{"manifest unknown", "manifest_unknown"}},
+	{PullCauseUnauthorized, []string{"pull access denied", "may require authorization", "insufficient_scope"}},
+	{PullCauseNotFound, []string{"repository does not exist", "name unknown", "not found"}},
+	{PullCauseUnauthorized, []string{"unauthorized", "authentication required", "access denied", "denied", "403 forbidden"}},
+	{PullCauseTLS, []string{"x509:", "tls:", "certificate", "server gave http response to https client"}},
+	{PullCauseDNS, []string{"no such host", "server misbehaving", "temporary failure in name resolution"}},
+	{PullCauseNetwork, []string{"i/o timeout", "connection refused", "connection reset", "network is unreachable", "context deadline exceeded"}},
+}
+
+// classifyPullError maps an image pull error message to a cause
+func classifyPullError(message string) string {
+	lower := strings.ToLower(message)
+	for _, entry := range pullCausePatterns {
+		if containsAny(lower, entry.patterns) {
+			return entry.cause
+		}
+	}
+	return PullCauseUnknown
+}
+
+// registryHost returns the registry an image is pulled from, following the
+// Docker convention that a first path component without "." or ":" is a Docker Hub repository
+func registryHost(image string) string {
+	first, _, found := strings.Cut(image, "/")
+	if !found || !strings.ContainsAny(first, ".:") && first != "localhost" {
+		return "docker.io"
+	}
+	return first
+}
+
+// dockerHubHosts are the names Docker Hub credentials are stored under
+var dockerHubHosts = []string{"docker.io", "index.docker.io", "registry-1.docker.io"}
+
+// authHostMatches reports whether a docker config key (e.g. "https://index.docker.io/v1/"
+// or "*.gcr.io") holds credentials for the registry host
+func authHostMatches(key, host string) bool {
+	key = strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	key, _, _ = strings.Cut(key, "/")
+	if host == "docker.io" && containsString(dockerHubHosts, key) {
+		return true
+	}
+	matched, err := path.Match(key, host)
+	return err == nil && matched
+}
+
+// pullSecretHosts returns the registry hosts a pull secret has auth entries for
+func pullSecretHosts(secret *corev1.Secret) ([]string, error) {
+	var auths map[string]json.RawMessage
+	switch secret.Type {
+	case corev1.SecretTypeDockerConfigJson:
+		var config struct {
+			Auths map[string]json.RawMessage `json:"auths"`
+		}
+		if err := json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &config); err != nil {
+			return nil, fmt.Errorf("%s is not valid JSON", corev1.DockerConfigJsonKey)
+		}
+		auths = config.Auths
+	case corev1.SecretTypeDockercfg:
+		if err := json.Unmarshal(secret.Data[corev1.DockerConfigKey], &auths); err != nil {
+			return nil, fmt.Errorf("%s is not valid JSON", corev1.DockerConfigKey)
+		}
+	default:
+		return nil, fmt.Errorf("type is %s, not %s", secret.Type, corev1.SecretTypeDockerConfigJson)
+	}
+
+	hosts := make([]string, 0, len(auths))
+	for host := range auths {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts, nil
+}
+
+// imagePullFindings explains why containers fail to pull their images: the cause
+// parsed from the pull error and whether the pod's pull secrets cover the registry
+func (s *K8sDiagnosticsServer) imagePullFindings(ctx context.Context, pod *corev1.Pod, failing []corev1.ContainerStatus) []Finding {
+	findings := []Finding{}
+	if len(failing) == 0 {
+		return findings
+	}
+	events := s.recentEvents(ctx, pod.Namespace, "Pod", pod.Name)
+
+	// Pull secrets come from the pod and its service account
+	type pullSecret struct {
+		name   string
+		source string
+	}
+	var secrets []pullSecret
+	for _, ref := range pod.Spec.ImagePullSecrets {
+		secrets = append(secrets, pullSecret{ref.Name, "pod"})
+	}
+	serviceAccount := pod.Spec.ServiceAccountName
+	if serviceAccount == "" {
+		serviceAccount = "default"
+	}
+	if sa, err := s.clientset.CoreV1().ServiceAccounts(pod.Namespace).Get(ctx, serviceAccount, metav1.GetOptions{}); err == nil {
+		for _, ref := range sa.ImagePullSecrets {
+			secrets = append(secrets, pullSecret{ref.Name, "service account " + serviceAccount})
+		}
+	} else {
+		noteOmitted(ctx, "ServiceAccount "+serviceAccount+" pull secrets", err)
+	}
+
+	// Registry hosts each readable secret has credentials for
+	secretHosts := map[string][]string{}
+	podRef := ObjectRef{Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name}
+	for _, ref := range secrets {
+		if _, seen := secretHosts[ref.name]; seen {
+			continue
+		}
+		secret, err := s.clientset.CoreV1().Secrets(pod.Namespace).Get(ctx, ref.name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			findings = append(findings, newFinding(findingPullSecretMissing, podRef,
+				fmt.Sprintf("Image pull secret %s/%s (from %s) does not exist", pod.Namespace, ref.name, ref.source),
+				map[string]string{"secret": ref.name, "source": ref.source}))
+			secretHosts[ref.name] = nil
+			continue
+		}
+		if err != nil {
+			noteOmitted(ctx, "Image pull secret "+pod.Namespace+"/"+ref.name+" check", err)
+			continue
+		}
+		hosts, err := pullSecretHosts(secret)
+		if err != nil {
+			findings = append(findings, newFinding(findingPullSecretInvalid, podRef,
+				fmt.Sprintf("Image pull secret %s/%s (from %s) is not a usable docker config: %v", pod.Namespace, ref.name, ref.source, err),
+				map[string]string{"secret": ref.name, "source": ref.source}))
+		}
+		secretHosts[ref.name] = hosts
+	}
+
+	for _, status := range failing {
+		image := status.Image
+		ref := ObjectRef{Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name, Container: status.Name}
+		host := registryHost(image)
+
+		// The waiting message of ImagePullBackOff only says "Back-off pulling image";
+		// the runtime's error is in the latest Failed event for this image
+		message := ""
+		if status.State.Waiting != nil {
+			message = status.State.Waiting.Message
+		}
+		var latest metav1.Time
+		for _, event := range events {
+			if event.Reason == "Failed" && strings.Contains(event.Message, fmt.Sprintf("%q", image)) &&
+				!event.LastTimestamp.Before(&latest) {
+				message = event.Message
+				latest = event.LastTimestamp
+			}
+		}
+
+		cause := classifyPullError(message)
+		evidence := map[string]string{"image": image, "registry": host, "cause": cause}
+		if message != "" {
+			evidence["message"] = message
+		}
+		findings = append(findings, newFinding(pullCauseDefs[cause], ref,
+			fmt.Sprintf("Container %s cannot pull %s: %s", status.Name, image, pullCauseDescriptions[cause]), evidence))
+
+		// Credentials for the registry
+		var covering, checked []string
+		for name, hosts := range secretHosts {
+			if hosts == nil {
+				continue
+			}
+			checked = append(checked, name)
+			for _, key := range hosts {
+				if authHostMatches(key, host) {
+					covering = append(covering, name)
+					break
+				}
+			}
+		}
+		sort.Strings(checked)
+		switch {
+		case len(covering) > 0:
+		case len(checked) > 0:
+			findings = append(findings, newFinding(findingPullSecretNoAuth, ref,
+				fmt.Sprintf("No image pull secret has credentials for %s (checked %s)", host, strings.Join(checked, ", ")),
+				map[string]string{"registry": host, "secrets": strings.Join(checked, ",")}))
+		case cause == PullCauseUnauthorized && len(secrets) == 0:
+			findings = append(findings, newFinding(findingPullSecretNoAuth, ref,
+				fmt.Sprintf("Registry %s requires credentials but neither the pod nor service account %s has imagePullSecrets", host, serviceAccount),
+				map[string]string{"registry": host, "service_account": serviceAccount}))
+		}
+	}
+
+	return findings
+}
+
+var pullCauseDescriptions = map[string]string{
+	PullCauseNotFound:         "the repository or tag does not exist",
+	PullCauseManifestUnknown:  "the registry has the repository but not this tag or digest",
+	PullCauseUnauthorized:     "the registry rejected the credentials (or none were sent)",
+	PullCauseRateLimited:      "the registry is rate limiting pulls",
+	PullCauseTLS:              "the registry's TLS certificate is not trusted",
+	PullCauseDNS:              "the registry host name does not resolve",
+	PullCauseNetwork:          "the registry is unreachable from the node",
+	PullCausePlatformMismatch: "the image has no variant for the node's OS/architecture",
+	PullCauseUnknown:          "the pull error could not be classified; see the message",
+}
+
+var pullCauseDefs = map[string]findingDef{
+	PullCauseNotFound:         findingImageNotFound,
+	PullCauseManifestUnknown:  findingImageManifestUnknown,
+	PullCauseUnauthorized:     findingImagePullUnauthorized,
+	PullCauseRateLimited:      findingImagePullRateLimited,
+	PullCauseTLS:              findingImageRegistryTLS,
+	PullCauseDNS:              findingImageRegistryDNS,
+	PullCauseNetwork:          findingImageRegistryUnreachable,
+	PullCausePlatformMismatch: findingImagePlatformMismatch,
+	PullCauseUnknown:          findingImagePullUnknown,
+}
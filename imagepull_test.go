@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+func TestClassifyPullError(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{`failed to pull and unpack image "docker.io/acme/api:1.0": failed to resolve reference "docker.io/acme/api:1.0": pull access denied, repository does not exist or may require authorization: server message: insufficient_scope: authorization failed`, PullCauseUnauthorized},
+		{`rpc error: code = Unknown desc = Error response from daemon: pull access denied for acme/api, repository does not exist or may require 'docker login'`, PullCauseUnauthorized},
+		{`failed to resolve reference "ghcr.io/acme/api:1.0": failed to authorize: failed to fetch anonymous token: unexpected status: 401 Unauthorized`, PullCauseUnauthorized},
+		{`failed to resolve reference "quay.io/acme/apii:1.0": quay.io/acme/apii:1.0: not found`, PullCauseNotFound},
+		{`Error response from daemon: repository acme/apii not found: name unknown`, PullCauseNotFound},
+		{`failed to resolve reference "docker.io/library/nginx:1.999": docker.io/library/nginx:1.999: manifest unknown`, PullCauseManifestUnknown},
+		{`toomanyrequests: You have reached your pull rate limit`, PullCauseRateLimited},
+		{`no match for platform in manifest: not found`, PullCausePlatformMismatch},
+		{`tls: failed to verify certificate: x509: certificate signed by unknown authority`, PullCauseTLS},
+		{`dial tcp: lookup registry.internal on 10.96.0.10:53: no such host`, PullCauseDNS},
+		{`dial tcp 10.0.0.5:443: i/o timeout`, PullCauseNetwork},
+		{`something else went wrong`, PullCauseUnknown},
+	}
+	for _, tt := range tests {
+		if got := classifyPullError(tt.message); got != tt.want {
+			t.Errorf("classifyPullError(%q) = %s, want %s", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestRegistryHost(t *testing.T) {
+	tests := map[string]string{
+		"nginx":                      "docker.io",
+		"nginx:1.27":                 "docker.io",
+		"acme/api:1.0":               "docker.io",
+		"docker.io/acme/api":         "docker.io",
+		"ghcr.io/acme/api:1.0":       "ghcr.io",
+		"registry.internal:5000/api": "registry.internal:5000",
+		"localhost/api":              "localhost",
+		"localhost:5000/api":         "localhost:5000",
+		"123.dkr.ecr.us-east-1.amazonaws.com/api@sha256:abc": "123.dkr.ecr.us-east-1.amazonaws.com",
+	}
+	for image, want := range tests {
+		if got := registryHost(image); got != want {
+			t.Errorf("registryHost(%q) = %s, want %s", image, got, want)
+		}
+	}
+}
+
+func TestAuthHostMatches(t *testing.T) {
+	tests := []struct {
+		key, host string
+		want      bool
+	}{
+		{"https://index.docker.io/v1/", "docker.io", true},
+		{"docker.io", "docker.io", true},
+		{"registry-1.docker.io", "docker.io", true},
+		{"ghcr.io", "ghcr.io", true},
+		{"https://ghcr.io", "ghcr.io", true},
+		{"*.gcr.io", "eu.gcr.io", true},
+		{"*.gcr.io", "gcr.io", false},
+		{"registry.internal:5000", "registry.internal:5000", true},
+		{"registry.internal", "registry.internal:5000", false},
+		{"https://index.docker.io/v1/", "ghcr.io", false},
+		{"[", "ghcr.io", false},
+	}
+	for _, tt := range tests {
+		if got := authHostMatches(tt.key, tt.host); got != tt.want {
+			t.Errorf("authHostMatches(%q, %q) = %v, want %v", tt.key, tt.host, got, tt.want)
+		}
+	}
+}
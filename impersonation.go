@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	metricsclientset "k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+// Identity is the Kubernetes user an HTTP caller is mapped to
+type Identity struct {
+	User   string   `json:"user"`
+	Groups []string `json:"groups,omitempty"`
+	// Scope names the AUTH_SCOPES_FILE entry a static token is pinned to
+	Scope string `json:"scope,omitempty"`
+}
+
+func (id Identity) String() string {
+	if len(id.Groups) == 0 {
+		return id.User
+	}
+	return fmt.Sprintf("%s (groups: %s)", id.User, strings.Join(id.Groups, ","))
+}
+
+// Authenticator maps an HTTP request's credentials to an identity. It returns
+// errNoCredentials when the request carries nothing it understands, so the next
+// authenticator can try.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+var errNoCredentials = errors.New("no credentials")
+
+// bearerToken returns the token of an "Authorization: Bearer" header
+func bearerToken(r *http.Request) string {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// tokenFileAuthenticator maps static bearer tokens to identities. The file uses
+// the kube-apiserver static token format, token,user,uid,"group1,group2", with
+// an optional fifth column naming the AUTH_SCOPES_FILE entry the token is limited to
+type tokenFileAuthenticator struct {
+	tokens map[string]Identity
+}
+
+func newTokenFileAuthenticator(path string) (*tokenFileAuthenticator, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open token file: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	auth := &tokenFileAuthenticator{tokens: map[string]Identity{}}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse token file: %w", err)
+		}
+		if len(record) < 2 || record[0] == "" || record[1] == "" {
+			return nil, fmt.Errorf("token file line %d: need at least token and user", line)
+		}
+		identity := Identity{User: record[1]}
+		if len(record) >= 4 {
+			identity.Groups = splitList(record[3])
+		}
+		if len(record) >= 5 {
+			identity.Scope = strings.TrimSpace(record[4])
+		}
+		auth.tokens[record[0]] = identity
+	}
+	if len(auth.tokens) == 0 {
+		return nil, fmt.Errorf("token file %s has no tokens", path)
+	}
+	return auth, nil
+}
+
+func (a *tokenFileAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, errNoCredentials
+	}
+	identity, ok := a.tokens[token]
+	if !ok {
+		// An unknown token may still be a JWT for the OIDC authenticator
+		return nil, errNoCredentials
+	}
+	return &identity, nil
+}
+
+// newAuthenticatorsFromEnv configures the authenticators that map HTTP callers
+// to Kubernetes identities: AUTH_TOKEN_FILE, OIDC_ISSUER_URL and client
+// certificates verified against TLS_CLIENT_CA_FILE
+func newAuthenticatorsFromEnv() ([]Authenticator, error) {
+	var authenticators []Authenticator
+	if path := os.Getenv("AUTH_TOKEN_FILE"); path != "" {
+		auth, err := newTokenFileAuthenticator(path)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, auth)
+	}
+	oidc, err := newOIDCAuthenticatorFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if oidc != nil {
+		authenticators = append(authenticators, oidc)
+	}
+	if os.Getenv("TLS_CLIENT_CA_FILE") != "" {
+		authenticators = append(authenticators, certAuthenticator{
+			allowedSystemUsers:  splitList(os.Getenv("AUTH_ALLOWED_SYSTEM_USERS")),
+			allowedSystemGroups: splitList(os.Getenv("AUTH_ALLOWED_SYSTEM_GROUPS")),
+		})
+	}
+	return authenticators, nil
+}
+
+// authenticate tries each authenticator in turn
+func authenticate(authenticators []Authenticator, r *http.Request) (*Identity, error) {
+	for _, auth := range authenticators {
+		identity, err := auth.Authenticate(r)
+		if errors.Is(err, errNoCredentials) {
+			continue
+		}
+		return identity, err
+	}
+	return nil, errNoCredentials
+}
+
+// callerCacheSize bounds the per-caller servers kept between requests
+const callerCacheSize = 256
+
+// callerServers caches a diagnostics server per caller, so a caller's
+// requests share client-go clients and connection pools
+type callerServers struct {
+	mu      sync.Mutex
+	servers map[string]*K8sDiagnosticsServer
+}
+
+// size returns the number of cached servers
+func (c *callerServers) size() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.servers)
+}
+
+// forCaller returns a copy of the server for an authenticated caller. Its access
+// policy is narrowed to the caller's namespace scope and, with impersonate set,
+// its Kubernetes clients impersonate the caller, so results are limited to what
+// the caller's own RBAC allows. Policy, rules and Prometheus settings are shared.
+func (s *K8sDiagnosticsServer) forCaller(identity Identity, scope *CallerScope, impersonate bool) (*K8sDiagnosticsServer, error) {
+	if s.callers == nil {
+		return nil, fmt.Errorf("per-caller clients are not configured")
+	}
+	groups := append([]string{}, identity.Groups...)
+	sort.Strings(groups)
+	// Two tokens of the same user can be pinned to different scopes
+	key := identity.User + "\x00" + strings.Join(groups, "\x00") + "\x00" + scope.describe()
+
+	s.callers.mu.Lock()
+	defer s.callers.mu.Unlock()
+	if server, ok := s.callers.servers[key]; ok {
+		return server, nil
+	}
+
+	server := &K8sDiagnosticsServer{
+		clientset:  s.clientset,
+		metrics:    s.metrics,
+		dynamic:    s.dynamic,
+		policy:     s.policy,
+		access:     s.access.withScope(scope.namespaces()),
+		rules:      s.rules,
+		prometheus: s.prometheus,
+		usage:      s.usage,
+	}
+	if impersonate {
+		if s.config == nil {
+			return nil, fmt.Errorf("impersonation needs a Kubernetes client configuration")
+		}
+		config := rest.CopyConfig(s.config)
+		config.Impersonate = rest.ImpersonationConfig{UserName: identity.User, Groups: groups}
+		clientset, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create impersonating client: %w", err)
+		}
+		metrics, err := metricsclientset.NewForConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create impersonating metrics client: %w", err)
+		}
+		dynamicClient, err := dynamic.NewForConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create impersonating dynamic client: %w", err)
+		}
+		server.clientset, server.metrics, server.dynamic = clientset, metrics, dynamicClient
+		server.identity = &identity
+	}
+
+	if s.callers.servers == nil || len(s.callers.servers) >= callerCacheSize {
+		s.callers.servers = map[string]*K8sDiagnosticsServer{}
+	}
+	s.callers.servers[key] = server
+	return server, nil
+}
+
+// checkCallerCanRead verifies an impersonated caller may list pods in a namespace.
+// Prometheus is not subject to Kubernetes RBAC, so metrics are gated on this instead.
+func (s *K8sDiagnosticsServer) checkCallerCanRead(ctx context.Context, namespace string) error {
+	if s.identity == nil {
+		return nil
+	}
+	review, err := s.clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{Verb: "list", Resource: "pods", Namespace: namespace},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to check %s's access to namespace %s: %w", s.identity.User, namespace, err)
+	}
+	if !review.Status.Allowed {
+		return apierrors.NewForbidden(schema.GroupResource{Resource: "pods"}, "",
+			fmt.Errorf("%s cannot list pods in namespace %s, so its metrics are not shown", s.identity.User, namespace))
+	}
+	return nil
+}
@@ -0,0 +1,863 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Annotations used for IngressClass selection before spec.ingressClassName
+const (
+	legacyIngressClassAnnotation  = "kubernetes.io/ingress.class"
+	defaultIngressClassAnnotation = "ingressclass.kubernetes.io/is-default-class"
+)
+
+// Gateway API resources, read through the dynamic client so the CRDs are optional
+var (
+	gatewayClassesGVR  = schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "gatewayclasses"}
+	gatewaysGVR        = schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "gateways"}
+	httpRoutesGVR      = schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "httproutes"}
+	referenceGrantsGVR = schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1beta1", Resource: "referencegrants"}
+)
+
+// Gateway API availability reported in IngressDiagnostic
+const (
+	GatewayAPIAvailable    = "available"
+	GatewayAPINotInstalled = "not installed"
+)
+
+// IngressDiagnostic is the diagnose_ingress result
+type IngressDiagnostic struct {
+	Namespace  string            `json:"namespace"`
+	Ingresses  []IngressReport   `json:"ingresses"`
+	HTTPRoutes []HTTPRouteReport `json:"http_routes"`
+	GatewayAPI string            `json:"gateway_api"`
+	Findings   []Finding         `json:"findings"`
+	Summary    *FindingSummary   `json:"summary,omitempty"`
+	Omitted    []string          `json:"omitted,omitempty"`
+}
+
+// IngressReport is one Ingress with its rules linked through to backends
+type IngressReport struct {
+	Name      string         `json:"name"`
+	Class     string         `json:"class,omitempty"`
+	Addresses []string       `json:"addresses"`
+	TLS       []TLSReport    `json:"tls"`
+	Routes    []RouteBackend `json:"routes"`
+}
+
+// HTTPRouteReport is one HTTPRoute with its parents and backends
+type HTTPRouteReport struct {
+	Name      string         `json:"name"`
+	Hostnames []string       `json:"hostnames"`
+	Parents   []RouteParent  `json:"parents"`
+	Routes    []RouteBackend `json:"routes"`
+}
+
+// RouteParent is a Gateway an HTTPRoute attaches to and the route's status there
+type RouteParent struct {
+	Gateway      string `json:"gateway"`
+	SectionName  string `json:"section_name,omitempty"`
+	GatewayClass string `json:"gateway_class,omitempty"`
+	Found        bool   `json:"found"`
+	Accepted     string `json:"accepted"`
+	ResolvedRefs string `json:"resolved_refs"`
+}
+
+// TLSReport is one TLS certificate reference
+type TLSReport struct {
+	Secret string   `json:"secret"`
+	Hosts  []string `json:"hosts,omitempty"`
+	Status string   `json:"status"`
+}
+
+// RouteBackend links a host/path to its Service and an Endpoints health verdict
+type RouteBackend struct {
+	Host           string `json:"host"`
+	Path           string `json:"path"`
+	Service        string `json:"service"`
+	Port           string `json:"port,omitempty"`
+	ReadyEndpoints int    `json:"ready_endpoints"`
+	Verdict        string `json:"verdict"`
+}
+
+// Backend verdicts
+const (
+	BackendHealthy          = "healthy"
+	BackendServiceMissing   = "service missing"
+	BackendPortMissing      = "port missing"
+	BackendNoReadyEndpoints = "no ready endpoints"
+	BackendNotPermitted     = "cross-namespace reference not permitted"
+)
+
+// Minimal Gateway API types; only the fields the checks use are decoded
+type gatewayClass struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Status            struct {
+		Conditions []metav1.Condition `json:"conditions"`
+	} `json:"status"`
+}
+
+type gatewayObject struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              struct {
+		GatewayClassName string `json:"gatewayClassName"`
+		Listeners        []struct {
+			Name     string  `json:"name"`
+			Hostname *string `json:"hostname"`
+			Port     int32   `json:"port"`
+			Protocol string  `json:"protocol"`
+			TLS      *struct {
+				CertificateRefs []gatewayObjectRef `json:"certificateRefs"`
+			} `json:"tls"`
+		} `json:"listeners"`
+	} `json:"spec"`
+}
+
+type gatewayObjectRef struct {
+	Group       *string `json:"group"`
+	Kind        *string `json:"kind"`
+	Name        string  `json:"name"`
+	Namespace   *string `json:"namespace"`
+	SectionName *string `json:"sectionName"`
+	Port        *int32  `json:"port"`
+}
+
+type httpRoute struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              struct {
+		ParentRefs []gatewayObjectRef `json:"parentRefs"`
+		Hostnames  []string           `json:"hostnames"`
+		Rules      []httpRouteRule    `json:"rules"`
+	} `json:"spec"`
+	Status struct {
+		Parents []struct {
+			ParentRef  gatewayObjectRef   `json:"parentRef"`
+			Conditions []metav1.Condition `json:"conditions"`
+		} `json:"parents"`
+	} `json:"status"`
+}
+
+type httpRouteRule struct {
+	Matches []struct {
+		Path *struct {
+			Type  *string `json:"type"`
+			Value *string `json:"value"`
+		} `json:"path"`
+	} `json:"matches"`
+	BackendRefs []gatewayObjectRef `json:"backendRefs"`
+}
+
+// paths returns the path of every match; a rule without matches matches "/"
+func (r httpRouteRule) paths() []string {
+	if len(r.Matches) == 0 {
+		return []string{"/"}
+	}
+	paths := make([]string, 0, len(r.Matches))
+	for _, match := range r.Matches {
+		if match.Path != nil && match.Path.Value != nil {
+			paths = append(paths, normalizePath(*match.Path.Value))
+		} else {
+			paths = append(paths, "/")
+		}
+	}
+	return paths
+}
+
+type referenceGrant struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              struct {
+		From []struct {
+			Group     string `json:"group"`
+			Kind      string `json:"kind"`
+			Namespace string `json:"namespace"`
+		} `json:"from"`
+		To []struct {
+			Group string  `json:"group"`
+			Kind  string  `json:"kind"`
+			Name  *string `json:"name"`
+		} `json:"to"`
+	} `json:"spec"`
+}
+
+// ingressChecker carries per-call caches so each Service is diagnosed once
+type ingressChecker struct {
+	s        *K8sDiagnosticsServer
+	ctx      context.Context
+	services map[string]*ServiceDiagnostic
+	svcObjs  map[string]*corev1.Service
+	secrets  map[string]string
+	findings []Finding
+	// classesUnknown is set when IngressClasses could not be read
+	classesUnknown bool
+}
+
+// diagnoseIngress checks the Ingresses and HTTPRoutes of a namespace (or a
+// single one by name) for missing backends, ports and TLS secrets, class
+// mismatches, overlapping rules and route status conditions
+func (s *K8sDiagnosticsServer) diagnoseIngress(ctx context.Context, namespace, name string) (*IngressDiagnostic, error) {
+	if err := s.access.checkNamespace(namespace); err != nil {
+		return nil, err
+	}
+	ctx, omitted := withOmissions(ctx)
+	c := &ingressChecker{
+		s:        s,
+		ctx:      ctx,
+		services: map[string]*ServiceDiagnostic{},
+		svcObjs:  map[string]*corev1.Service{},
+		secrets:  map[string]string{},
+		findings: []Finding{},
+	}
+	result := &IngressDiagnostic{
+		Namespace:  namespace,
+		Ingresses:  []IngressReport{},
+		HTTPRoutes: []HTTPRouteReport{},
+	}
+
+	// All Ingresses are listed to find rules that overlap across namespaces. A
+	// caller who may only read this namespace gets its Ingresses without that check.
+	ingresses, err := s.clientset.NetworkingV1().Ingresses("").List(ctx, metav1.ListOptions{})
+	if apierrors.IsForbidden(err) && namespace != "" {
+		noteOmitted(ctx, "Ingress overlap check across namespaces", err)
+		ingresses, err = s.clientset.NetworkingV1().Ingresses(namespace).List(ctx, metav1.ListOptions{})
+	}
+	if err != nil {
+		return nil, err
+	}
+	classes, err := s.clientset.NetworkingV1().IngressClasses().List(ctx, metav1.ListOptions{})
+	if apierrors.IsForbidden(err) {
+		noteOmitted(ctx, "IngressClass checks", err)
+		classes, err = nil, nil
+		c.classesUnknown = true
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Overlaps are only reported with Ingresses the access policy allows
+	var allowed []networkingv1.Ingress
+	for _, ingress := range ingresses.Items {
+		if err := s.access.checkObject("Ingress", ingress.Namespace, ingress.Name, ingress.Labels); err != nil {
+			if ingress.Namespace == namespace && ingress.Name == name {
+				return nil, err
+			}
+			continue
+		}
+		allowed = append(allowed, ingress)
+	}
+	var classItems []networkingv1.IngressClass
+	if classes != nil {
+		classItems = classes.Items
+	}
+	overlaps := ingressOverlaps(allowed, classItems)
+	for _, ingress := range allowed {
+		if ingress.Namespace != namespace || (name != "" && ingress.Name != name) {
+			continue
+		}
+		result.Ingresses = append(result.Ingresses, c.checkIngress(ingress, classItems, overlaps))
+	}
+
+	result.GatewayAPI, err = c.checkHTTPRoutes(result, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if name != "" && len(result.Ingresses) == 0 && len(result.HTTPRoutes) == 0 {
+		return nil, fmt.Errorf("no Ingress or HTTPRoute named %s in namespace %s", name, namespace)
+	}
+
+	result.Findings = c.findings
+	sortFindings(result.Findings)
+	result.Summary = summarizeFindings(result.Findings)
+	result.Omitted = omitted.list()
+
+	return result, nil
+}
+
+func (c *ingressChecker) checkIngress(ingress networkingv1.Ingress, classes []networkingv1.IngressClass, overlaps map[string][]string) IngressReport {
+	ref := ObjectRef{Kind: "Ingress", Namespace: ingress.Namespace, Name: ingress.Name}
+	report := IngressReport{
+		Name:      ingress.Name,
+		Class:     ingressClassName(ingress, classes),
+		Addresses: []string{},
+		TLS:       []TLSReport{},
+		Routes:    []RouteBackend{},
+	}
+
+	// IngressClass
+	specClass := ""
+	if ingress.Spec.IngressClassName != nil {
+		specClass = *ingress.Spec.IngressClassName
+	}
+	annotationClass := ingress.Annotations[legacyIngressClassAnnotation]
+	switch {
+	case specClass != "" && annotationClass != "" && specClass != annotationClass:
+		c.add(findingIngressClassMismatch, ref,
+			fmt.Sprintf("Ingress %s/%s sets ingressClassName %q but the %s annotation says %q; controllers disagree on who serves it",
+				ingress.Namespace, ingress.Name, specClass, legacyIngressClassAnnotation, annotationClass), nil)
+	case c.classesUnknown:
+		// Without the IngressClasses there is no telling whether the class exists or is the default
+	case report.Class == "":
+		c.add(findingIngressClassMismatch, ref,
+			fmt.Sprintf("Ingress %s/%s has no ingress class and there is no default IngressClass: no controller may serve it", ingress.Namespace, ingress.Name), nil)
+	case specClass != "" && !ingressClassExists(specClass, classes):
+		c.add(findingIngressClassMismatch, ref,
+			fmt.Sprintf("Ingress %s/%s uses IngressClass %q, which does not exist", ingress.Namespace, ingress.Name, specClass), nil)
+	}
+
+	for _, lb := range ingress.Status.LoadBalancer.Ingress {
+		if lb.IP != "" {
+			report.Addresses = append(report.Addresses, lb.IP)
+		} else if lb.Hostname != "" {
+			report.Addresses = append(report.Addresses, lb.Hostname)
+		}
+	}
+	if len(report.Addresses) == 0 {
+		c.add(findingIngressNoAddress, ref,
+			fmt.Sprintf("Ingress %s/%s has no load balancer address: the ingress controller hasn't picked it up", ingress.Namespace, ingress.Name), nil)
+	}
+
+	// TLS
+	for _, tls := range ingress.Spec.TLS {
+		if tls.SecretName == "" {
+			continue
+		}
+		status := c.checkTLSSecret(ingress.Namespace, tls.SecretName, ref)
+		report.TLS = append(report.TLS, TLSReport{Secret: tls.SecretName, Hosts: tls.Hosts, Status: status})
+	}
+
+	// Rules and backends
+	if backend := ingress.Spec.DefaultBackend; backend != nil {
+		report.Routes = append(report.Routes, c.ingressBackend(ingress, "*", "(default backend)", *backend, ref))
+	}
+	for _, rule := range ingress.Spec.Rules {
+		host := rule.Host
+		if host == "" {
+			host = "*"
+		}
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			p := normalizePath(path.Path)
+			report.Routes = append(report.Routes, c.ingressBackend(ingress, host, p, path.Backend, ref))
+
+			key := overlapKey(report.Class, host, p)
+			if others := otherObjects(overlaps[key], ingress.Namespace+"/"+ingress.Name); len(others) > 0 {
+				c.add(findingRouteOverlap, ref,
+					fmt.Sprintf("Ingress %s/%s rule %s%s is also defined by %s: the controller picks one and the other is silently ignored",
+						ingress.Namespace, ingress.Name, host, p, strings.Join(others, ", ")),
+					map[string]string{"host": host, "path": p})
+			}
+		}
+	}
+
+	return report
+}
+
+func (c *ingressChecker) ingressBackend(ingress networkingv1.Ingress, host, path string, backend networkingv1.IngressBackend, ref ObjectRef) RouteBackend {
+	route := RouteBackend{Host: host, Path: path}
+	if backend.Service == nil {
+		route.Service = "(resource backend)"
+		route.Verdict = BackendHealthy
+		return route
+	}
+
+	route.Service = ingress.Namespace + "/" + backend.Service.Name
+	port := ""
+	var number int32
+	if backend.Service.Port.Name != "" {
+		port = backend.Service.Port.Name
+	} else {
+		number = backend.Service.Port.Number
+		port = fmt.Sprint(number)
+	}
+	route.Port = port
+	c.backendVerdict(&route, ingress.Namespace, backend.Service.Name, backend.Service.Port.Name, number, ref)
+	return route
+}
+
+// backendVerdict links a route to its Service: existence, port and ready endpoints
+func (c *ingressChecker) backendVerdict(route *RouteBackend, namespace, service, portName string, portNumber int32, ref ObjectRef) {
+	where := fmt.Sprintf("%s %s/%s %s%s", ref.Kind, ref.Namespace, ref.Name, route.Host, route.Path)
+	svc, err := c.service(namespace, service)
+	if err != nil {
+		route.Verdict = BackendServiceMissing
+		c.add(findingRouteBackendMissing, ref,
+			fmt.Sprintf("%s: backend service %s/%s is unavailable (%v)", where, namespace, service, err),
+			map[string]string{"service": service})
+		return
+	}
+
+	portFound := false
+	for _, p := range svc.Spec.Ports {
+		if portName != "" && p.Name == portName || portName == "" && p.Port == portNumber {
+			portFound = true
+		}
+	}
+	if !portFound {
+		route.Verdict = BackendPortMissing
+		c.add(findingRouteBackendPortMissing, ref,
+			fmt.Sprintf("%s: service %s/%s has no port %s", where, namespace, service, route.Port),
+			map[string]string{"service": service, "port": route.Port})
+		return
+	}
+
+	diagnostic := c.services[namespace+"/"+service]
+	route.ReadyEndpoints = diagnostic.ReadyEndpoints
+	if diagnostic.ReadyEndpoints == 0 && svc.Spec.Type != corev1.ServiceTypeExternalName {
+		route.Verdict = BackendNoReadyEndpoints
+		c.add(findingRouteBackendUnhealthy, ref,
+			fmt.Sprintf("%s: service %s/%s has no ready endpoints, requests get 503s (see diagnose_service)", where, namespace, service),
+			map[string]string{"service": service})
+		return
+	}
+	route.Verdict = BackendHealthy
+}
+
+// service fetches and diagnoses a Service once per call
+func (c *ingressChecker) service(namespace, name string) (*corev1.Service, error) {
+	if err := c.s.access.checkNamespace(namespace); err != nil {
+		return nil, err
+	}
+	key := namespace + "/" + name
+	if svc, ok := c.svcObjs[key]; ok {
+		if svc == nil {
+			return nil, fmt.Errorf("not found")
+		}
+		return svc, nil
+	}
+	svc, err := c.s.clientset.CoreV1().Services(namespace).Get(c.ctx, name, metav1.GetOptions{})
+	if err != nil {
+		c.svcObjs[key] = nil
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("not found")
+		}
+		return nil, err
+	}
+	if err := c.s.access.checkObject("Service", namespace, name, svc.Labels); err != nil {
+		return nil, err
+	}
+	c.svcObjs[key] = svc
+	diagnostic, err := c.s.diagnoseService(c.ctx, namespace, name)
+	if err != nil {
+		diagnostic = &ServiceDiagnostic{}
+	}
+	c.services[key] = diagnostic
+	return svc, nil
+}
+
+// checkTLSSecret verifies a TLS secret exists and holds a certificate and key
+func (c *ingressChecker) checkTLSSecret(namespace, name string, ref ObjectRef) string {
+	key := namespace + "/" + name
+	if status, ok := c.secrets[key]; ok {
+		return status
+	}
+
+	status := "ok"
+	secret, err := c.s.clientset.CoreV1().Secrets(namespace).Get(c.ctx, name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		status = "missing"
+		c.add(findingRouteTLSSecretMissing, ref,
+			fmt.Sprintf("%s %s/%s references TLS secret %s/%s, which does not exist: the controller serves a default certificate or drops HTTPS",
+				ref.Kind, ref.Namespace, ref.Name, namespace, name),
+			map[string]string{"secret": name})
+	case err != nil:
+		status = fmt.Sprintf("unknown: %v", err)
+		noteOmitted(c.ctx, "TLS secret "+key+" check", err)
+	case len(secret.Data[corev1.TLSCertKey]) == 0 || len(secret.Data[corev1.TLSPrivateKeyKey]) == 0:
+		status = "invalid"
+		c.add(findingRouteTLSSecretInvalid, ref,
+			fmt.Sprintf("%s %s/%s references secret %s/%s, which has no %s and %s (type %s)",
+				ref.Kind, ref.Namespace, ref.Name, namespace, name, corev1.TLSCertKey, corev1.TLSPrivateKeyKey, secret.Type),
+			map[string]string{"secret": name})
+	}
+	c.secrets[key] = status
+	return status
+}
+
+// checkHTTPRoutes checks Gateway API routes. A cluster without the CRDs is
+// reported as not installed rather than as an error.
+func (c *ingressChecker) checkHTTPRoutes(result *IngressDiagnostic, namespace, name string) (string, error) {
+	if c.s.dynamic == nil {
+		return GatewayAPINotInstalled, nil
+	}
+
+	routeList, err := c.s.dynamic.Resource(httpRoutesGVR).Namespace("").List(c.ctx, metav1.ListOptions{})
+	if apierrors.IsForbidden(err) && namespace != "" {
+		noteOmitted(c.ctx, "HTTPRoute overlap check across namespaces", err)
+		routeList, err = c.s.dynamic.Resource(httpRoutesGVR).Namespace(namespace).List(c.ctx, metav1.ListOptions{})
+	}
+	if apierrors.IsNotFound(err) {
+		return GatewayAPINotInstalled, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to list HTTPRoutes: %w", err)
+	}
+
+	var routes []httpRoute
+	for _, item := range routeList.Items {
+		var route httpRoute
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &route); err != nil {
+			return "", fmt.Errorf("failed to decode HTTPRoute %s/%s: %w", item.GetNamespace(), item.GetName(), err)
+		}
+		if c.s.access.allows(route.Namespace, route.Labels) {
+			routes = append(routes, route)
+		}
+	}
+
+	gateways := map[string]*gatewayObject{}
+	gatewayClasses := map[string]*gatewayClass{}
+	overlaps := httpRouteOverlaps(routes)
+
+	for _, route := range routes {
+		if route.Namespace != namespace || (name != "" && route.Name != name) {
+			continue
+		}
+		ref := ObjectRef{Kind: "HTTPRoute", Namespace: route.Namespace, Name: route.Name}
+		report := HTTPRouteReport{Name: route.Name, Hostnames: route.Spec.Hostnames, Parents: []RouteParent{}, Routes: []RouteBackend{}}
+		if report.Hostnames == nil {
+			report.Hostnames = []string{}
+		}
+
+		for _, parentRef := range route.Spec.ParentRefs {
+			report.Parents = append(report.Parents, c.routeParent(route, parentRef, ref, gateways, gatewayClasses))
+		}
+		if len(route.Status.Parents) == 0 {
+			c.add(findingHTTPRouteNotAccepted, ref,
+				fmt.Sprintf("HTTPRoute %s/%s has no status from any gateway controller: no controller has processed it", route.Namespace, route.Name), nil)
+		}
+
+		hosts := route.Spec.Hostnames
+		if len(hosts) == 0 {
+			hosts = []string{"*"}
+		}
+		for _, rule := range route.Spec.Rules {
+			for _, host := range hosts {
+				for _, path := range rule.paths() {
+					for _, parent := range route.Spec.ParentRefs {
+						gateway := refNamespace(parent.Namespace, route.Namespace) + "/" + parent.Name
+						if others := otherObjects(overlaps[overlapKey(gateway, host, path)], route.Namespace+"/"+route.Name); len(others) > 0 {
+							c.add(findingRouteOverlap, ref,
+								fmt.Sprintf("HTTPRoute %s/%s match %s%s on gateway %s is also defined by %s: precedence rules decide which route wins",
+									route.Namespace, route.Name, host, path, gateway, strings.Join(others, ", ")),
+								map[string]string{"host": host, "path": path})
+						}
+					}
+					for _, backend := range rule.BackendRefs {
+						report.Routes = append(report.Routes, c.routeBackend(route, host, path, backend, ref))
+					}
+				}
+			}
+		}
+
+		result.HTTPRoutes = append(result.HTTPRoutes, report)
+	}
+
+	return GatewayAPIAvailable, nil
+}
+
+// routeParent resolves a parentRef to its Gateway and GatewayClass and reads the route status for it
+func (c *ingressChecker) routeParent(route httpRoute, parentRef gatewayObjectRef, ref ObjectRef, gateways map[string]*gatewayObject, classes map[string]*gatewayClass) RouteParent {
+	gwNamespace := refNamespace(parentRef.Namespace, route.Namespace)
+	parent := RouteParent{Gateway: gwNamespace + "/" + parentRef.Name, Accepted: "Unknown", ResolvedRefs: "Unknown"}
+	if parentRef.SectionName != nil {
+		parent.SectionName = *parentRef.SectionName
+	}
+
+	for _, status := range route.Status.Parents {
+		if status.ParentRef.Name != parentRef.Name || refNamespace(status.ParentRef.Namespace, route.Namespace) != gwNamespace {
+			continue
+		}
+		for _, condition := range status.Conditions {
+			switch condition.Type {
+			case "Accepted":
+				parent.Accepted = string(condition.Status)
+				if condition.Status == metav1.ConditionFalse {
+					c.add(findingHTTPRouteNotAccepted, ref,
+						fmt.Sprintf("HTTPRoute %s/%s is not accepted by gateway %s: %s: %s", route.Namespace, route.Name, parent.Gateway, condition.Reason, condition.Message),
+						map[string]string{"reason": condition.Reason})
+				}
+			case "ResolvedRefs":
+				parent.ResolvedRefs = string(condition.Status)
+				if condition.Status == metav1.ConditionFalse {
+					c.add(findingHTTPRouteRefsUnresolved, ref,
+						fmt.Sprintf("HTTPRoute %s/%s has unresolved references on gateway %s: %s: %s", route.Namespace, route.Name, parent.Gateway, condition.Reason, condition.Message),
+						map[string]string{"reason": condition.Reason})
+				}
+			}
+		}
+	}
+
+	if parentRef.Kind != nil && *parentRef.Kind != "Gateway" {
+		parent.Found = true
+		return parent
+	}
+
+	gw, ok := gateways[parent.Gateway]
+	if !ok {
+		obj, err := c.s.dynamic.Resource(gatewaysGVR).Namespace(gwNamespace).Get(c.ctx, parentRef.Name, metav1.GetOptions{})
+		if err == nil {
+			gw = &gatewayObject{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, gw); err != nil {
+				gw = nil
+			}
+		}
+		gateways[parent.Gateway] = gw
+		if gw != nil {
+			c.checkGateway(gw, classes)
+		}
+	}
+	if gw == nil {
+		c.add(findingHTTPRouteParentMissing, ref,
+			fmt.Sprintf("HTTPRoute %s/%s attaches to gateway %s, which does not exist", route.Namespace, route.Name, parent.Gateway), nil)
+		return parent
+	}
+	parent.Found = true
+	parent.GatewayClass = gw.Spec.GatewayClassName
+
+	if parent.SectionName != "" {
+		found := false
+		for _, listener := range gw.Spec.Listeners {
+			if listener.Name == parent.SectionName {
+				found = true
+			}
+		}
+		if !found {
+			c.add(findingHTTPRouteParentMissing, ref,
+				fmt.Sprintf("HTTPRoute %s/%s attaches to listener %q, which gateway %s does not have", route.Namespace, route.Name, parent.SectionName, parent.Gateway), nil)
+		}
+	}
+	return parent
+}
+
+// checkGateway verifies a Gateway's GatewayClass and listener certificates
+func (c *ingressChecker) checkGateway(gw *gatewayObject, classes map[string]*gatewayClass) {
+	ref := ObjectRef{Kind: "Gateway", Namespace: gw.Namespace, Name: gw.Name}
+
+	class, known := classes[gw.Spec.GatewayClassName]
+	if !known {
+		obj, err := c.s.dynamic.Resource(gatewayClassesGVR).Get(c.ctx, gw.Spec.GatewayClassName, metav1.GetOptions{})
+		switch {
+		case err == nil:
+			class = &gatewayClass{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, class); err != nil {
+				class = nil
+			}
+			known = true
+		case apierrors.IsNotFound(err):
+			known = true
+		default:
+			// Unreadable is not missing, so the class is not checked
+			noteOmitted(c.ctx, "GatewayClass "+gw.Spec.GatewayClassName+" check", err)
+		}
+		if known {
+			classes[gw.Spec.GatewayClassName] = class
+		}
+	}
+	var accepted *metav1.Condition
+	if class != nil {
+		accepted = apimeta.FindStatusCondition(class.Status.Conditions, "Accepted")
+	}
+	switch {
+	case !known:
+		// The omission is already noted; neither missing nor rejected can be told
+	case class == nil:
+		c.add(findingIngressClassMismatch, ref,
+			fmt.Sprintf("Gateway %s/%s uses GatewayClass %q, which does not exist", gw.Namespace, gw.Name, gw.Spec.GatewayClassName), nil)
+	case accepted != nil && accepted.Status == metav1.ConditionFalse:
+		c.add(findingIngressClassMismatch, ref,
+			fmt.Sprintf("Gateway %s/%s uses GatewayClass %q, which its controller has not accepted: %s: %s",
+				gw.Namespace, gw.Name, gw.Spec.GatewayClassName, accepted.Reason, accepted.Message), nil)
+	}
+
+	for _, listener := range gw.Spec.Listeners {
+		if listener.TLS == nil {
+			continue
+		}
+		for _, cert := range listener.TLS.CertificateRefs {
+			if cert.Kind != nil && *cert.Kind != "Secret" {
+				continue
+			}
+			c.checkTLSSecret(refNamespace(cert.Namespace, gw.Namespace), cert.Name, ref)
+		}
+	}
+}
+
+func (c *ingressChecker) routeBackend(route httpRoute, host, path string, backend gatewayObjectRef, ref ObjectRef) RouteBackend {
+	namespace := refNamespace(backend.Namespace, route.Namespace)
+	result := RouteBackend{Host: host, Path: path, Service: namespace + "/" + backend.Name}
+	if backend.Kind != nil && *backend.Kind != "Service" {
+		result.Service = fmt.Sprintf("%s %s", *backend.Kind, result.Service)
+		result.Verdict = BackendHealthy
+		return result
+	}
+	var port int32
+	if backend.Port != nil {
+		port = *backend.Port
+		result.Port = fmt.Sprint(port)
+	}
+
+	if namespace != route.Namespace && !c.referenceGranted(route.Namespace, namespace, backend.Name) {
+		result.Verdict = BackendNotPermitted
+		c.add(findingRouteBackendNotPermitted, ref,
+			fmt.Sprintf("HTTPRoute %s/%s %s%s: backend %s is in another namespace and no ReferenceGrant in %s allows it",
+				route.Namespace, route.Name, host, path, result.Service, namespace),
+			map[string]string{"service": backend.Name})
+		return result
+	}
+
+	c.backendVerdict(&result, namespace, backend.Name, "", port, ref)
+	return result
+}
+
+// referenceGranted reports whether a ReferenceGrant lets HTTPRoutes in from reference a Service in to
+func (c *ingressChecker) referenceGranted(from, to, service string) bool {
+	list, err := c.s.dynamic.Resource(referenceGrantsGVR).Namespace(to).List(c.ctx, metav1.ListOptions{})
+	if err != nil {
+		noteOmitted(c.ctx, "ReferenceGrants in "+to, err)
+		return false
+	}
+	for _, item := range list.Items {
+		var grant referenceGrant
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &grant); err != nil {
+			continue
+		}
+		fromOK := false
+		for _, f := range grant.Spec.From {
+			if f.Group == gatewayClassesGVR.Group && f.Kind == "HTTPRoute" && f.Namespace == from {
+				fromOK = true
+			}
+		}
+		for _, t := range grant.Spec.To {
+			if fromOK && t.Group == "" && t.Kind == "Service" && (t.Name == nil || *t.Name == service) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *ingressChecker) add(def findingDef, ref ObjectRef, message string, evidence map[string]string) {
+	c.findings = append(c.findings, newFinding(def, ref, message, evidence))
+}
+
+// ingressClassName resolves the class an Ingress is served by, falling back to the default IngressClass
+func ingressClassName(ingress networkingv1.Ingress, classes []networkingv1.IngressClass) string {
+	if ingress.Spec.IngressClassName != nil {
+		return *ingress.Spec.IngressClassName
+	}
+	if class := ingress.Annotations[legacyIngressClassAnnotation]; class != "" {
+		return class
+	}
+	for _, class := range classes {
+		if class.Annotations[defaultIngressClassAnnotation] == "true" {
+			return class.Name
+		}
+	}
+	return ""
+}
+
+func ingressClassExists(name string, classes []networkingv1.IngressClass) bool {
+	for _, class := range classes {
+		if class.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ingressOverlaps indexes every host/path rule by controller scope
+func ingressOverlaps(ingresses []networkingv1.Ingress, classes []networkingv1.IngressClass) map[string][]string {
+	index := map[string][]string{}
+	for _, ingress := range ingresses {
+		class := ingressClassName(ingress, classes)
+		id := ingress.Namespace + "/" + ingress.Name
+		for _, rule := range ingress.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			host := rule.Host
+			if host == "" {
+				host = "*"
+			}
+			for _, path := range rule.HTTP.Paths {
+				key := overlapKey(class, host, normalizePath(path.Path))
+				index[key] = appendUnique(index[key], id)
+			}
+		}
+	}
+	return index
+}
+
+// httpRouteOverlaps indexes every hostname/path match by parent Gateway
+func httpRouteOverlaps(routes []httpRoute) map[string][]string {
+	index := map[string][]string{}
+	for _, route := range routes {
+		id := route.Namespace + "/" + route.Name
+		hosts := route.Spec.Hostnames
+		if len(hosts) == 0 {
+			hosts = []string{"*"}
+		}
+		for _, parent := range route.Spec.ParentRefs {
+			gateway := refNamespace(parent.Namespace, route.Namespace) + "/" + parent.Name
+			for _, rule := range route.Spec.Rules {
+				for _, path := range rule.paths() {
+					for _, host := range hosts {
+						key := overlapKey(gateway, host, path)
+						index[key] = appendUnique(index[key], id)
+					}
+				}
+			}
+		}
+	}
+	return index
+}
+
+func overlapKey(scope, host, path string) string {
+	return scope + "|" + host + "|" + path
+}
+
+func normalizePath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+func otherObjects(ids []string, self string) []string {
+	var others []string
+	for _, id := range ids {
+		if id != self {
+			others = append(others, id)
+		}
+	}
+	sort.Strings(others)
+	return others
+}
+
+func appendUnique(list []string, value string) []string {
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+func refNamespace(namespace *string, fallback string) string {
+	if namespace != nil && *namespace != "" {
+		return *namespace
+	}
+	return fallback
+}
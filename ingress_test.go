@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// forbidClusterWide makes cluster-wide lists and cluster-scoped reads of a
+// resource fail the way they do for a caller with only namespace RBAC
+func forbidClusterWide(verb, resource string) (string, string, k8stesting.ReactionFunc) {
+	return verb, resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetNamespace() != "" {
+			return false, nil, nil
+		}
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: resource}, "",
+			fmt.Errorf("user cannot %s %s at the cluster scope", verb, resource))
+	}
+}
+
+// forbidGet makes reads of one object fail the way they do for a caller whose
+// RBAC does not cover it; an empty name forbids every read of the resource
+func forbidGet(resource, name string) (string, string, k8stesting.ReactionFunc) {
+	return "get", resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if name != "" && action.(k8stesting.GetAction).GetName() != name {
+			return false, nil, nil
+		}
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: resource}, name,
+			fmt.Errorf("user cannot get %s", resource))
+	}
+}
+
+// forbidList makes lists of a resource fail in every namespace
+func forbidList(resource string) (string, string, k8stesting.ReactionFunc) {
+	return "list", resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: resource}, "",
+			fmt.Errorf("user cannot list %s", resource))
+	}
+}
+
+func TestDiagnoseIngressWithNamespaceRBAC(t *testing.T) {
+	className := "nginx"
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "shop"},
+		Spec:       networkingv1.IngressSpec{IngressClassName: &className},
+	}
+	clientset := fake.NewSimpleClientset(ingress)
+	clientset.PrependReactor(forbidClusterWide("list", "ingresses"))
+	clientset.PrependReactor(forbidClusterWide("list", "ingressclasses"))
+
+	route := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "gateway.networking.k8s.io/v1",
+		"kind":       "HTTPRoute",
+		"metadata":   map[string]interface{}{"namespace": "web", "name": "shop-route"},
+		"spec": map[string]interface{}{
+			"parentRefs": []interface{}{map[string]interface{}{"name": "public"}},
+		},
+	}}
+	gateway := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "gateway.networking.k8s.io/v1",
+		"kind":       "Gateway",
+		"metadata":   map[string]interface{}{"namespace": "web", "name": "public"},
+		"spec":       map[string]interface{}{"gatewayClassName": "eg"},
+	}}
+	dynamic := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		httpRoutesGVR:      "HTTPRouteList",
+		gatewaysGVR:        "GatewayList",
+		gatewayClassesGVR:  "GatewayClassList",
+		referenceGrantsGVR: "ReferenceGrantList",
+	})
+	for gvr, obj := range map[schema.GroupVersionResource]*unstructured.Unstructured{httpRoutesGVR: route, gatewaysGVR: gateway} {
+		if err := dynamic.Tracker().Create(gvr, obj, obj.GetNamespace()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dynamic.PrependReactor(forbidClusterWide("list", "httproutes"))
+	dynamic.PrependReactor(forbidClusterWide("get", "gatewayclasses"))
+
+	s := &K8sDiagnosticsServer{clientset: clientset, dynamic: dynamic}
+	result, err := s.diagnoseIngress(context.Background(), "web", "")
+	if err != nil {
+		t.Fatalf("diagnoseIngress: %v", err)
+	}
+
+	if len(result.Ingresses) != 1 || result.Ingresses[0].Name != "shop" {
+		t.Errorf("expected the namespace's Ingress, got %+v", result.Ingresses)
+	}
+	if len(result.HTTPRoutes) != 1 || result.HTTPRoutes[0].Name != "shop-route" {
+		t.Errorf("expected the namespace's HTTPRoute, got %+v", result.HTTPRoutes)
+	}
+	for _, want := range []string{"Ingress overlap check", "IngressClass checks", "HTTPRoute overlap check", "GatewayClass eg check"} {
+		found := false
+		for _, note := range result.Omitted {
+			found = found || strings.HasPrefix(note, want)
+		}
+		if !found {
+			t.Errorf("expected an omission for %q, got %v", want, result.Omitted)
+		}
+	}
+	// Classes that could not be read must not be reported as missing
+	for _, finding := range result.Findings {
+		if finding.ID == findingIngressClassMismatch.ID {
+			t.Errorf("unexpected class finding: %s", finding.Message)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	clientmetrics "k8s.io/client-go/tools/metrics"
+)
+
+// Tool call outcomes, the outcome label of k8s_diagnostics_tool_calls_total
+const (
+	OutcomeSuccess     = "success"
+	OutcomePartial     = "partial"
+	OutcomeClientError = "client_error"
+	OutcomeError       = "error"
+	OutcomeTimeout     = "timeout"
+	// HTTP calls rejected before the tool runs: bad credentials, or a tool
+	// outside the caller's scope
+	OutcomeUnauthorized = "unauthorized"
+	OutcomeForbidden    = "forbidden"
+)
+
+// ServerMetrics holds the Prometheus metrics the server exposes about itself on /metrics
+type ServerMetrics struct {
+	registry *prometheus.Registry
+
+	toolCalls    *prometheus.CounterVec
+	toolDuration *prometheus.HistogramVec
+	toolInFlight *prometheus.GaugeVec
+
+	apiDuration *prometheus.HistogramVec
+	apiResults  *prometheus.CounterVec
+}
+
+var (
+	serverMetrics     *ServerMetrics
+	serverMetricsOnce sync.Once
+)
+
+// getServerMetrics returns the process-wide metrics. client-go accepts a
+// single metrics adapter per process, so there is exactly one set.
+func getServerMetrics() *ServerMetrics {
+	serverMetricsOnce.Do(func() {
+		m := &ServerMetrics{
+			registry: prometheus.NewRegistry(),
+			toolCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "k8s_diagnostics_tool_calls_total",
+				Help: "Tool calls by tool and outcome (success, partial, client_error, error, timeout).",
+			}, []string{"tool", "outcome"}),
+			toolDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "k8s_diagnostics_tool_call_duration_seconds",
+				Help:    "Tool call latency by tool.",
+				Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+			}, []string{"tool"}),
+			toolInFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+				Name: "k8s_diagnostics_tool_calls_in_flight",
+				Help: "Tool calls currently running, by tool.",
+			}, []string{"tool"}),
+			apiDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Name:    "k8s_diagnostics_kube_api_request_duration_seconds",
+				Help:    "Kubernetes API request latency by verb and resource.",
+				Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+			}, []string{"verb", "resource"}),
+			apiResults: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Name: "k8s_diagnostics_kube_api_requests_total",
+				Help: "Kubernetes API requests by HTTP method and status code.",
+			}, []string{"method", "code"}),
+		}
+		m.registry.MustRegister(m.toolCalls, m.toolDuration, m.toolInFlight, m.apiDuration, m.apiResults,
+			collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+		clientmetrics.Register(clientmetrics.RegisterOpts{
+			RequestLatency: kubeAPILatency{m.apiDuration},
+			RequestResult:  kubeAPIResults{m.apiResults},
+		})
+		serverMetrics = m
+	})
+	return serverMetrics
+}
+
+// watchCaches reports the sizes of the server's in-memory caches. The tools
+// read live from the API server rather than through informers, so these are
+// the caches there are: usage samples, per-caller clients and compiled rules.
+func (m *ServerMetrics) watchCaches(s *K8sDiagnosticsServer) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "k8s_diagnostics_cache_entries",
+		Help:        "Entries in the server's in-memory caches.",
+		ConstLabels: prometheus.Labels{"cache": "usage_samples"},
+	}, func() float64 {
+		if sampler, ok := s.usage.(*metricsSampler); ok {
+			return float64(sampler.sampleCount())
+		}
+		return 0
+	}), prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "k8s_diagnostics_cache_entries",
+		Help:        "Entries in the server's in-memory caches.",
+		ConstLabels: prometheus.Labels{"cache": "caller_clients"},
+	}, func() float64 {
+		return float64(s.callers.size())
+	}), prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name:        "k8s_diagnostics_cache_entries",
+		Help:        "Entries in the server's in-memory caches.",
+		ConstLabels: prometheus.Labels{"cache": "rules"},
+	}, func() float64 {
+		return float64(s.rules.RuleCount())
+	}))
+}
+
+// handler serves the metrics in the Prometheus exposition format
+func (m *ServerMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// observe records one tool call
+func (m *ServerMetrics) observe(tool, outcome string, duration time.Duration) {
+	m.toolCalls.WithLabelValues(tool, outcome).Inc()
+	m.toolDuration.WithLabelValues(tool).Observe(duration.Seconds())
+}
+
+// mcpMiddleware records MCP tool calls. It runs inside the timeout middleware
+// so it can tell timeouts and partial results apart.
+func (m *ServerMetrics) mcpMiddleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		tool := req.Params.Name
+		m.toolInFlight.WithLabelValues(tool).Inc()
+		defer m.toolInFlight.WithLabelValues(tool).Dec()
+		start := time.Now()
+
+		result, err := next(ctx, req)
+		outcome := OutcomeSuccess
+		switch {
+		case (err != nil || result == nil || result.IsError) && timedOut(ctx):
+			outcome = OutcomeTimeout
+		case err != nil || result == nil || result.IsError:
+			outcome = OutcomeError
+		case timedOut(ctx):
+			outcome = OutcomePartial
+		}
+		m.observe(tool, outcome, time.Since(start))
+		return result, err
+	}
+}
+
+// statusRecorder remembers the status code a handler wrote
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(data)
+}
+
+// instrument records HTTP tool calls. It runs inside withTimeout so it can
+// tell timeouts and partial results apart; authorize counts the calls it rejects.
+func (s *HTTPServer) instrument(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tool := strings.TrimPrefix(r.URL.Path, "/")
+		s.metrics.toolInFlight.WithLabelValues(tool).Inc()
+		defer s.metrics.toolInFlight.WithLabelValues(tool).Dec()
+		start := time.Now()
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next(recorder, r)
+		outcome := OutcomeSuccess
+		switch {
+		case recorder.status >= http.StatusInternalServerError && timedOut(r.Context()):
+			outcome = OutcomeTimeout
+		case recorder.status >= http.StatusInternalServerError:
+			outcome = OutcomeError
+		case recorder.status >= http.StatusBadRequest:
+			outcome = OutcomeClientError
+		case timedOut(r.Context()):
+			outcome = OutcomePartial
+		}
+		s.metrics.observe(tool, outcome, time.Since(start))
+	}
+}
+
+// serveMetricsFromEnv serves /metrics on METRICS_ADDR, if set, in the
+// background. It returns whether a listener was started.
+func serveMetricsFromEnv(m *ServerMetrics) bool {
+	addr := os.Getenv("METRICS_ADDR")
+	if addr == "" {
+		return false
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.handler())
+	go func() {
+		metricsServer := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		log.Printf("Serving metrics on %s/metrics", addr)
+		if err := metricsServer.ListenAndServe(); err != nil {
+			log.Printf("Metrics listener stopped: %v", err)
+		}
+	}()
+	return true
+}
+
+// kubeAPILatency adapts client-go request latencies to the verb/resource histogram
+type kubeAPILatency struct {
+	histogram *prometheus.HistogramVec
+}
+
+func (k kubeAPILatency) Observe(_ context.Context, method string, u url.URL, latency time.Duration) {
+	verb, resource := kubeRequestInfo(method, u)
+	k.histogram.WithLabelValues(verb, resource).Observe(latency.Seconds())
+}
+
+// kubeAPIResults adapts client-go request results to the method/code counter
+type kubeAPIResults struct {
+	counter *prometheus.CounterVec
+}
+
+func (k kubeAPIResults) Increment(_ context.Context, code, method, _ string) {
+	k.counter.WithLabelValues(method, code).Inc()
+}
+
+// kubeRequestInfo derives the Kubernetes verb and resource of an API request
+// from its method and path, e.g. GET /api/v1/namespaces/a/pods is a pods list
+// and GET /api/v1/namespaces/a/pods/b/log is a pods/log get
+func kubeRequestInfo(method string, u url.URL) (string, string) {
+	var segments []string
+	if i := strings.Index(u.Path, "/api/"); i >= 0 {
+		segments = dropSegments(splitPath(u.Path[i+len("/api/"):]), 1) // version
+	} else if i := strings.Index(u.Path, "/apis/"); i >= 0 {
+		segments = dropSegments(splitPath(u.Path[i+len("/apis/"):]), 2) // group and version
+	} else {
+		return strings.ToLower(method), "nonresource"
+	}
+
+	if len(segments) >= 2 && segments[0] == "namespaces" && len(segments) > 2 {
+		segments = segments[2:]
+	}
+	if len(segments) == 0 {
+		return strings.ToLower(method), "discovery"
+	}
+	resource, named := segments[0], len(segments) > 1
+	if len(segments) > 2 {
+		resource += "/" + segments[2]
+	}
+
+	switch method {
+	case http.MethodGet:
+		if watch, _ := strconv.ParseBool(u.Query().Get("watch")); watch {
+			return "watch", resource
+		}
+		if named {
+			return "get", resource
+		}
+		return "list", resource
+	case http.MethodPost:
+		return "create", resource
+	case http.MethodPut:
+		return "update", resource
+	case http.MethodPatch:
+		return "patch", resource
+	case http.MethodDelete:
+		return "delete", resource
+	}
+	return strings.ToLower(method), resource
+}
+
+func dropSegments(segments []string, n int) []string {
+	if len(segments) <= n {
+		return nil
+	}
+	return segments[n:]
+}
+
+func splitPath(path string) []string {
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// newTLSConfigFromEnv returns the HTTP server's TLS configuration, or nil to serve
+// plain HTTP:
+//
+//	TLS_CERT_FILE, TLS_KEY_FILE  serving certificate and key, reloaded when they change
+//	TLS_RELOAD_INTERVAL          how often the files are checked (default: 30s)
+//	TLS_CLIENT_CA_FILE           CA bundle for client certificates; enables mTLS authentication
+func newTLSConfigFromEnv() (*tls.Config, error) {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	clientCAFile := os.Getenv("TLS_CLIENT_CA_FILE")
+	if certFile == "" && keyFile == "" {
+		if clientCAFile != "" {
+			return nil, fmt.Errorf("TLS_CLIENT_CA_FILE needs TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	certs := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := certs.reload(); err != nil {
+		return nil, err
+	}
+	interval, err := durationFromEnv("TLS_RELOAD_INTERVAL", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	go certs.Watch(context.Background(), interval)
+
+	config := &tls.Config{GetCertificate: certs.GetCertificate, MinVersion: tls.VersionTLS12}
+	if clientCAFile != "" {
+		data, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS_CLIENT_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("TLS_CLIENT_CA_FILE %s has no PEM certificates", clientCAFile)
+		}
+		// Certificates stay optional at the TLS layer so bearer tokens and probes keep working
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// certReloader serves the current certificate and key pair, picking up
+// renewals (e.g. from cert-manager) without a restart
+type certReloader struct {
+	certFile, keyFile string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTimes [2]time.Time
+}
+
+// reload re-reads the certificate and key if either changed since the last load.
+// A broken pair keeps the previous certificate in place.
+func (c *certReloader) reload() (bool, error) {
+	var modTimes [2]time.Time
+	for i, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, fmt.Errorf("failed to stat TLS file: %w", err)
+		}
+		modTimes[i] = info.ModTime()
+	}
+
+	c.mu.RLock()
+	unchanged := c.cert != nil && modTimes == c.modTimes
+	c.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.modTimes = modTimes
+	c.mu.Unlock()
+	return true, nil
+}
+
+// Watch polls the certificate files until ctx is cancelled
+func (c *certReloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := c.reload()
+			if err != nil {
+				log.Printf("TLS certificate reload failed, keeping previous certificate: %v", err)
+			} else if changed {
+				log.Printf("Reloaded TLS certificate from %s", c.certFile)
+			}
+		}
+	}
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// newServerFromEnv builds the http.Server with timeouts from the environment:
+//
+//	HTTP_READ_HEADER_TIMEOUT  default 10s
+//	HTTP_READ_TIMEOUT         default 30s
+//	HTTP_WRITE_TIMEOUT        default 2m, long enough for the slowest diagnoses
+//	HTTP_IDLE_TIMEOUT         default 2m
+func newServerFromEnv(addr string, handler http.Handler, tlsConfig *tls.Config) (*http.Server, error) {
+	server := &http.Server{Addr: addr, Handler: handler, TLSConfig: tlsConfig}
+	for _, timeout := range []struct {
+		env      string
+		fallback time.Duration
+		field    *time.Duration
+	}{
+		{"HTTP_READ_HEADER_TIMEOUT", 10 * time.Second, &server.ReadHeaderTimeout},
+		{"HTTP_READ_TIMEOUT", 30 * time.Second, &server.ReadTimeout},
+		{"HTTP_WRITE_TIMEOUT", 2 * time.Minute, &server.WriteTimeout},
+		{"HTTP_IDLE_TIMEOUT", 2 * time.Minute, &server.IdleTimeout},
+	} {
+		value, err := durationFromEnv(timeout.env, timeout.fallback)
+		if err != nil {
+			return nil, err
+		}
+		*timeout.field = value
+	}
+	return server, nil
+}
+
+// readinessCacheTTL bounds how often /readyz probes the Kubernetes API
+const readinessCacheTTL = 5 * time.Second
+
+// readiness tracks whether the server should receive traffic: it is not ready
+// while draining for shutdown or when the Kubernetes API is unreachable
+type readiness struct {
+	draining atomic.Bool
+
+	mu        sync.Mutex
+	checkedAt time.Time
+	lastError error
+}
+
+// checkReady returns why the server is not ready, or nil
+func (s *HTTPServer) checkReady(ctx context.Context) error {
+	if s.readiness.draining.Load() {
+		return fmt.Errorf("shutting down")
+	}
+	if s.demoMode {
+		return nil
+	}
+
+	s.readiness.mu.Lock()
+	defer s.readiness.mu.Unlock()
+	if time.Since(s.readiness.checkedAt) < readinessCacheTTL {
+		return s.readiness.lastError
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	// The version endpoint is readable by every client, so this tests reachability, not RBAC
+	_, err := s.diagnostics.clientset.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Raw()
+	if err != nil {
+		err = fmt.Errorf("kubernetes API unreachable: %w", err)
+	}
+	s.readiness.checkedAt, s.readiness.lastError = time.Now(), err
+	return err
+}
+
+// serveUntilSignal runs the server until ctx is cancelled by SIGTERM or SIGINT.
+// /readyz then fails, and after SHUTDOWN_DELAY (default: none) for load
+// balancers to notice, the server stops accepting connections and waits up to
+// SHUTDOWN_TIMEOUT (default: 30s) for in-flight requests to finish.
+func (s *HTTPServer) serveUntilSignal(ctx context.Context, httpServer *http.Server) error {
+	shutdownTimeout, err := durationFromEnv("SHUTDOWN_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return err
+	}
+	var shutdownDelay time.Duration
+	if os.Getenv("SHUTDOWN_DELAY") != "" {
+		if shutdownDelay, err = durationFromEnv("SHUTDOWN_DELAY", 0); err != nil {
+			return err
+		}
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		if httpServer.TLSConfig != nil {
+			// The certificate comes from TLSConfig.GetCertificate
+			errs <- httpServer.ListenAndServeTLS("", "")
+		} else {
+			errs <- httpServer.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	s.readiness.draining.Store(true)
+	if shutdownDelay > 0 {
+		log.Printf("Shutting down in %s, no longer ready", shutdownDelay)
+		time.Sleep(shutdownDelay)
+	}
+	log.Printf("Shutting down, draining in-flight requests for up to %s", shutdownTimeout)
+	drainCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(drainCtx); err != nil {
+		return fmt.Errorf("shutdown did not finish in %s: %w", shutdownTimeout, err)
+	}
+	log.Println("HTTP server stopped")
+	return nil
+}
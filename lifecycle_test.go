@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// writeTestCertPair writes a self-signed certificate and key for commonName
+func writeTestCertPair(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func servedCommonName(t *testing.T, certs *certReloader) string {
+	t.Helper()
+	cert, err := certs.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil || cert == nil {
+		t.Fatalf("GetCertificate() = %v, %v", cert, err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+// touch moves both files' modification times forward so reload sees a change
+func touch(t *testing.T, files ...string) {
+	t.Helper()
+	later := time.Now().Add(time.Minute)
+	for _, file := range files {
+		if err := os.Chtimes(file, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCertReloaderKeepsPreviousCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeTestCertPair(t, certFile, keyFile, "first")
+
+	certs := &certReloader{certFile: certFile, keyFile: keyFile}
+	if changed, err := certs.reload(); err != nil || !changed {
+		t.Fatalf("initial reload() = %v, %v", changed, err)
+	}
+	if changed, err := certs.reload(); err != nil || changed {
+		t.Errorf("reload() of unchanged files = %v, %v, want false, nil", changed, err)
+	}
+
+	// A half-written renewal: the certificate is replaced but the key is garbage
+	writeTestCertPair(t, certFile, filepath.Join(dir, "other.key"), "second")
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, certFile, keyFile)
+	if _, err := certs.reload(); err == nil {
+		t.Fatal("reload() of a broken pair succeeded")
+	}
+	if name := servedCommonName(t, certs); name != "first" {
+		t.Errorf("served certificate %q after a failed reload, want the previous one", name)
+	}
+
+	// The completed renewal is picked up
+	writeTestCertPair(t, certFile, keyFile, "second")
+	touch(t, certFile, keyFile)
+	if changed, err := certs.reload(); err != nil || !changed {
+		t.Fatalf("reload() of a renewed pair = %v, %v", changed, err)
+	}
+	if name := servedCommonName(t, certs); name != "second" {
+		t.Errorf("served certificate %q, want the renewed one", name)
+	}
+}
+
+// newVersionCountingServer returns an HTTPServer whose Kubernetes API counts /version requests
+func newVersionCountingServer(t *testing.T, status int) (*HTTPServer, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/version" {
+			calls.Add(1)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"major":"1","minor":"30"}`))
+	}))
+	t.Cleanup(api.Close)
+
+	clientset, err := kubernetes.NewForConfig(&rest.Config{Host: api.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &HTTPServer{diagnostics: &K8sDiagnosticsServer{clientset: clientset}}, &calls
+}
+
+func TestCheckReadyCachesResult(t *testing.T) {
+	s, calls := newVersionCountingServer(t, http.StatusOK)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if err := s.checkReady(ctx); err != nil {
+			t.Fatalf("checkReady() = %v", err)
+		}
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("API probed %d times within readinessCacheTTL, want 1", got)
+	}
+
+	// Once the cached result expires the API is probed again
+	s.readiness.checkedAt = time.Now().Add(-readinessCacheTTL)
+	if err := s.checkReady(ctx); err != nil {
+		t.Fatalf("checkReady() = %v", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("API probed %d times after the cache expired, want 2", got)
+	}
+}
+
+func TestCheckReadyCachesFailure(t *testing.T) {
+	s, calls := newVersionCountingServer(t, http.StatusServiceUnavailable)
+
+	for i := 0; i < 2; i++ {
+		err := s.checkReady(context.Background())
+		if err == nil || !strings.Contains(err.Error(), "unreachable") {
+			t.Fatalf("checkReady() = %v, want an unreachable error", err)
+		}
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("API probed %d times within readinessCacheTTL, want 1", got)
+	}
+}
+
+func TestCheckReadyFailsWhileDraining(t *testing.T) {
+	s, calls := newVersionCountingServer(t, http.StatusOK)
+	s.readiness.draining.Store(true)
+
+	if err := s.checkReady(context.Background()); err == nil || !strings.Contains(err.Error(), "shutting down") {
+		t.Errorf("checkReady() = %v, want shutting down", err)
+	}
+	if got := calls.Load(); got != 0 {
+		t.Errorf("API probed %d times while draining, want 0", got)
+	}
+
+	demo := &HTTPServer{demoMode: true}
+	demo.readiness.draining.Store(true)
+	if err := demo.checkReady(context.Background()); err == nil {
+		t.Error("checkReady() in demo mode succeeded while draining")
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func TestServeUntilSignalDrainsInFlightRequests(t *testing.T) {
+	t.Setenv("SHUTDOWN_TIMEOUT", "5s")
+	t.Setenv("SHUTDOWN_DELAY", "")
+
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+	addr := freeAddr(t)
+	s := &HTTPServer{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	served := make(chan error, 1)
+	go func() { served <- s.serveUntilSignal(ctx, &http.Server{Addr: addr, Handler: handler}) }()
+
+	// Wait for the listener before sending the slow request
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	type result struct {
+		status int
+		err    error
+	}
+	responses := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow")
+		if err != nil {
+			responses <- result{err: err}
+			return
+		}
+		resp.Body.Close()
+		responses <- result{status: resp.StatusCode}
+	}()
+	<-started
+
+	cancel()
+	select {
+	case err := <-served:
+		t.Fatalf("serveUntilSignal() returned %v before the in-flight request finished", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if !s.readiness.draining.Load() {
+		t.Error("readiness not draining after the signal")
+	}
+
+	close(release)
+	if res := <-responses; res.err != nil || res.status != http.StatusOK {
+		t.Errorf("in-flight request = %d, %v, want 200", res.status, res.err)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("serveUntilSignal() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serveUntilSignal() did not return after draining")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -14,14 +15,30 @@ import (
 	"github.com/mark3labs/mcp-go/server"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
+	metricsclientset "k8s.io/metrics/pkg/client/clientset/versioned"
 )
 
 type K8sDiagnosticsServer struct {
-	clientset *kubernetes.Clientset
+	clientset  kubernetes.Interface
+	metrics    metricsclientset.Interface
+	dynamic    dynamic.Interface
+	policy     *PolicyStore
+	access     *AccessPolicy
+	rules      *RuleEngine
+	prometheus *PrometheusClient
+	usage      usageHistory
+
+	// config and callers back per-caller servers on the HTTP server;
+	// identity is set on those servers to the caller they impersonate
+	config   *rest.Config
+	callers  *callerServers
+	identity *Identity
 }
 
 type PodDiagnostic struct {
@@ -31,9 +48,18 @@ type PodDiagnostic struct {
 	RestartCount int32             `json:"restart_count"`
 	Issues       []string          `json:"issues"`
 	Suggestions  []string          `json:"suggestions"`
+	Findings     []Finding         `json:"findings"`
+	Summary      *FindingSummary   `json:"summary,omitempty"`
 	Events       []string          `json:"recent_events"`
 	Resources    map[string]string `json:"resources"`
-	CreatedAt    time.Time         `json:"created_at"`
+	Probes       []ProbeReport     `json:"probes,omitempty"`
+	// Metrics is attached by diagnose_pod when a Prometheus endpoint is configured
+	Metrics *PodMetricsSummary `json:"metrics,omitempty"`
+	// Omitted lists the data left out because the server lacks permission to read it
+	Omitted []string `json:"omitted,omitempty"`
+	// RuleErrors lists custom rules that failed to evaluate against the pod
+	RuleErrors []string  `json:"rule_errors,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
 }
 
 type ClusterHealth struct {
@@ -43,30 +69,126 @@ type ClusterHealth struct {
 	PodIssues       []PodDiagnostic        `json:"pod_issues"`
 	ResourceUsage   map[string]interface{} `json:"resource_usage"`
 	Recommendations []string               `json:"recommendations"`
+	Capacity        *CapacityTotals        `json:"capacity,omitempty"`
+	Findings        []Finding              `json:"findings"`
+	Summary         *FindingSummary        `json:"summary,omitempty"`
+	Omitted         []string               `json:"omitted,omitempty"`
 	Timestamp       time.Time              `json:"timestamp"`
 }
 
 type LogAnalysis struct {
-	PodName      string   `json:"pod_name"`
-	Namespace    string   `json:"namespace"`
-	LogLines     int      `json:"log_lines"`
-	ErrorsFound  []string `json:"errors_found"`
-	Suggestions  []string `json:"suggestions"`
-	ErrorCount   int      `json:"error_count"`
-	WarningCount int      `json:"warning_count"`
+	PodName      string          `json:"pod_name"`
+	Namespace    string          `json:"namespace"`
+	LogLines     int             `json:"log_lines"`
+	ErrorsFound  []string        `json:"errors_found"`
+	Suggestions  []string        `json:"suggestions"`
+	ErrorCount   int             `json:"error_count"`
+	WarningCount int             `json:"warning_count"`
+	Findings     []Finding       `json:"findings"`
+	Summary      *FindingSummary `json:"summary,omitempty"`
+}
+
+// WorkloadRecommendations holds the best-practice findings for a namespace
+type WorkloadRecommendations struct {
+	Namespace       string          `json:"namespace"`
+	Recommendations []string        `json:"recommendations"`
+	Findings        []Finding       `json:"findings"`
+	Summary         *FindingSummary `json:"summary,omitempty"`
+	// RightSizing holds per-container suggestions derived from observed usage
+	RightSizing []RightSizingRecommendation `json:"right_sizing"`
+	// RightSizingErrors lists deployments whose usage could not be read
+	RightSizingErrors []string `json:"right_sizing_errors,omitempty"`
+	// RuleErrors lists custom Deployment rules that failed to evaluate
+	RuleErrors []string `json:"rule_errors,omitempty"`
+	// Omitted lists the data left out because the server lacks permission to read it
+	Omitted []string `json:"omitted,omitempty"`
+}
+
+// ResourceAmounts holds requests, limits and live usage. CPU is in
+// millicores and memory in bytes; percentages are usage relative to the
+// request or limit and are omitted when that value is not set.
+type ResourceAmounts struct {
+	CPURequestMillis     int64    `json:"cpu_request_millicores"`
+	CPULimitMillis       int64    `json:"cpu_limit_millicores"`
+	CPUUsageMillis       int64    `json:"cpu_usage_millicores"`
+	MemoryRequestBytes   int64    `json:"memory_request_bytes"`
+	MemoryLimitBytes     int64    `json:"memory_limit_bytes"`
+	MemoryUsageBytes     int64    `json:"memory_usage_bytes"`
+	CPURequestPercent    *float64 `json:"cpu_request_percent,omitempty"`
+	CPULimitPercent      *float64 `json:"cpu_limit_percent,omitempty"`
+	MemoryRequestPercent *float64 `json:"memory_request_percent,omitempty"`
+	MemoryLimitPercent   *float64 `json:"memory_limit_percent,omitempty"`
+}
+
+func (a *ResourceAmounts) add(other ResourceAmounts) {
+	a.CPURequestMillis += other.CPURequestMillis
+	a.CPULimitMillis += other.CPULimitMillis
+	a.CPUUsageMillis += other.CPUUsageMillis
+	a.MemoryRequestBytes += other.MemoryRequestBytes
+	a.MemoryLimitBytes += other.MemoryLimitBytes
+	a.MemoryUsageBytes += other.MemoryUsageBytes
+}
+
+func (a *ResourceAmounts) setPercentages() {
+	a.CPURequestPercent = percentOf(a.CPUUsageMillis, a.CPURequestMillis)
+	a.CPULimitPercent = percentOf(a.CPUUsageMillis, a.CPULimitMillis)
+	a.MemoryRequestPercent = percentOf(a.MemoryUsageBytes, a.MemoryRequestBytes)
+	a.MemoryLimitPercent = percentOf(a.MemoryUsageBytes, a.MemoryLimitBytes)
+}
+
+// ContainerResourceInfo holds resource configuration and usage for a container
+type ContainerResourceInfo struct {
+	Name string `json:"name"`
+	ResourceAmounts
+	RestartCount int32 `json:"restart_count"`
+	// CPUThrottlingPercent is the share of CFS periods in which the container was throttled
+	CPUThrottlingPercent *float64  `json:"cpu_throttling_percent,omitempty"`
+	Findings             []Finding `json:"findings,omitempty"`
 }
 
 // PodResourceInfo holds resource usage and status info for a pod
 type PodResourceInfo struct {
-	Name              string `json:"name"`
-	Namespace         string `json:"namespace"`
-	CPURequest        string `json:"cpu_request"`
-	MemoryRequest     string `json:"memory_request"`
-	CPULimit          string `json:"cpu_limit"`
-	MemoryLimit       string `json:"memory_limit"`
-	RestartCount      int32  `json:"restart_count"`
-	Status            string `json:"status"`
-	HasResourceIssues bool   `json:"has_resource_issues"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	ResourceAmounts
+	RestartCount      int32                   `json:"restart_count"`
+	Status            string                  `json:"status"`
+	HasResourceIssues bool                    `json:"has_resource_issues"`
+	MetricsAvailable  bool                    `json:"metrics_available"`
+	Containers        []ContainerResourceInfo `json:"containers"`
+}
+
+// ResourceUsageReport is the get_resource_usage result
+type ResourceUsageReport struct {
+	Namespace        string            `json:"namespace"`
+	SortBy           string            `json:"sort_by"`
+	PodCount         int               `json:"pod_count"`
+	MetricsAvailable bool              `json:"metrics_available"`
+	MetricsError     string            `json:"metrics_error,omitempty"`
+	PressureSource   string            `json:"pressure_source,omitempty"`
+	PressureError    string            `json:"pressure_error,omitempty"`
+	ResourceUsage    []PodResourceInfo `json:"resource_usage"`
+	Findings         []Finding         `json:"findings"`
+	Summary          *FindingSummary   `json:"summary,omitempty"`
+}
+
+// PodStatusInfo is one pod in a list_pods result
+type PodStatusInfo struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Status    string `json:"status"`
+	Ready     string `json:"ready"`
+	Restarts  int32  `json:"restarts"`
+	Age       string `json:"age"`
+}
+
+// PodListResult is the list_pods result
+type PodListResult struct {
+	Namespace string          `json:"namespace"`
+	PodCount  int             `json:"pod_count"`
+	Pods      []PodStatusInfo `json:"pods"`
+	Findings  []Finding       `json:"findings"`
+	Summary   *FindingSummary `json:"summary,omitempty"`
 }
 
 func NewK8sDiagnosticsServer() (*K8sDiagnosticsServer, error) {
@@ -91,71 +213,101 @@ func NewK8sDiagnosticsServer() (*K8sDiagnosticsServer, error) {
 		}
 	}
 
+	// The tools only read; refuse anything else even if the credentials allow it
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &readOnlyTransport{next: rt}
+	})
+
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
 	}
 
-	return &K8sDiagnosticsServer{clientset: clientset}, nil
+	metrics, err := metricsclientset.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metrics client: %w", err)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+
+	policy, err := newPolicyStoreFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load policy: %w", err)
+	}
+
+	access, err := newAccessPolicyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	rules, err := newRuleEngineFromEnv(ctx, clientset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rules: %w", err)
+	}
+
+	prometheus, err := newPrometheusClientFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	server := &K8sDiagnosticsServer{clientset: clientset, metrics: metrics, dynamic: dynamicClient, policy: policy, access: access, rules: rules, prometheus: prometheus,
+		config: config, callers: &callerServers{}}
+	server.usage, err = newUsageHistoryFromEnv(prometheus, server)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure usage history: %w", err)
+	}
+
+	return server, nil
 }
 
 func (s *K8sDiagnosticsServer) diagnosePod(ctx context.Context, namespace, podName string) (*PodDiagnostic, error) {
+	if err := s.access.checkNamespace(namespace); err != nil {
+		return nil, err
+	}
 	pod, err := s.clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
 	if err != nil {
 		return nil, err
 	}
+	if err := s.access.checkObject("Pod", namespace, podName, pod.Labels); err != nil {
+		return nil, err
+	}
+
+	thresholds := s.policy.Thresholds(namespace)
+	ctx, omitted := withOmissions(ctx)
 
 	diagnostic := &PodDiagnostic{
-		Name:        pod.Name,
-		Namespace:   pod.Namespace,
-		Status:      string(pod.Status.Phase),
-		Issues:      []string{},
-		Suggestions: []string{},
-		Resources:   make(map[string]string),
-		CreatedAt:   time.Now(),
+		Name:      pod.Name,
+		Namespace: pod.Namespace,
+		Status:    string(pod.Status.Phase),
+		Findings:  []Finding{},
+		Resources: make(map[string]string),
+		CreatedAt: time.Now(),
+	}
+
+	containerRef := func(container string) ObjectRef {
+		return ObjectRef{Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name, Container: container}
 	}
 
 	// Analyze container statuses
 	for _, containerStatus := range pod.Status.ContainerStatuses {
 		diagnostic.RestartCount += containerStatus.RestartCount
-
-		if containerStatus.RestartCount > 5 {
-			diagnostic.Issues = append(diagnostic.Issues,
-				fmt.Sprintf("Container %s has high restart count: %d",
-					containerStatus.Name, containerStatus.RestartCount))
-			diagnostic.Suggestions = append(diagnostic.Suggestions,
-				"Check container logs and resource limits")
-		}
-
-		if !containerStatus.Ready {
-			diagnostic.Issues = append(diagnostic.Issues,
-				fmt.Sprintf("Container %s is not ready", containerStatus.Name))
-		}
-
-		// Check waiting state
-		if containerStatus.State.Waiting != nil {
-			reason := containerStatus.State.Waiting.Reason
-			diagnostic.Issues = append(diagnostic.Issues,
-				fmt.Sprintf("Container %s is waiting: %s", containerStatus.Name, reason))
-
-			switch reason {
-			case "ImagePullBackOff", "ErrImagePull":
-				diagnostic.Suggestions = append(diagnostic.Suggestions,
-					"Check image name, registry credentials, and network connectivity")
-			case "CrashLoopBackOff":
-				diagnostic.Suggestions = append(diagnostic.Suggestions,
-					"Check application logs and startup configuration")
-			}
-		}
 	}
+	statusFindings, pullFailures := containerStatusFindings(pod, thresholds)
+	diagnostic.Findings = append(diagnostic.Findings, statusFindings...)
+
+	// Find the root cause of image pull failures
+	diagnostic.Findings = append(diagnostic.Findings, s.imagePullFindings(ctx, pod, pullFailures)...)
 
 	// Check resource requests/limits
 	for _, container := range pod.Spec.Containers {
 		if container.Resources.Requests == nil && container.Resources.Limits == nil {
-			diagnostic.Issues = append(diagnostic.Issues,
-				fmt.Sprintf("Container %s has no resource requests/limits", container.Name))
-			diagnostic.Suggestions = append(diagnostic.Suggestions,
-				"Set appropriate resource requests and limits")
+			diagnostic.Findings = append(diagnostic.Findings, newFinding(findingNoResources, containerRef(container.Name),
+				fmt.Sprintf("Container %s has no resource requests/limits", container.Name), nil))
 		}
 
 		// Store resource information
@@ -169,27 +321,112 @@ func (s *K8sDiagnosticsServer) diagnosePod(ctx context.Context, namespace, podNa
 		}
 	}
 
+	// Check probe failures and configuration
+	probeReports, probeFindings := s.podProbes(ctx, pod)
+	diagnostic.Probes = probeReports
+	diagnostic.Findings = append(diagnostic.Findings, probeFindings...)
+
+	// Check ConfigMap and Secret references (the cause of CreateContainerConfigError)
+	diagnostic.Findings = append(diagnostic.Findings, s.checkConfigRefs(ctx, pod)...)
+
+	// Apply custom rules
+	ruleFindings, ruleErrors := s.evaluatePodRules(ctx, pod)
+	diagnostic.Findings = append(diagnostic.Findings, ruleFindings...)
+	diagnostic.RuleErrors = ruleErrors
+
+	// Explain pods stuck on their volumes
+	if waitingOnVolumes(pod) {
+		if storage, err := s.podStorage(ctx, pod); err == nil {
+			diagnostic.Findings = append(diagnostic.Findings, storage.Findings...)
+			for _, note := range storage.Omitted {
+				omitted.add(note)
+			}
+		} else {
+			noteOmitted(ctx, "Volume diagnosis", err)
+		}
+	}
+
 	// Get recent events
 	events, err := s.clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
 		FieldSelector: fmt.Sprintf("involvedObject.name=%s", podName),
 	})
 	if err == nil {
 		for _, event := range events.Items {
-			if time.Since(event.LastTimestamp.Time) < time.Hour*24 {
+			if time.Since(event.LastTimestamp.Time) < thresholds.EventWindow.Duration {
 				diagnostic.Events = append(diagnostic.Events,
 					fmt.Sprintf("%s: %s (%s)", event.Reason, event.Message, event.LastTimestamp.Format(time.RFC3339)))
 			}
 		}
+	} else {
+		noteOmitted(ctx, "Pod "+podName+" events", err)
 	}
 
+	sortFindings(diagnostic.Findings)
+	diagnostic.Issues, diagnostic.Suggestions = issuesAndSuggestions(diagnostic.Findings)
+	diagnostic.Summary = summarizeFindings(diagnostic.Findings)
+	diagnostic.Omitted = omitted.list()
+
 	return diagnostic, nil
 }
 
+// containerStatusFindings reports restarts, readiness and waiting states of a
+// pod's containers, and returns the containers that failed to pull their image
+func containerStatusFindings(pod *corev1.Pod, thresholds Thresholds) ([]Finding, []corev1.ContainerStatus) {
+	var findings []Finding
+	var pullFailures []corev1.ContainerStatus
+
+	for _, containerStatus := range pod.Status.ContainerStatuses {
+		ref := ObjectRef{Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name, Container: containerStatus.Name}
+
+		if containerStatus.RestartCount > thresholds.PodRestartCount {
+			findings = append(findings, newFinding(findingHighRestarts, ref,
+				fmt.Sprintf("Container %s has high restart count: %d",
+					containerStatus.Name, containerStatus.RestartCount),
+				map[string]string{
+					"restart_count": fmt.Sprint(containerStatus.RestartCount),
+					"threshold":     fmt.Sprint(thresholds.PodRestartCount),
+				}))
+		}
+
+		if !containerStatus.Ready {
+			findings = append(findings, newFinding(findingContainerNotReady, ref,
+				fmt.Sprintf("Container %s is not ready", containerStatus.Name), nil))
+		}
+
+		// Check waiting state
+		if containerStatus.State.Waiting != nil {
+			reason := containerStatus.State.Waiting.Reason
+			def := findingContainerWaiting
+
+			switch reason {
+			case "ImagePullBackOff", "ErrImagePull":
+				def = findingImagePull
+				pullFailures = append(pullFailures, containerStatus)
+			case "CrashLoopBackOff":
+				def = findingCrashLoop
+			}
+
+			evidence := map[string]string{"reason": reason}
+			if message := containerStatus.State.Waiting.Message; message != "" {
+				evidence["message"] = message
+			}
+			findings = append(findings, newFinding(def, ref,
+				fmt.Sprintf("Container %s is waiting: %s", containerStatus.Name, reason), evidence))
+		}
+	}
+
+	return findings, pullFailures
+}
+
 func (s *K8sDiagnosticsServer) analyzeClusterHealth(ctx context.Context) (*ClusterHealth, error) {
+	thresholds := s.policy.Thresholds("")
+	ctx, omitted := withOmissions(ctx)
+
 	health := &ClusterHealth{
 		PodIssues:       []PodDiagnostic{},
 		ResourceUsage:   make(map[string]interface{}),
 		Recommendations: []string{},
+		Findings:        []Finding{},
 		Timestamp:       time.Now(),
 	}
 
@@ -204,9 +441,14 @@ func (s *K8sDiagnosticsServer) analyzeClusterHealth(ctx context.Context) (*Clust
 
 	for _, node := range nodes.Items {
 		ready := false
+		reason := "Ready condition missing"
 		for _, condition := range node.Status.Conditions {
-			if condition.Type == "Ready" && condition.Status == "True" {
-				ready = true
+			if condition.Type == "Ready" {
+				if condition.Status == "True" {
+					ready = true
+				} else {
+					reason = fmt.Sprintf("Ready=%s: %s", condition.Status, condition.Reason)
+				}
 				break
 			}
 		}
@@ -214,6 +456,10 @@ func (s *K8sDiagnosticsServer) analyzeClusterHealth(ctx context.Context) (*Clust
 			health.HealthyNodes++
 		} else {
 			unhealthyNodes = append(unhealthyNodes, node.Name)
+			health.Findings = append(health.Findings, newFinding(findingNodeNotReady,
+				ObjectRef{Kind: "Node", Name: node.Name},
+				fmt.Sprintf("Node %s is not ready", node.Name),
+				map[string]string{"condition": reason}))
 		}
 	}
 
@@ -221,6 +467,8 @@ func (s *K8sDiagnosticsServer) analyzeClusterHealth(ctx context.Context) (*Clust
 	namespaces, err := s.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
 	if err == nil {
 		health.NamespaceCount = len(namespaces.Items)
+	} else {
+		noteOmitted(ctx, "Namespace count", err)
 	}
 
 	// Find problematic pods across all namespaces
@@ -232,7 +480,7 @@ func (s *K8sDiagnosticsServer) analyzeClusterHealth(ctx context.Context) (*Clust
 	problemPods := 0
 	totalPods := 0
 
-	for _, pod := range pods.Items {
+	for _, pod := range s.access.filterPods(pods.Items) {
 		// Skip completed jobs and succeeded pods
 		if pod.Status.Phase == "Succeeded" {
 			continue
@@ -241,8 +489,9 @@ func (s *K8sDiagnosticsServer) analyzeClusterHealth(ctx context.Context) (*Clust
 		totalPods++
 		hasIssues := false
 
+		restartThreshold := s.policy.Thresholds(pod.Namespace).ProblemRestartCount
 		for _, containerStatus := range pod.Status.ContainerStatuses {
-			if containerStatus.RestartCount > 3 || !containerStatus.Ready {
+			if containerStatus.RestartCount > restartThreshold || !containerStatus.Ready {
 				hasIssues = true
 				break
 			}
@@ -266,27 +515,73 @@ func (s *K8sDiagnosticsServer) analyzeClusterHealth(ctx context.Context) (*Clust
 	health.ResourceUsage["problem_pods"] = problemPods
 	health.ResourceUsage["problem_percentage"] = float64(problemPods) / float64(totalPods) * 100
 
-	// Generate recommendations
-	if float64(health.HealthyNodes)/float64(health.NodeCount) < 0.8 {
-		health.Recommendations = append(health.Recommendations,
+	// Generate cluster-wide findings
+	clusterRef := ObjectRef{Kind: "Cluster", Name: "cluster"}
+
+	if float64(health.HealthyNodes)/float64(health.NodeCount) < thresholds.HealthyNodeRatio {
+		health.Findings = append(health.Findings, newFinding(findingUnhealthyNodes, clusterRef,
 			fmt.Sprintf("Cluster has %d unhealthy nodes: %s",
-				len(unhealthyNodes), strings.Join(unhealthyNodes, ", ")))
+				len(unhealthyNodes), strings.Join(unhealthyNodes, ", ")),
+			map[string]string{
+				"healthy_nodes": fmt.Sprint(health.HealthyNodes),
+				"total_nodes":   fmt.Sprint(health.NodeCount),
+			}))
+	}
+
+	if problemPods > thresholds.ProblemPodCount {
+		health.Findings = append(health.Findings, newFinding(findingManyProblemPods, clusterRef,
+			"High number of problematic pods detected - investigate cluster resource constraints",
+			map[string]string{
+				"problem_pods": fmt.Sprint(problemPods),
+				"threshold":    fmt.Sprint(thresholds.ProblemPodCount),
+			}))
+	}
+
+	if float64(problemPods)/float64(totalPods)*100 > thresholds.ProblemPodPercentage {
+		health.Findings = append(health.Findings, newFinding(findingHighProblemRate, clusterRef,
+			fmt.Sprintf("More than %g%% of pods have issues - consider cluster-wide investigation",
+				thresholds.ProblemPodPercentage),
+			map[string]string{
+				"problem_pods": fmt.Sprint(problemPods),
+				"total_pods":   fmt.Sprint(totalPods),
+			}))
 	}
 
-	if problemPods > 10 {
-		health.Recommendations = append(health.Recommendations,
-			"High number of problematic pods detected - investigate cluster resource constraints")
+	// Capacity is best effort: a failure here shouldn't hide the rest of the report
+	if capacity, err := s.clusterCapacity(ctx, "", "", 0); err == nil {
+		health.Capacity = &capacity.CapacityTotals
+		health.Findings = append(health.Findings, capacity.Findings...)
+	} else {
+		noteOmitted(ctx, "Cluster capacity", err)
 	}
 
-	if float64(problemPods)/float64(totalPods) > 0.2 {
-		health.Recommendations = append(health.Recommendations,
-			"More than 20% of pods have issues - consider cluster-wide investigation")
+	sortFindings(health.Findings)
+	for _, finding := range health.Findings {
+		if finding.Object.Kind == "Cluster" {
+			health.Recommendations = append(health.Recommendations, finding.Message)
+		}
 	}
+	health.Summary = summarizeFindings(health.Findings, podFindings(health.PodIssues))
+	health.Omitted = omitted.list()
 
 	return health, nil
 }
 
+// logPatternFindings maps log error patterns to the check they trigger
+var logPatternFindings = map[string]findingDef{
+	"out of memory":      findingLogOutOfMemory,
+	"connection refused": findingLogConnectionRefused,
+	"permission denied":  findingLogPermissionDenied,
+	"timeout":            findingLogTimeout,
+	"killed":             findingLogKilled,
+	"segmentation fault": findingLogSegfault,
+}
+
 func (s *K8sDiagnosticsServer) analyzePodLogs(ctx context.Context, namespace, podName, container string, lines int64) (*LogAnalysis, error) {
+	if err := s.checkPod(ctx, namespace, podName); err != nil {
+		return nil, err
+	}
+
 	logOptions := &corev1.PodLogOptions{
 		TailLines: &lines,
 	}
@@ -299,6 +594,8 @@ func (s *K8sDiagnosticsServer) analyzePodLogs(ctx context.Context, namespace, po
 		return nil, fmt.Errorf("failed to get logs: %w", err)
 	}
 
+	thresholds := s.policy.Thresholds(namespace)
+
 	logText := string(logs)
 	logLines := strings.Split(logText, "\n")
 
@@ -307,7 +604,7 @@ func (s *K8sDiagnosticsServer) analyzePodLogs(ctx context.Context, namespace, po
 		Namespace:   namespace,
 		LogLines:    len(logLines),
 		ErrorsFound: []string{},
-		Suggestions: []string{},
+		Findings:    []Finding{},
 	}
 
 	// Analyze logs for common error patterns
@@ -322,7 +619,9 @@ func (s *K8sDiagnosticsServer) analyzePodLogs(ctx context.Context, namespace, po
 	}
 
 	errorMap := make(map[string]bool)
-	suggestionMap := make(map[string]bool)
+	patternHits := make(map[string]int)
+	patternSample := make(map[string]string)
+	patternOrder := []string{}
 
 	for _, line := range logLines {
 		lowerLine := strings.ToLower(line)
@@ -336,26 +635,13 @@ func (s *K8sDiagnosticsServer) analyzePodLogs(ctx context.Context, namespace, po
 					errorMap[line] = true
 				}
 
-				// Add specific suggestions
-				var suggestion string
-				switch pattern {
-				case "out of memory":
-					suggestion = "Consider increasing memory limits or optimizing application memory usage"
-				case "connection refused":
-					suggestion = "Check network policies, service configurations, and target service availability"
-				case "permission denied":
-					suggestion = "Review RBAC permissions and file system permissions"
-				case "timeout":
-					suggestion = "Check network connectivity and increase timeout values if appropriate"
-				case "killed":
-					suggestion = "Pod may have been killed due to resource limits (OOMKilled) - check resource usage"
-				case "segmentation fault":
-					suggestion = "Application crash detected - review application code and dependencies"
-				}
-
-				if suggestion != "" && !suggestionMap[suggestion] {
-					analysis.Suggestions = append(analysis.Suggestions, suggestion)
-					suggestionMap[suggestion] = true
+				// Track patterns that map to a specific check
+				if _, ok := logPatternFindings[pattern]; ok {
+					if patternHits[pattern] == 0 {
+						patternOrder = append(patternOrder, pattern)
+						patternSample[pattern] = line
+					}
+					patternHits[pattern]++
 				}
 				break
 			}
@@ -370,22 +656,54 @@ func (s *K8sDiagnosticsServer) analyzePodLogs(ctx context.Context, namespace, po
 		}
 	}
 
-	// Add general suggestions based on error count
-	if analysis.ErrorCount > 10 {
-		analysis.Suggestions = append(analysis.Suggestions,
-			"High error rate detected - consider reviewing application stability")
+	ref := ObjectRef{Kind: "Pod", Namespace: namespace, Name: podName, Container: container}
+
+	for _, pattern := range patternOrder {
+		analysis.Findings = append(analysis.Findings, newFinding(logPatternFindings[pattern], ref,
+			fmt.Sprintf("Log contains %q (%d lines)", pattern, patternHits[pattern]),
+			map[string]string{
+				"matches":     fmt.Sprint(patternHits[pattern]),
+				"sample_line": patternSample[pattern],
+			}))
 	}
 
-	if analysis.WarningCount > 5 {
-		analysis.Suggestions = append(analysis.Suggestions,
-			"Multiple warnings detected - review application configuration")
+	// Add general findings based on error count
+	if analysis.ErrorCount > thresholds.LogErrorCount {
+		analysis.Findings = append(analysis.Findings, newFinding(findingLogHighErrorRate, ref,
+			fmt.Sprintf("High error rate detected: %d error lines", analysis.ErrorCount),
+			map[string]string{
+				"error_lines": fmt.Sprint(analysis.ErrorCount),
+				"threshold":   fmt.Sprint(thresholds.LogErrorCount),
+			}))
 	}
 
+	if analysis.WarningCount > thresholds.LogWarningCount {
+		analysis.Findings = append(analysis.Findings, newFinding(findingLogManyWarnings, ref,
+			fmt.Sprintf("Multiple warnings detected: %d warning lines", analysis.WarningCount),
+			map[string]string{
+				"warning_lines": fmt.Sprint(analysis.WarningCount),
+				"threshold":     fmt.Sprint(thresholds.LogWarningCount),
+			}))
+	}
+
+	sortFindings(analysis.Findings)
+	_, analysis.Suggestions = issuesAndSuggestions(analysis.Findings)
+	analysis.Summary = summarizeFindings(analysis.Findings)
+
 	return analysis, nil
 }
 
-func (s *K8sDiagnosticsServer) getWorkloadRecommendations(ctx context.Context, namespace string) ([]string, error) {
-	recommendations := []string{}
+func (s *K8sDiagnosticsServer) getWorkloadRecommendations(ctx context.Context, namespace string) (*WorkloadRecommendations, error) {
+	if err := s.access.checkNamespace(namespace); err != nil {
+		return nil, err
+	}
+
+	ctx, omitted := withOmissions(ctx)
+	result := &WorkloadRecommendations{
+		Namespace:   namespace,
+		Findings:    []Finding{},
+		RightSizing: []RightSizingRecommendation{},
+	}
 
 	// Check deployments
 	deployments, err := s.clientset.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
@@ -394,6 +712,10 @@ func (s *K8sDiagnosticsServer) getWorkloadRecommendations(ctx context.Context, n
 	}
 
 	for _, deployment := range deployments.Items {
+		if !s.access.allows(deployment.Namespace, deployment.Labels) {
+			continue
+		}
+		ref := ObjectRef{Kind: "Deployment", Namespace: deployment.Namespace, Name: deployment.Name}
 		hasLimits := false
 		hasRequests := false
 
@@ -407,46 +729,74 @@ func (s *K8sDiagnosticsServer) getWorkloadRecommendations(ctx context.Context, n
 		}
 
 		if !hasLimits {
-			recommendations = append(recommendations,
+			result.Findings = append(result.Findings, newFinding(findingWorkloadNoLimits, ref,
 				fmt.Sprintf("Deployment %s/%s should have resource limits",
-					deployment.Namespace, deployment.Name))
+					deployment.Namespace, deployment.Name), nil))
 		}
 
 		if !hasRequests {
-			recommendations = append(recommendations,
+			result.Findings = append(result.Findings, newFinding(findingWorkloadNoRequests, ref,
 				fmt.Sprintf("Deployment %s/%s should have resource requests",
-					deployment.Namespace, deployment.Name))
+					deployment.Namespace, deployment.Name), nil))
 		}
 
 		// Check replica count
 		if deployment.Spec.Replicas != nil && *deployment.Spec.Replicas == 1 {
-			recommendations = append(recommendations,
+			result.Findings = append(result.Findings, newFinding(findingSingleReplica, ref,
 				fmt.Sprintf("Deployment %s/%s has only 1 replica - consider scaling for HA",
-					deployment.Namespace, deployment.Name))
+					deployment.Namespace, deployment.Name), nil))
 		}
 
 		// Check for missing probes
 		for _, container := range deployment.Spec.Template.Spec.Containers {
+			containerRef := ref
+			containerRef.Container = container.Name
 			if container.LivenessProbe == nil {
-				recommendations = append(recommendations,
+				result.Findings = append(result.Findings, newFinding(findingNoLivenessProbe, containerRef,
 					fmt.Sprintf("Container %s in deployment %s/%s missing liveness probe",
-						container.Name, deployment.Namespace, deployment.Name))
+						container.Name, deployment.Namespace, deployment.Name), nil))
 			}
 			if container.ReadinessProbe == nil {
-				recommendations = append(recommendations,
+				result.Findings = append(result.Findings, newFinding(findingNoReadinessProbe, containerRef,
 					fmt.Sprintf("Container %s in deployment %s/%s missing readiness probe",
-						container.Name, deployment.Namespace, deployment.Name))
+						container.Name, deployment.Namespace, deployment.Name), nil))
 			}
 		}
+
+		result.Findings = append(result.Findings, s.deploymentProbeFindings(ctx, &deployment)...)
+
+		// Apply custom rules
+		ruleFindings, ruleErrors := s.evaluateDeploymentRules(ctx, &deployment)
+		result.Findings = append(result.Findings, ruleFindings...)
+		result.RuleErrors = append(result.RuleErrors, ruleErrors...)
+
+		// Suggest requests/limits from observed usage. A failing usage source
+		// shouldn't hide the best-practice findings or the other deployments.
+		rightSizing, findings, err := s.rightSizeDeployment(ctx, &deployment)
+		if err != nil {
+			result.RightSizingErrors = append(result.RightSizingErrors,
+				fmt.Sprintf("right-sizing deployment %s failed: %v", deployment.Name, err))
+		}
+		result.RightSizing = append(result.RightSizing, rightSizing...)
+		result.Findings = append(result.Findings, findings...)
 	}
 
-	return recommendations, nil
+	sortFindings(result.Findings)
+	result.Recommendations, _ = issuesAndSuggestions(result.Findings)
+	result.Summary = summarizeFindings(result.Findings)
+	result.Omitted = omitted.list()
+
+	return result, nil
 }
 
 // Additional exploratory tools to add to the existing K8s diagnostics MCP server
 
 // Tool: Find and diagnose problematic pods
 func (s *K8sDiagnosticsServer) findProblematicPods(ctx context.Context, namespace string, criteria string) ([]PodDiagnostic, error) {
+	if err := s.access.checkNamespace(namespace); err != nil {
+		return nil, err
+	}
+
 	var pods *corev1.PodList
 	var err error
 
@@ -464,20 +814,19 @@ func (s *K8sDiagnosticsServer) findProblematicPods(ctx context.Context, namespac
 
 	for _, pod := range pods.Items {
 		// Skip system namespaces unless specifically requested
-		if namespace == "" && (strings.HasPrefix(pod.Namespace, "kube-") ||
-			pod.Namespace == "kube-system" || pod.Namespace == "kube-public" ||
-			pod.Namespace == "kube-node-lease") {
+		if !s.access.listed(&pod, namespace != "") {
 			continue
 		}
 
 		isProblem := false
+		restartThreshold := s.policy.Thresholds(pod.Namespace).ProblemRestartCount
 
 		switch criteria {
 		case "failing", "failed", "error":
 			isProblem = pod.Status.Phase == "Failed" || pod.Status.Phase == "Pending"
 		case "restarting", "restart":
 			for _, cs := range pod.Status.ContainerStatuses {
-				if cs.RestartCount > 3 {
+				if cs.RestartCount > restartThreshold {
 					isProblem = true
 					break
 				}
@@ -490,11 +839,9 @@ func (s *K8sDiagnosticsServer) findProblematicPods(ctx context.Context, namespac
 				}
 			}
 		case "resource-issues":
-			// Check for resource-related issues
+			// OOM kills are the resource problem visible without metrics
 			for _, cs := range pod.Status.ContainerStatuses {
-				if cs.State.Waiting != nil &&
-					(strings.Contains(cs.State.Waiting.Reason, "Memory") ||
-						strings.Contains(cs.State.Waiting.Reason, "CPU")) {
+				if oomKilled(cs) {
 					isProblem = true
 					break
 				}
@@ -512,7 +859,7 @@ func (s *K8sDiagnosticsServer) findProblematicPods(ctx context.Context, namespac
 			isProblem = pod.Status.Phase != "Running" && pod.Status.Phase != "Succeeded"
 			if !isProblem {
 				for _, cs := range pod.Status.ContainerStatuses {
-					if cs.RestartCount > 3 || !cs.Ready {
+					if cs.RestartCount > restartThreshold || !cs.Ready {
 						isProblem = true
 						break
 					}
@@ -533,6 +880,10 @@ func (s *K8sDiagnosticsServer) findProblematicPods(ctx context.Context, namespac
 
 // Tool: Search pods by name pattern
 func (s *K8sDiagnosticsServer) searchPods(ctx context.Context, namePattern string, namespace string) ([]PodDiagnostic, error) {
+	if err := s.access.checkNamespace(namespace); err != nil {
+		return nil, err
+	}
+
 	var pods *corev1.PodList
 	var err error
 
@@ -551,9 +902,7 @@ func (s *K8sDiagnosticsServer) searchPods(ctx context.Context, namePattern strin
 
 	for _, pod := range pods.Items {
 		// Skip system namespaces unless specifically requested
-		if namespace == "" && (strings.HasPrefix(pod.Namespace, "kube-") ||
-			pod.Namespace == "kube-system" || pod.Namespace == "kube-public" ||
-			pod.Namespace == "kube-node-lease") {
+		if !s.access.listed(&pod, namespace != "") {
 			continue
 		}
 
@@ -585,109 +934,243 @@ func (s *K8sDiagnosticsServer) searchPods(ctx context.Context, namePattern strin
 	return matchingPods, nil
 }
 
+// Tool: List pods in a namespace with their status
+func (s *K8sDiagnosticsServer) listPods(ctx context.Context, namespace string, showSystem bool) (*PodListResult, error) {
+	if err := s.access.checkNamespace(namespace); err != nil {
+		return nil, err
+	}
+
+	listNamespace := namespace
+	if namespace == "all" || showSystem {
+		listNamespace = ""
+	}
+	pods, err := s.clientset.CoreV1().Pods(listNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PodListResult{
+		Namespace: namespace,
+		Pods:      []PodStatusInfo{},
+		Findings:  []Finding{},
+	}
+	for _, pod := range pods.Items {
+		// Skip system namespaces unless explicitly requested
+		if !s.access.listed(&pod, showSystem) {
+			continue
+		}
+
+		readyCount := 0
+		totalCount := len(pod.Status.ContainerStatuses)
+		restarts := int32(0)
+
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.Ready {
+				readyCount++
+			}
+			restarts += cs.RestartCount
+		}
+
+		age := time.Since(pod.CreationTimestamp.Time).Truncate(time.Second).String()
+
+		result.Pods = append(result.Pods, PodStatusInfo{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+			Status:    string(pod.Status.Phase),
+			Ready:     fmt.Sprintf("%d/%d", readyCount, totalCount),
+			Restarts:  restarts,
+			Age:       age,
+		})
+
+		// Completed pods have containers that are no longer ready by design
+		if pod.Status.Phase != corev1.PodSucceeded {
+			findings, _ := containerStatusFindings(&pod, s.policy.Thresholds(pod.Namespace))
+			result.Findings = append(result.Findings, findings...)
+		}
+	}
+	result.PodCount = len(result.Pods)
+
+	sortFindings(result.Findings)
+	result.Summary = summarizeFindings(result.Findings)
+
+	return result, nil
+}
+
 // Tool: Get resource usage across pods
-func (s *K8sDiagnosticsServer) getResourceUsage(ctx context.Context, namespace string, sortBy string) ([]PodResourceInfo, error) {
+func (s *K8sDiagnosticsServer) getResourceUsage(ctx context.Context, namespace string, sortBy string) (*ResourceUsageReport, error) {
+	// Reject a bad sort key before making any API calls
+	if err := sortResourceInfo(nil, sortBy); err != nil {
+		return nil, err
+	}
+	if err := s.access.checkNamespace(namespace); err != nil {
+		return nil, err
+	}
+
 	var pods *corev1.PodList
 	var err error
 
-	if namespace == "" || namespace == "all" {
-		pods, err = s.clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
-	} else {
-		pods, err = s.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	listNamespace := namespace
+	if namespace == "all" {
+		listNamespace = ""
 	}
 
+	pods, err = s.clientset.CoreV1().Pods(listNamespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return nil, err
 	}
+	pods.Items = s.access.filterPods(pods.Items)
 
-	var resourceInfo []PodResourceInfo
+	report := &ResourceUsageReport{
+		Namespace:     namespace,
+		SortBy:        sortBy,
+		ResourceUsage: []PodResourceInfo{},
+		Findings:      []Finding{},
+	}
+
+	// Live usage is optional: without metrics-server we still report requests and limits
+	usage, err := s.fetchPodUsage(ctx, listNamespace)
+	if err != nil {
+		report.MetricsError = err.Error()
+	} else {
+		report.MetricsAvailable = true
+	}
+
+	// Throttling and working set come from Prometheus or the kubelets
+	pressure, source, err := s.fetchContainerPressure(ctx, listNamespace, pods.Items)
+	report.PressureSource = source
+	if err != nil {
+		report.PressureError = err.Error()
+	}
 
 	for _, pod := range pods.Items {
 		// Skip system namespaces unless specifically requested
-		if namespace == "" && (strings.HasPrefix(pod.Namespace, "kube-") ||
-			pod.Namespace == "kube-system" || pod.Namespace == "kube-public" ||
-			pod.Namespace == "kube-node-lease") {
+		if !s.access.listed(&pod, namespace != "") {
 			continue
 		}
 
 		info := PodResourceInfo{
-			Name:      pod.Name,
-			Namespace: pod.Namespace,
-			Status:    string(pod.Status.Phase),
+			Name:       pod.Name,
+			Namespace:  pod.Namespace,
+			Status:     string(pod.Status.Phase),
+			Containers: []ContainerResourceInfo{},
+		}
+
+		podUsage, hasUsage := usage[podUsageKey{Namespace: pod.Namespace, Name: pod.Name}]
+		info.MetricsAvailable = hasUsage
+
+		podPressure := pressure[podUsageKey{Namespace: pod.Namespace, Name: pod.Name}]
+		thresholds := s.policy.Thresholds(pod.Namespace)
+
+		statuses := make(map[string]*corev1.ContainerStatus)
+		for i, cs := range pod.Status.ContainerStatuses {
+			info.RestartCount += cs.RestartCount
+			statuses[cs.Name] = &pod.Status.ContainerStatuses[i]
 		}
 
-		totalRestarts := int32(0)
-		hasResourceIssues := false
+		// Pod-level percentages are only meaningful if every container sets the value
+		allCPURequests, allCPULimits := true, true
+		allMemoryRequests, allMemoryLimits := true, true
 
-		// Aggregate resource information from all containers
 		for _, container := range pod.Spec.Containers {
-			if container.Resources.Requests != nil {
-				if cpu := container.Resources.Requests.Cpu(); cpu != nil {
-					info.CPURequest += cpu.String() + " "
-				}
-				if memory := container.Resources.Requests.Memory(); memory != nil {
-					info.MemoryRequest += memory.String() + " "
-				}
+			requests, limits := container.Resources.Requests, container.Resources.Limits
+
+			c := ContainerResourceInfo{Name: container.Name}
+			if status := statuses[container.Name]; status != nil {
+				c.RestartCount = status.RestartCount
 			}
-			if container.Resources.Limits != nil {
-				if cpu := container.Resources.Limits.Cpu(); cpu != nil {
-					info.CPULimit += cpu.String() + " "
-				}
-				if memory := container.Resources.Limits.Memory(); memory != nil {
-					info.MemoryLimit += memory.String() + " "
-				}
+			c.CPURequestMillis = quantityMillis(requests, corev1.ResourceCPU)
+			c.CPULimitMillis = quantityMillis(limits, corev1.ResourceCPU)
+			c.MemoryRequestBytes = quantityValue(requests, corev1.ResourceMemory)
+			c.MemoryLimitBytes = quantityValue(limits, corev1.ResourceMemory)
+
+			p, hasPressure := podPressure[container.Name]
+			if hasUsage {
+				u := podUsage[container.Name]
+				c.CPUUsageMillis = u.CPUMillis
+				c.MemoryUsageBytes = u.MemoryBytes
+				c.setPercentages()
+			} else if hasPressure && p.WorkingSetBytes > 0 {
+				c.MemoryUsageBytes = p.WorkingSetBytes
+				c.MemoryRequestPercent = percentOf(c.MemoryUsageBytes, c.MemoryRequestBytes)
+				c.MemoryLimitPercent = percentOf(c.MemoryUsageBytes, c.MemoryLimitBytes)
 			}
-
-			// Check if container has no resource configuration
-			if container.Resources.Requests == nil && container.Resources.Limits == nil {
-				hasResourceIssues = true
+			if hasPressure {
+				c.CPUThrottlingPercent = p.ThrottlingPercent
+			}
+			ref := ObjectRef{Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name, Container: container.Name}
+			c.Findings = containerResourceFindings(ref, container, statuses[container.Name], c, thresholds)
+			if len(c.Findings) > 0 {
+				info.HasResourceIssues = true
+				report.Findings = append(report.Findings, c.Findings...)
 			}
+
+			allCPURequests = allCPURequests && c.CPURequestMillis > 0
+			allCPULimits = allCPULimits && c.CPULimitMillis > 0
+			allMemoryRequests = allMemoryRequests && c.MemoryRequestBytes > 0
+			allMemoryLimits = allMemoryLimits && c.MemoryLimitBytes > 0
+
+			info.add(c.ResourceAmounts)
+			info.Containers = append(info.Containers, c)
 		}
 
-		// Get restart count
-		for _, cs := range pod.Status.ContainerStatuses {
-			totalRestarts += cs.RestartCount
-
-			// Check for resource-related waiting states
-			if cs.State.Waiting != nil {
-				reason := cs.State.Waiting.Reason
-				if strings.Contains(reason, "Memory") || strings.Contains(reason, "CPU") ||
-					reason == "OOMKilled" {
-					hasResourceIssues = true
-				}
+		if hasUsage {
+			info.setPercentages()
+			if !allCPURequests {
+				info.CPURequestPercent = nil
+			}
+			if !allCPULimits {
+				info.CPULimitPercent = nil
+			}
+			if !allMemoryRequests {
+				info.MemoryRequestPercent = nil
+			}
+			if !allMemoryLimits {
+				info.MemoryLimitPercent = nil
 			}
 		}
 
-		info.RestartCount = totalRestarts
-		info.HasResourceIssues = hasResourceIssues
-
-		resourceInfo = append(resourceInfo, info)
+		report.ResourceUsage = append(report.ResourceUsage, info)
 	}
 
-	// TODO: Implement sorting based on sortBy parameter
-	// Could sort by restart count, resource usage, etc.
+	sortResourceInfo(report.ResourceUsage, sortBy)
+	report.PodCount = len(report.ResourceUsage)
+	sortFindings(report.Findings)
+	report.Summary = summarizeFindings(report.Findings)
 
-	return resourceInfo, nil
+	return report, nil
 }
 
 func runMCPServer() {
+	timeouts, err := newToolTimeoutsFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure tool timeouts: %v", err)
+	}
+	metrics := getServerMetrics()
+
 	s := server.NewMCPServer(
 		"K8s Diagnostics MCP Server",
 		"1.0.0",
 		server.WithToolCapabilities(false),
 		server.WithRecovery(),
+		server.WithToolHandlerMiddleware(timeouts.mcpMiddleware),
+		server.WithToolHandlerMiddleware(metrics.mcpMiddleware),
 	)
 
 	diagnostics, err := NewK8sDiagnosticsServer()
 	if err != nil {
 		log.Fatalf("Failed to create diagnostics server: %v", err)
 	}
+	diagnostics.logPermissionPreflight()
+	metrics.watchCaches(diagnostics)
+	serveMetricsFromEnv(metrics)
 
 	// Tool: Diagnose Pod
 	diagnosePodTool := mcp.NewTool("diagnose_pod",
 		mcp.WithDescription("Diagnose issues with a specific Kubernetes pod"),
 		mcp.WithString("namespace", mcp.Description("Namespace of the pod (default: default)")),
 		mcp.WithString("pod_name", mcp.Required(), mcp.Description("Name of the pod")),
+		mcp.WithBoolean("include_metrics", mcp.Description("Attach a Prometheus metrics summary when PROMETHEUS_URL is configured (default: true)")),
+		timeoutArgument,
 	)
 
 	s.AddTool(diagnosePodTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
@@ -701,6 +1184,9 @@ func runMCPServer() {
 		if err != nil {
 			return mcp.NewToolResultErrorFromErr("failed to diagnose pod", err), nil
 		}
+		if req.GetBool("include_metrics", true) {
+			result.Metrics = diagnostics.podMetricsSummary(ctx, namespace, podName)
+		}
 
 		jsonBytes, _ := json.MarshalIndent(result, "", "  ")
 		return mcp.NewToolResultText(string(jsonBytes)), nil
@@ -709,6 +1195,7 @@ func runMCPServer() {
 	// Tool: Analyze cluster health
 	analyzeClusterTool := mcp.NewTool("analyze_cluster_health",
 		mcp.WithDescription("Analyze overall cluster health and identify issues"),
+		timeoutArgument,
 	)
 
 	s.AddTool(analyzeClusterTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
@@ -722,8 +1209,9 @@ func runMCPServer() {
 
 	// Tool: Get workload recommendations
 	recommendTool := mcp.NewTool("get_workload_recommendations",
-		mcp.WithDescription("Get optimization recommendations for workloads in a namespace"),
+		mcp.WithDescription("Get optimization recommendations for workloads in a namespace, including right-sizing suggestions from observed usage"),
 		mcp.WithString("namespace", mcp.Description("Namespace to scan (default: default)")),
+		timeoutArgument,
 	)
 
 	s.AddTool(recommendTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
@@ -743,6 +1231,7 @@ func runMCPServer() {
 		mcp.WithString("pod_name", mcp.Required(), mcp.Description("Name of the pod")),
 		mcp.WithString("container", mcp.Description("Container name (optional)")),
 		mcp.WithNumber("lines", mcp.Description("Number of log lines to retrieve (default: 100)")),
+		timeoutArgument,
 	)
 
 	s.AddTool(analyzePodLogsTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
@@ -772,72 +1261,18 @@ func runMCPServer() {
 		mcp.WithDescription("List all pods in a namespace with their status"),
 		mcp.WithString("namespace", mcp.Description("Namespace to list pods from (default: default)")),
 		mcp.WithBoolean("show_system", mcp.Description("Include system namespaces (default: false)")),
+		timeoutArgument,
 	)
 
 	s.AddTool(listPodsTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		namespace := req.GetString("namespace", "default")
 		showSystem := req.GetBool("show_system", false)
 
-		var pods *corev1.PodList
-		var err error
-
-		if namespace == "all" || showSystem {
-			pods, err = diagnostics.clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
-		} else {
-			pods, err = diagnostics.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
-		}
-
+		result, err := diagnostics.listPods(ctx, namespace, showSystem)
 		if err != nil {
 			return mcp.NewToolResultErrorFromErr("failed to list pods", err), nil
 		}
 
-		type PodInfo struct {
-			Name      string `json:"name"`
-			Namespace string `json:"namespace"`
-			Status    string `json:"status"`
-			Ready     string `json:"ready"`
-			Restarts  int32  `json:"restarts"`
-			Age       string `json:"age"`
-		}
-
-		var podList []PodInfo
-		for _, pod := range pods.Items {
-			// Skip system namespaces unless explicitly requested
-			if !showSystem && (strings.HasPrefix(pod.Namespace, "kube-") ||
-				pod.Namespace == "kube-system" || pod.Namespace == "kube-public" ||
-				pod.Namespace == "kube-node-lease") {
-				continue
-			}
-
-			readyCount := 0
-			totalCount := len(pod.Status.ContainerStatuses)
-			restarts := int32(0)
-
-			for _, cs := range pod.Status.ContainerStatuses {
-				if cs.Ready {
-					readyCount++
-				}
-				restarts += cs.RestartCount
-			}
-
-			age := time.Since(pod.CreationTimestamp.Time).Truncate(time.Second).String()
-
-			podList = append(podList, PodInfo{
-				Name:      pod.Name,
-				Namespace: pod.Namespace,
-				Status:    string(pod.Status.Phase),
-				Ready:     fmt.Sprintf("%d/%d", readyCount, totalCount),
-				Restarts:  restarts,
-				Age:       age,
-			})
-		}
-
-		result := map[string]interface{}{
-			"namespace": namespace,
-			"pod_count": len(podList),
-			"pods":      podList,
-		}
-
 		jsonBytes, _ := json.MarshalIndent(result, "", "  ")
 		return mcp.NewToolResultText(string(jsonBytes)), nil
 	})
@@ -847,6 +1282,7 @@ func runMCPServer() {
 		mcp.WithDescription("Find and diagnose pods with issues (failing, restarting, not ready, etc.)"),
 		mcp.WithString("namespace", mcp.Description("Namespace to search (default: all non-system namespaces)")),
 		mcp.WithString("criteria", mcp.Description("Type of problems to find: failing, restarting, not-ready, resource-issues, image-issues, or all (default: all)")),
+		timeoutArgument,
 	)
 
 	s.AddTool(findProblematicTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
@@ -863,6 +1299,7 @@ func runMCPServer() {
 			"namespace":        namespace,
 			"problem_count":    len(result),
 			"problematic_pods": result,
+			"summary":          summarizeFindings(podFindings(result)),
 		}
 
 		jsonBytes, _ := json.MarshalIndent(response, "", "  ")
@@ -874,6 +1311,7 @@ func runMCPServer() {
 		mcp.WithDescription("Search for pods by name pattern, namespace, or labels and get their diagnostics"),
 		mcp.WithString("pattern", mcp.Required(), mcp.Description("Search pattern (pod name, namespace, or label value)")),
 		mcp.WithString("namespace", mcp.Description("Namespace to search (default: all non-system namespaces)")),
+		timeoutArgument,
 	)
 
 	s.AddTool(searchPodsTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
@@ -894,6 +1332,7 @@ func runMCPServer() {
 			"namespace":      namespace,
 			"matches_found":  len(result),
 			"matching_pods":  result,
+			"summary":        summarizeFindings(podFindings(result)),
 		}
 
 		jsonBytes, _ := json.MarshalIndent(response, "", "  ")
@@ -904,7 +1343,8 @@ func runMCPServer() {
 	resourceUsageTool := mcp.NewTool("get_resource_usage",
 		mcp.WithDescription("Get resource usage overview for pods to identify resource-related issues"),
 		mcp.WithString("namespace", mcp.Description("Namespace to analyze (default: all non-system namespaces)")),
-		mcp.WithString("sort_by", mcp.Description("Sort results by: restarts, cpu, memory (default: restarts)")),
+		mcp.WithString("sort_by", mcp.Description("Sort results by: restarts, cpu, memory, cpu_pct (usage % of request), mem_pct (usage % of limit) (default: restarts)")),
+		timeoutArgument,
 	)
 
 	s.AddTool(resourceUsageTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
@@ -916,20 +1356,14 @@ func runMCPServer() {
 			return mcp.NewToolResultErrorFromErr("resource usage analysis failed", err), nil
 		}
 
-		response := map[string]interface{}{
-			"namespace":      namespace,
-			"sort_by":        sortBy,
-			"pod_count":      len(result),
-			"resource_usage": result,
-		}
-
-		jsonBytes, _ := json.MarshalIndent(response, "", "  ")
+		jsonBytes, _ := json.MarshalIndent(result, "", "  ")
 		return mcp.NewToolResultText(string(jsonBytes)), nil
 	})
 
 	// Tool: Quick cluster triage
 	triageTool := mcp.NewTool("quick_triage",
 		mcp.WithDescription("Perform quick cluster triage to identify immediate issues across all namespaces"),
+		timeoutArgument,
 	)
 
 	s.AddTool(triageTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
@@ -956,6 +1390,7 @@ func runMCPServer() {
 			"cluster_health":  clusterHealth,
 			"critical_pods":   criticalPods,
 			"restarting_pods": restartingPods,
+			"summary":         clusterHealth.Summary,
 			"immediate_actions": []string{
 				"Check critical/failing pods first",
 				"Investigate high restart count pods",
@@ -968,6 +1403,287 @@ func runMCPServer() {
 		return mcp.NewToolResultText(string(jsonBytes)), nil
 	})
 
+	// Tool: Evaluate custom rules
+	evaluateRulesTool := mcp.NewTool("evaluate_rules",
+		mcp.WithDescription("Evaluate custom CEL diagnostic rules against pods, deployments and nodes"),
+		mcp.WithString("namespace", mcp.Description("Namespace to evaluate, or 'all' to include every namespace and nodes (default: default)")),
+		timeoutArgument,
+	)
+
+	s.AddTool(evaluateRulesTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		namespace := req.GetString("namespace", "default")
+		result, err := diagnostics.evaluateRules(ctx, namespace)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("rule evaluation failed", err), nil
+		}
+		jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	})
+
+	// Tool: Query Prometheus with curated templates
+	queryMetricsTool := mcp.NewTool("query_metrics",
+		mcp.WithDescription("Query Prometheus/Thanos with curated PromQL templates for a pod, a deployment or a namespace. Call without template to list the templates."),
+		mcp.WithString("template", mcp.Description("Template name: container_restarts, cpu_usage, cpu_throttling, memory_working_set, memory_vs_limit, http_5xx_rate, http_latency_p99")),
+		mcp.WithString("namespace", mcp.Description("Namespace (default: default)")),
+		mcp.WithString("pod", mcp.Description("Limit the query to one pod")),
+		mcp.WithString("workload", mcp.Description("Limit the query to the pods of a deployment")),
+		mcp.WithString("window", mcp.Description("Lookback window, e.g. 30m or 6h (default: 1h)")),
+		timeoutArgument,
+	)
+
+	s.AddTool(queryMetricsTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		template := req.GetString("template", "")
+		if template == "" {
+			jsonBytes, _ := json.MarshalIndent(map[string]interface{}{"templates": listMetricTemplates()}, "", "  ")
+			return mcp.NewToolResultText(string(jsonBytes)), nil
+		}
+
+		result, err := diagnostics.queryMetrics(ctx, MetricsQueryRequest{
+			Template:  template,
+			Namespace: req.GetString("namespace", "default"),
+			Pod:       req.GetString("pod", ""),
+			Workload:  req.GetString("workload", ""),
+			Window:    req.GetString("window", ""),
+		})
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("metrics query failed", err), nil
+		}
+		jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	})
+
+	// Tool: Namespace capacity
+	namespaceCapacityTool := mcp.NewTool("namespace_capacity",
+		mcp.WithDescription("Report ResourceQuota usage, LimitRange defaults, rollout headroom and quota-related FailedCreate events for a namespace"),
+		mcp.WithString("namespace", mcp.Description("Namespace to analyze (default: default)")),
+		timeoutArgument,
+	)
+
+	s.AddTool(namespaceCapacityTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		namespace := req.GetString("namespace", "default")
+		result, err := diagnostics.namespaceCapacity(ctx, namespace)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("namespace capacity analysis failed", err), nil
+		}
+		jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	})
+
+	// Tool: Cluster capacity
+	clusterCapacityTool := mcp.NewTool("cluster_capacity",
+		mcp.WithDescription("Report allocatable vs. requested vs. limited CPU, memory, pods and ephemeral storage per node and cluster-wide, fragmentation and overcommit, and optionally whether N more replicas of a deployment can be scheduled"),
+		mcp.WithString("deployment", mcp.Description("Deployment to simulate scaling up (optional)")),
+		mcp.WithString("namespace", mcp.Description("Namespace of the deployment (default: default)")),
+		mcp.WithNumber("replicas", mcp.Description("Number of additional replicas to simulate (default: 1, at most 1000)")),
+		timeoutArgument,
+	)
+
+	s.AddTool(clusterCapacityTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		replicas := req.GetInt("replicas", 1)
+		if replicas <= 0 {
+			replicas = 1
+		}
+		if replicas > maxSimulatedReplicas {
+			return mcp.NewToolResultError(fmt.Sprintf("replicas must be at most %d", maxSimulatedReplicas)), nil
+		}
+		result, err := diagnostics.clusterCapacity(ctx, req.GetString("namespace", "default"), req.GetString("deployment", ""), replicas)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("cluster capacity analysis failed", err), nil
+		}
+		jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	})
+
+	// Tool: Diagnose service
+	diagnoseServiceTool := mcp.NewTool("diagnose_service",
+		mcp.WithDescription("Diagnose why a Service doesn't reach its pods: selector mismatches, empty or not-ready EndpointSlices, targetPort and protocol mismatches, headless and ExternalName quirks"),
+		mcp.WithString("namespace", mcp.Description("Kubernetes namespace (default: default)")),
+		mcp.WithString("service_name", mcp.Required(), mcp.Description("Name of the service to diagnose")),
+		timeoutArgument,
+	)
+
+	s.AddTool(diagnoseServiceTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		namespace := req.GetString("namespace", "default")
+		serviceName, err := req.RequireString("service_name")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		result, err := diagnostics.diagnoseService(ctx, namespace, serviceName)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("service diagnosis failed", err), nil
+		}
+		jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	})
+
+	// Tool: Check network path
+	checkNetworkPathTool := mcp.NewTool("check_network_path",
+		mcp.WithDescription("Simulate whether NetworkPolicies allow traffic from a source pod to a destination pod or service port, naming the policies and rules responsible"),
+		mcp.WithString("source_namespace", mcp.Description("Namespace of the source pod (default: default)")),
+		mcp.WithString("source_pod", mcp.Required(), mcp.Description("Name of the source pod")),
+		mcp.WithString("destination_namespace", mcp.Description("Namespace of the destination (default: source namespace)")),
+		mcp.WithString("destination_pod", mcp.Description("Destination pod (either this or destination_service)")),
+		mcp.WithString("destination_service", mcp.Description("Destination service (either this or destination_pod)")),
+		mcp.WithString("port", mcp.Required(), mcp.Description("Port number or name; for a service, the service port")),
+		mcp.WithString("protocol", mcp.Description("TCP, UDP or SCTP (default: TCP, or the service port's protocol)")),
+		timeoutArgument,
+	)
+
+	s.AddTool(checkNetworkPathTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		sourcePod, err := req.RequireString("source_pod")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		port, err := req.RequireString("port")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		result, err := diagnostics.checkNetworkPath(ctx, NetworkPathRequest{
+			SourceNamespace:      req.GetString("source_namespace", "default"),
+			SourcePod:            sourcePod,
+			DestinationNamespace: req.GetString("destination_namespace", ""),
+			DestinationPod:       req.GetString("destination_pod", ""),
+			DestinationService:   req.GetString("destination_service", ""),
+			Port:                 intstr.Parse(port),
+			Protocol:             req.GetString("protocol", ""),
+		})
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("network path check failed", err), nil
+		}
+		jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	})
+
+	// Tool: Diagnose ingress
+	diagnoseIngressTool := mcp.NewTool("diagnose_ingress",
+		mcp.WithDescription("Diagnose Ingresses and Gateway API HTTPRoutes: missing backend services or ports, missing TLS secrets, IngressClass/GatewayClass mismatches, overlapping host/path rules and route status, with a health verdict per backend"),
+		mcp.WithString("namespace", mcp.Description("Kubernetes namespace (default: default)")),
+		mcp.WithString("name", mcp.Description("Only check the Ingress or HTTPRoute with this name")),
+		timeoutArgument,
+	)
+
+	s.AddTool(diagnoseIngressTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		namespace := req.GetString("namespace", "default")
+		result, err := diagnostics.diagnoseIngress(ctx, namespace, req.GetString("name", ""))
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("ingress diagnosis failed", err), nil
+		}
+		jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	})
+
+	// Tool: Diagnose storage
+	diagnoseStorageTool := mcp.NewTool("diagnose_storage",
+		mcp.WithDescription("Follow a pod's volumes through PVC, PV and StorageClass: Pending claims, provisioner errors, WaitForFirstConsumer topology conflicts, ReadWriteOnce volumes used across nodes, mount/attach failures, VolumeAttachment state and capacity vs request"),
+		mcp.WithString("namespace", mcp.Description("Kubernetes namespace (default: default)")),
+		mcp.WithString("pod_name", mcp.Required(), mcp.Description("Name of the pod whose volumes to check")),
+		timeoutArgument,
+	)
+
+	s.AddTool(diagnoseStorageTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		namespace := req.GetString("namespace", "default")
+		podName, err := req.RequireString("pod_name")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		result, err := diagnostics.diagnoseStorage(ctx, namespace, podName)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("storage diagnosis failed", err), nil
+		}
+		jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	})
+
+	// Tool: Audit security
+	auditSecurityTool := mcp.NewTool("audit_security",
+		mcp.WithDescription("Audit pods and workload templates in a namespace against the Pod Security Standards baseline and restricted profiles (privileged, host namespaces, hostPath, capabilities, runAsNonRoot, seccomp, readOnlyRootFilesystem) and check the namespace's pod-security.kubernetes.io labels"),
+		mcp.WithString("namespace", mcp.Description("Kubernetes namespace (default: default)")),
+		timeoutArgument,
+	)
+
+	s.AddTool(auditSecurityTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		namespace := req.GetString("namespace", "default")
+		result, err := diagnostics.auditSecurity(ctx, namespace)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("security audit failed", err), nil
+		}
+		jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	})
+
+	// Tool: Analyze RBAC
+	analyzeRBACTool := mcp.NewTool("analyze_rbac",
+		mcp.WithDescription("Resolve a service account's effective permissions across Roles, ClusterRoles and their bindings, flag over-privileged grants (cluster-admin, wildcards, reading secrets), and answer \"can this service account <verb> <resource> in <namespace>\" with a SubjectAccessReview or local evaluation"),
+		mcp.WithString("namespace", mcp.Description("Namespace of the service account (default: default)")),
+		mcp.WithString("service_account", mcp.Required(), mcp.Description("Name of the service account")),
+		mcp.WithString("verb", mcp.Description("Verb to check, e.g. get, list, create (checked together with resource)")),
+		mcp.WithString("resource", mcp.Description("Resource to check, e.g. pods, pods/log, deployments")),
+		mcp.WithString("api_group", mcp.Description("API group of the resource, e.g. apps (default: core)")),
+		mcp.WithString("resource_name", mcp.Description("Name of a specific resource to check")),
+		mcp.WithString("resource_namespace", mcp.Description("Namespace of the resource to check (default: the service account's namespace, 'all' for cluster-wide)")),
+		timeoutArgument,
+	)
+
+	s.AddTool(analyzeRBACTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		namespace := req.GetString("namespace", "default")
+		serviceAccount, err := req.RequireString("service_account")
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		var check *AccessCheckRequest
+		verb, resource := req.GetString("verb", ""), req.GetString("resource", "")
+		if verb != "" || resource != "" {
+			if verb == "" || resource == "" {
+				return mcp.NewToolResultError("verb and resource must be given together"), nil
+			}
+			check = &AccessCheckRequest{
+				Verb:      verb,
+				Resource:  resource,
+				APIGroup:  req.GetString("api_group", ""),
+				Name:      req.GetString("resource_name", ""),
+				Namespace: req.GetString("resource_namespace", ""),
+			}
+		}
+		result, err := diagnostics.analyzeRBAC(ctx, namespace, serviceAccount, check)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("RBAC analysis failed", err), nil
+		}
+		jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	})
+
+	// Tool: Check the server's own permissions
+	checkPermissionsTool := mcp.NewTool("check_permissions",
+		mcp.WithDescription("Check which Kubernetes APIs this server's credentials can use (SelfSubjectAccessReview for every API the tools call), list the tools that will fail or return partial results, and print the minimal ClusterRole that grants everything"),
+		timeoutArgument,
+	)
+
+	s.AddTool(checkPermissionsTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		result, err := diagnostics.checkPermissions(ctx)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("Permission check failed", err), nil
+		}
+		jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	})
+
+	// Tool: Get effective diagnostics policy
+	getPolicyTool := mcp.NewTool("get_policy",
+		mcp.WithDescription("Show the effective diagnostics thresholds, globally or for a namespace"),
+		mcp.WithString("namespace", mcp.Description("Namespace to resolve overrides for (default: show the whole policy)")),
+		timeoutArgument,
+	)
+
+	s.AddTool(getPolicyTool, func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		namespace := req.GetString("namespace", "")
+		result, err := diagnostics.getPolicy(namespace)
+		if err != nil {
+			return mcp.NewToolResultErrorFromErr("failed to get policy", err), nil
+		}
+		jsonBytes, _ := json.MarshalIndent(result, "", "  ")
+		return mcp.NewToolResultText(string(jsonBytes)), nil
+	})
+
 	// Add comprehensive troubleshooting guide resource
 	troubleshootingGuide := `# Kubernetes Diagnostics MCP Server Guide
 
@@ -979,8 +1695,12 @@ This MCP server provides comprehensive Kubernetes cluster diagnostics and troubl
 Performs detailed analysis of a specific pod including:
 - Container status and restart counts
 - Resource configuration
+- Missing ConfigMaps, Secrets and keys behind CreateContainerConfigError
+- Image pull root cause (not found, unauthorized, rate limited, TLS, DNS, platform) and pull secret coverage
+- Probe failures per probe type with their cause, and probe configuration linting
 - Recent events
 - Common issues and suggestions
+- Metrics summary from Prometheus when configured
 
 **Usage:** Provide namespace and pod_name
 
@@ -996,8 +1716,9 @@ Provides cluster-wide health analysis including:
 Analyzes workloads (deployments) for best practices:
 - Resource requests and limits
 - Replica counts for HA
-- Health probe configurations
+- Health probe configurations: undeclared ports, shared liveness/readiness endpoints, timing vs observed startup
 - Security and reliability recommendations
+- Right-sizing from observed usage percentiles, with savings/risk, QoS impact and a patch
 
 ### 4. analyze_pod_logs
 Advanced log analysis with pattern detection:
@@ -1030,10 +1751,12 @@ Searches for pods by name pattern, namespace, or labels:
 
 ### 8. get_resource_usage
 Analyzes resource usage across pods:
-- CPU and memory requests/limits
+- CPU and memory requests/limits (millicores and bytes)
+- Live usage from metrics-server as a percentage of request and limit, per container
 - Restart counts
-- Resource issues detection
-- Sorting options for resource usage
+- CPU throttling ratio and memory working set vs. limit per container (Prometheus or kubelet cAdvisor)
+- Resource issues with an explanation and numbers: throttling, memory near the limit, OOM kills, missing requests/limits
+- Sorting by restarts, cpu, memory, cpu_pct or mem_pct
 
 ### 9. quick_triage
 Performs quick cluster triage:
@@ -1042,6 +1765,88 @@ Performs quick cluster triage:
 - High restart pod detection
 - Immediate actions for cluster issues
 
+### 10. get_policy
+Shows the thresholds the diagnostics use:
+- Restart, node health and problem pod thresholds
+- Event window and log error/warning limits
+- Per-namespace overrides from the policy file (POLICY_FILE)
+
+### 11. evaluate_rules
+Runs custom CEL rules (RULES_PATH / RULES_CONFIGMAP) against workloads:
+- Pod, Deployment and Node rules
+- Severity, message, suggestion and tags per finding
+- Pod rule findings also appear in diagnose_pod issues
+
+### 12. query_metrics
+Queries Prometheus/Thanos (PROMETHEUS_URL) with curated PromQL templates:
+- Container restarts, CPU usage and throttling
+- Memory working set and memory vs. limit
+- HTTP 5xx rate and p99 latency
+- Scoped to a pod, a deployment's pods or a namespace
+- diagnose_pod attaches a summary of these metrics when configured
+
+### 13. namespace_capacity
+Explains quota and LimitRange effects on a namespace:
+- ResourceQuota used vs. hard per resource
+- LimitRange defaults and the containers they were applied to
+- Headroom for the next rollout (surge pods × per-pod requests) per deployment
+- ReplicaSet FailedCreate events caused by exceeded quota
+
+### 14. cluster_capacity
+Explains where pods can still be scheduled:
+- Allocatable vs. requested vs. limited CPU, memory, pods and ephemeral storage per node and cluster-wide
+- Fragmentation: the largest pod that can still be scheduled on a single node
+- Overcommit ratio of limits to allocatable
+- What-if for N more replicas of a deployment (node selectors, node affinity, taints and quota)
+
+### 15. diagnose_service
+Explains why a Service doesn't reach its pods:
+- Selector vs. pod labels, with the closest non-matching pod
+- Empty or not-ready EndpointSlices and the pods behind them
+- targetPort not matching any container port name or number, and protocol mismatches
+- Headless and ExternalName quirks
+
+### 16. check_network_path
+Simulates NetworkPolicy for a connection from a source pod to a destination pod or service port:
+- Egress policies selecting the source and ingress policies selecting each destination pod
+- podSelector, namespaceSelector, ipBlock, named ports and port ranges
+- Allowed/denied with the exact policies and rules responsible
+
+### 17. diagnose_ingress
+Checks Ingresses and Gateway API HTTPRoutes:
+- Backend Services and ports, linked to a Service/Endpoints health verdict
+- TLS secrets, IngressClass/GatewayClass mismatches
+- Host/path rules overlapping across namespaces
+- HTTPRoute Accepted/ResolvedRefs conditions and ReferenceGrants for cross-namespace backends
+
+### 18. diagnose_storage
+Follows a pod's volumes through PVC, PV and StorageClass:
+- Pending claims, missing StorageClasses and provisioner errors
+- WaitForFirstConsumer topology conflicts from scheduling events
+- ReadWriteOnce volumes used by pods on other nodes
+- FailedMount/FailedAttachVolume events and VolumeAttachment state
+- Capacity vs request for claims waiting on a resize
+
+### 19. audit_security
+Audits pods and workload templates against the Pod Security Standards:
+- Baseline: privileged, host namespaces and ports, hostPath, added capabilities, seccomp Unconfined
+- Restricted: allowPrivilegeEscalation, drop ALL, runAsNonRoot, seccomp profile
+- readOnlyRootFilesystem as a best practice
+- The namespace's pod-security.kubernetes.io labels; workloads the enforced level would reject
+
+### 20. analyze_rbac
+Resolves a service account's effective permissions:
+- Every RoleBinding and ClusterRoleBinding that matches the account or its groups, with the rules they grant
+- "Can it <verb> <resource> in <namespace>" via SubjectAccessReview, or local rule evaluation
+- Over-privileged grants: cluster-admin, wildcards, reading all secrets; dangling bindings
+
+### 21. check_permissions
+Checks the server's own credentials:
+- A SelfSubjectAccessReview for every API the tools call
+- Tools that will fail or return partial results, and the permissions they are missing
+- The minimal ClusterRole that grants everything the tools use
+- Tool responses list data left out due to forbidden errors under "omitted"
+
 ## Integration with Other MCP Servers
 
 This server is designed to work alongside:
@@ -1062,12 +1867,50 @@ This server is designed to work alongside:
 3. Check node health and resource allocation
 4. Identify problematic workloads
 
+### Pods Not Created or Rollout Stuck
+1. Use namespace_capacity to check for exceeded quota and FailedCreate events
+2. Check rollout headroom before scaling or deploying
+3. Review LimitRange defaults applied to containers without requests/limits
+4. Use cluster_capacity with the deployment and replicas to check whether nodes have room
+
 ### Application Errors
 1. Use analyze_pod_logs for detailed error analysis
 2. Cross-reference with pod diagnostic information
 3. Check networking and permissions
 4. Review resource constraints
 
+### Connection Refused
+1. Use analyze_pod_logs to find which service the client can't reach
+2. Use diagnose_service on that service to check its selector, endpoints and target ports
+3. Use diagnose_pod on not-ready backends to check their readiness probes
+4. Use check_network_path from the client pod to the service to rule out NetworkPolicy (also for timeouts)
+
+### External Traffic Returns 404/503
+1. Use diagnose_ingress on the namespace to check routes, TLS secrets and classes
+2. Follow unhealthy backends with diagnose_service
+
+### Pod Stuck in ContainerCreating or Pending on Volumes
+1. Use diagnose_storage on the pod to follow its volumes to PVC, PV and StorageClass
+2. For Multi-Attach errors, find the other pod holding the ReadWriteOnce volume
+3. For provisioning errors, check the CSI driver named as provisioner
+
+### Security Review
+1. Use audit_security on the namespace to find Pod Security Standards violations
+2. Fix baseline violations first; each finding names the field to change
+3. Label the namespace with pod-security.kubernetes.io/enforce once no workload would be rejected
+4. Use analyze_rbac on each workload's service account to find over-privileged grants
+
+### Empty or Partial Results
+1. Look for "omitted" in the response: data the server was forbidden to read or ran out of time for
+2. If "timed_out" is true, retry with a larger timeout_seconds or a narrower namespace
+3. Use check_permissions to see which tools are degraded
+4. Apply and bind the ClusterRole it prints
+
+### Quick Metrics Review
+1. Use query_metrics with http_5xx_rate and http_latency_p99 for error rate and response time
+2. Use memory_vs_limit and cpu_throttling to spot resource pressure
+3. Use container_restarts to correlate with recent rollouts
+
 ## Best Practices
 
 - Always start with cluster health analysis
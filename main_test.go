@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// testRuleEngine loads a rules document the way RULES_PATH does
+func testRuleEngine(t *testing.T, document string) *RuleEngine {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(file, []byte(document), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env, err := newRuleEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := &RuleEngine{paths: []string{file}, env: env}
+	if err := engine.reload(context.Background(), nil); err != nil {
+		t.Fatalf("loading rules: %v", err)
+	}
+	return engine
+}
+
+func testDeployment(namespace, name, image string, replicas int32) *appsv1.Deployment {
+	podLabels := map[string]string{"app": name}
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: podLabels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: podLabels},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: image}}},
+			},
+		},
+	}
+}
+
+func TestGetWorkloadRecommendationsAppliesDeploymentRules(t *testing.T) {
+	rules := testRuleEngine(t, `
+rules:
+  - id: no-latest-tag
+    kind: Deployment
+    expression: object.spec.template.spec.containers.exists(c, c.image.endsWith(':latest'))
+    message: "Deployment {{.Name}} uses a :latest image"
+  - id: broken-rule
+    kind: Deployment
+    expression: object.spec.strategy.rollingUpdate.maxSurge == 1
+    message: never fires
+  - id: pod-only
+    kind: Pod
+    expression: "true"
+    message: pods are not evaluated here
+`)
+	s := &K8sDiagnosticsServer{
+		clientset: fake.NewSimpleClientset(
+			testDeployment("shop", "web", "nginx:latest", 2),
+			testDeployment("shop", "api", "api:1.4.2", 2),
+		),
+		rules: rules,
+	}
+
+	result, err := s.getWorkloadRecommendations(context.Background(), "shop")
+	if err != nil {
+		t.Fatalf("getWorkloadRecommendations: %v", err)
+	}
+
+	var ruleFindings []Finding
+	for _, finding := range result.Findings {
+		if finding.Category == CategoryCustom {
+			ruleFindings = append(ruleFindings, finding)
+		}
+	}
+	if len(ruleFindings) != 1 || ruleFindings[0].ID != "no-latest-tag" || ruleFindings[0].Object.Name != "web" {
+		t.Errorf("expected no-latest-tag on web only, got %+v", ruleFindings)
+	}
+	if len(result.RuleErrors) != 2 || !strings.Contains(result.RuleErrors[0], "broken-rule") {
+		t.Errorf("expected broken-rule to fail on both deployments, got %v", result.RuleErrors)
+	}
+}
+
+// failingUsage fails for the listed pods and reports no samples otherwise
+type failingUsage map[string]bool
+
+func (f failingUsage) name() string { return "test" }
+
+func (f failingUsage) containerStats(_ context.Context, _ string, pods []string, _ string) (*usageStats, error) {
+	for _, pod := range pods {
+		if f[pod] {
+			return nil, fmt.Errorf("no series for %s", pod)
+		}
+	}
+	return &usageStats{}, nil
+}
+
+func TestGetWorkloadRecommendationsRightSizesEveryDeployment(t *testing.T) {
+	api := testDeployment("shop", "api", "api:1.4.2", 1)
+	web := testDeployment("shop", "web", "web:2.0.0", 1)
+	worker := testDeployment("shop", "worker", "worker:1.0.0", 1)
+	s := &K8sDiagnosticsServer{
+		clientset: fake.NewSimpleClientset(api, web, worker,
+			testPod("shop", "api-1", "10.0.0.1", api.Spec.Template.Labels),
+			testPod("shop", "web-1", "10.0.0.2", web.Spec.Template.Labels),
+			testPod("shop", "worker-1", "10.0.0.3", worker.Spec.Template.Labels)),
+		usage: failingUsage{"api-1": true, "web-1": true},
+	}
+
+	result, err := s.getWorkloadRecommendations(context.Background(), "shop")
+	if err != nil {
+		t.Fatalf("getWorkloadRecommendations: %v", err)
+	}
+	if len(result.RightSizingErrors) != 2 {
+		t.Errorf("expected an error for api and web, got %v", result.RightSizingErrors)
+	}
+	if len(result.RightSizing) != 1 || result.RightSizing[0].Workload.Name != "worker" {
+		t.Errorf("expected worker to be right-sized after the failures, got %+v", result.RightSizing)
+	}
+}
+
+func TestGetWorkloadRecommendationsNotesForbiddenPodList(t *testing.T) {
+	clientset := fake.NewSimpleClientset(testDeployment("shop", "web", "web:2.0.0", 1))
+	clientset.PrependReactor(forbidList("pods"))
+	s := &K8sDiagnosticsServer{clientset: clientset}
+
+	result, err := s.getWorkloadRecommendations(context.Background(), "shop")
+	if err != nil {
+		t.Fatalf("getWorkloadRecommendations: %v", err)
+	}
+	if len(result.Omitted) != 1 || !strings.HasPrefix(result.Omitted[0], "Observed startup times of deployment web omitted") {
+		t.Errorf("expected the startup time check to be noted as omitted, got %v", result.Omitted)
+	}
+}
+
+func TestListPodsReportsFindings(t *testing.T) {
+	crashing := testPod("shop", "web-1", "10.0.0.1", nil)
+	crashing.Status.Phase = corev1.PodRunning
+	crashing.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		Name:         "app",
+		RestartCount: 12,
+		State:        corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+	}}
+	healthy := testPod("shop", "api-1", "10.0.0.2", nil)
+	healthy.Status.Phase = corev1.PodRunning
+	healthy.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: "app", Ready: true}}
+	completed := testPod("shop", "migrate-1", "", nil)
+	completed.Status.Phase = corev1.PodSucceeded
+	completed.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: "app"}}
+	s := &K8sDiagnosticsServer{clientset: fake.NewSimpleClientset(crashing, healthy, completed)}
+
+	result, err := s.listPods(context.Background(), "shop", false)
+	if err != nil {
+		t.Fatalf("listPods: %v", err)
+	}
+	if result.PodCount != 3 {
+		t.Errorf("expected 3 pods, got %d", result.PodCount)
+	}
+	for _, finding := range result.Findings {
+		if finding.Object.Name != "web-1" {
+			t.Errorf("expected findings only for web-1, got %+v", finding)
+		}
+	}
+	if len(result.Findings) != 3 || result.Findings[0].ID != findingCrashLoop.ID {
+		t.Errorf("expected crash loop, restarts and not ready, most severe first, got %+v", result.Findings)
+	}
+	if result.Summary == nil || result.Summary.Critical != 1 || result.Summary.Warning != 2 {
+		t.Errorf("expected 1 critical and 2 warnings in the summary, got %+v", result.Summary)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Valid get_resource_usage sort keys
+const (
+	SortByRestarts = "restarts"
+	SortByCPU      = "cpu"
+	SortByMemory   = "memory"
+	SortByCPUPct   = "cpu_pct"
+	SortByMemPct   = "mem_pct"
+)
+
+// containerUsage is live usage for one container from metrics.k8s.io
+type containerUsage struct {
+	CPUMillis   int64
+	MemoryBytes int64
+}
+
+// podUsageKey identifies a pod in a usage map
+type podUsageKey struct {
+	Namespace string
+	Name      string
+}
+
+// fetchPodUsage returns live container usage for the pods in a namespace
+// ("" for all namespaces) from the metrics.k8s.io API
+func (s *K8sDiagnosticsServer) fetchPodUsage(ctx context.Context, namespace string) (map[podUsageKey]map[string]containerUsage, error) {
+	if s.metrics == nil {
+		return nil, fmt.Errorf("metrics client not configured")
+	}
+
+	podMetrics, err := s.metrics.MetricsV1beta1().PodMetricses(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("metrics.k8s.io unavailable (is metrics-server installed?): %w", err)
+	}
+
+	usage := make(map[podUsageKey]map[string]containerUsage, len(podMetrics.Items))
+	for _, pm := range podMetrics.Items {
+		containers := make(map[string]containerUsage, len(pm.Containers))
+		for _, c := range pm.Containers {
+			containers[c.Name] = containerUsage{
+				CPUMillis:   c.Usage.Cpu().MilliValue(),
+				MemoryBytes: c.Usage.Memory().Value(),
+			}
+		}
+		usage[podUsageKey{Namespace: pm.Namespace, Name: pm.Name}] = containers
+	}
+	return usage, nil
+}
+
+// quantityMillis returns the milli value of a resource, 0 when unset
+func quantityMillis(list corev1.ResourceList, name corev1.ResourceName) int64 {
+	if q, ok := list[name]; ok {
+		return q.MilliValue()
+	}
+	return 0
+}
+
+// quantityValue returns the integer value of a resource, 0 when unset
+func quantityValue(list corev1.ResourceList, name corev1.ResourceName) int64 {
+	if q, ok := list[name]; ok {
+		return q.Value()
+	}
+	return 0
+}
+
+// percentOf returns usage as a percentage of total, or nil when total is unset
+func percentOf(usage, total int64) *float64 {
+	if total <= 0 {
+		return nil
+	}
+	pct := math.Round(float64(usage)/float64(total)*1000) / 10
+	return &pct
+}
+
+// sortablePercent is used for sorting; pods without the reference value sort last
+func sortablePercent(pct *float64) float64 {
+	if pct == nil {
+		return -1
+	}
+	return *pct
+}
+
+// cpuPressure is CPU usage relative to request, falling back to limit
+func (p PodResourceInfo) cpuPressure() float64 {
+	if p.CPURequestPercent != nil {
+		return *p.CPURequestPercent
+	}
+	return sortablePercent(p.CPULimitPercent)
+}
+
+// memoryPressure is memory usage relative to limit (what triggers OOM kills),
+// falling back to request
+func (p PodResourceInfo) memoryPressure() float64 {
+	if p.MemoryLimitPercent != nil {
+		return *p.MemoryLimitPercent
+	}
+	return sortablePercent(p.MemoryRequestPercent)
+}
+
+// sortResourceInfo sorts pods in descending order of the requested key.
+// Without live metrics, cpu and memory fall back to the requested amounts.
+func sortResourceInfo(pods []PodResourceInfo, sortBy string) error {
+	var less func(a, b PodResourceInfo) bool
+
+	switch sortBy {
+	case "", SortByRestarts:
+		less = func(a, b PodResourceInfo) bool { return a.RestartCount > b.RestartCount }
+	case SortByCPU:
+		less = func(a, b PodResourceInfo) bool {
+			if a.CPUUsageMillis != b.CPUUsageMillis {
+				return a.CPUUsageMillis > b.CPUUsageMillis
+			}
+			return a.CPURequestMillis > b.CPURequestMillis
+		}
+	case SortByMemory:
+		less = func(a, b PodResourceInfo) bool {
+			if a.MemoryUsageBytes != b.MemoryUsageBytes {
+				return a.MemoryUsageBytes > b.MemoryUsageBytes
+			}
+			return a.MemoryRequestBytes > b.MemoryRequestBytes
+		}
+	case SortByCPUPct:
+		less = func(a, b PodResourceInfo) bool { return a.cpuPressure() > b.cpuPressure() }
+	case SortByMemPct:
+		less = func(a, b PodResourceInfo) bool { return a.memoryPressure() > b.memoryPressure() }
+	default:
+		return fmt.Errorf("unsupported sort_by %q (expected restarts, cpu, memory, cpu_pct or mem_pct)", sortBy)
+	}
+
+	sort.SliceStable(pods, func(i, j int) bool { return less(pods[i], pods[j]) })
+	return nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	metricsv1beta1 "k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	metricsfake "k8s.io/metrics/pkg/client/clientset/versioned/fake"
+)
+
+func testResources(cpuRequest, memoryRequest, cpuLimit, memoryLimit string) corev1.ResourceRequirements {
+	list := func(cpu, memory string) corev1.ResourceList {
+		resources := corev1.ResourceList{}
+		if cpu != "" {
+			resources[corev1.ResourceCPU] = resource.MustParse(cpu)
+		}
+		if memory != "" {
+			resources[corev1.ResourceMemory] = resource.MustParse(memory)
+		}
+		return resources
+	}
+	return corev1.ResourceRequirements{Requests: list(cpuRequest, memoryRequest), Limits: list(cpuLimit, memoryLimit)}
+}
+
+func testPodMetrics(namespace, name string, usage map[string][2]string) metricsv1beta1.PodMetrics {
+	pm := metricsv1beta1.PodMetrics{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	for container, values := range usage {
+		pm.Containers = append(pm.Containers, metricsv1beta1.ContainerMetrics{Name: container, Usage: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(values[0]),
+			corev1.ResourceMemory: resource.MustParse(values[1]),
+		}})
+	}
+	return pm
+}
+
+// newFakeMetrics serves pod metrics from the fake metrics client. Its object
+// tracker files PodMetrics under "podmetricses" while the client lists "pods",
+// so the list is answered by a reactor instead.
+func newFakeMetrics(items ...metricsv1beta1.PodMetrics) *metricsfake.Clientset {
+	metrics := metricsfake.NewSimpleClientset()
+	metrics.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		namespace := action.GetNamespace()
+		list := &metricsv1beta1.PodMetricsList{}
+		for _, item := range items {
+			if namespace == "" || item.Namespace == namespace {
+				list.Items = append(list.Items, item)
+			}
+		}
+		return true, list, nil
+	})
+	return metrics
+}
+
+func TestGetResourceUsagePercentages(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "app", Resources: testResources("100m", "100Mi", "200m", "200Mi")},
+			{Name: "sidecar"},
+			{Name: "idle", Resources: testResources("50m", "64Mi", "0", "")},
+		}},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	s := &K8sDiagnosticsServer{
+		clientset: fake.NewSimpleClientset(pod),
+		metrics: newFakeMetrics(testPodMetrics("shop", "web", map[string][2]string{
+			"app":     {"50m", "150Mi"},
+			"sidecar": {"10m", "20Mi"},
+			"idle":    {"0", "0"},
+		})),
+	}
+
+	report, err := s.getResourceUsage(context.Background(), "shop", SortByRestarts)
+	if err != nil {
+		t.Fatalf("getResourceUsage: %v", err)
+	}
+	if !report.MetricsAvailable || len(report.ResourceUsage) != 1 {
+		t.Fatalf("expected live usage for one pod, got %+v", report)
+	}
+	info := report.ResourceUsage[0]
+	containers := map[string]ContainerResourceInfo{}
+	for _, c := range info.Containers {
+		containers[c.Name] = c
+	}
+
+	expectPercent := func(name string, got *float64, want float64) {
+		t.Helper()
+		if got == nil || *got != want {
+			t.Errorf("%s: expected %v%%, got %v", name, want, formatPercent(got))
+		}
+	}
+	expectNil := func(name string, got *float64) {
+		t.Helper()
+		if got != nil {
+			t.Errorf("%s: expected no percentage, got %v", name, *got)
+		}
+	}
+
+	app := containers["app"]
+	expectPercent("app cpu request", app.CPURequestPercent, 50)
+	expectPercent("app cpu limit", app.CPULimitPercent, 25)
+	expectPercent("app memory request", app.MemoryRequestPercent, 150)
+	expectPercent("app memory limit", app.MemoryLimitPercent, 75)
+
+	sidecar := containers["sidecar"]
+	if sidecar.CPUUsageMillis != 10 {
+		t.Errorf("sidecar: expected 10m usage, got %dm", sidecar.CPUUsageMillis)
+	}
+	expectNil("sidecar cpu request", sidecar.CPURequestPercent)
+	expectNil("sidecar cpu limit", sidecar.CPULimitPercent)
+	expectNil("sidecar memory request", sidecar.MemoryRequestPercent)
+	expectNil("sidecar memory limit", sidecar.MemoryLimitPercent)
+
+	idle := containers["idle"]
+	expectPercent("idle cpu request", idle.CPURequestPercent, 0)
+	expectPercent("idle memory request", idle.MemoryRequestPercent, 0)
+	expectNil("idle zero cpu limit", idle.CPULimitPercent)
+
+	// The sidecar sets nothing, so no pod-level percentage is meaningful
+	expectNil("pod cpu request", info.CPURequestPercent)
+	expectNil("pod memory limit", info.MemoryLimitPercent)
+	if info.CPUUsageMillis != 60 {
+		t.Errorf("pod: expected 60m usage, got %dm", info.CPUUsageMillis)
+	}
+
+	// Only the sidecar sets no requests or limits
+	if len(report.Findings) != 1 || report.Findings[0].ID != findingNoResources.ID || report.Findings[0].Object.Container != "sidecar" {
+		t.Errorf("expected a container-no-resources finding for the sidecar, got %+v", report.Findings)
+	}
+	if report.Summary == nil || report.Summary.Warning != 1 {
+		t.Errorf("expected the finding in the summary, got %+v", report.Summary)
+	}
+}
+
+func formatPercent(pct *float64) interface{} {
+	if pct == nil {
+		return "nil"
+	}
+	return *pct
+}
+
+func TestGetResourceUsageWithoutMetricsServer(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Resources: testResources("100m", "", "", "")}}},
+	}
+	s := &K8sDiagnosticsServer{clientset: fake.NewSimpleClientset(pod), metrics: metricsfake.NewSimpleClientset()}
+
+	report, err := s.getResourceUsage(context.Background(), "shop", SortByCPU)
+	if err != nil {
+		t.Fatalf("getResourceUsage: %v", err)
+	}
+	info := report.ResourceUsage[0]
+	if info.MetricsAvailable || info.CPURequestMillis != 100 || info.CPURequestPercent != nil {
+		t.Errorf("expected requests without usage for a pod metrics-server does not report, got %+v", info)
+	}
+	if report.Summary == nil || report.Summary.Total != 0 {
+		t.Errorf("expected an empty summary, got %+v", report.Summary)
+	}
+}
+
+func TestGetResourceUsageRejectsUnknownSortKey(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	metrics := metricsfake.NewSimpleClientset()
+	s := &K8sDiagnosticsServer{clientset: clientset, metrics: metrics}
+
+	if _, err := s.getResourceUsage(context.Background(), "shop", "name"); err == nil {
+		t.Fatal("expected an error for sort_by=name")
+	}
+	if calls := len(clientset.Actions()) + len(metrics.Actions()); calls != 0 {
+		t.Errorf("expected no API calls before the sort key is validated, got %d", calls)
+	}
+}
+
+func TestSortResourceInfo(t *testing.T) {
+	pct := func(value float64) *float64 { return &value }
+	pods := []PodResourceInfo{
+		{Name: "busy", RestartCount: 1, ResourceAmounts: ResourceAmounts{
+			CPUUsageMillis: 900, CPURequestMillis: 1000, CPURequestPercent: pct(90),
+			MemoryUsageBytes: 100, MemoryLimitBytes: 1000, MemoryLimitPercent: pct(10)}},
+		{Name: "crashy", RestartCount: 7, ResourceAmounts: ResourceAmounts{
+			CPUUsageMillis: 10, CPURequestMillis: 100, CPURequestPercent: pct(10),
+			MemoryUsageBytes: 500, MemoryLimitBytes: 600, MemoryLimitPercent: pct(83.3)}},
+		{Name: "unlimited", RestartCount: 3, ResourceAmounts: ResourceAmounts{
+			CPUUsageMillis: 200, CPULimitMillis: 400, CPULimitPercent: pct(50),
+			MemoryUsageBytes: 2000, MemoryRequestBytes: 1000, MemoryRequestPercent: pct(200)}},
+		{Name: "bare", ResourceAmounts: ResourceAmounts{CPUUsageMillis: 200, CPURequestMillis: 50, MemoryRequestBytes: 4000}},
+	}
+
+	tests := []struct {
+		sortBy string
+		want   []string
+	}{
+		{"", []string{"crashy", "unlimited", "busy", "bare"}},
+		{SortByRestarts, []string{"crashy", "unlimited", "busy", "bare"}},
+		// Equal usage falls back to the request
+		{SortByCPU, []string{"busy", "bare", "unlimited", "crashy"}},
+		{SortByMemory, []string{"unlimited", "crashy", "busy", "bare"}},
+		// Request percentage first, then limit percentage; pods without either last
+		{SortByCPUPct, []string{"busy", "unlimited", "crashy", "bare"}},
+		// Limit percentage first, then request percentage
+		{SortByMemPct, []string{"unlimited", "crashy", "busy", "bare"}},
+	}
+	for _, tt := range tests {
+		t.Run("sort_by="+tt.sortBy, func(t *testing.T) {
+			sorted := append([]PodResourceInfo{}, pods...)
+			if err := sortResourceInfo(sorted, tt.sortBy); err != nil {
+				t.Fatalf("sortResourceInfo: %v", err)
+			}
+			for i, pod := range sorted {
+				if pod.Name != tt.want[i] {
+					t.Fatalf("expected order %v, got %s at %d", tt.want, pod.Name, i)
+				}
+			}
+		})
+	}
+
+	if err := sortResourceInfo(pods, "age"); err == nil {
+		t.Error("expected an error for an unknown sort key")
+	}
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// NetworkPathRequest describes the traffic to check. Port is a number or a
+// named port; for a service destination it refers to the service port.
+type NetworkPathRequest struct {
+	SourceNamespace      string             `json:"source_namespace"`
+	SourcePod            string             `json:"source_pod"`
+	DestinationNamespace string             `json:"destination_namespace"`
+	DestinationPod       string             `json:"destination_pod,omitempty"`
+	DestinationService   string             `json:"destination_service,omitempty"`
+	Port                 intstr.IntOrString `json:"port"`
+	Protocol             string             `json:"protocol,omitempty"`
+}
+
+// NetworkPathResult is the check_network_path result
+type NetworkPathResult struct {
+	Source      string             `json:"source"`
+	Destination string             `json:"destination"`
+	Protocol    string             `json:"protocol"`
+	Allowed     bool               `json:"allowed"`
+	Paths       []NetworkPathCheck `json:"paths"`
+	Explanation string             `json:"explanation"`
+	Findings    []Finding          `json:"findings"`
+	Summary     *FindingSummary    `json:"summary,omitempty"`
+	Omitted     []string           `json:"omitted,omitempty"`
+}
+
+// NetworkPathCheck is the verdict for traffic to one destination pod
+type NetworkPathCheck struct {
+	DestinationPod string           `json:"destination_pod"`
+	DestinationIP  string           `json:"destination_ip,omitempty"`
+	Port           int32            `json:"port"`
+	Allowed        bool             `json:"allowed"`
+	Egress         DirectionVerdict `json:"egress"`
+	Ingress        DirectionVerdict `json:"ingress"`
+}
+
+// DirectionVerdict explains the egress or ingress side of a path. A pod is
+// isolated in a direction once any policy of that type selects it; traffic is
+// then allowed only if some rule of those policies matches.
+type DirectionVerdict struct {
+	Isolated    bool               `json:"isolated"`
+	Allowed     bool               `json:"allowed"`
+	Policies    []PolicyEvaluation `json:"policies"`
+	Explanation string             `json:"explanation"`
+}
+
+// PolicyEvaluation is how one policy selecting the pod treated the traffic
+type PolicyEvaluation struct {
+	Policy       string   `json:"policy"`
+	AllowedBy    []string `json:"allowed_by,omitempty"`
+	RulesChecked int      `json:"rules_checked"`
+}
+
+// policyEndpoint is one end of the simulated connection
+type policyEndpoint struct {
+	pod             corev1.Pod
+	namespaceLabels map[string]string
+}
+
+// checkNetworkPath evaluates every NetworkPolicy that applies to the source
+// (egress) and destination (ingress) of a connection. It only uses API
+// objects; whether the CNI plugin enforces NetworkPolicy is not checked.
+func (s *K8sDiagnosticsServer) checkNetworkPath(ctx context.Context, req NetworkPathRequest) (*NetworkPathResult, error) {
+	if req.SourcePod == "" {
+		return nil, fmt.Errorf("source_pod is required")
+	}
+	if (req.DestinationPod == "") == (req.DestinationService == "") {
+		return nil, fmt.Errorf("exactly one of destination_pod and destination_service is required")
+	}
+	if req.Port.Type == intstr.Int && req.Port.IntVal == 0 || req.Port.Type == intstr.String && req.Port.StrVal == "" {
+		return nil, fmt.Errorf("port is required")
+	}
+	if req.SourceNamespace == "" {
+		req.SourceNamespace = "default"
+	}
+	if req.DestinationNamespace == "" {
+		req.DestinationNamespace = req.SourceNamespace
+	}
+	protocol := corev1.Protocol(strings.ToUpper(req.Protocol))
+	if protocol == "" {
+		protocol = corev1.ProtocolTCP
+	}
+	for _, namespace := range []string{req.SourceNamespace, req.DestinationNamespace} {
+		if err := s.access.checkNamespace(namespace); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, omitted := withOmissions(ctx)
+	namespaceLabels, err := s.namespaceLabelLookup(ctx, req.SourceNamespace, req.DestinationNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	sourcePod, err := s.clientset.CoreV1().Pods(req.SourceNamespace).Get(ctx, req.SourcePod, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.access.checkObject("Pod", sourcePod.Namespace, sourcePod.Name, sourcePod.Labels); err != nil {
+		return nil, err
+	}
+	source := policyEndpoint{pod: *sourcePod, namespaceLabels: namespaceLabels(sourcePod.Namespace)}
+
+	// Resolve the destination pods and the container port each one receives on
+	type target struct {
+		pod  corev1.Pod
+		port int32
+	}
+	var targets []target
+	destination := fmt.Sprintf("%s/%s", req.DestinationNamespace, req.DestinationPod)
+
+	if req.DestinationService != "" {
+		destination = fmt.Sprintf("service %s/%s", req.DestinationNamespace, req.DestinationService)
+		svc, err := s.clientset.CoreV1().Services(req.DestinationNamespace).Get(ctx, req.DestinationService, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if err := s.access.checkObject("Service", svc.Namespace, svc.Name, svc.Labels); err != nil {
+			return nil, err
+		}
+		servicePort := findServicePort(svc, req.Port)
+		if servicePort == nil {
+			return nil, fmt.Errorf("service %s has no port %s", req.DestinationService, req.Port.String())
+		}
+		if servicePort.Protocol != "" {
+			protocol = servicePort.Protocol
+		}
+		if len(svc.Spec.Selector) == 0 {
+			return nil, fmt.Errorf("service %s has no selector; check a destination pod instead", req.DestinationService)
+		}
+		pods, err := s.clientset.CoreV1().Pods(req.DestinationNamespace).List(ctx, metav1.ListOptions{
+			LabelSelector: labels.SelectorFromSet(svc.Spec.Selector).String(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		targetPort := servicePort.TargetPort
+		if targetPort.Type == intstr.Int && targetPort.IntVal == 0 {
+			targetPort = intstr.FromInt32(servicePort.Port)
+		}
+		for _, pod := range s.access.filterPods(pods.Items) {
+			if port, ok := resolvePodPort(pod, targetPort); ok {
+				targets = append(targets, target{pod: pod, port: port})
+			}
+		}
+		if len(targets) == 0 {
+			return nil, fmt.Errorf("service %s has no pods with target port %s (see diagnose_service)", req.DestinationService, targetPort.String())
+		}
+	} else {
+		pod, err := s.clientset.CoreV1().Pods(req.DestinationNamespace).Get(ctx, req.DestinationPod, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if err := s.access.checkObject("Pod", pod.Namespace, pod.Name, pod.Labels); err != nil {
+			return nil, err
+		}
+		port, ok := resolvePodPort(*pod, req.Port)
+		if !ok {
+			return nil, fmt.Errorf("pod %s has no container port named %q", req.DestinationPod, req.Port.StrVal)
+		}
+		targets = append(targets, target{pod: *pod, port: port})
+	}
+
+	egressPolicies, err := s.clientset.NetworkingV1().NetworkPolicies(req.SourceNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	ingressPolicies := egressPolicies
+	if req.DestinationNamespace != req.SourceNamespace {
+		ingressPolicies, err = s.clientset.NetworkingV1().NetworkPolicies(req.DestinationNamespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := &NetworkPathResult{
+		Source:      fmt.Sprintf("%s/%s", sourcePod.Namespace, sourcePod.Name),
+		Destination: destination,
+		Protocol:    string(protocol),
+		Allowed:     true,
+		Paths:       []NetworkPathCheck{},
+		Findings:    []Finding{},
+	}
+
+	var denied []string
+	for _, t := range targets {
+		dest := policyEndpoint{pod: t.pod, namespaceLabels: namespaceLabels(t.pod.Namespace)}
+		check := NetworkPathCheck{
+			DestinationPod: t.pod.Name,
+			DestinationIP:  t.pod.Status.PodIP,
+			Port:           t.port,
+			Egress:         evaluatePolicies(egressPolicies.Items, networkingv1.PolicyTypeEgress, source, dest, t.port, protocol),
+			Ingress:        evaluatePolicies(ingressPolicies.Items, networkingv1.PolicyTypeIngress, dest, source, t.port, protocol),
+		}
+		check.Allowed = check.Egress.Allowed && check.Ingress.Allowed
+		result.Paths = append(result.Paths, check)
+		if check.Allowed {
+			continue
+		}
+
+		result.Allowed = false
+		denied = append(denied, t.pod.Name)
+		for _, verdict := range []DirectionVerdict{check.Egress, check.Ingress} {
+			if verdict.Allowed {
+				continue
+			}
+			policies := make([]string, 0, len(verdict.Policies))
+			for _, p := range verdict.Policies {
+				policies = append(policies, p.Policy)
+			}
+			result.Findings = append(result.Findings, newFinding(findingNetworkPolicyDenied,
+				ObjectRef{Kind: "Pod", Namespace: t.pod.Namespace, Name: t.pod.Name},
+				fmt.Sprintf("%s/%s -> %s/%s port %d/%s: %s", sourcePod.Namespace, sourcePod.Name, t.pod.Namespace, t.pod.Name, t.port, protocol, verdict.Explanation),
+				map[string]string{"policies": strings.Join(policies, ", ")}))
+		}
+	}
+
+	switch {
+	case result.Allowed:
+		result.Explanation = fmt.Sprintf("NetworkPolicies allow %s to reach %s", result.Source, destination)
+	case len(denied) == len(targets):
+		result.Explanation = fmt.Sprintf("NetworkPolicies deny %s to %s", result.Source, destination)
+	default:
+		result.Explanation = fmt.Sprintf("NetworkPolicies deny %s to %d of %d backend pod(s): %s",
+			result.Source, len(denied), len(targets), strings.Join(denied, ", "))
+	}
+
+	sortFindings(result.Findings)
+	result.Summary = summarizeFindings(result.Findings)
+	result.Omitted = omitted.list()
+
+	return result, nil
+}
+
+// evaluatePolicies decides one direction. For egress, subject is the source
+// and peer the destination; for ingress it's the other way round.
+func evaluatePolicies(policies []networkingv1.NetworkPolicy, direction networkingv1.PolicyType, subject, peer policyEndpoint, port int32, protocol corev1.Protocol) DirectionVerdict {
+	verdict := DirectionVerdict{Policies: []PolicyEvaluation{}}
+	side := "egress from " + subject.pod.Name
+	if direction == networkingv1.PolicyTypeIngress {
+		side = "ingress to " + subject.pod.Name
+	}
+
+	for _, policy := range policies {
+		if !policyHasType(policy, direction) {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(&policy.Spec.PodSelector)
+		if err != nil || !selector.Matches(labels.Set(subject.pod.Labels)) {
+			continue
+		}
+		verdict.Isolated = true
+
+		evaluation := PolicyEvaluation{Policy: fmt.Sprintf("%s/%s", policy.Namespace, policy.Name)}
+		if direction == networkingv1.PolicyTypeEgress {
+			evaluation.RulesChecked = len(policy.Spec.Egress)
+			for i, rule := range policy.Spec.Egress {
+				if peersMatch(rule.To, policy.Namespace, peer) && portsMatch(rule.Ports, peer.pod, port, protocol) {
+					evaluation.AllowedBy = append(evaluation.AllowedBy, fmt.Sprintf("egress[%d]", i))
+				}
+			}
+		} else {
+			evaluation.RulesChecked = len(policy.Spec.Ingress)
+			for i, rule := range policy.Spec.Ingress {
+				if peersMatch(rule.From, policy.Namespace, peer) && portsMatch(rule.Ports, subject.pod, port, protocol) {
+					evaluation.AllowedBy = append(evaluation.AllowedBy, fmt.Sprintf("ingress[%d]", i))
+				}
+			}
+		}
+		if len(evaluation.AllowedBy) > 0 {
+			verdict.Allowed = true
+		}
+		verdict.Policies = append(verdict.Policies, evaluation)
+	}
+	sort.Slice(verdict.Policies, func(i, j int) bool { return verdict.Policies[i].Policy < verdict.Policies[j].Policy })
+
+	switch {
+	case !verdict.Isolated:
+		verdict.Allowed = true
+		verdict.Explanation = fmt.Sprintf("no NetworkPolicy selects %s for %s, so all traffic is allowed", subject.pod.Name, strings.ToLower(string(direction)))
+	case verdict.Allowed:
+		var rules []string
+		for _, p := range verdict.Policies {
+			for _, rule := range p.AllowedBy {
+				rules = append(rules, p.Policy+" "+rule)
+			}
+		}
+		verdict.Explanation = fmt.Sprintf("%s is allowed by %s", side, strings.Join(rules, ", "))
+	default:
+		names := make([]string, 0, len(verdict.Policies))
+		for _, p := range verdict.Policies {
+			names = append(names, p.Policy)
+		}
+		verb := "select"
+		if len(names) == 1 {
+			verb = "selects"
+		}
+		verdict.Explanation = fmt.Sprintf("%s is denied: %s %s the pod but no rule matches this peer and port",
+			side, strings.Join(names, ", "), verb)
+	}
+	return verdict
+}
+
+// policyHasType applies the API defaulting: Ingress is always implied and
+// Egress only when the policy has egress rules
+func policyHasType(policy networkingv1.NetworkPolicy, direction networkingv1.PolicyType) bool {
+	if len(policy.Spec.PolicyTypes) == 0 {
+		return direction == networkingv1.PolicyTypeIngress || len(policy.Spec.Egress) > 0
+	}
+	for _, t := range policy.Spec.PolicyTypes {
+		if t == direction {
+			return true
+		}
+	}
+	return false
+}
+
+// peersMatch reports whether any peer of a rule selects the endpoint. An
+// empty peer list matches everything.
+func peersMatch(peers []networkingv1.NetworkPolicyPeer, policyNamespace string, endpoint policyEndpoint) bool {
+	if len(peers) == 0 {
+		return true
+	}
+	for _, peer := range peers {
+		if peer.IPBlock != nil {
+			if ipBlockMatches(peer.IPBlock, endpoint.pod.Status.PodIP) {
+				return true
+			}
+			continue
+		}
+
+		if peer.NamespaceSelector != nil {
+			nsSelector, err := metav1.LabelSelectorAsSelector(peer.NamespaceSelector)
+			if err != nil || !nsSelector.Matches(labels.Set(endpoint.namespaceLabels)) {
+				continue
+			}
+		} else if endpoint.pod.Namespace != policyNamespace {
+			// A podSelector alone only selects pods in the policy's namespace
+			continue
+		}
+
+		if peer.PodSelector != nil {
+			podSelector, err := metav1.LabelSelectorAsSelector(peer.PodSelector)
+			if err != nil || !podSelector.Matches(labels.Set(endpoint.pod.Labels)) {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func ipBlockMatches(block *networkingv1.IPBlock, ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	_, cidr, err := net.ParseCIDR(block.CIDR)
+	if err != nil || !cidr.Contains(addr) {
+		return false
+	}
+	for _, except := range block.Except {
+		if _, excluded, err := net.ParseCIDR(except); err == nil && excluded.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// portsMatch reports whether any port of a rule covers the destination port.
+// Named ports are resolved on the destination pod.
+func portsMatch(ports []networkingv1.NetworkPolicyPort, destination corev1.Pod, port int32, protocol corev1.Protocol) bool {
+	if len(ports) == 0 {
+		return true
+	}
+	for _, p := range ports {
+		ruleProtocol := corev1.ProtocolTCP
+		if p.Protocol != nil {
+			ruleProtocol = *p.Protocol
+		}
+		if ruleProtocol != protocol {
+			continue
+		}
+		switch {
+		case p.Port == nil:
+			return true
+		case p.Port.Type == intstr.String:
+			if resolved, ok := resolvePodPort(destination, *p.Port); ok && resolved == port {
+				return true
+			}
+		case p.EndPort != nil:
+			if port >= p.Port.IntVal && port <= *p.EndPort {
+				return true
+			}
+		case p.Port.IntVal == port:
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePodPort turns a port number or container port name into a number
+func resolvePodPort(pod corev1.Pod, port intstr.IntOrString) (int32, bool) {
+	if port.Type == intstr.Int {
+		return port.IntVal, true
+	}
+	if containerPort, _ := findContainerPort(pod, port); containerPort != nil {
+		return containerPort.ContainerPort, true
+	}
+	return 0, false
+}
+
+// findServicePort finds a service port by number or name
+func findServicePort(svc *corev1.Service, port intstr.IntOrString) *corev1.ServicePort {
+	for i, p := range svc.Spec.Ports {
+		if port.Type == intstr.Int && p.Port == port.IntVal || port.Type == intstr.String && p.Name == port.StrVal {
+			return &svc.Spec.Ports[i]
+		}
+	}
+	return nil
+}
+
+// namespaceLabelLookup returns the labels of a namespace, including the
+// kubernetes.io/metadata.name label the API server sets automatically. A caller
+// who may not list namespaces gets the named ones read individually; when those
+// are forbidden too, namespaceSelectors can only match the name label.
+func (s *K8sDiagnosticsServer) namespaceLabelLookup(ctx context.Context, names ...string) (func(string) map[string]string, error) {
+	byName := map[string]map[string]string{}
+	namespaces, err := s.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	switch {
+	case err == nil:
+		for _, ns := range namespaces.Items {
+			byName[ns.Name] = ns.Labels
+		}
+	case apierrors.IsForbidden(err):
+		for _, name := range names {
+			if _, ok := byName[name]; ok {
+				continue
+			}
+			ns, err := s.clientset.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+			if apierrors.IsForbidden(err) {
+				noteOmitted(ctx, "Labels of namespace "+name+" for namespaceSelector rules", err)
+				byName[name] = nil
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			byName[name] = ns.Labels
+		}
+	default:
+		return nil, err
+	}
+	return func(name string) map[string]string {
+		nsLabels := map[string]string{corev1.LabelMetadataName: name}
+		for key, value := range byName[name] {
+			nsLabels[key] = value
+		}
+		return nsLabels
+	}, nil
+}
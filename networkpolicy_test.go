@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testNamespace(name string, nsLabels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: nsLabels}}
+}
+
+func testPod(namespace, name, ip string, podLabels map[string]string, ports ...corev1.ContainerPort) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: podLabels},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Ports: ports}}},
+		Status:     corev1.PodStatus{PodIP: ip},
+	}
+}
+
+func testNetworkPolicy(namespace, name string, podLabels map[string]string, spec networkingv1.NetworkPolicySpec) *networkingv1.NetworkPolicy {
+	spec.PodSelector = metav1.LabelSelector{MatchLabels: podLabels}
+	return &networkingv1.NetworkPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}, Spec: spec}
+}
+
+func portRef(port intstr.IntOrString) *intstr.IntOrString { return &port }
+
+func int32Ref(value int32) *int32 { return &value }
+
+func TestCheckNetworkPath(t *testing.T) {
+	frontend := map[string]string{"app": "frontend"}
+	api := map[string]string{"app": "api"}
+	baseObjects := []runtime.Object{
+		testNamespace("web", nil),
+		testNamespace("backend", nil),
+		testPod("web", "frontend", "10.0.2.5", frontend),
+		testPod("backend", "api", "10.0.3.7", api, corev1.ContainerPort{Name: "http", ContainerPort: 8080}),
+	}
+	toAPI := NetworkPathRequest{SourceNamespace: "web", SourcePod: "frontend",
+		DestinationNamespace: "backend", DestinationPod: "api", Port: intstr.FromInt32(8080)}
+
+	tests := []struct {
+		name        string
+		objects     []runtime.Object
+		request     NetworkPathRequest
+		wantAllowed bool
+		check       func(t *testing.T, result *NetworkPathResult)
+	}{
+		{
+			name:        "no policy selects the pods",
+			objects:     baseObjects,
+			request:     toAPI,
+			wantAllowed: true,
+			check: func(t *testing.T, result *NetworkPathResult) {
+				if result.Paths[0].Ingress.Isolated || result.Paths[0].Egress.Isolated {
+					t.Errorf("expected neither side to be isolated, got %+v", result.Paths[0])
+				}
+			},
+		},
+		{
+			name: "default deny ingress",
+			objects: append(baseObjects, testNetworkPolicy("backend", "default-deny", nil, networkingv1.NetworkPolicySpec{
+				PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			})),
+			request:     toAPI,
+			wantAllowed: false,
+			check: func(t *testing.T, result *NetworkPathResult) {
+				if len(result.Findings) != 1 || result.Findings[0].ID != findingNetworkPolicyDenied.ID {
+					t.Errorf("expected one %s finding, got %+v", findingNetworkPolicyDenied.ID, result.Findings)
+				}
+				if !result.Paths[0].Ingress.Isolated || result.Paths[0].Egress.Isolated {
+					t.Errorf("expected only ingress to be isolated, got %+v", result.Paths[0])
+				}
+			},
+		},
+		{
+			name: "pod selector peer does not match pods in other namespaces",
+			objects: append(baseObjects, testNetworkPolicy("backend", "allow-frontend", api, networkingv1.NetworkPolicySpec{
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					From: []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{MatchLabels: frontend}}},
+				}},
+			})),
+			request:     toAPI,
+			wantAllowed: false,
+		},
+		{
+			name: "namespace selector on the automatic metadata.name label",
+			objects: append(baseObjects, testNetworkPolicy("backend", "allow-web", api, networkingv1.NetworkPolicySpec{
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					From: []networkingv1.NetworkPolicyPeer{{
+						NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{corev1.LabelMetadataName: "web"}},
+						PodSelector:       &metav1.LabelSelector{MatchLabels: frontend},
+					}},
+				}},
+			})),
+			request:     toAPI,
+			wantAllowed: true,
+			check: func(t *testing.T, result *NetworkPathResult) {
+				policies := result.Paths[0].Ingress.Policies
+				if len(policies) != 1 || len(policies[0].AllowedBy) != 1 || policies[0].AllowedBy[0] != "ingress[0]" {
+					t.Errorf("expected backend/allow-web ingress[0] to allow, got %+v", policies)
+				}
+			},
+		},
+		{
+			name: "ip block allows the source",
+			objects: append(baseObjects, testNetworkPolicy("backend", "allow-cidr", api, networkingv1.NetworkPolicySpec{
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					From: []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/16", Except: []string{"10.0.1.0/24"}}}},
+				}},
+			})),
+			request:     toAPI,
+			wantAllowed: true,
+		},
+		{
+			name: "ip block except excludes the source",
+			objects: append(baseObjects, testNetworkPolicy("backend", "allow-cidr", api, networkingv1.NetworkPolicySpec{
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					From: []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/16", Except: []string{"10.0.2.0/24"}}}},
+				}},
+			})),
+			request:     toAPI,
+			wantAllowed: false,
+		},
+		{
+			name: "named port resolves on the destination pod",
+			objects: append(baseObjects, testNetworkPolicy("backend", "allow-http", api, networkingv1.NetworkPolicySpec{
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					Ports: []networkingv1.NetworkPolicyPort{{Port: portRef(intstr.FromString("http"))}},
+				}},
+			})),
+			request:     toAPI,
+			wantAllowed: true,
+		},
+		{
+			name: "named port does not cover another port",
+			objects: append(baseObjects, testNetworkPolicy("backend", "allow-http", api, networkingv1.NetworkPolicySpec{
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					Ports: []networkingv1.NetworkPolicyPort{{Port: portRef(intstr.FromString("http"))}},
+				}},
+			})),
+			request: NetworkPathRequest{SourceNamespace: "web", SourcePod: "frontend",
+				DestinationNamespace: "backend", DestinationPod: "api", Port: intstr.FromInt32(9090)},
+			wantAllowed: false,
+		},
+		{
+			name: "end port range includes the port",
+			objects: append(baseObjects, testNetworkPolicy("backend", "allow-range", api, networkingv1.NetworkPolicySpec{
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					Ports: []networkingv1.NetworkPolicyPort{{Port: portRef(intstr.FromInt32(8000)), EndPort: int32Ref(8100)}},
+				}},
+			})),
+			request:     toAPI,
+			wantAllowed: true,
+		},
+		{
+			name: "end port range excludes the port",
+			objects: append(baseObjects, testNetworkPolicy("backend", "allow-range", api, networkingv1.NetworkPolicySpec{
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{
+					Ports: []networkingv1.NetworkPolicyPort{{Port: portRef(intstr.FromInt32(8000)), EndPort: int32Ref(8079)}},
+				}},
+			})),
+			request:     toAPI,
+			wantAllowed: false,
+		},
+		{
+			name: "egress rules without policy types isolate egress",
+			objects: append(baseObjects, testNetworkPolicy("web", "egress-dns-only", frontend, networkingv1.NetworkPolicySpec{
+				Egress: []networkingv1.NetworkPolicyEgressRule{{
+					Ports: []networkingv1.NetworkPolicyPort{{Port: portRef(intstr.FromInt32(53))}},
+				}},
+			})),
+			request:     toAPI,
+			wantAllowed: false,
+			check: func(t *testing.T, result *NetworkPathResult) {
+				path := result.Paths[0]
+				if !path.Egress.Isolated || path.Egress.Allowed || !path.Ingress.Allowed {
+					t.Errorf("expected egress to be isolated and denied, got %+v", path)
+				}
+			},
+		},
+		{
+			name: "service with only some backends denied",
+			objects: []runtime.Object{
+				testNamespace("web", nil),
+				testNamespace("backend", nil),
+				testPod("web", "frontend", "10.0.2.5", frontend),
+				testPod("backend", "api-a", "10.0.3.7", map[string]string{"app": "api", "track": "stable"},
+					corev1.ContainerPort{Name: "http", ContainerPort: 8080}),
+				testPod("backend", "api-b", "10.0.3.8", map[string]string{"app": "api", "track": "canary"},
+					corev1.ContainerPort{Name: "http", ContainerPort: 8080}),
+				&corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "backend", Name: "api"},
+					Spec: corev1.ServiceSpec{Selector: api, Ports: []corev1.ServicePort{
+						{Name: "web", Port: 80, TargetPort: intstr.FromString("http"), Protocol: corev1.ProtocolTCP},
+					}},
+				},
+				testNetworkPolicy("backend", "deny-canary", map[string]string{"track": "canary"}, networkingv1.NetworkPolicySpec{
+					PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+				}),
+			},
+			request: NetworkPathRequest{SourceNamespace: "web", SourcePod: "frontend",
+				DestinationNamespace: "backend", DestinationService: "api", Port: intstr.FromInt32(80)},
+			wantAllowed: false,
+			check: func(t *testing.T, result *NetworkPathResult) {
+				if len(result.Paths) != 2 {
+					t.Fatalf("expected a path per backend, got %d", len(result.Paths))
+				}
+				for _, path := range result.Paths {
+					if path.Port != 8080 {
+						t.Errorf("expected target port 8080 for %s, got %d", path.DestinationPod, path.Port)
+					}
+					if want := path.DestinationPod == "api-a"; path.Allowed != want {
+						t.Errorf("expected %s allowed=%v, got %v", path.DestinationPod, want, path.Allowed)
+					}
+				}
+				if !strings.Contains(result.Explanation, "1 of 2") || !strings.Contains(result.Explanation, "api-b") {
+					t.Errorf("expected the explanation to name the denied backend, got %q", result.Explanation)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &K8sDiagnosticsServer{clientset: fake.NewSimpleClientset(tt.objects...)}
+			result, err := s.checkNetworkPath(context.Background(), tt.request)
+			if err != nil {
+				t.Fatalf("checkNetworkPath: %v", err)
+			}
+			if result.Allowed != tt.wantAllowed {
+				t.Errorf("expected allowed=%v, got %v: %s", tt.wantAllowed, result.Allowed, result.Explanation)
+			}
+			if tt.check != nil {
+				tt.check(t, result)
+			}
+		})
+	}
+}
+
+func TestCheckNetworkPathWithNamespaceRBAC(t *testing.T) {
+	frontend := map[string]string{"app": "frontend"}
+	objects := []runtime.Object{
+		testNamespace("web", map[string]string{"team": "storefront"}),
+		testNamespace("backend", nil),
+		testPod("web", "frontend", "10.0.2.5", frontend),
+		testPod("backend", "api", "10.0.3.7", map[string]string{"app": "api"}),
+		testNetworkPolicy("backend", "allow-storefront", map[string]string{"app": "api"}, networkingv1.NetworkPolicySpec{
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{{
+				From: []networkingv1.NetworkPolicyPeer{{
+					NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "storefront"}},
+				}},
+			}},
+		}),
+	}
+	request := NetworkPathRequest{SourceNamespace: "web", SourcePod: "frontend",
+		DestinationNamespace: "backend", DestinationPod: "api", Port: intstr.FromInt32(8080)}
+
+	t.Run("namespaces read individually", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(objects...)
+		clientset.PrependReactor(forbidClusterWide("list", "namespaces"))
+		s := &K8sDiagnosticsServer{clientset: clientset}
+
+		result, err := s.checkNetworkPath(context.Background(), request)
+		if err != nil {
+			t.Fatalf("checkNetworkPath: %v", err)
+		}
+		if !result.Allowed || len(result.Omitted) != 0 {
+			t.Errorf("expected the namespace labels to be read individually, got allowed=%v omitted=%v", result.Allowed, result.Omitted)
+		}
+	})
+
+	t.Run("namespaces unreadable", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(objects...)
+		clientset.PrependReactor(forbidClusterWide("list", "namespaces"))
+		clientset.PrependReactor(forbidClusterWide("get", "namespaces"))
+		s := &K8sDiagnosticsServer{clientset: clientset}
+
+		result, err := s.checkNetworkPath(context.Background(), request)
+		if err != nil {
+			t.Fatalf("checkNetworkPath: %v", err)
+		}
+		if result.Allowed {
+			t.Error("expected the team label selector not to match without namespace labels")
+		}
+		if len(result.Omitted) != 2 || !strings.HasPrefix(result.Omitted[0], "Labels of namespace web") {
+			t.Errorf("expected the namespace labels to be noted as omitted, got %v", result.Omitted)
+		}
+	})
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oidcClockSkew is the leeway allowed when checking exp and nbf
+const oidcClockSkew = time.Minute
+
+// oidcKeyReloadInterval is the least time between JWKS re-reads triggered by
+// unknown kids, so a flood of forged tokens cannot keep the file busy
+const oidcKeyReloadInterval = 10 * time.Second
+
+// oidcAuthenticator validates OIDC ID tokens against a local JWKS file and maps
+// their claims to an identity, like kube-apiserver's --oidc-* flags
+type oidcAuthenticator struct {
+	issuer         string
+	clientID       string
+	jwksFile       string
+	usernameClaim  string
+	usernamePrefix string
+	groupsClaim    string
+	groupsPrefix   string
+	// allowedSystemUsers and allowedSystemGroups are the system: names accepted
+	// without a username or groups prefix
+	allowedSystemUsers  []string
+	allowedSystemGroups []string
+
+	mu   sync.Mutex
+	keys map[string]crypto.PublicKey
+	// lastReload is when an unknown kid last triggered a re-read
+	lastReload time.Time
+}
+
+// newOIDCAuthenticatorFromEnv returns nil when OIDC_ISSUER_URL is not set
+func newOIDCAuthenticatorFromEnv() (*oidcAuthenticator, error) {
+	issuer := os.Getenv("OIDC_ISSUER_URL")
+	if issuer == "" {
+		return nil, nil
+	}
+	auth := &oidcAuthenticator{
+		issuer:         issuer,
+		clientID:       os.Getenv("OIDC_CLIENT_ID"),
+		jwksFile:       os.Getenv("OIDC_JWKS_FILE"),
+		usernameClaim:  os.Getenv("OIDC_USERNAME_CLAIM"),
+		usernamePrefix: os.Getenv("OIDC_USERNAME_PREFIX"),
+		groupsClaim:    os.Getenv("OIDC_GROUPS_CLAIM"),
+		groupsPrefix:   os.Getenv("OIDC_GROUPS_PREFIX"),
+
+		allowedSystemUsers:  splitList(os.Getenv("AUTH_ALLOWED_SYSTEM_USERS")),
+		allowedSystemGroups: splitList(os.Getenv("AUTH_ALLOWED_SYSTEM_GROUPS")),
+	}
+	if auth.clientID == "" || auth.jwksFile == "" {
+		return nil, fmt.Errorf("OIDC_ISSUER_URL needs OIDC_CLIENT_ID and OIDC_JWKS_FILE")
+	}
+	if auth.usernameClaim == "" {
+		auth.usernameClaim = "sub"
+	}
+	if auth.groupsClaim == "" {
+		auth.groupsClaim = "groups"
+	}
+	if err := auth.loadKeys(); err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+// loadKeys reads the RSA and EC keys of the JWKS file
+func (a *oidcAuthenticator) loadKeys() error {
+	data, err := os.ReadFile(a.jwksFile)
+	if err != nil {
+		return fmt.Errorf("failed to read JWKS file: %w", err)
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return fmt.Errorf("failed to parse JWKS file: %w", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, key := range jwks.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		switch key.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(key.N)
+			e, errE := base64.RawURLEncoding.DecodeString(key.E)
+			if errN != nil || errE != nil {
+				return fmt.Errorf("JWKS key %q: invalid RSA modulus or exponent", key.Kid)
+			}
+			keys[key.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			curve, ok := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}[key.Crv]
+			if !ok {
+				return fmt.Errorf("JWKS key %q: unsupported curve %q", key.Kid, key.Crv)
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(key.X)
+			y, errY := base64.RawURLEncoding.DecodeString(key.Y)
+			if errX != nil || errY != nil {
+				return fmt.Errorf("JWKS key %q: invalid EC coordinates", key.Kid)
+			}
+			keys[key.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("JWKS file %s has no RSA or EC signing keys", a.jwksFile)
+	}
+
+	a.mu.Lock()
+	a.keys = keys
+	a.mu.Unlock()
+	return nil
+}
+
+// key returns the key for a kid, re-reading the JWKS file for an unknown kid
+// so rotated keys are picked up without a restart. Re-reads happen at most
+// once per oidcKeyReloadInterval.
+func (a *oidcAuthenticator) key(kid string) (crypto.PublicKey, error) {
+	if key, ok := a.lookupKey(kid); ok {
+		return key, nil
+	}
+	a.mu.Lock()
+	due := time.Since(a.lastReload) >= oidcKeyReloadInterval
+	if due {
+		a.lastReload = time.Now()
+	}
+	a.mu.Unlock()
+	if !due {
+		return nil, fmt.Errorf("no JWKS key with kid %q", kid)
+	}
+	if err := a.loadKeys(); err != nil {
+		return nil, err
+	}
+	if key, ok := a.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("no JWKS key with kid %q", kid)
+}
+
+// lookupKey finds a key by kid; a token without a kid may use the only key
+func (a *oidcAuthenticator) lookupKey(kid string) (crypto.PublicKey, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if key, ok := a.keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(a.keys) == 1 {
+		for _, key := range a.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+func (a *oidcAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	token := bearerToken(r)
+	if strings.Count(token, ".") != 2 {
+		return nil, errNoCredentials
+	}
+	claims, err := a.verify(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OIDC token: %w", err)
+	}
+	return a.identity(claims)
+}
+
+// verify checks the token's signature, issuer, audience and lifetime and returns its claims
+func (a *oidcAuthenticator) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed header")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("malformed header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature")
+	}
+
+	key, err := a.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed payload")
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed payload")
+	}
+
+	if iss, _ := claims["iss"].(string); iss != a.issuer {
+		return nil, fmt.Errorf("issuer %q is not %q", iss, a.issuer)
+	}
+	if !stringClaimContains(claims["aud"], a.clientID) {
+		return nil, fmt.Errorf("audience does not include %q", a.clientID)
+	}
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("missing exp")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(oidcClockSkew)) {
+		return nil, fmt.Errorf("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(oidcClockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("token not valid yet")
+	}
+	return claims, nil
+}
+
+// verifyJWTSignature checks an RS*, PS* or ES* signature
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	hashes := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+	hash, ok := hashes[strings.TrimLeft(alg, "RSPE")]
+	if !ok || len(alg) != 5 {
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS", "PS":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match algorithm %s", alg)
+		}
+		var err error
+		if alg[:2] == "RS" {
+			err = rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature)
+		} else {
+			err = rsa.VerifyPSS(rsaKey, hash, digest, signature, nil)
+		}
+		if err != nil {
+			return fmt.Errorf("signature verification failed")
+		}
+	case "ES":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature)%2 != 0 {
+			return fmt.Errorf("key type does not match algorithm %s", alg)
+		}
+		half := len(signature) / 2
+		r := new(big.Int).SetBytes(signature[:half])
+		s := new(big.Int).SetBytes(signature[half:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return fmt.Errorf("signature verification failed")
+		}
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	return nil
+}
+
+// identity maps verified claims to a user and groups
+func (a *oidcAuthenticator) identity(claims map[string]interface{}) (*Identity, error) {
+	user, _ := claims[a.usernameClaim].(string)
+	if user == "" {
+		return nil, fmt.Errorf("invalid OIDC token: claim %q is missing", a.usernameClaim)
+	}
+	// Like kube-apiserver, an email is only trusted when the provider verified it
+	if a.usernameClaim == "email" {
+		if verified, present := claims["email_verified"].(bool); present && !verified {
+			return nil, fmt.Errorf("invalid OIDC token: email %s is not verified", user)
+		}
+	}
+	identity := &Identity{User: a.usernamePrefix + user}
+	// OIDC_USERNAME_PREFIX keeps provider subjects out of the system: namespace
+	if err := checkSystemUser(identity.User, a.allowedSystemUsers); err != nil {
+		return nil, fmt.Errorf("invalid OIDC token: %w", err)
+	}
+
+	switch groups := claims[a.groupsClaim].(type) {
+	case string:
+		identity.Groups = []string{a.groupsPrefix + groups}
+	case []interface{}:
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, a.groupsPrefix+name)
+			}
+		}
+	}
+	// OIDC_GROUPS_PREFIX keeps provider groups out of the system: namespace
+	if err := checkSystemGroups(identity.Groups, a.allowedSystemGroups); err != nil {
+		return nil, fmt.Errorf("invalid OIDC token: %w", err)
+	}
+	return identity, nil
+}
+
+// stringClaimContains reports whether a string or string-array claim contains value
+func stringClaimContains(claim interface{}, value string) bool {
+	switch claim := claim.(type) {
+	case string:
+		return claim == value
+	case []interface{}:
+		for _, item := range claim {
+			if item == value {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeJWKS writes a JWKS file with one RSA signing key per kid
+func writeJWKS(t *testing.T, file string, kids ...string) {
+	t.Helper()
+	var keys []map[string]string
+	for _, kid := range kids {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, map[string]string{
+			"kty": "RSA", "kid": kid, "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCKeyReloadIsRateLimited(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, file, "old")
+	auth := &oidcAuthenticator{jwksFile: file}
+	if err := auth.loadKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := auth.key("forged"); err == nil {
+		t.Fatal("expected an unknown kid to fail")
+	}
+	// The key is rotated right after a reload: it is only seen once the interval passed
+	writeJWKS(t, file, "old", "new")
+	if _, err := auth.key("new"); err == nil {
+		t.Error("expected the JWKS file not to be re-read within the reload interval")
+	}
+	auth.lastReload = time.Now().Add(-oidcKeyReloadInterval)
+	if _, err := auth.key("new"); err != nil {
+		t.Errorf("expected the rotated key after the reload interval: %v", err)
+	}
+	if _, err := auth.key("old"); err != nil {
+		t.Errorf("known keys must not wait for a reload: %v", err)
+	}
+}
+
+func TestOIDCIdentityRejectsSystemGroups(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		allowed []string
+		groups  interface{}
+		want    []string
+		wantErr bool
+	}{
+		{name: "ordinary groups", groups: []interface{}{"dev", "ops"}, want: []string{"dev", "ops"}},
+		{name: "system:masters", groups: []interface{}{"dev", "system:masters"}, wantErr: true},
+		{name: "system group as a string claim", groups: "system:nodes", wantErr: true},
+		{name: "prefixed", prefix: "oidc:", groups: []interface{}{"system:masters"},
+			want: []string{"oidc:system:masters"}},
+		{name: "explicitly allowed", allowed: []string{"system:monitoring"},
+			groups: []interface{}{"system:monitoring"}, want: []string{"system:monitoring"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := &oidcAuthenticator{usernameClaim: "sub", groupsClaim: "groups", groupsPrefix: tt.prefix, allowedSystemGroups: tt.allowed}
+			identity, err := auth.identity(map[string]interface{}{"sub": "alice", "groups": tt.groups})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", identity)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("identity: %v", err)
+			}
+			if !reflect.DeepEqual(identity.Groups, tt.want) {
+				t.Errorf("expected groups %v, got %v", tt.want, identity.Groups)
+			}
+		})
+	}
+}
+
+func TestOIDCIdentityRejectsSystemUsers(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		allowed []string
+		sub     string
+		want    string
+		wantErr bool
+	}{
+		{name: "ordinary user", sub: "alice", want: "alice"},
+		{name: "controller manager", sub: "system:kube-controller-manager", wantErr: true},
+		{name: "service account", sub: "system:serviceaccount:kube-system:admin", wantErr: true},
+		{name: "prefixed", prefix: "oidc:", sub: "system:kube-controller-manager", want: "oidc:system:kube-controller-manager"},
+		{name: "explicitly allowed", allowed: []string{"system:monitoring"}, sub: "system:monitoring", want: "system:monitoring"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := &oidcAuthenticator{usernameClaim: "sub", groupsClaim: "groups", usernamePrefix: tt.prefix, allowedSystemUsers: tt.allowed}
+			identity, err := auth.identity(map[string]interface{}{"sub": tt.sub})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", identity)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("identity: %v", err)
+			}
+			if identity.User != tt.want {
+				t.Errorf("expected user %s, got %s", tt.want, identity.User)
+			}
+		})
+	}
+}
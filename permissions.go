@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// apiPermission is one API call the tools make, cluster-wide
+type apiPermission struct {
+	Group    string
+	Resource string
+	Verb     string
+	Tools    []string
+}
+
+// requiredPermissions lists every API the tools use. Keep it in sync when a tool starts
+// calling a new API: check_permissions and the generated ClusterRole are built from it.
+var requiredPermissions = []apiPermission{
+	{"", "pods", "get", []string{"diagnose_pod", "diagnose_storage", "check_network_path"}},
+	{"", "pods", "list", []string{"list_pods", "find_problematic_pods", "search_pods", "analyze_cluster_health", "quick_triage",
+		"get_resource_usage", "get_workload_recommendations", "namespace_capacity", "cluster_capacity", "diagnose_service",
+		"check_network_path", "diagnose_storage", "audit_security", "query_metrics", "evaluate_rules"}},
+	{"", "pods/log", "get", []string{"analyze_pod_logs"}},
+	{"", "events", "list", []string{"diagnose_pod", "diagnose_storage", "namespace_capacity"}},
+	{"", "nodes", "list", []string{"analyze_cluster_health", "cluster_capacity", "evaluate_rules"}},
+	{"", "nodes/proxy", "get", []string{"get_resource_usage", "get_workload_recommendations"}},
+	{"", "namespaces", "get", []string{"audit_security"}},
+	{"", "namespaces", "list", []string{"analyze_cluster_health", "check_network_path"}},
+	{"", "services", "get", []string{"diagnose_service", "check_network_path", "diagnose_ingress"}},
+	{"", "configmaps", "get", []string{"diagnose_pod"}},
+	{"", "secrets", "get", []string{"diagnose_pod", "diagnose_ingress"}},
+	{"", "serviceaccounts", "get", []string{"diagnose_pod", "analyze_rbac"}},
+	{"", "persistentvolumeclaims", "get", []string{"diagnose_pod", "diagnose_storage"}},
+	{"", "persistentvolumes", "get", []string{"diagnose_storage"}},
+	{"", "resourcequotas", "list", []string{"namespace_capacity", "cluster_capacity"}},
+	{"", "limitranges", "list", []string{"namespace_capacity", "cluster_capacity"}},
+	{"apps", "deployments", "get", []string{"cluster_capacity", "query_metrics"}},
+	{"apps", "deployments", "list", []string{"get_workload_recommendations", "namespace_capacity", "audit_security", "evaluate_rules"}},
+	{"apps", "statefulsets", "list", []string{"audit_security"}},
+	{"apps", "daemonsets", "list", []string{"audit_security"}},
+	{"apps", "replicasets", "list", []string{"audit_security"}},
+	{"batch", "jobs", "list", []string{"audit_security"}},
+	{"batch", "cronjobs", "list", []string{"audit_security"}},
+	{"policy", "poddisruptionbudgets", "list", []string{"evaluate_rules"}},
+	{"discovery.k8s.io", "endpointslices", "list", []string{"diagnose_service", "diagnose_ingress"}},
+	{"networking.k8s.io", "networkpolicies", "list", []string{"check_network_path"}},
+	{"networking.k8s.io", "ingresses", "list", []string{"diagnose_ingress"}},
+	{"networking.k8s.io", "ingressclasses", "list", []string{"diagnose_ingress"}},
+	{"gateway.networking.k8s.io", "httproutes", "list", []string{"diagnose_ingress"}},
+	{"gateway.networking.k8s.io", "gateways", "get", []string{"diagnose_ingress"}},
+	{"gateway.networking.k8s.io", "gatewayclasses", "get", []string{"diagnose_ingress"}},
+	{"gateway.networking.k8s.io", "referencegrants", "list", []string{"diagnose_ingress"}},
+	{"storage.k8s.io", "storageclasses", "list", []string{"diagnose_storage"}},
+	{"storage.k8s.io", "volumeattachments", "list", []string{"diagnose_storage"}},
+	{"rbac.authorization.k8s.io", "clusterrolebindings", "list", []string{"analyze_rbac"}},
+	{"rbac.authorization.k8s.io", "rolebindings", "list", []string{"analyze_rbac"}},
+	{"rbac.authorization.k8s.io", "clusterroles", "get", []string{"analyze_rbac"}},
+	{"rbac.authorization.k8s.io", "roles", "get", []string{"analyze_rbac"}},
+	{"authorization.k8s.io", "subjectaccessreviews", "create", []string{"analyze_rbac"}},
+	{"metrics.k8s.io", "pods", "list", []string{"get_resource_usage", "get_workload_recommendations"}},
+}
+
+// PermissionReport is the check_permissions result
+type PermissionReport struct {
+	Checks        []PermissionCheck `json:"checks"`
+	DegradedTools []DegradedTool    `json:"degraded_tools"`
+	// ClusterRole is the minimal ClusterRole YAML granting everything the tools use
+	ClusterRole string          `json:"cluster_role"`
+	Findings    []Finding       `json:"findings"`
+	Summary     *FindingSummary `json:"summary,omitempty"`
+}
+
+// PermissionCheck is the SelfSubjectAccessReview result for one API
+type PermissionCheck struct {
+	Group    string   `json:"group"`
+	Resource string   `json:"resource"`
+	Verb     string   `json:"verb"`
+	Allowed  bool     `json:"allowed"`
+	Reason   string   `json:"reason,omitempty"`
+	Tools    []string `json:"tools"`
+}
+
+// DegradedTool is a tool that will fail or return partial results
+type DegradedTool struct {
+	Tool    string   `json:"tool"`
+	Missing []string `json:"missing"`
+}
+
+// permissionString renders a permission like "list events" or "get deployments.apps"
+func permissionString(group, resource, verb string) string {
+	if group != "" {
+		resource += "." + group
+	}
+	return verb + " " + resource
+}
+
+// checkPermissions runs a SelfSubjectAccessReview for every API the tools use
+func (s *K8sDiagnosticsServer) checkPermissions(ctx context.Context) (*PermissionReport, error) {
+	checks := []PermissionCheck{}
+	for _, permission := range requiredPermissions {
+		resource, subresource, _ := strings.Cut(permission.Resource, "/")
+		review, err := s.clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Verb:        permission.Verb,
+					Group:       permission.Group,
+					Resource:    resource,
+					Subresource: subresource,
+				},
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("SelfSubjectAccessReview for %s failed: %w",
+				permissionString(permission.Group, permission.Resource, permission.Verb), err)
+		}
+		checks = append(checks, PermissionCheck{
+			Group:    permission.Group,
+			Resource: permission.Resource,
+			Verb:     permission.Verb,
+			Allowed:  review.Status.Allowed,
+			Reason:   review.Status.Reason,
+			Tools:    permission.Tools,
+		})
+	}
+	return permissionReport(checks)
+}
+
+// permissionReport works out which tools the denied checks degrade
+func permissionReport(checks []PermissionCheck) (*PermissionReport, error) {
+	report := &PermissionReport{
+		Checks:        checks,
+		DegradedTools: []DegradedTool{},
+		Findings:      []Finding{},
+	}
+
+	missing := map[string][]string{}
+	for _, check := range checks {
+		if check.Allowed {
+			continue
+		}
+		for _, tool := range check.Tools {
+			missing[tool] = append(missing[tool], permissionString(check.Group, check.Resource, check.Verb))
+		}
+	}
+
+	tools := make([]string, 0, len(missing))
+	for tool := range missing {
+		tools = append(tools, tool)
+	}
+	sort.Strings(tools)
+	for _, tool := range tools {
+		report.DegradedTools = append(report.DegradedTools, DegradedTool{Tool: tool, Missing: missing[tool]})
+		report.Findings = append(report.Findings, newFinding(findingPermissionMissing, ObjectRef{Kind: "Tool", Name: tool},
+			fmt.Sprintf("%s is degraded: the server's credentials cannot %s", tool, strings.Join(missing[tool], ", ")),
+			map[string]string{"missing": strings.Join(missing[tool], ",")}))
+	}
+
+	clusterRole, err := minimalClusterRole()
+	if err != nil {
+		return nil, err
+	}
+	report.ClusterRole = clusterRole
+
+	sortFindings(report.Findings)
+	report.Summary = summarizeFindings(report.Findings)
+
+	return report, nil
+}
+
+// minimalClusterRole renders the ClusterRole the tools need, one rule per API group
+// and verb set
+func minimalClusterRole() (string, error) {
+	type ruleKey struct{ group, verbs string }
+	resources := map[ruleKey][]string{}
+	verbs := map[string][]string{}
+	for _, permission := range requiredPermissions {
+		key := permission.Group + "/" + permission.Resource
+		if !containsString(verbs[key], permission.Verb) {
+			verbs[key] = append(verbs[key], permission.Verb)
+		}
+	}
+	for key, resourceVerbs := range verbs {
+		group, resource, _ := strings.Cut(key, "/")
+		sort.Strings(resourceVerbs)
+		rk := ruleKey{group, strings.Join(resourceVerbs, ",")}
+		resources[rk] = append(resources[rk], resource)
+	}
+
+	keys := make([]ruleKey, 0, len(resources))
+	for key := range resources {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].group != keys[j].group {
+			return keys[i].group < keys[j].group
+		}
+		return keys[i].verbs < keys[j].verbs
+	})
+
+	role := rbacv1.ClusterRole{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+		ObjectMeta: metav1.ObjectMeta{Name: "k8s-diagnostics-mcp"},
+	}
+	for _, key := range keys {
+		sort.Strings(resources[key])
+		role.Rules = append(role.Rules, rbacv1.PolicyRule{
+			APIGroups: []string{key.group},
+			Resources: resources[key],
+			Verbs:     strings.Split(key.verbs, ","),
+		})
+	}
+
+	data, err := yaml.Marshal(role)
+	if err != nil {
+		return "", err
+	}
+	// creationTimestamp: null is noise in a manifest meant to be applied
+	return strings.Replace(string(data), "  creationTimestamp: null\n", "", 1), nil
+}
+
+// logPermissionPreflight checks the server's permissions at startup and logs degraded tools
+func (s *K8sDiagnosticsServer) logPermissionPreflight() {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	report, err := s.checkPermissions(ctx)
+	if err != nil {
+		log.Printf("Permission preflight skipped: %v", err)
+		return
+	}
+	for _, degraded := range report.DegradedTools {
+		log.Printf("Permission preflight: %s is degraded (missing: %s)", degraded.Tool, strings.Join(degraded.Missing, ", "))
+	}
+	if len(report.DegradedTools) > 0 {
+		log.Printf("Permission preflight: run check_permissions for the ClusterRole that grants the missing permissions")
+	}
+}
+
+// omissions collects the data a tool left out of its response because the API
+// server refused access or time ran out, so responses can say what is missing
+// instead of dropping it silently
+type omissions struct {
+	mu    sync.Mutex
+	notes []string
+}
+
+type omissionsKey struct{}
+
+// withOmissions returns a context that collects omissions for one tool response
+func withOmissions(ctx context.Context) (context.Context, *omissions) {
+	o := &omissions{}
+	return context.WithValue(ctx, omissionsKey{}, o), o
+}
+
+// noteOmitted records that data was omitted when err is a forbidden error or
+// the tool ran out of time. A nil err records nothing.
+func noteOmitted(ctx context.Context, what string, err error) {
+	if err == nil {
+		return
+	}
+	o, ok := ctx.Value(omissionsKey{}).(*omissions)
+	switch {
+	case !ok:
+	case apierrors.IsForbidden(err):
+		o.add(fmt.Sprintf("%s omitted: %v", what, err))
+	case errors.Is(err, context.DeadlineExceeded) || timedOut(ctx):
+		o.add(fmt.Sprintf("%s omitted: timed out", what))
+	}
+}
+
+// add records a note once
+func (o *omissions) add(note string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !containsString(o.notes, note) {
+		o.notes = append(o.notes, note)
+	}
+}
+
+// list returns the recorded notes, or nil when nothing was omitted
+func (o *omissions) list() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.notes
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestNoteOmitted(t *testing.T) {
+	forbidden := apierrors.NewForbidden(schema.GroupResource{Resource: "secrets"}, "db", fmt.Errorf("denied"))
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want int
+	}{
+		{name: "forbidden", ctx: context.Background(), err: forbidden, want: 1},
+		{name: "deadline exceeded", ctx: context.Background(), err: context.DeadlineExceeded, want: 1},
+		{name: "other error", ctx: context.Background(), err: errors.New("connection refused"), want: 0},
+		{name: "no error", ctx: context.Background(), want: 0},
+		{name: "no error after the deadline", ctx: expired, want: 0},
+		{name: "other error after the deadline", ctx: expired, err: errors.New("context canceled"), want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, omitted := withOmissions(tt.ctx)
+			noteOmitted(ctx, "Secret shop/db reference check", tt.err)
+			if got := len(omitted.list()); got != tt.want {
+				t.Errorf("expected %d notes, got %v", tt.want, omitted.list())
+			}
+		})
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Thresholds holds the tunable values used by the diagnostic heuristics
+type Thresholds struct {
+	PodRestartCount      int32           `json:"pod_restart_count"`
+	ProblemRestartCount  int32           `json:"problem_restart_count"`
+	HealthyNodeRatio     float64         `json:"healthy_node_ratio"`
+	ProblemPodCount      int             `json:"problem_pod_count"`
+	ProblemPodPercentage float64         `json:"problem_pod_percentage"`
+	EventWindow          metav1.Duration `json:"event_window"`
+	LogErrorCount        int             `json:"log_error_count"`
+	LogWarningCount      int             `json:"log_warning_count"`
+	CPUThrottlingPercent float64         `json:"cpu_throttling_percent"`
+	MemoryLimitPercent   float64         `json:"memory_limit_percent"`
+	QuotaWarningPercent  float64         `json:"quota_warning_percent"`
+	NodeCapacityPercent  float64         `json:"node_capacity_percent"`
+}
+
+// DefaultThresholds returns the built-in thresholds used when no policy file is configured
+func DefaultThresholds() Thresholds {
+	return Thresholds{
+		PodRestartCount:      5,
+		ProblemRestartCount:  3,
+		HealthyNodeRatio:     0.8,
+		ProblemPodCount:      10,
+		ProblemPodPercentage: 20,
+		EventWindow:          metav1.Duration{Duration: 24 * time.Hour},
+		LogErrorCount:        10,
+		LogWarningCount:      5,
+		CPUThrottlingPercent: 25,
+		MemoryLimitPercent:   90,
+		QuotaWarningPercent:  90,
+		NodeCapacityPercent:  90,
+	}
+}
+
+func (t Thresholds) validate() error {
+	if t.PodRestartCount < 0 || t.ProblemRestartCount < 0 {
+		return fmt.Errorf("restart counts must not be negative")
+	}
+	if t.HealthyNodeRatio < 0 || t.HealthyNodeRatio > 1 {
+		return fmt.Errorf("healthy_node_ratio must be between 0 and 1, got %v", t.HealthyNodeRatio)
+	}
+	if t.ProblemPodCount < 0 {
+		return fmt.Errorf("problem_pod_count must not be negative")
+	}
+	if t.ProblemPodPercentage < 0 || t.ProblemPodPercentage > 100 {
+		return fmt.Errorf("problem_pod_percentage must be between 0 and 100, got %v", t.ProblemPodPercentage)
+	}
+	if t.EventWindow.Duration <= 0 {
+		return fmt.Errorf("event_window must be positive")
+	}
+	if t.LogErrorCount < 0 || t.LogWarningCount < 0 {
+		return fmt.Errorf("log thresholds must not be negative")
+	}
+	if t.CPUThrottlingPercent < 0 || t.CPUThrottlingPercent > 100 {
+		return fmt.Errorf("cpu_throttling_percent must be between 0 and 100, got %v", t.CPUThrottlingPercent)
+	}
+	if t.MemoryLimitPercent < 0 || t.MemoryLimitPercent > 100 {
+		return fmt.Errorf("memory_limit_percent must be between 0 and 100, got %v", t.MemoryLimitPercent)
+	}
+	if t.QuotaWarningPercent < 0 || t.QuotaWarningPercent > 100 {
+		return fmt.Errorf("quota_warning_percent must be between 0 and 100, got %v", t.QuotaWarningPercent)
+	}
+	if t.NodeCapacityPercent < 0 || t.NodeCapacityPercent > 100 {
+		return fmt.Errorf("node_capacity_percent must be between 0 and 100, got %v", t.NodeCapacityPercent)
+	}
+	return nil
+}
+
+// Policy is the effective diagnostics policy: cluster defaults plus
+// fully resolved per-namespace thresholds
+type Policy struct {
+	Defaults   Thresholds            `json:"defaults"`
+	Namespaces map[string]Thresholds `json:"namespaces,omitempty"`
+}
+
+// policyFile is the on-disk format. Namespace entries only need to list the
+// fields they override; everything else is inherited from the defaults.
+type policyFile struct {
+	Defaults   json.RawMessage            `json:"defaults,omitempty"`
+	Namespaces map[string]json.RawMessage `json:"namespaces,omitempty"`
+}
+
+// parsePolicy parses a YAML or JSON policy document
+func parsePolicy(data []byte) (*Policy, error) {
+	var file policyFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %w", err)
+	}
+
+	policy := &Policy{
+		Defaults:   DefaultThresholds(),
+		Namespaces: make(map[string]Thresholds),
+	}
+
+	if err := decodeThresholds(file.Defaults, &policy.Defaults); err != nil {
+		return nil, fmt.Errorf("invalid defaults: %w", err)
+	}
+
+	for namespace, raw := range file.Namespaces {
+		thresholds := policy.Defaults
+		if err := decodeThresholds(raw, &thresholds); err != nil {
+			return nil, fmt.Errorf("invalid overrides for namespace %s: %w", namespace, err)
+		}
+		policy.Namespaces[namespace] = thresholds
+	}
+
+	return policy, nil
+}
+
+// decodeThresholds overlays the fields present in raw onto thresholds
+func decodeThresholds(raw json.RawMessage, thresholds *Thresholds) error {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(thresholds); err != nil {
+		return err
+	}
+	return thresholds.validate()
+}
+
+// PolicyStore holds the current policy and reloads it when the file changes
+type PolicyStore struct {
+	path string
+
+	mu        sync.RWMutex
+	policy    *Policy
+	modTime   time.Time
+	loadedAt  time.Time
+	lastError string
+}
+
+// NewPolicyStore loads the policy at path. An empty path yields the built-in defaults.
+func NewPolicyStore(path string) (*PolicyStore, error) {
+	store := &PolicyStore{
+		path:     path,
+		policy:   &Policy{Defaults: DefaultThresholds(), Namespaces: map[string]Thresholds{}},
+		loadedAt: time.Now(),
+	}
+	if path == "" {
+		return store, nil
+	}
+	if _, err := store.reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// newPolicyStoreFromEnv builds the policy store from POLICY_FILE and starts
+// watching it for changes every POLICY_RELOAD_INTERVAL (default: 30s)
+func newPolicyStoreFromEnv() (*PolicyStore, error) {
+	store, err := NewPolicyStore(os.Getenv("POLICY_FILE"))
+	if err != nil {
+		return nil, err
+	}
+
+	if store.path != "" {
+		interval, err := durationFromEnv("POLICY_RELOAD_INTERVAL", 30*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		go store.Watch(context.Background(), interval)
+	}
+
+	return store, nil
+}
+
+// durationFromEnv parses a positive duration from the named environment variable
+func durationFromEnv(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return duration, nil
+}
+
+// reload re-reads the policy file if it changed since the last load.
+// A broken file keeps the previous policy in place.
+func (p *PolicyStore) reload() (bool, error) {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return false, p.recordError(fmt.Errorf("failed to stat policy file: %w", err))
+	}
+
+	p.mu.RLock()
+	unchanged := info.ModTime().Equal(p.modTime)
+	p.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return false, p.recordError(fmt.Errorf("failed to read policy file: %w", err))
+	}
+
+	policy, err := parsePolicy(data)
+	if err != nil {
+		return false, p.recordError(err)
+	}
+
+	p.mu.Lock()
+	p.policy = policy
+	p.modTime = info.ModTime()
+	p.loadedAt = time.Now()
+	p.lastError = ""
+	p.mu.Unlock()
+
+	return true, nil
+}
+
+func (p *PolicyStore) recordError(err error) error {
+	p.mu.Lock()
+	p.lastError = err.Error()
+	p.mu.Unlock()
+	return err
+}
+
+// Watch polls the policy file until ctx is cancelled
+func (p *PolicyStore) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := p.reload()
+			if err != nil {
+				log.Printf("Policy reload failed, keeping previous policy: %v", err)
+			} else if changed {
+				log.Printf("Reloaded policy from %s", p.path)
+			}
+		}
+	}
+}
+
+// Thresholds returns the effective thresholds for a namespace. An empty
+// namespace, or one without overrides, gets the defaults.
+func (p *PolicyStore) Thresholds(namespace string) Thresholds {
+	if p == nil {
+		return DefaultThresholds()
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if thresholds, ok := p.policy.Namespaces[namespace]; ok {
+		return thresholds
+	}
+	return p.policy.Defaults
+}
+
+// PolicyInfo describes the effective policy for the get_policy tool
+type PolicyInfo struct {
+	Source             string                `json:"source"`
+	LoadedAt           time.Time             `json:"loaded_at"`
+	LastReloadError    string                `json:"last_reload_error,omitempty"`
+	Namespace          string                `json:"namespace,omitempty"`
+	Effective          *Thresholds           `json:"effective,omitempty"`
+	Defaults           Thresholds            `json:"defaults"`
+	OverrideNamespaces []string              `json:"override_namespaces"`
+	Namespaces         map[string]Thresholds `json:"namespaces,omitempty"`
+}
+
+// Info returns the policy as seen by the given namespace, or the whole
+// policy when namespace is empty. Overrides for namespaces the access policy
+// denies are left out.
+func (p *PolicyStore) Info(namespace string, access *AccessPolicy) *PolicyInfo {
+	if p == nil {
+		p, _ = NewPolicyStore("")
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	info := &PolicyInfo{
+		Source:             p.path,
+		LoadedAt:           p.loadedAt,
+		LastReloadError:    p.lastError,
+		Defaults:           p.policy.Defaults,
+		OverrideNamespaces: []string{},
+	}
+	if info.Source == "" {
+		info.Source = "built-in defaults"
+	}
+
+	visible := make(map[string]Thresholds)
+	for name, thresholds := range p.policy.Namespaces {
+		if access.allows(name, nil) {
+			visible[name] = thresholds
+			info.OverrideNamespaces = append(info.OverrideNamespaces, name)
+		}
+	}
+	sort.Strings(info.OverrideNamespaces)
+
+	if namespace != "" {
+		effective := p.policy.Defaults
+		if thresholds, ok := p.policy.Namespaces[namespace]; ok {
+			effective = thresholds
+		}
+		info.Namespace = namespace
+		info.Effective = &effective
+	} else if len(visible) > 0 {
+		info.Namespaces = visible
+	}
+
+	return info
+}
+
+// getPolicy is the get_policy tool: the effective thresholds of an allowed
+// namespace, or the policy of every namespace the caller may see
+func (s *K8sDiagnosticsServer) getPolicy(namespace string) (*PolicyInfo, error) {
+	if err := s.access.checkNamespace(namespace); err != nil {
+		return nil, err
+	}
+	return s.policy.Info(namespace, s.access), nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetPolicyHidesDeniedNamespaces(t *testing.T) {
+	policy, err := parsePolicy([]byte(`
+namespaces:
+  kube-system:
+    pod_restart_count: 1
+  team-a:
+    pod_restart_count: 3
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &K8sDiagnosticsServer{
+		policy: &PolicyStore{policy: policy},
+		access: &AccessPolicy{Denied: []string{"kube-*"}},
+	}
+
+	info, err := s.getPolicy("")
+	if err != nil {
+		t.Fatalf("getPolicy: %v", err)
+	}
+	if !reflect.DeepEqual(info.OverrideNamespaces, []string{"team-a"}) {
+		t.Errorf("expected only team-a overrides, got %v", info.OverrideNamespaces)
+	}
+	if _, leaked := info.Namespaces["kube-system"]; leaked || len(info.Namespaces) != 1 {
+		t.Errorf("expected kube-system thresholds to be hidden, got %v", info.Namespaces)
+	}
+
+	var denied *AccessDeniedError
+	if _, err := s.getPolicy("kube-system"); !errors.As(err, &denied) {
+		t.Errorf("expected kube-system to be denied, got %v", err)
+	}
+	if info, err := s.getPolicy("team-a"); err != nil || info.Effective.PodRestartCount != 3 {
+		t.Errorf("expected team-a's override, got %+v, %v", info, err)
+	}
+}
+
+func TestParsePolicyOverlaysDefaults(t *testing.T) {
+	policy, err := parsePolicy([]byte(`
+defaults:
+  pod_restart_count: 8
+  event_window: 1h
+namespaces:
+  team-a:
+    memory_limit_percent: 80
+  team-b: {}
+`))
+	if err != nil {
+		t.Fatalf("parsePolicy: %v", err)
+	}
+
+	want := DefaultThresholds()
+	want.PodRestartCount = 8
+	want.EventWindow.Duration = time.Hour
+	if policy.Defaults != want {
+		t.Errorf("expected defaults %+v, got %+v", want, policy.Defaults)
+	}
+	// Namespace entries inherit the file's defaults, not only the built-in ones
+	want.MemoryLimitPercent = 80
+	if policy.Namespaces["team-a"] != want {
+		t.Errorf("expected team-a %+v, got %+v", want, policy.Namespaces["team-a"])
+	}
+	if policy.Namespaces["team-b"] != policy.Defaults {
+		t.Errorf("expected team-b to equal the defaults, got %+v", policy.Namespaces["team-b"])
+	}
+}
+
+func TestParsePolicyRejectsUnknownFields(t *testing.T) {
+	for name, document := range map[string]string{
+		"top level": "default:\n  pod_restart_count: 1\n",
+		"defaults":  "defaults:\n  pod_restart_limit: 1\n",
+		"namespace": "namespaces:\n  team-a:\n    memory_percent: 80\n",
+	} {
+		if _, err := parsePolicy([]byte(document)); err == nil {
+			t.Errorf("%s: expected an unknown field to be rejected", name)
+		}
+	}
+}
+
+func TestThresholdsValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(*Thresholds)
+	}{
+		{"pod_restart_count", func(t *Thresholds) { t.PodRestartCount = -1 }},
+		{"problem_restart_count", func(t *Thresholds) { t.ProblemRestartCount = -1 }},
+		{"healthy_node_ratio below 0", func(t *Thresholds) { t.HealthyNodeRatio = -0.1 }},
+		{"healthy_node_ratio above 1", func(t *Thresholds) { t.HealthyNodeRatio = 1.1 }},
+		{"problem_pod_count", func(t *Thresholds) { t.ProblemPodCount = -1 }},
+		{"problem_pod_percentage below 0", func(t *Thresholds) { t.ProblemPodPercentage = -1 }},
+		{"problem_pod_percentage above 100", func(t *Thresholds) { t.ProblemPodPercentage = 101 }},
+		{"event_window zero", func(t *Thresholds) { t.EventWindow.Duration = 0 }},
+		{"event_window negative", func(t *Thresholds) { t.EventWindow.Duration = -time.Minute }},
+		{"log_error_count", func(t *Thresholds) { t.LogErrorCount = -1 }},
+		{"log_warning_count", func(t *Thresholds) { t.LogWarningCount = -1 }},
+		{"cpu_throttling_percent below 0", func(t *Thresholds) { t.CPUThrottlingPercent = -1 }},
+		{"cpu_throttling_percent above 100", func(t *Thresholds) { t.CPUThrottlingPercent = 101 }},
+		{"memory_limit_percent below 0", func(t *Thresholds) { t.MemoryLimitPercent = -1 }},
+		{"memory_limit_percent above 100", func(t *Thresholds) { t.MemoryLimitPercent = 101 }},
+		{"quota_warning_percent below 0", func(t *Thresholds) { t.QuotaWarningPercent = -1 }},
+		{"quota_warning_percent above 100", func(t *Thresholds) { t.QuotaWarningPercent = 101 }},
+		{"node_capacity_percent below 0", func(t *Thresholds) { t.NodeCapacityPercent = -1 }},
+		{"node_capacity_percent above 100", func(t *Thresholds) { t.NodeCapacityPercent = 101 }},
+	}
+	if err := DefaultThresholds().validate(); err != nil {
+		t.Fatalf("expected the defaults to be valid: %v", err)
+	}
+	for _, tt := range tests {
+		thresholds := DefaultThresholds()
+		tt.mutate(&thresholds)
+		if err := thresholds.validate(); err == nil {
+			t.Errorf("%s: expected a validation error", tt.name)
+		}
+	}
+
+	// Bounds are inclusive
+	edge := DefaultThresholds()
+	edge.HealthyNodeRatio, edge.ProblemPodPercentage, edge.MemoryLimitPercent, edge.NodeCapacityPercent = 1, 0, 100, 100
+	if err := edge.validate(); err != nil {
+		t.Errorf("expected the bounds themselves to be valid: %v", err)
+	}
+
+	// Overrides are validated after the overlay
+	if _, err := parsePolicy([]byte("namespaces:\n  team-a:\n    healthy_node_ratio: 2\n")); err == nil ||
+		!strings.Contains(err.Error(), "team-a") {
+		t.Errorf("expected the namespace override to be rejected, got %v", err)
+	}
+}
+
+func TestPolicyStoreReloadKeepsPreviousPolicy(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.yaml")
+	write := func(document string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(file, []byte(document), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	start := time.Now().Add(-time.Hour)
+
+	write("defaults:\n  pod_restart_count: 7\n", start)
+	store, err := NewPolicyStore(file)
+	if err != nil {
+		t.Fatalf("NewPolicyStore: %v", err)
+	}
+	if changed, err := store.reload(); changed || err != nil {
+		t.Errorf("expected an unchanged file not to be reloaded, got %v, %v", changed, err)
+	}
+
+	write("defaults:\n  pod_restart_count: [\n", start.Add(time.Minute))
+	if _, err := store.reload(); err == nil {
+		t.Fatal("expected the broken file to fail to reload")
+	}
+	if got := store.Thresholds("").PodRestartCount; got != 7 {
+		t.Errorf("expected the previous policy to stay in effect, got pod_restart_count %d", got)
+	}
+	if info := store.Info("", nil); info.LastReloadError == "" {
+		t.Error("expected last_reload_error to be set")
+	}
+
+	write("defaults:\n  pod_restart_count: 9\n", start.Add(2*time.Minute))
+	if changed, err := store.reload(); !changed || err != nil {
+		t.Fatalf("expected the fixed file to reload, got %v, %v", changed, err)
+	}
+	if got := store.Thresholds("").PodRestartCount; got != 9 {
+		t.Errorf("expected pod_restart_count 9, got %d", got)
+	}
+	if info := store.Info("", nil); info.LastReloadError != "" {
+		t.Errorf("expected a successful reload to clear the error, got %q", info.LastReloadError)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+// throttlingWindow is how far back Prometheus throttling ratios look
+const throttlingWindow = 15 * time.Minute
+
+// Sources of CPU throttling and working set data
+const (
+	PressureSourcePrometheus = "prometheus"
+	PressureSourceKubelet    = "kubelet-cadvisor"
+)
+
+// containerPressure is CPU throttling and memory working set for one container
+type containerPressure struct {
+	ThrottlingPercent *float64
+	WorkingSetBytes   int64
+}
+
+// fetchContainerPressure returns throttling and working set for the containers
+// of the given pods. Prometheus is used when configured; otherwise the
+// cAdvisor endpoint of each node's kubelet is scraped through the API server.
+func (s *K8sDiagnosticsServer) fetchContainerPressure(ctx context.Context, namespace string, pods []corev1.Pod) (map[podUsageKey]map[string]containerPressure, string, error) {
+	if s.prometheus != nil {
+		pressure, err := s.prometheusPressure(ctx, namespace)
+		return pressure, PressureSourcePrometheus, err
+	}
+	pressure, err := s.kubeletPressure(ctx, pods)
+	return pressure, PressureSourceKubelet, err
+}
+
+func (s *K8sDiagnosticsServer) prometheusPressure(ctx context.Context, namespace string) (map[podUsageKey]map[string]containerPressure, error) {
+	pressure := make(map[podUsageKey]map[string]containerPressure)
+	update := func(labels map[string]string, set func(*containerPressure)) {
+		key := podUsageKey{Namespace: labels["namespace"], Name: labels["pod"]}
+		if pressure[key] == nil {
+			pressure[key] = make(map[string]containerPressure)
+		}
+		p := pressure[key][labels["container"]]
+		set(&p)
+		pressure[key][labels["container"]] = p
+	}
+
+	selector := promSelector(namespace, nil)
+	for _, name := range []string{"cpu_throttling", "memory_working_set"} {
+		template, err := findMetricTemplate(name)
+		if err != nil {
+			return nil, err
+		}
+		samples, err := s.prometheus.Query(ctx, template.render(selector, throttlingWindow))
+		if err != nil {
+			return nil, err
+		}
+		for _, sample := range samples {
+			if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) {
+				continue
+			}
+			value := sample.Value
+			update(sample.Labels, func(p *containerPressure) {
+				if name == "cpu_throttling" {
+					pct := math.Round(value*1000) / 10
+					p.ThrottlingPercent = &pct
+				} else {
+					p.WorkingSetBytes = int64(value)
+				}
+			})
+		}
+	}
+
+	return pressure, nil
+}
+
+// cAdvisor series used for pressure detection
+const (
+	cadvisorThrottledPeriods = "container_cpu_cfs_throttled_periods_total"
+	cadvisorPeriods          = "container_cpu_cfs_periods_total"
+	cadvisorWorkingSet       = "container_memory_working_set_bytes"
+)
+
+// kubeletPressure scrapes /metrics/cadvisor on every node running one of the
+// pods. The CFS counters are cumulative, so the throttling ratio covers the
+// container's lifetime. Requires get on nodes/proxy.
+func (s *K8sDiagnosticsServer) kubeletPressure(ctx context.Context, pods []corev1.Pod) (map[podUsageKey]map[string]containerPressure, error) {
+	restClient, ok := s.clientset.CoreV1().RESTClient().(*rest.RESTClient)
+	if !ok || restClient == nil {
+		return nil, fmt.Errorf("kubelet proxy not available")
+	}
+
+	wanted := make(map[podUsageKey]bool, len(pods))
+	nodes := make(map[string]bool)
+	for _, pod := range pods {
+		if pod.Spec.NodeName != "" {
+			wanted[podUsageKey{Namespace: pod.Namespace, Name: pod.Name}] = true
+			nodes[pod.Spec.NodeName] = true
+		}
+	}
+
+	type counters struct {
+		throttled, periods float64
+		workingSet         int64
+	}
+	collected := make(map[podUsageKey]map[string]*counters)
+	var failed []string
+
+	for node := range nodes {
+		body, err := restClient.Get().AbsPath("/api/v1/nodes", node, "proxy/metrics/cadvisor").DoRaw(ctx)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", node, err))
+			continue
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			name, labels, value, ok := parseExpositionLine(scanner.Text())
+			if !ok || (name != cadvisorThrottledPeriods && name != cadvisorPeriods && name != cadvisorWorkingSet) {
+				continue
+			}
+			container := labels["container"]
+			key := podUsageKey{Namespace: labels["namespace"], Name: labels["pod"]}
+			if container == "" || container == "POD" || !wanted[key] {
+				continue
+			}
+			if collected[key] == nil {
+				collected[key] = make(map[string]*counters)
+			}
+			c := collected[key][container]
+			if c == nil {
+				c = &counters{}
+				collected[key][container] = c
+			}
+			switch name {
+			case cadvisorThrottledPeriods:
+				c.throttled = value
+			case cadvisorPeriods:
+				c.periods = value
+			case cadvisorWorkingSet:
+				c.workingSet = int64(value)
+			}
+		}
+	}
+
+	if len(failed) > 0 && len(collected) == 0 {
+		return nil, fmt.Errorf("failed to scrape kubelet cAdvisor metrics (needs get on nodes/proxy): %s", strings.Join(failed, "; "))
+	}
+
+	pressure := make(map[podUsageKey]map[string]containerPressure, len(collected))
+	for key, containers := range collected {
+		pressure[key] = make(map[string]containerPressure, len(containers))
+		for name, c := range containers {
+			p := containerPressure{WorkingSetBytes: c.workingSet}
+			if c.periods > 0 {
+				pct := math.Round(c.throttled/c.periods*1000) / 10
+				p.ThrottlingPercent = &pct
+			}
+			pressure[key][name] = p
+		}
+	}
+	return pressure, nil
+}
+
+// parseExpositionLine parses one sample line of the Prometheus text format
+func parseExpositionLine(line string) (string, map[string]string, float64, bool) {
+	if line == "" || line[0] == '#' {
+		return "", nil, 0, false
+	}
+
+	labels := make(map[string]string)
+	var name, rest string
+	if i := strings.IndexAny(line, "{ "); i < 0 {
+		return "", nil, 0, false
+	} else if line[i] == ' ' {
+		name, rest = line[:i], line[i:]
+	} else {
+		name = line[:i]
+		end, ok := parseLabels(line[i+1:], labels)
+		if !ok {
+			return "", nil, 0, false
+		}
+		rest = line[i+1+end:]
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return "", nil, 0, false
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return "", nil, 0, false
+	}
+	return name, labels, value, true
+}
+
+// parseLabels reads `key="value",...}` and returns the index after the closing brace
+func parseLabels(s string, labels map[string]string) (int, bool) {
+	i := 0
+	for {
+		for i < len(s) && (s[i] == ',' || s[i] == ' ') {
+			i++
+		}
+		if i >= len(s) {
+			return 0, false
+		}
+		if s[i] == '}' {
+			return i + 1, true
+		}
+
+		eq := strings.IndexByte(s[i:], '=')
+		if eq < 0 || i+eq+1 >= len(s) || s[i+eq+1] != '"' {
+			return 0, false
+		}
+		key := strings.TrimSpace(s[i : i+eq])
+		i += eq + 2
+
+		var value strings.Builder
+		for {
+			if i >= len(s) {
+				return 0, false
+			}
+			c := s[i]
+			if c == '"' {
+				i++
+				break
+			}
+			if c == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(s[i])
+				}
+			} else {
+				value.WriteByte(c)
+			}
+			i++
+		}
+		labels[key] = value.String()
+	}
+}
+
+// containerResourceFindings explains why a container is flagged, with the numbers behind it
+func containerResourceFindings(object ObjectRef, container corev1.Container, status *corev1.ContainerStatus, info ContainerResourceInfo, thresholds Thresholds) []Finding {
+	var findings []Finding
+
+	if container.Resources.Requests == nil && container.Resources.Limits == nil {
+		findings = append(findings, newFinding(findingNoResources, object,
+			fmt.Sprintf("Container %s has no CPU or memory requests or limits: the scheduler cannot place the pod reliably and it is evicted first under node pressure", container.Name), nil))
+	}
+
+	if info.CPUThrottlingPercent != nil && *info.CPUThrottlingPercent >= thresholds.CPUThrottlingPercent {
+		message := fmt.Sprintf("Container %s is CPU throttled in %.1f%% of CFS periods", container.Name, *info.CPUThrottlingPercent)
+		evidence := map[string]string{"throttling_percent": fmt.Sprintf("%.1f", *info.CPUThrottlingPercent)}
+		if info.CPULimitMillis > 0 {
+			message += fmt.Sprintf(" with a %s limit", formatMillis(info.CPULimitMillis))
+			evidence["cpu_limit"] = formatMillis(info.CPULimitMillis)
+			if info.CPUUsageMillis > 0 {
+				message += fmt.Sprintf(" (current usage %s)", formatMillis(info.CPUUsageMillis))
+				evidence["cpu_usage"] = formatMillis(info.CPUUsageMillis)
+			}
+		}
+		findings = append(findings, newFinding(findingCPUThrottled, object,
+			message+": latency suffers even when average usage looks low", evidence))
+	}
+
+	if info.MemoryLimitBytes > 0 && info.MemoryUsageBytes > 0 {
+		pct := float64(info.MemoryUsageBytes) / float64(info.MemoryLimitBytes) * 100
+		if pct >= thresholds.MemoryLimitPercent {
+			findings = append(findings, newFinding(findingMemoryNearLimit, object,
+				fmt.Sprintf("Container %s memory working set %s is %.1f%% of the %s limit: the container is OOM killed when it reaches the limit",
+					container.Name, formatBytes(info.MemoryUsageBytes), pct, formatBytes(info.MemoryLimitBytes)),
+				map[string]string{
+					"limit_percent": fmt.Sprintf("%.1f", pct),
+					"memory_usage":  formatBytes(info.MemoryUsageBytes),
+					"memory_limit":  formatBytes(info.MemoryLimitBytes),
+				}))
+		}
+	}
+
+	if status != nil && oomKilled(*status) {
+		terminated := status.LastTerminationState.Terminated
+		message := fmt.Sprintf("Container %s last terminated with OOMKilled (exit code %d)", container.Name, terminated.ExitCode)
+		evidence := map[string]string{
+			"exit_code":     fmt.Sprintf("%d", terminated.ExitCode),
+			"restart_count": fmt.Sprintf("%d", status.RestartCount),
+		}
+		if !terminated.FinishedAt.IsZero() {
+			message += " at " + terminated.FinishedAt.UTC().Format(time.RFC3339)
+			evidence["finished_at"] = terminated.FinishedAt.UTC().Format(time.RFC3339)
+		}
+		if info.MemoryLimitBytes > 0 {
+			evidence["memory_limit"] = formatBytes(info.MemoryLimitBytes)
+		}
+		findings = append(findings, newFinding(findingOOMKilled, object,
+			message+fmt.Sprintf(" after %d restart(s)", status.RestartCount), evidence))
+	}
+
+	return findings
+}
+
+// oomKilled reports whether a container was last terminated by the OOM killer
+func oomKilled(status corev1.ContainerStatus) bool {
+	return status.LastTerminationState.Terminated != nil &&
+		status.LastTerminationState.Terminated.Reason == "OOMKilled"
+}
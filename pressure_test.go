@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestContainerResourceFindings(t *testing.T) {
+	ref := ObjectRef{Kind: "Pod", Namespace: "shop", Name: "web", Container: "app"}
+	container := corev1.Container{Name: "app", Resources: testResources("100m", "128Mi", "200m", "256Mi")}
+	throttling := 38.5
+	info := ContainerResourceInfo{
+		ResourceAmounts: ResourceAmounts{
+			CPULimitMillis:   200,
+			CPUUsageMillis:   150,
+			MemoryLimitBytes: 256 << 20,
+			MemoryUsageBytes: 240 << 20,
+		},
+		CPUThrottlingPercent: &throttling,
+	}
+	status := &corev1.ContainerStatus{Name: "app", RestartCount: 3, LastTerminationState: corev1.ContainerState{
+		Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137},
+	}}
+
+	findings := containerResourceFindings(ref, container, status, info, DefaultThresholds())
+	byID := map[string]Finding{}
+	for _, finding := range findings {
+		byID[finding.ID] = finding
+	}
+	if len(findings) != 3 {
+		t.Fatalf("expected throttling, memory and OOM findings, got %+v", findings)
+	}
+	if e := byID["container-cpu-throttled"].Evidence; e["throttling_percent"] != "38.5" || e["cpu_limit"] != "200m" {
+		t.Errorf("expected the throttling ratio and CPU limit as evidence, got %v", e)
+	}
+	if e := byID["container-memory-near-limit"].Evidence; e["limit_percent"] != "93.8" || e["memory_limit"] != formatBytes(256<<20) {
+		t.Errorf("expected the memory ratio and limit as evidence, got %v", e)
+	}
+	if f := byID["container-oom-killed"]; f.Severity != SeverityCritical || f.Evidence["exit_code"] != "137" || f.Object != ref {
+		t.Errorf("expected a critical OOM finding on the container, got %+v", f)
+	}
+
+	// Below the thresholds nothing is flagged
+	throttling = 1
+	info.MemoryUsageBytes = 64 << 20
+	if findings := containerResourceFindings(ref, container, nil, info, DefaultThresholds()); len(findings) != 0 {
+		t.Errorf("expected no findings, got %+v", findings)
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Probe types as named in kubelet events
+const (
+	ProbeLiveness  = "liveness"
+	ProbeReadiness = "readiness"
+	ProbeStartup   = "startup"
+)
+
+// slowStartThreshold is the observed startup time above which a container should have a startupProbe
+const slowStartThreshold = 30 * time.Second
+
+// Kubelet defaults for unset probe fields
+const (
+	defaultProbePeriodSeconds    = 10
+	defaultProbeFailureThreshold = 3
+)
+
+// ProbeReport describes one probe of a container and how it has been failing
+type ProbeReport struct {
+	Container string `json:"container"`
+	Probe     string `json:"probe"`
+	Handler   string `json:"handler"`
+	// FailureBudgetSeconds is how long the container can be unhealthy before the probe fails:
+	// initialDelaySeconds + failureThreshold * periodSeconds
+	FailureBudgetSeconds int32   `json:"failure_budget_seconds"`
+	StartupSeconds       float64 `json:"observed_startup_seconds,omitempty"`
+	Failures             int32   `json:"failures,omitempty"`
+	Warnings             int32   `json:"warnings,omitempty"`
+	LastMessage          string  `json:"last_message,omitempty"`
+	Cause                string  `json:"cause,omitempty"`
+}
+
+// probeEventPattern splits "Liveness probe failed: ..." and "Readiness probe warning: ..." messages
+var probeEventPattern = regexp.MustCompile(`^(Liveness|Readiness|Startup) probe (failed|warning|errored): ?(.*)$`)
+
+var probeStatusCodePattern = regexp.MustCompile(`statuscode: (\d+)`)
+
+// probeFailureCause summarizes why a probe failed from the kubelet message
+func probeFailureCause(message string) string {
+	lower := strings.ToLower(message)
+	switch {
+	case strings.Contains(lower, "connection refused"):
+		return "connection refused: nothing listens on the probe port"
+	case strings.Contains(lower, "timeout") || strings.Contains(lower, "deadline exceeded"):
+		return "timed out: the endpoint is slower than timeoutSeconds"
+	case probeStatusCodePattern.MatchString(lower):
+		return "HTTP status " + probeStatusCodePattern.FindStringSubmatch(lower)[1]
+	case strings.Contains(lower, "no such host") || strings.Contains(lower, "no route to host"):
+		return "the probe host is unreachable"
+	case strings.Contains(lower, "exit code") || strings.Contains(lower, "command"):
+		return "the exec command failed"
+	}
+	return ""
+}
+
+// containerProbes returns a container's probes by type, skipping unset ones
+func containerProbes(container corev1.Container) map[string]*corev1.Probe {
+	probes := map[string]*corev1.Probe{}
+	if container.LivenessProbe != nil {
+		probes[ProbeLiveness] = container.LivenessProbe
+	}
+	if container.ReadinessProbe != nil {
+		probes[ProbeReadiness] = container.ReadinessProbe
+	}
+	if container.StartupProbe != nil {
+		probes[ProbeStartup] = container.StartupProbe
+	}
+	return probes
+}
+
+// probeHandler describes what a probe checks, e.g. "GET :8080/healthz"
+func probeHandler(probe *corev1.Probe) string {
+	switch {
+	case probe.HTTPGet != nil:
+		return fmt.Sprintf("GET %s:%s%s", probe.HTTPGet.Host, probe.HTTPGet.Port.String(), probe.HTTPGet.Path)
+	case probe.TCPSocket != nil:
+		return fmt.Sprintf("tcp %s:%s", probe.TCPSocket.Host, probe.TCPSocket.Port.String())
+	case probe.GRPC != nil:
+		service := ""
+		if probe.GRPC.Service != nil {
+			service = "/" + *probe.GRPC.Service
+		}
+		return fmt.Sprintf("grpc :%d%s", probe.GRPC.Port, service)
+	case probe.Exec != nil:
+		return "exec " + strings.Join(probe.Exec.Command, " ")
+	}
+	return "none"
+}
+
+// probePort returns the port a network probe connects to
+func probePort(probe *corev1.Probe) (intstr.IntOrString, bool) {
+	switch {
+	case probe.HTTPGet != nil:
+		return probe.HTTPGet.Port, true
+	case probe.TCPSocket != nil:
+		return probe.TCPSocket.Port, true
+	case probe.GRPC != nil:
+		return intstr.FromInt32(probe.GRPC.Port), true
+	}
+	return intstr.IntOrString{}, false
+}
+
+// probeBudget is the time from container start until a probe that never succeeds fails
+func probeBudget(probe *corev1.Probe) int32 {
+	period, failures := probe.PeriodSeconds, probe.FailureThreshold
+	if period == 0 {
+		period = defaultProbePeriodSeconds
+	}
+	if failures == 0 {
+		failures = defaultProbeFailureThreshold
+	}
+	return probe.InitialDelaySeconds + failures*period
+}
+
+// lintProbes checks a container's probe configuration: ports that aren't declared
+// and liveness probes that hit the same endpoint as readiness
+func lintProbes(container corev1.Container, ref ObjectRef) []Finding {
+	var findings []Finding
+	probes := containerProbes(container)
+
+	for _, probeType := range []string{ProbeLiveness, ProbeReadiness, ProbeStartup} {
+		probe, ok := probes[probeType]
+		if !ok {
+			continue
+		}
+		port, ok := probePort(probe)
+		if !ok {
+			continue
+		}
+		evidence := map[string]string{"probe": probeType, "port": port.String()}
+		found, declares := false, len(container.Ports) > 0
+		for _, containerPort := range container.Ports {
+			if port.Type == intstr.String && containerPort.Name == port.StrVal ||
+				port.Type == intstr.Int && containerPort.ContainerPort == port.IntVal {
+				found = true
+			}
+		}
+		switch {
+		case found:
+		case port.Type == intstr.String:
+			findings = append(findings, newFinding(findingProbePortUnknown, ref,
+				fmt.Sprintf("Container %s %s probe uses port name %q, which the container does not declare: the probe always fails",
+					container.Name, probeType, port.StrVal), evidence))
+		case declares:
+			findings = append(findings, newFinding(findingProbePortUndeclared, ref,
+				fmt.Sprintf("Container %s %s probe checks port %d, which is not one of the container's ports",
+					container.Name, probeType, port.IntVal), evidence))
+		}
+	}
+
+	liveness, hasLiveness := probes[ProbeLiveness]
+	readiness, hasReadiness := probes[ProbeReadiness]
+	if hasLiveness && hasReadiness && probeHandler(liveness) == probeHandler(readiness) {
+		findings = append(findings, newFinding(findingProbeSharedEndpoint, ref,
+			fmt.Sprintf("Container %s liveness and readiness probes both check %s: a dependency outage fails both and restarts healthy containers",
+				container.Name, probeHandler(liveness)),
+			map[string]string{"handler": probeHandler(liveness)}))
+	}
+
+	return findings
+}
+
+// lintStartup compares probe timing with how long a container was observed to take to become ready
+func lintStartup(container corev1.Container, startup time.Duration, ref ObjectRef) []Finding {
+	var findings []Finding
+	evidence := map[string]string{"observed_startup_seconds": fmt.Sprintf("%.0f", startup.Seconds())}
+
+	if container.StartupProbe != nil {
+		if budget := probeBudget(container.StartupProbe); time.Duration(budget)*time.Second < startup {
+			evidence["failure_budget_seconds"] = fmt.Sprint(budget)
+			findings = append(findings, newFinding(findingProbeTooEarly, ref,
+				fmt.Sprintf("Container %s took %.0fs to become ready but its startup probe gives up after %ds",
+					container.Name, startup.Seconds(), budget), evidence))
+		}
+		// Liveness only starts once the startup probe succeeds
+		return findings
+	}
+
+	if container.LivenessProbe != nil {
+		if budget := probeBudget(container.LivenessProbe); time.Duration(budget)*time.Second < startup {
+			evidence["failure_budget_seconds"] = fmt.Sprint(budget)
+			findings = append(findings, newFinding(findingProbeTooEarly, ref,
+				fmt.Sprintf("Container %s took %.0fs to become ready but its liveness probe fails after %ds (initialDelaySeconds + failureThreshold x periodSeconds): slow starts get restarted",
+					container.Name, startup.Seconds(), budget), evidence))
+		}
+	}
+
+	if startup > slowStartThreshold {
+		findings = append(findings, newFinding(findingProbeNoStartupProbe, ref,
+			fmt.Sprintf("Container %s took %.0fs to become ready and has no startup probe", container.Name, startup.Seconds()),
+			map[string]string{"observed_startup_seconds": evidence["observed_startup_seconds"]}))
+	}
+
+	return findings
+}
+
+// observedStartup is the time between a container's start and the pod becoming ready.
+// It is only known for containers that are running and ready since their last start.
+func observedStartup(pod *corev1.Pod, status corev1.ContainerStatus) (time.Duration, bool) {
+	if !status.Ready || status.State.Running == nil {
+		return 0, false
+	}
+	started := status.State.Running.StartedAt.Time
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.ContainersReady && condition.Status == corev1.ConditionTrue &&
+			!condition.LastTransitionTime.Time.Before(started) {
+			return condition.LastTransitionTime.Time.Sub(started), true
+		}
+	}
+	return 0, false
+}
+
+// podProbes reports every probe of a pod with its failures from Unhealthy and
+// ProbeWarning events, and lints probe ports, endpoints and timing
+func (s *K8sDiagnosticsServer) podProbes(ctx context.Context, pod *corev1.Pod) ([]ProbeReport, []Finding) {
+	reports := []ProbeReport{}
+	findings := []Finding{}
+	index := map[string]int{}
+
+	startups := map[string]time.Duration{}
+	for _, status := range pod.Status.ContainerStatuses {
+		if startup, ok := observedStartup(pod, status); ok {
+			startups[status.Name] = startup
+		}
+	}
+
+	for _, container := range pod.Spec.Containers {
+		ref := ObjectRef{Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name, Container: container.Name}
+		probes := containerProbes(container)
+		for _, probeType := range []string{ProbeLiveness, ProbeReadiness, ProbeStartup} {
+			probe, ok := probes[probeType]
+			if !ok {
+				continue
+			}
+			index[container.Name+"/"+probeType] = len(reports)
+			reports = append(reports, ProbeReport{
+				Container:            container.Name,
+				Probe:                probeType,
+				Handler:              probeHandler(probe),
+				FailureBudgetSeconds: probeBudget(probe),
+				StartupSeconds:       startups[container.Name].Round(time.Second).Seconds(),
+			})
+		}
+		findings = append(findings, lintProbes(container, ref)...)
+		if startup, ok := startups[container.Name]; ok {
+			findings = append(findings, lintStartup(container, startup, ref)...)
+		}
+	}
+
+	// Kubelet reports probe failures as Unhealthy and ProbeWarning events on the pod
+	for _, event := range s.recentEvents(ctx, pod.Namespace, "Pod", pod.Name) {
+		if event.Reason != "Unhealthy" && event.Reason != "ProbeWarning" {
+			continue
+		}
+		match := probeEventPattern.FindStringSubmatch(event.Message)
+		if match == nil {
+			continue
+		}
+		container := strings.TrimSuffix(strings.TrimPrefix(event.InvolvedObject.FieldPath, "spec.containers{"), "}")
+		i, ok := index[container+"/"+strings.ToLower(match[1])]
+		if !ok {
+			continue
+		}
+		count := event.Count
+		if count == 0 {
+			count = 1
+		}
+		if match[2] == "warning" {
+			reports[i].Warnings += count
+		} else {
+			reports[i].Failures += count
+		}
+		reports[i].LastMessage = match[3]
+		if cause := probeFailureCause(match[3]); cause != "" {
+			reports[i].Cause = cause
+		}
+	}
+
+	for _, report := range reports {
+		ref := ObjectRef{Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name, Container: report.Container}
+		evidence := map[string]string{"handler": report.Handler, "count": fmt.Sprint(report.Failures)}
+		if report.Cause != "" {
+			evidence["cause"] = report.Cause
+		}
+		if report.LastMessage != "" {
+			evidence["message"] = report.LastMessage
+		}
+		if report.Failures > 0 {
+			findings = append(findings, newFinding(probeFailureDefs[report.Probe], ref,
+				fmt.Sprintf("Container %s %s probe (%s) failed %d times: %s", report.Container, report.Probe, report.Handler, report.Failures, report.LastMessage),
+				evidence))
+		} else if report.Warnings > 0 {
+			evidence["count"] = fmt.Sprint(report.Warnings)
+			findings = append(findings, newFinding(findingProbeWarning, ref,
+				fmt.Sprintf("Container %s %s probe (%s) reported %d warnings: %s", report.Container, report.Probe, report.Handler, report.Warnings, report.LastMessage),
+				evidence))
+		}
+	}
+
+	return reports, findings
+}
+
+var probeFailureDefs = map[string]findingDef{
+	ProbeLiveness:  findingLivenessFailing,
+	ProbeReadiness: findingReadinessFailing,
+	ProbeStartup:   findingStartupFailing,
+}
+
+// deploymentProbeFindings lints the probes of a deployment's containers, using the
+// slowest observed startup among its pods for the timing checks
+func (s *K8sDiagnosticsServer) deploymentProbeFindings(ctx context.Context, deployment *appsv1.Deployment) []Finding {
+	var findings []Finding
+
+	slowest := map[string]time.Duration{}
+	if selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector); err == nil {
+		pods, err := s.clientset.CoreV1().Pods(deployment.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			noteOmitted(ctx, fmt.Sprintf("Observed startup times of deployment %s", deployment.Name), err)
+		} else {
+			visible := s.access.filterPods(pods.Items)
+			for i := range visible {
+				for _, status := range visible[i].Status.ContainerStatuses {
+					if startup, ok := observedStartup(&visible[i], status); ok && startup > slowest[status.Name] {
+						slowest[status.Name] = startup
+					}
+				}
+			}
+		}
+	}
+
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		ref := ObjectRef{Kind: "Deployment", Namespace: deployment.Namespace, Name: deployment.Name, Container: container.Name}
+		findings = append(findings, lintProbes(container, ref)...)
+		if startup, ok := slowest[container.Name]; ok {
+			findings = append(findings, lintStartup(container, startup, ref)...)
+		}
+	}
+
+	return findings
+}
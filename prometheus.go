@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PrometheusClient is a minimal client for the Prometheus HTTP query API.
+// It works with any compatible endpoint (Prometheus, Thanos Query, Mimir).
+type PrometheusClient struct {
+	baseURL   string
+	client    *http.Client
+	tokenFile string
+	headers   http.Header
+}
+
+// promSample is one series of an instant query result
+type promSample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// newPrometheusClientFromEnv returns a client for PROMETHEUS_URL, or nil if it is not set
+func newPrometheusClientFromEnv() (*PrometheusClient, error) {
+	baseURL := os.Getenv("PROMETHEUS_URL")
+	if baseURL == "" {
+		return nil, nil
+	}
+	if _, err := url.ParseRequestURI(baseURL); err != nil {
+		return nil, fmt.Errorf("invalid PROMETHEUS_URL: %w", err)
+	}
+
+	timeout, err := durationFromEnv("PROMETHEUS_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	// Extra headers, e.g. X-Scope-OrgID for multi-tenant Thanos/Mimir
+	headers := make(http.Header)
+	if raw := os.Getenv("PROMETHEUS_HEADERS"); raw != "" {
+		for _, pair := range strings.Split(raw, ",") {
+			name, value, ok := strings.Cut(pair, "=")
+			if !ok || strings.TrimSpace(name) == "" {
+				return nil, fmt.Errorf("invalid PROMETHEUS_HEADERS entry %q (expected Name=value)", pair)
+			}
+			headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+	}
+
+	return &PrometheusClient{
+		baseURL:   strings.TrimRight(baseURL, "/"),
+		client:    &http.Client{Timeout: timeout},
+		tokenFile: os.Getenv("PROMETHEUS_BEARER_TOKEN_FILE"),
+		headers:   headers,
+	}, nil
+}
+
+// Query runs an instant query and returns the resulting vector. A scalar
+// result is returned as a single unlabeled sample.
+func (c *PrometheusClient) Query(ctx context.Context, query string) ([]promSample, error) {
+	params := url.Values{"query": {query}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/v1/query",
+		strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for name, values := range c.headers {
+		req.Header[name] = values
+	}
+	// Read the token on every request so rotated service account tokens are picked up
+	if c.tokenFile != "" {
+		token, err := os.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PROMETHEUS_BEARER_TOKEN_FILE: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("prometheus query failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prometheus response: %w", err)
+	}
+
+	var result struct {
+		Status    string `json:"status"`
+		ErrorType string `json:"errorType"`
+		Error     string `json:"error"`
+		Data      struct {
+			ResultType string          `json:"resultType"`
+			Result     json.RawMessage `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("invalid prometheus response (HTTP %d): %w", resp.StatusCode, err)
+	}
+	if result.Status != "success" {
+		return nil, fmt.Errorf("prometheus query failed: %s: %s", result.ErrorType, result.Error)
+	}
+
+	switch result.Data.ResultType {
+	case "vector":
+		var vector []struct {
+			Metric map[string]string `json:"metric"`
+			Value  [2]interface{}    `json:"value"`
+		}
+		if err := json.Unmarshal(result.Data.Result, &vector); err != nil {
+			return nil, fmt.Errorf("invalid prometheus vector: %w", err)
+		}
+		samples := make([]promSample, 0, len(vector))
+		for _, v := range vector {
+			value, err := parsePromValue(v.Value[1])
+			if err != nil {
+				return nil, err
+			}
+			samples = append(samples, promSample{Labels: v.Metric, Value: value})
+		}
+		return samples, nil
+	case "scalar":
+		var scalar [2]interface{}
+		if err := json.Unmarshal(result.Data.Result, &scalar); err != nil {
+			return nil, fmt.Errorf("invalid prometheus scalar: %w", err)
+		}
+		value, err := parsePromValue(scalar[1])
+		if err != nil {
+			return nil, err
+		}
+		return []promSample{{Labels: map[string]string{}, Value: value}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported prometheus result type %q", result.Data.ResultType)
+	}
+}
+
+// QueryScalar runs a query expected to return at most one series. ok is
+// false when the result is empty.
+func (c *PrometheusClient) QueryScalar(ctx context.Context, query string) (value float64, ok bool, err error) {
+	samples, err := c.Query(ctx, query)
+	if err != nil || len(samples) == 0 {
+		return 0, false, err
+	}
+	return samples[0].Value, true, nil
+}
+
+func parsePromValue(raw interface{}) (float64, error) {
+	s, ok := raw.(string)
+	if !ok {
+		return 0, fmt.Errorf("invalid prometheus sample value %v", raw)
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// promDuration renders a duration in Prometheus range syntax
+func promDuration(d time.Duration) string {
+	if d%time.Hour == 0 {
+		return fmt.Sprintf("%dh", int64(d/time.Hour))
+	}
+	if d%time.Minute == 0 {
+		return fmt.Sprintf("%dm", int64(d/time.Minute))
+	}
+	return fmt.Sprintf("%ds", int64(d/time.Second))
+}
+
+// promQuote quotes a label value for use in a selector
+func promQuote(value string) string {
+	return strconv.Quote(value)
+}
+
+// promSelector builds label matchers for the containers of some pods in a
+// namespace. No pods means every pod in the namespace; no namespace means
+// every namespace.
+func promSelector(namespace string, pods []string) string {
+	selector := `namespace!=""`
+	if namespace != "" {
+		selector = "namespace=" + promQuote(namespace)
+	}
+	if len(pods) > 0 {
+		quoted := make([]string, len(pods))
+		for i, pod := range pods {
+			quoted[i] = regexp.QuoteMeta(pod)
+		}
+		selector += ",pod=~" + promQuote(strings.Join(quoted, "|"))
+	}
+	return selector
+}
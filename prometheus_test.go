@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// promStub is a local stand-in for the Prometheus query API. It records each
+// request and answers with the body returned by respond.
+type promStub struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	queries  []string
+	requests []*http.Request
+}
+
+func newPromStub(t *testing.T, respond func(query string) string) *promStub {
+	t.Helper()
+	stub := &promStub{}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" {
+			http.NotFound(w, r)
+			return
+		}
+		query := r.FormValue("query")
+		stub.mu.Lock()
+		stub.queries = append(stub.queries, query)
+		stub.requests = append(stub.requests, r)
+		stub.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(respond(query)))
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func (p *promStub) client() *PrometheusClient {
+	return &PrometheusClient{baseURL: p.URL, client: p.Client(), headers: http.Header{}}
+}
+
+const emptyVector = `{"status":"success","data":{"resultType":"vector","result":[]}}`
+
+func TestPromSelector(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace string
+		pods      []string
+		want      string
+	}{
+		{"all namespaces", "", nil, `namespace!=""`},
+		{"one namespace", "shop", nil, `namespace="shop"`},
+		{"quotes in the namespace", `sh"op`, nil, `namespace="sh\"op"`},
+		{"one pod", "shop", []string{"web-1"}, `namespace="shop",pod=~"web-1"`},
+		{"regexp characters in pod names", "shop", []string{"web.1", "api+2"}, `namespace="shop",pod=~"web\\.1|api\\+2"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := promSelector(tt.namespace, tt.pods); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPrometheusQueryDecoding(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []promSample
+		wantErr string
+	}{
+		{
+			name: "vector",
+			body: `{"status":"success","data":{"resultType":"vector","result":[` +
+				`{"metric":{"pod":"web-1","container":"app"},"value":[1700000000.1,"0.25"]},` +
+				`{"metric":{"pod":"web-2","container":"app"},"value":[1700000000.1,"NaN"]}]}}`,
+			want: []promSample{
+				{Labels: map[string]string{"pod": "web-1", "container": "app"}, Value: 0.25},
+				{Labels: map[string]string{"pod": "web-2", "container": "app"}, Value: math.NaN()},
+			},
+		},
+		{
+			name: "empty vector",
+			body: emptyVector,
+			want: []promSample{},
+		},
+		{
+			name: "scalar",
+			body: `{"status":"success","data":{"resultType":"scalar","result":[1700000000.1,"42"]}}`,
+			want: []promSample{{Labels: map[string]string{}, Value: 42}},
+		},
+		{
+			name:    "error status",
+			body:    `{"status":"error","errorType":"bad_data","error":"parse error at char 5"}`,
+			wantErr: "bad_data: parse error at char 5",
+		},
+		{
+			name:    "matrix",
+			body:    `{"status":"success","data":{"resultType":"matrix","result":[]}}`,
+			wantErr: `unsupported prometheus result type "matrix"`,
+		},
+		{
+			name:    "not json",
+			body:    `<html>bad gateway</html>`,
+			wantErr: "invalid prometheus response",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newPromStub(t, func(string) string { return tt.body })
+			samples, err := stub.client().Query(context.Background(), "up")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+			if len(samples) != len(tt.want) {
+				t.Fatalf("expected %d samples, got %+v", len(tt.want), samples)
+			}
+			for i, want := range tt.want {
+				got := samples[i]
+				if got.Value != want.Value && !(math.IsNaN(got.Value) && math.IsNaN(want.Value)) {
+					t.Errorf("sample %d: expected value %v, got %v", i, want.Value, got.Value)
+				}
+				for name, value := range want.Labels {
+					if got.Labels[name] != value {
+						t.Errorf("sample %d: expected %s=%s, got %v", i, name, value, got.Labels)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPrometheusClientFromEnv(t *testing.T) {
+	stub := newPromStub(t, func(string) string { return emptyVector })
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("first-token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PROMETHEUS_URL", stub.URL+"/")
+	t.Setenv("PROMETHEUS_HEADERS", "X-Scope-OrgID=tenant-a, X-Team = payments")
+	t.Setenv("PROMETHEUS_BEARER_TOKEN_FILE", tokenFile)
+
+	client, err := newPrometheusClientFromEnv()
+	if err != nil {
+		t.Fatalf("newPrometheusClientFromEnv: %v", err)
+	}
+	if _, err := client.Query(context.Background(), "up"); err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	// The token file is re-read on every query so rotated tokens are picked up
+	if err := os.WriteFile(tokenFile, []byte("second-token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Query(context.Background(), "up"); err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+
+	for i, wantToken := range []string{"first-token", "second-token"} {
+		header := stub.requests[i].Header
+		if got := header.Get("Authorization"); got != "Bearer "+wantToken {
+			t.Errorf("request %d: expected bearer %s, got %q", i, wantToken, got)
+		}
+		if header.Get("X-Scope-OrgID") != "tenant-a" || header.Get("X-Team") != "payments" {
+			t.Errorf("request %d: expected PROMETHEUS_HEADERS to be sent, got %v", i, header)
+		}
+	}
+
+	if err := os.Remove(tokenFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Query(context.Background(), "up"); err == nil || !strings.Contains(err.Error(), "PROMETHEUS_BEARER_TOKEN_FILE") {
+		t.Errorf("expected a missing token file to fail the query, got %v", err)
+	}
+
+	t.Setenv("PROMETHEUS_HEADERS", "X-Scope-OrgID")
+	if _, err := newPrometheusClientFromEnv(); err == nil {
+		t.Error("expected a header without a value separator to be rejected")
+	}
+}
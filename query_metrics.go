@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// metricTemplate is a curated PromQL query. $SELECTOR is replaced with the
+// namespace/pod label matchers and $WINDOW with the lookback window.
+type metricTemplate struct {
+	Name        string
+	Description string
+	Unit        string
+	Query       string
+}
+
+// Curated templates, based on cAdvisor, kube-state-metrics and the
+// conventional http_requests_total / http_request_duration_seconds metrics
+var metricTemplates = []metricTemplate{
+	{
+		Name:        "container_restarts",
+		Description: "Container restarts during the window (kube-state-metrics)",
+		Unit:        "count",
+		Query:       `sum by (namespace, pod, container) (increase(kube_pod_container_status_restarts_total{$SELECTOR}[$WINDOW]))`,
+	},
+	{
+		Name:        "cpu_usage",
+		Description: "CPU usage averaged over 5 minutes",
+		Unit:        "cores",
+		Query:       `sum by (namespace, pod, container) (rate(container_cpu_usage_seconds_total{$SELECTOR,container!="",container!="POD"}[5m]))`,
+	},
+	{
+		Name:        "cpu_throttling",
+		Description: "Fraction of CFS periods in which the container was throttled",
+		Unit:        "ratio",
+		Query: `sum by (namespace, pod, container) (increase(container_cpu_cfs_throttled_periods_total{$SELECTOR,container!=""}[$WINDOW]))` +
+			` / sum by (namespace, pod, container) (increase(container_cpu_cfs_periods_total{$SELECTOR,container!=""}[$WINDOW]))`,
+	},
+	{
+		Name:        "memory_working_set",
+		Description: "Current memory working set (what the OOM killer looks at)",
+		Unit:        "bytes",
+		Query:       `max by (namespace, pod, container) (container_memory_working_set_bytes{$SELECTOR,container!="",container!="POD"})`,
+	},
+	{
+		Name:        "memory_vs_limit",
+		Description: "Peak memory working set during the window relative to the memory limit",
+		Unit:        "ratio",
+		Query: `max by (namespace, pod, container) (max_over_time(container_memory_working_set_bytes{$SELECTOR,container!="",container!="POD"}[$WINDOW]))` +
+			` / max by (namespace, pod, container) (kube_pod_container_resource_limits{$SELECTOR,resource="memory"})`,
+	},
+	{
+		Name:        "http_5xx_rate",
+		Description: "Share of HTTP requests answered with a 5xx status",
+		Unit:        "ratio",
+		Query: `sum by (namespace, pod) (rate(http_requests_total{$SELECTOR,code=~"5.."}[$WINDOW]))` +
+			` / sum by (namespace, pod) (rate(http_requests_total{$SELECTOR}[$WINDOW]))`,
+	},
+	{
+		Name:        "http_latency_p99",
+		Description: "99th percentile HTTP request latency",
+		Unit:        "seconds",
+		Query:       `histogram_quantile(0.99, sum by (namespace, pod, le) (rate(http_request_duration_seconds_bucket{$SELECTOR}[$WINDOW])))`,
+	},
+}
+
+// podSummaryTemplates are attached to diagnose_pod results
+var podSummaryTemplates = []string{"container_restarts", "cpu_throttling", "memory_vs_limit", "http_5xx_rate", "http_latency_p99"}
+
+const defaultMetricsWindow = time.Hour
+
+func findMetricTemplate(name string) (metricTemplate, error) {
+	names := make([]string, 0, len(metricTemplates))
+	for _, t := range metricTemplates {
+		if t.Name == name {
+			return t, nil
+		}
+		names = append(names, t.Name)
+	}
+	return metricTemplate{}, fmt.Errorf("unknown metrics template %q (expected one of: %s)", name, strings.Join(names, ", "))
+}
+
+func (t metricTemplate) render(selector string, window time.Duration) string {
+	return strings.NewReplacer("$SELECTOR", selector, "$WINDOW", promDuration(window)).Replace(t.Query)
+}
+
+// MetricsQueryRequest selects a template and the pods it runs against
+type MetricsQueryRequest struct {
+	Template  string `json:"template"`
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod,omitempty"`
+	Workload  string `json:"workload,omitempty"`
+	Window    string `json:"window,omitempty"`
+}
+
+// MetricsQueryResult is the outcome of a templated query
+type MetricsQueryResult struct {
+	Template    string         `json:"template"`
+	Description string         `json:"description"`
+	Unit        string         `json:"unit"`
+	Namespace   string         `json:"namespace"`
+	Pod         string         `json:"pod,omitempty"`
+	Workload    string         `json:"workload,omitempty"`
+	Window      string         `json:"window"`
+	Query       string         `json:"query"`
+	Series      []MetricSeries `json:"series"`
+}
+
+// MetricSeries is one labeled value of a query result
+type MetricSeries struct {
+	Labels map[string]string `json:"labels"`
+	Value  float64           `json:"value"`
+}
+
+// MetricTemplateInfo describes a template for listing
+type MetricTemplateInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Unit        string `json:"unit"`
+	Query       string `json:"query"`
+}
+
+// listMetricTemplates returns the available templates
+func listMetricTemplates() []MetricTemplateInfo {
+	infos := make([]MetricTemplateInfo, 0, len(metricTemplates))
+	for _, t := range metricTemplates {
+		infos = append(infos, MetricTemplateInfo{Name: t.Name, Description: t.Description, Unit: t.Unit, Query: t.Query})
+	}
+	return infos
+}
+
+// parseMetricsWindow parses a lookback window such as "30m" or "6h"
+func parseMetricsWindow(window string) (time.Duration, error) {
+	if window == "" {
+		return defaultMetricsWindow, nil
+	}
+	d, err := time.ParseDuration(window)
+	if err != nil || d < time.Minute {
+		return 0, fmt.Errorf("invalid window %q (expected a duration of at least 1m, e.g. 30m or 6h)", window)
+	}
+	return d.Truncate(time.Second), nil
+}
+
+// queryMetrics runs a curated template against a pod, a deployment's pods or a whole namespace
+func (s *K8sDiagnosticsServer) queryMetrics(ctx context.Context, req MetricsQueryRequest) (*MetricsQueryResult, error) {
+	if s.prometheus == nil {
+		return nil, fmt.Errorf("no metrics endpoint configured (set PROMETHEUS_URL)")
+	}
+
+	template, err := findMetricTemplate(req.Template)
+	if err != nil {
+		return nil, err
+	}
+	window, err := parseMetricsWindow(req.Window)
+	if err != nil {
+		return nil, err
+	}
+	if req.Pod != "" && req.Workload != "" {
+		return nil, fmt.Errorf("pod and workload are mutually exclusive")
+	}
+	if err := s.access.checkNamespace(req.Namespace); err != nil {
+		return nil, err
+	}
+	if err := s.checkCallerCanRead(ctx, req.Namespace); err != nil {
+		return nil, err
+	}
+
+	var pods []string
+	switch {
+	case req.Pod != "":
+		if err := s.checkPod(ctx, req.Namespace, req.Pod); err != nil {
+			return nil, err
+		}
+		pods = []string{req.Pod}
+	case req.Workload != "":
+		pods, err = s.deploymentPodNames(ctx, req.Namespace, req.Workload)
+		if err != nil {
+			return nil, err
+		}
+		if len(pods) == 0 {
+			return nil, fmt.Errorf("deployment %s/%s has no pods", req.Namespace, req.Workload)
+		}
+	}
+
+	query := template.render(promSelector(req.Namespace, pods), window)
+	samples, err := s.prometheus.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MetricsQueryResult{
+		Template:    template.Name,
+		Description: template.Description,
+		Unit:        template.Unit,
+		Namespace:   req.Namespace,
+		Pod:         req.Pod,
+		Workload:    req.Workload,
+		Window:      promDuration(window),
+		Query:       query,
+		Series:      []MetricSeries{},
+	}
+	// A namespace-wide query can return series of pods the access policy excludes
+	excluded, err := s.excludedPodNames(ctx, req.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	for _, sample := range samples {
+		if math.IsNaN(sample.Value) || math.IsInf(sample.Value, 0) || excluded[sample.Labels["pod"]] {
+			continue
+		}
+		result.Series = append(result.Series, MetricSeries{Labels: sample.Labels, Value: sample.Value})
+	}
+	sort.SliceStable(result.Series, func(i, j int) bool { return result.Series[i].Value > result.Series[j].Value })
+
+	return result, nil
+}
+
+// deploymentPodNames lists the pods currently selected by a deployment
+func (s *K8sDiagnosticsServer) deploymentPodNames(ctx context.Context, namespace, name string) ([]string, error) {
+	deployment, err := s.clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.access.checkObject("Deployment", namespace, name, deployment.Labels); err != nil {
+		return nil, err
+	}
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector on deployment %s: %w", name, err)
+	}
+	pods, err := s.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(pods.Items))
+	for _, pod := range s.access.filterPods(pods.Items) {
+		names = append(names, pod.Name)
+	}
+	return names, nil
+}
+
+// PodMetricsSummary is the Prometheus context attached to a pod diagnosis
+type PodMetricsSummary struct {
+	Window  string        `json:"window"`
+	Metrics []MetricValue `json:"metrics"`
+	Errors  []string      `json:"errors,omitempty"`
+}
+
+// MetricValue is one template value for a pod or one of its containers
+type MetricValue struct {
+	Template    string  `json:"template"`
+	Description string  `json:"description"`
+	Container   string  `json:"container,omitempty"`
+	Value       float64 `json:"value"`
+	Unit        string  `json:"unit"`
+}
+
+// podMetricsSummary runs the pod summary templates. Missing series (e.g. an
+// app without HTTP metrics) are left out; failed queries are reported as errors.
+func (s *K8sDiagnosticsServer) podMetricsSummary(ctx context.Context, namespace, podName string) *PodMetricsSummary {
+	if s.prometheus == nil {
+		return nil
+	}
+
+	summary := &PodMetricsSummary{Window: promDuration(defaultMetricsWindow), Metrics: []MetricValue{}}
+	for _, name := range podSummaryTemplates {
+		result, err := s.queryMetrics(ctx, MetricsQueryRequest{Template: name, Namespace: namespace, Pod: podName})
+		if err != nil {
+			summary.Errors = append(summary.Errors, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		for _, series := range result.Series {
+			summary.Metrics = append(summary.Metrics, MetricValue{
+				Template:    result.Template,
+				Description: result.Description,
+				Container:   series.Labels["container"],
+				Value:       series.Value,
+				Unit:        result.Unit,
+			})
+		}
+	}
+	return summary
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestQueryMetricsTemplates(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web-1"}}
+	for _, template := range metricTemplates {
+		t.Run(template.Name, func(t *testing.T) {
+			stub := newPromStub(t, func(string) string { return emptyVector })
+			s := &K8sDiagnosticsServer{clientset: fake.NewSimpleClientset(pod), prometheus: stub.client()}
+
+			result, err := s.queryMetrics(context.Background(), MetricsQueryRequest{
+				Template: template.Name, Namespace: "shop", Pod: "web-1", Window: "30m"})
+			if err != nil {
+				t.Fatalf("queryMetrics: %v", err)
+			}
+			if len(stub.queries) != 1 || stub.queries[0] != result.Query {
+				t.Fatalf("expected Prometheus to receive the rendered query %q, got %v", result.Query, stub.queries)
+			}
+			query := stub.queries[0]
+			if strings.Contains(query, "$") {
+				t.Errorf("placeholders left in %s", query)
+			}
+			selectors := strings.Count(template.Query, "$SELECTOR")
+			if got := strings.Count(query, `{namespace="shop",pod=~"web-1"`); got != selectors {
+				t.Errorf("expected the pod selector %d times, got %d in %s", selectors, got, query)
+			}
+			if strings.Contains(template.Query, "$WINDOW") && !strings.Contains(query, "[30m]") {
+				t.Errorf("expected the 30m window in %s", query)
+			}
+		})
+	}
+}
+
+func TestQueryMetricsSkipsUnusableSeries(t *testing.T) {
+	stub := newPromStub(t, func(string) string {
+		return `{"status":"success","data":{"resultType":"vector","result":[` +
+			`{"metric":{"pod":"web-1"},"value":[1,"0.1"]},` +
+			`{"metric":{"pod":"web-2"},"value":[1,"NaN"]},` +
+			`{"metric":{"pod":"web-3"},"value":[1,"+Inf"]},` +
+			`{"metric":{"pod":"web-4"},"value":[1,"0.7"]}]}}`
+	})
+	s := &K8sDiagnosticsServer{clientset: fake.NewSimpleClientset(), prometheus: stub.client()}
+
+	result, err := s.queryMetrics(context.Background(), MetricsQueryRequest{Template: "http_5xx_rate", Namespace: "shop"})
+	if err != nil {
+		t.Fatalf("queryMetrics: %v", err)
+	}
+	if len(result.Series) != 2 || result.Series[0].Labels["pod"] != "web-4" || result.Series[1].Labels["pod"] != "web-1" {
+		t.Errorf("expected web-4 then web-1, got %+v", result.Series)
+	}
+	if !strings.Contains(stub.queries[0], `{namespace="shop",code=~"5.."}`) {
+		t.Errorf("expected a namespace-wide selector, got %s", stub.queries[0])
+	}
+}
+
+func TestQueryMetricsCallerGate(t *testing.T) {
+	tests := []struct {
+		name          string
+		identity      *Identity
+		allowed       bool
+		wantForbidden bool
+		wantReviews   int
+	}{
+		{name: "server identity is not checked", wantReviews: 0},
+		{name: "caller allowed to list pods", identity: &Identity{User: "alice"}, allowed: true, wantReviews: 1},
+		{name: "caller denied", identity: &Identity{User: "bob"}, wantForbidden: true, wantReviews: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := newPromStub(t, func(string) string { return emptyVector })
+			clientset := fake.NewSimpleClientset()
+			var reviews []*authorizationv1.SelfSubjectAccessReview
+			clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+				reviews = append(reviews, review)
+				review = review.DeepCopy()
+				review.Status.Allowed = tt.allowed
+				return true, review, nil
+			})
+			s := &K8sDiagnosticsServer{clientset: clientset, prometheus: stub.client(), identity: tt.identity}
+
+			_, err := s.queryMetrics(context.Background(), MetricsQueryRequest{Template: "cpu_usage", Namespace: "shop"})
+			if tt.wantForbidden {
+				if !apierrors.IsForbidden(err) {
+					t.Fatalf("expected Forbidden, got %v", err)
+				}
+				if len(stub.queries) != 0 {
+					t.Errorf("expected no Prometheus query for a denied caller, got %v", stub.queries)
+				}
+			} else if err != nil {
+				t.Fatalf("queryMetrics: %v", err)
+			}
+
+			if len(reviews) != tt.wantReviews {
+				t.Fatalf("expected %d access reviews, got %d", tt.wantReviews, len(reviews))
+			}
+			if len(reviews) > 0 {
+				attributes := reviews[0].Spec.ResourceAttributes
+				if attributes.Verb != "list" || attributes.Resource != "pods" || attributes.Namespace != "shop" {
+					t.Errorf("expected a review of list pods in shop, got %+v", attributes)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// limitRangerAnnotation is set by the LimitRanger admission plugin on pods it mutated
+const limitRangerAnnotation = "kubernetes.io/limit-ranger"
+
+// limitRangerContainers returns the app containers named in a LimitRanger
+// annotation such as "LimitRanger plugin set: cpu request for container app;
+// memory limit for init container setup". Init containers are skipped.
+func limitRangerContainers(applied string) map[string]bool {
+	names := map[string]bool{}
+	applied = strings.TrimPrefix(applied, "LimitRanger plugin set:")
+	for _, segment := range strings.Split(applied, ";") {
+		i := strings.LastIndex(segment, " for ")
+		if i < 0 {
+			continue
+		}
+		if name, ok := strings.CutPrefix(strings.TrimSpace(segment[i+len(" for "):]), "container "); ok {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// NamespaceCapacity is the namespace_capacity result
+type NamespaceCapacity struct {
+	Namespace           string               `json:"namespace"`
+	Quotas              []QuotaReport        `json:"quotas"`
+	LimitRanges         []LimitRangeReport   `json:"limit_ranges"`
+	DefaultedContainers []DefaultedContainer `json:"defaulted_containers"`
+	Rollouts            []RolloutHeadroom    `json:"rollouts"`
+	QuotaEvents         []string             `json:"quota_events"`
+	Findings            []Finding            `json:"findings"`
+	Summary             *FindingSummary      `json:"summary,omitempty"`
+}
+
+// QuotaReport is one ResourceQuota
+type QuotaReport struct {
+	Name      string          `json:"name"`
+	Scopes    []string        `json:"scopes,omitempty"`
+	Resources []QuotaResource `json:"resources"`
+}
+
+// QuotaResource is used vs. hard for one quota resource
+type QuotaResource struct {
+	Resource    string   `json:"resource"`
+	Used        string   `json:"used"`
+	Hard        string   `json:"hard"`
+	Remaining   string   `json:"remaining"`
+	UsedPercent *float64 `json:"used_percent,omitempty"`
+}
+
+// LimitRangeReport is one LimitRange
+type LimitRangeReport struct {
+	Name   string           `json:"name"`
+	Limits []LimitRangeItem `json:"limits"`
+}
+
+// LimitRangeItem is one type/resource entry of a LimitRange
+type LimitRangeItem struct {
+	Type                 string `json:"type"`
+	Resource             string `json:"resource"`
+	Default              string `json:"default_limit,omitempty"`
+	DefaultRequest       string `json:"default_request,omitempty"`
+	Min                  string `json:"min,omitempty"`
+	Max                  string `json:"max,omitempty"`
+	MaxLimitRequestRatio string `json:"max_limit_request_ratio,omitempty"`
+}
+
+// DefaultedContainer is a container whose requests/limits were filled in by a LimitRange
+type DefaultedContainer struct {
+	Pod       string           `json:"pod"`
+	Container string           `json:"container"`
+	Applied   string           `json:"applied"`
+	Effective ResourceSettings `json:"effective"`
+}
+
+// RolloutHeadroom is whether the next rollout of a deployment fits in the quotas
+type RolloutHeadroom struct {
+	Deployment  string            `json:"deployment"`
+	Replicas    int32             `json:"replicas"`
+	SurgePods   int               `json:"surge_pods"`
+	PerPod      map[string]string `json:"per_pod"`
+	SurgeNeeds  map[string]string `json:"surge_needs"`
+	Fits        bool              `json:"fits"`
+	BlockedBy   []string          `json:"blocked_by,omitempty"`
+	Explanation string            `json:"explanation"`
+}
+
+// quotaPodResources maps quota resource names to the per-pod amount they count
+var quotaPodResources = map[corev1.ResourceName]func(requests, limits corev1.ResourceList) (resource.Quantity, bool){
+	corev1.ResourcePods: func(_, _ corev1.ResourceList) (resource.Quantity, bool) {
+		return *resource.NewQuantity(1, resource.DecimalSI), true
+	},
+	corev1.ResourceCPU:            fromList(true, corev1.ResourceCPU),
+	corev1.ResourceRequestsCPU:    fromList(true, corev1.ResourceCPU),
+	corev1.ResourceMemory:         fromList(true, corev1.ResourceMemory),
+	corev1.ResourceRequestsMemory: fromList(true, corev1.ResourceMemory),
+	corev1.ResourceLimitsCPU:      fromList(false, corev1.ResourceCPU),
+	corev1.ResourceLimitsMemory:   fromList(false, corev1.ResourceMemory),
+}
+
+func fromList(requests bool, name corev1.ResourceName) func(requests, limits corev1.ResourceList) (resource.Quantity, bool) {
+	return func(r, l corev1.ResourceList) (resource.Quantity, bool) {
+		list := l
+		if requests {
+			list = r
+		}
+		q, ok := list[name]
+		return q, ok
+	}
+}
+
+// namespaceCapacity reports quota usage, LimitRange defaults and rollout headroom for a namespace
+func (s *K8sDiagnosticsServer) namespaceCapacity(ctx context.Context, namespace string) (*NamespaceCapacity, error) {
+	if err := s.access.checkNamespace(namespace); err != nil {
+		return nil, err
+	}
+	quotas, err := s.clientset.CoreV1().ResourceQuotas(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	limitRanges, err := s.clientset.CoreV1().LimitRanges(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &NamespaceCapacity{
+		Namespace:           namespace,
+		Quotas:              []QuotaReport{},
+		LimitRanges:         []LimitRangeReport{},
+		DefaultedContainers: []DefaultedContainer{},
+		Rollouts:            []RolloutHeadroom{},
+		QuotaEvents:         []string{},
+		Findings:            []Finding{},
+	}
+
+	warningPercent := s.policy.Thresholds(namespace).QuotaWarningPercent
+	for _, quota := range quotas.Items {
+		result.Quotas = append(result.Quotas, quotaReport(quota))
+		for _, r := range result.Quotas[len(result.Quotas)-1].Resources {
+			if r.UsedPercent != nil && *r.UsedPercent >= warningPercent {
+				result.Findings = append(result.Findings, newFinding(findingQuotaNearLimit,
+					ObjectRef{Kind: "ResourceQuota", Namespace: namespace, Name: quota.Name},
+					fmt.Sprintf("ResourceQuota %s/%s: %s is %.1f%% used (%s of %s)",
+						namespace, quota.Name, r.Resource, *r.UsedPercent, r.Used, r.Hard),
+					map[string]string{"resource": r.Resource, "used": r.Used, "hard": r.Hard}))
+			}
+		}
+	}
+
+	for _, lr := range limitRanges.Items {
+		result.LimitRanges = append(result.LimitRanges, limitRangeReport(lr))
+	}
+
+	pods, err := s.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range s.access.filterPods(pods.Items) {
+		applied, ok := pod.Annotations[limitRangerAnnotation]
+		if !ok {
+			continue
+		}
+		defaulted := limitRangerContainers(applied)
+		for _, container := range pod.Spec.Containers {
+			if !defaulted[container.Name] {
+				continue
+			}
+			result.DefaultedContainers = append(result.DefaultedContainers, DefaultedContainer{
+				Pod:       pod.Name,
+				Container: container.Name,
+				Applied:   applied,
+				Effective: resourceSettings(container.Resources),
+			})
+		}
+	}
+	if len(result.DefaultedContainers) > 0 {
+		result.Findings = append(result.Findings, newFinding(findingLimitRangeDefaulted,
+			ObjectRef{Kind: "Namespace", Name: namespace},
+			fmt.Sprintf("%d container(s) in namespace %s got requests or limits from a LimitRange",
+				len(result.DefaultedContainers), namespace), nil))
+	}
+
+	deployments, err := s.clientset.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, deployment := range deployments.Items {
+		if s.access.excludes(deployment.Labels) {
+			continue
+		}
+		headroom := rolloutHeadroom(deployment, quotas.Items, limitRanges.Items)
+		result.Rollouts = append(result.Rollouts, headroom)
+		if !headroom.Fits {
+			result.Findings = append(result.Findings, newFinding(findingRolloutBlocked,
+				ObjectRef{Kind: "Deployment", Namespace: namespace, Name: deployment.Name},
+				fmt.Sprintf("Deployment %s/%s: %s", namespace, deployment.Name, headroom.Explanation),
+				map[string]string{"blocked_by": strings.Join(headroom.BlockedBy, ", ")}))
+		}
+	}
+
+	// ReplicaSets that could not create pods because of a quota
+	events, err := s.clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: "involvedObject.kind=ReplicaSet,reason=FailedCreate",
+	})
+	if err == nil {
+		window := s.policy.Thresholds(namespace).EventWindow.Duration
+		seen := make(map[string]bool)
+		for _, event := range events.Items {
+			if event.InvolvedObject.Kind != "ReplicaSet" || event.Reason != "FailedCreate" ||
+				!strings.Contains(event.Message, "exceeded quota") {
+				continue
+			}
+			last := event.LastTimestamp.Time
+			if last.IsZero() {
+				last = event.EventTime.Time
+			}
+			if time.Since(last) > window {
+				continue
+			}
+			result.QuotaEvents = append(result.QuotaEvents, fmt.Sprintf("ReplicaSet %s: %s (%s)",
+				event.InvolvedObject.Name, event.Message, last.Format(time.RFC3339)))
+			if !seen[event.InvolvedObject.Name] {
+				seen[event.InvolvedObject.Name] = true
+				result.Findings = append(result.Findings, newFinding(findingQuotaExceeded,
+					ObjectRef{Kind: "ReplicaSet", Namespace: namespace, Name: event.InvolvedObject.Name},
+					fmt.Sprintf("ReplicaSet %s/%s cannot create pods: %s", namespace, event.InvolvedObject.Name, event.Message),
+					map[string]string{"count": fmt.Sprint(event.Count)}))
+			}
+		}
+	}
+
+	sortFindings(result.Findings)
+	result.Summary = summarizeFindings(result.Findings)
+
+	return result, nil
+}
+
+func quotaReport(quota corev1.ResourceQuota) QuotaReport {
+	report := QuotaReport{Name: quota.Name, Resources: []QuotaResource{}}
+	for _, scope := range quota.Spec.Scopes {
+		report.Scopes = append(report.Scopes, string(scope))
+	}
+
+	names := make([]string, 0, len(quota.Status.Hard))
+	for name := range quota.Status.Hard {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		hard := quota.Status.Hard[corev1.ResourceName(name)]
+		used := quota.Status.Used[corev1.ResourceName(name)]
+		remaining := hard.DeepCopy()
+		remaining.Sub(used)
+		if remaining.Sign() < 0 {
+			remaining = resource.Quantity{}
+		}
+		report.Resources = append(report.Resources, QuotaResource{
+			Resource:    name,
+			Used:        used.String(),
+			Hard:        hard.String(),
+			Remaining:   remaining.String(),
+			UsedPercent: percentOf(used.MilliValue(), hard.MilliValue()),
+		})
+	}
+	return report
+}
+
+func limitRangeReport(lr corev1.LimitRange) LimitRangeReport {
+	report := LimitRangeReport{Name: lr.Name, Limits: []LimitRangeItem{}}
+	str := func(list corev1.ResourceList, name corev1.ResourceName) string {
+		if q, ok := list[name]; ok {
+			return q.String()
+		}
+		return ""
+	}
+
+	for _, item := range lr.Spec.Limits {
+		names := make(map[corev1.ResourceName]bool)
+		for _, list := range []corev1.ResourceList{item.Default, item.DefaultRequest, item.Min, item.Max, item.MaxLimitRequestRatio} {
+			for name := range list {
+				names[name] = true
+			}
+		}
+		sorted := make([]string, 0, len(names))
+		for name := range names {
+			sorted = append(sorted, string(name))
+		}
+		sort.Strings(sorted)
+
+		for _, n := range sorted {
+			name := corev1.ResourceName(n)
+			report.Limits = append(report.Limits, LimitRangeItem{
+				Type:                 string(item.Type),
+				Resource:             n,
+				Default:              str(item.Default, name),
+				DefaultRequest:       str(item.DefaultRequest, name),
+				Min:                  str(item.Min, name),
+				Max:                  str(item.Max, name),
+				MaxLimitRequestRatio: str(item.MaxLimitRequestRatio, name),
+			})
+		}
+	}
+	return report
+}
+
+// effectiveResources applies LimitRange container defaults the way the
+// LimitRanger admission plugin does: a missing limit gets the default limit,
+// a missing request gets the default request, else the limit
+func effectiveResources(resources corev1.ResourceRequirements, limitRanges []corev1.LimitRange) (corev1.ResourceList, corev1.ResourceList) {
+	requests := resources.Requests.DeepCopy()
+	limits := resources.Limits.DeepCopy()
+	if requests == nil {
+		requests = corev1.ResourceList{}
+	}
+	if limits == nil {
+		limits = corev1.ResourceList{}
+	}
+
+	for _, lr := range limitRanges {
+		for _, item := range lr.Spec.Limits {
+			if item.Type != corev1.LimitTypeContainer {
+				continue
+			}
+			for name, q := range item.Default {
+				if _, ok := limits[name]; !ok {
+					limits[name] = q
+				}
+			}
+			for name, q := range item.DefaultRequest {
+				if _, ok := requests[name]; !ok {
+					requests[name] = q
+				}
+			}
+		}
+	}
+	for name, q := range limits {
+		if _, ok := requests[name]; !ok {
+			requests[name] = q
+		}
+	}
+	return requests, limits
+}
+
+// podResources sums effective container requests/limits for a pod template.
+// Init containers run one at a time, so the pod needs the larger of the
+// biggest init container and the sum of the app containers.
+func podResources(spec corev1.PodSpec, limitRanges []corev1.LimitRange) (corev1.ResourceList, corev1.ResourceList) {
+	requests, limits := corev1.ResourceList{}, corev1.ResourceList{}
+	for _, c := range spec.Containers {
+		r, l := effectiveResources(c.Resources, limitRanges)
+		addResources(requests, r)
+		addResources(limits, l)
+	}
+	for _, c := range spec.InitContainers {
+		r, l := effectiveResources(c.Resources, limitRanges)
+		maxResources(requests, r)
+		maxResources(limits, l)
+	}
+	return requests, limits
+}
+
+func addResources(total, list corev1.ResourceList) {
+	for name, q := range list {
+		sum := total[name]
+		sum.Add(q)
+		total[name] = sum
+	}
+}
+
+func maxResources(total, list corev1.ResourceList) {
+	for name, q := range list {
+		if current, ok := total[name]; !ok || q.Cmp(current) > 0 {
+			total[name] = q
+		}
+	}
+}
+
+// surgePods is how many extra pods a rolling update creates at once
+func surgePods(deployment appsv1.Deployment) int {
+	if deployment.Spec.Strategy.Type == appsv1.RecreateDeploymentStrategyType {
+		return 0
+	}
+	replicas := 1
+	if deployment.Spec.Replicas != nil {
+		replicas = int(*deployment.Spec.Replicas)
+	}
+	maxSurge := intstr.FromString("25%")
+	if ru := deployment.Spec.Strategy.RollingUpdate; ru != nil && ru.MaxSurge != nil {
+		maxSurge = *ru.MaxSurge
+	}
+	surge, err := intstr.GetScaledValueFromIntOrPercent(&maxSurge, replicas, true)
+	if err != nil {
+		return 1
+	}
+	return surge
+}
+
+// rolloutHeadroom checks whether the surge pods of the next rollout fit in
+// every quota that applies to the namespace
+func rolloutHeadroom(deployment appsv1.Deployment, quotas []corev1.ResourceQuota, limitRanges []corev1.LimitRange) RolloutHeadroom {
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	surge := surgePods(deployment)
+	requests, limits := podResources(deployment.Spec.Template.Spec, limitRanges)
+
+	headroom := RolloutHeadroom{
+		Deployment: deployment.Name,
+		Replicas:   replicas,
+		SurgePods:  surge,
+		PerPod:     map[string]string{},
+	}
+
+	for name, value := range quotaPodResources {
+		q, ok := value(requests, limits)
+		if !ok {
+			continue
+		}
+		headroom.PerPod[string(name)] = q.String()
+	}
+
+	if surge == 0 {
+		headroom.SurgeNeeds = map[string]string{}
+		headroom.Fits = true
+		headroom.Explanation = "Recreate strategy: old pods are deleted before new ones are created, so no extra quota is needed"
+		return headroom
+	}
+
+	headroom.SurgeNeeds, headroom.BlockedBy = quotaShortfall(requests, limits, surge, quotas)
+	headroom.Fits = len(headroom.BlockedBy) == 0
+
+	switch {
+	case headroom.Fits:
+		headroom.Explanation = fmt.Sprintf("%d surge pod(s) fit in the remaining quota", surge)
+	default:
+		headroom.Explanation = fmt.Sprintf("%d surge pod(s) would exceed the quota (%s); the rollout stalls with FailedCreate events",
+			surge, strings.Join(headroom.BlockedBy, "; "))
+	}
+	return headroom
+}
+
+// quotaShortfall computes the quota that count more pods need and which
+// unscoped quotas cannot accommodate them
+func quotaShortfall(requests, limits corev1.ResourceList, count int, quotas []corev1.ResourceQuota) (map[string]string, []string) {
+	needs := map[string]string{}
+	var blocked []string
+
+	for _, quota := range quotas {
+		// Scoped quotas (BestEffort, PriorityClass, ...) only count some pods
+		if len(quota.Spec.Scopes) > 0 || quota.Spec.ScopeSelector != nil {
+			continue
+		}
+		for name, hard := range quota.Status.Hard {
+			value, tracked := quotaPodResources[name]
+			if !tracked {
+				continue
+			}
+			perPod, ok := value(requests, limits)
+			if !ok {
+				// A quota on compute resources rejects pods that don't set them
+				blocked = append(blocked, fmt.Sprintf("%s/%s: pods must set %s", quota.Name, name, name))
+				continue
+			}
+
+			need := resource.NewMilliQuantity(perPod.MilliValue()*int64(count), perPod.Format)
+			needs[string(name)] = need.String()
+
+			remaining := hard.DeepCopy()
+			remaining.Sub(quota.Status.Used[name])
+			if need.Cmp(remaining) > 0 {
+				blocked = append(blocked,
+					fmt.Sprintf("%s/%s: needs %s, %s left", quota.Name, name, need.String(), remaining.String()))
+			}
+		}
+	}
+
+	sort.Strings(blocked)
+	return needs, blocked
+}
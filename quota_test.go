@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLimitRangerContainers(t *testing.T) {
+	tests := []struct {
+		applied string
+		want    map[string]bool
+	}{
+		{
+			applied: "LimitRanger plugin set: cpu, memory request for container app; cpu, memory limit for container app",
+			want:    map[string]bool{"app": true},
+		},
+		{
+			applied: "LimitRanger plugin set: cpu request for container app-sidecar; memory limit for init container app",
+			want:    map[string]bool{"app-sidecar": true},
+		},
+		{
+			applied: "LimitRanger plugin set: cpu request for container web; cpu request for container proxy",
+			want:    map[string]bool{"web": true, "proxy": true},
+		},
+		{applied: "", want: map[string]bool{}},
+	}
+	for _, tt := range tests {
+		if got := limitRangerContainers(tt.applied); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("limitRangerContainers(%q) = %v, want %v", tt.applied, got, tt.want)
+		}
+	}
+}
+
+func TestNamespaceCapacityQuotaWarningThreshold(t *testing.T) {
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "compute"},
+		Status: corev1.ResourceQuotaStatus{
+			Hard: corev1.ResourceList{corev1.ResourcePods: resource.MustParse("10")},
+			Used: corev1.ResourceList{corev1.ResourcePods: resource.MustParse("8")},
+		},
+	}
+	policy, err := parsePolicy([]byte("namespaces:\n  shop:\n    quota_warning_percent: 75\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		policy *PolicyStore
+		want   bool
+	}{
+		{name: "default threshold", want: false},
+		{name: "namespace override", policy: &PolicyStore{policy: policy}, want: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &K8sDiagnosticsServer{clientset: fake.NewSimpleClientset(quota), policy: tt.policy}
+			result, err := s.namespaceCapacity(context.Background(), "shop")
+			if err != nil {
+				t.Fatalf("namespaceCapacity: %v", err)
+			}
+			got := false
+			for _, finding := range result.Findings {
+				got = got || finding.ID == findingQuotaNearLimit.ID
+			}
+			if got != tt.want {
+				t.Errorf("expected near-limit finding %v at 80%% used, got %+v", tt.want, result.Findings)
+			}
+		})
+	}
+}
+
+func TestQuotaShortfall(t *testing.T) {
+	requests := corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("250m"),
+		corev1.ResourceMemory: resource.MustParse("512Mi"),
+	}
+	quota := corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "compute"},
+		Status: corev1.ResourceQuotaStatus{
+			Hard: corev1.ResourceList{
+				corev1.ResourceRequestsCPU:    resource.MustParse("10"),
+				corev1.ResourceRequestsMemory: resource.MustParse("8Gi"),
+			},
+			Used: corev1.ResourceList{
+				corev1.ResourceRequestsCPU:    resource.MustParse("9"),
+				corev1.ResourceRequestsMemory: resource.MustParse("1Gi"),
+			},
+		},
+	}
+
+	needs, blocked := quotaShortfall(requests, nil, 4, []corev1.ResourceQuota{quota})
+	if needs["requests.cpu"] != "1" || needs["requests.memory"] != "2Gi" || len(blocked) != 0 {
+		t.Errorf("expected 4 pods to need 1 CPU and 2Gi and fit, got %v, %v", needs, blocked)
+	}
+
+	needs, blocked = quotaShortfall(requests, nil, maxSimulatedReplicas, []corev1.ResourceQuota{quota})
+	if needs["requests.cpu"] != "250" || len(blocked) != 2 {
+		t.Errorf("expected %d pods to need 250 CPUs and exceed both quotas, got %v, %v", maxSimulatedReplicas, needs, blocked)
+	}
+}
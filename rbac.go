@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// clusterScope marks rules and checks that apply to all namespaces and cluster-scoped resources
+const clusterScope = "cluster"
+
+// AccessCheckRequest asks whether a service account may perform an action
+type AccessCheckRequest struct {
+	Verb        string `json:"verb"`
+	Resource    string `json:"resource"`
+	Subresource string `json:"subresource,omitempty"`
+	APIGroup    string `json:"api_group,omitempty"`
+	Name        string `json:"name,omitempty"`
+	// Namespace of the resource; "all" checks cluster-wide or cluster-scoped access
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// RBACAnalysis is the analyze_rbac result
+type RBACAnalysis struct {
+	ServiceAccount string          `json:"service_account"`
+	Namespace      string          `json:"namespace"`
+	Exists         bool            `json:"exists"`
+	Bindings       []RBACBinding   `json:"bindings"`
+	Rules          []EffectiveRule `json:"rules"`
+	Check          *AccessCheck    `json:"check,omitempty"`
+	Findings       []Finding       `json:"findings"`
+	Summary        *FindingSummary `json:"summary,omitempty"`
+	// Omitted lists the roles that could not be read
+	Omitted []string `json:"omitted,omitempty"`
+}
+
+// RBACBinding is a RoleBinding or ClusterRoleBinding that grants the service account a role
+type RBACBinding struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	RoleKind  string `json:"role_kind"`
+	Role      string `json:"role"`
+	// Subject is how the binding matches: the service account itself or one of its groups
+	Subject     string `json:"subject"`
+	RoleMissing bool   `json:"role_missing,omitempty"`
+	// RulesUnknown is set when the role exists or may exist but could not be
+	// read, so its rules are absent from the effective rules
+	RulesUnknown bool `json:"rules_unknown,omitempty"`
+}
+
+// EffectiveRule is a policy rule with where it applies and the binding granting it
+type EffectiveRule struct {
+	Scope           string   `json:"scope"`
+	Verbs           []string `json:"verbs"`
+	APIGroups       []string `json:"api_groups,omitempty"`
+	Resources       []string `json:"resources,omitempty"`
+	ResourceNames   []string `json:"resource_names,omitempty"`
+	NonResourceURLs []string `json:"non_resource_urls,omitempty"`
+	Via             string   `json:"via"`
+}
+
+// AccessCheck answers "can the service account do this"
+type AccessCheck struct {
+	AccessCheckRequest
+	Allowed bool `json:"allowed"`
+	// Method is "SubjectAccessReview" when the API server answered, "local" when
+	// the rules were evaluated here because the review could not be created
+	Method string `json:"method"`
+	Reason string `json:"reason,omitempty"`
+	// LocalAllowed is the result of evaluating the RBAC rules here; it differs from
+	// Allowed when another authorizer (e.g. a webhook) decides
+	LocalAllowed bool           `json:"local_allowed"`
+	MatchedRule  *EffectiveRule `json:"matched_rule,omitempty"`
+}
+
+// serviceAccountUser is the user name the API server authenticates a service account as
+func serviceAccountUser(namespace, name string) string {
+	return fmt.Sprintf("system:serviceaccount:%s:%s", namespace, name)
+}
+
+// serviceAccountGroups are the groups every token of a service account is in
+func serviceAccountGroups(namespace string) []string {
+	return []string{"system:serviceaccounts", "system:serviceaccounts:" + namespace, "system:authenticated"}
+}
+
+// analyzeRBAC resolves a service account's effective permissions across Roles,
+// ClusterRoles and their bindings, flags over-privileged grants and optionally checks one action
+func (s *K8sDiagnosticsServer) analyzeRBAC(ctx context.Context, namespace, serviceAccount string, check *AccessCheckRequest) (*RBACAnalysis, error) {
+	if err := s.access.checkNamespace(namespace); err != nil {
+		return nil, err
+	}
+	if check != nil {
+		if err := s.access.checkNamespace(check.Namespace); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, omitted := withOmissions(ctx)
+	result := &RBACAnalysis{
+		ServiceAccount: serviceAccount,
+		Namespace:      namespace,
+		Bindings:       []RBACBinding{},
+		Rules:          []EffectiveRule{},
+		Findings:       []Finding{},
+	}
+	ref := ObjectRef{Kind: "ServiceAccount", Namespace: namespace, Name: serviceAccount}
+
+	_, err := s.clientset.CoreV1().ServiceAccounts(namespace).Get(ctx, serviceAccount, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		result.Exists = true
+	case apierrors.IsNotFound(err):
+		result.Findings = append(result.Findings, newFinding(findingRBACServiceAccountMissing, ref,
+			fmt.Sprintf("ServiceAccount %s/%s does not exist; bindings to it grant nothing until it is created", namespace, serviceAccount), nil))
+	default:
+		return nil, err
+	}
+
+	matchSubject := func(subjects []rbacv1.Subject) string {
+		groups := serviceAccountGroups(namespace)
+		for _, subject := range subjects {
+			switch subject.Kind {
+			case rbacv1.ServiceAccountKind:
+				if subject.Name == serviceAccount && subject.Namespace == namespace {
+					return "ServiceAccount " + serviceAccount
+				}
+			case rbacv1.UserKind:
+				if subject.Name == serviceAccountUser(namespace, serviceAccount) {
+					return "User " + subject.Name
+				}
+			case rbacv1.GroupKind:
+				if containsString(groups, subject.Name) {
+					return "Group " + subject.Name
+				}
+			}
+		}
+		return ""
+	}
+
+	// clusterRole returns nil for a missing ClusterRole; known is false when
+	// the role could not be read for any other reason
+	type roleLookup struct {
+		role  *rbacv1.ClusterRole
+		known bool
+	}
+	clusterRoles := map[string]roleLookup{}
+	clusterRole := func(name string) (*rbacv1.ClusterRole, bool) {
+		if lookup, ok := clusterRoles[name]; ok {
+			return lookup.role, lookup.known
+		}
+		lookup := roleLookup{known: true}
+		role, err := s.clientset.RbacV1().ClusterRoles().Get(ctx, name, metav1.GetOptions{})
+		switch {
+		case err == nil:
+			lookup.role = role
+		case !apierrors.IsNotFound(err):
+			lookup.known = false
+			noteOmitted(ctx, "ClusterRole "+name+" rules", err)
+		}
+		clusterRoles[name] = lookup
+		return lookup.role, lookup.known
+	}
+
+	addRules := func(binding RBACBinding, scope string, rules []rbacv1.PolicyRule) {
+		via := fmt.Sprintf("%s %s -> %s %s", binding.Kind, binding.Name, binding.RoleKind, binding.Role)
+		if binding.Namespace != "" {
+			via = fmt.Sprintf("%s %s/%s -> %s %s", binding.Kind, binding.Namespace, binding.Name, binding.RoleKind, binding.Role)
+		}
+		for _, rule := range rules {
+			result.Rules = append(result.Rules, EffectiveRule{
+				Scope:           scope,
+				Verbs:           rule.Verbs,
+				APIGroups:       rule.APIGroups,
+				Resources:       rule.Resources,
+				ResourceNames:   rule.ResourceNames,
+				NonResourceURLs: rule.NonResourceURLs,
+				Via:             via,
+			})
+		}
+	}
+
+	// Callers with only namespace RBAC get the service account's own namespace
+	clusterBindings, err := s.clientset.RbacV1().ClusterRoleBindings().List(ctx, metav1.ListOptions{})
+	if apierrors.IsForbidden(err) {
+		noteOmitted(ctx, "ClusterRoleBindings", err)
+		clusterBindings, err = &rbacv1.ClusterRoleBindingList{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, crb := range clusterBindings.Items {
+		subject := matchSubject(crb.Subjects)
+		if subject == "" {
+			continue
+		}
+		binding := RBACBinding{Kind: "ClusterRoleBinding", Name: crb.Name, RoleKind: crb.RoleRef.Kind, Role: crb.RoleRef.Name, Subject: subject}
+		role, known := clusterRole(crb.RoleRef.Name)
+		switch {
+		case role != nil:
+			addRules(binding, clusterScope, role.Rules)
+		case known:
+			binding.RoleMissing = true
+		default:
+			binding.RulesUnknown = true
+		}
+		result.Bindings = append(result.Bindings, binding)
+	}
+
+	// RoleBindings in any namespace can grant the service account access there
+	roleBindings, err := s.clientset.RbacV1().RoleBindings("").List(ctx, metav1.ListOptions{})
+	if apierrors.IsForbidden(err) {
+		noteOmitted(ctx, "RoleBindings outside namespace "+namespace, err)
+		roleBindings, err = s.clientset.RbacV1().RoleBindings(namespace).List(ctx, metav1.ListOptions{})
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, rb := range roleBindings.Items {
+		subject := matchSubject(rb.Subjects)
+		if subject == "" || !s.access.allows(rb.Namespace, nil) {
+			continue
+		}
+		binding := RBACBinding{Kind: "RoleBinding", Name: rb.Name, Namespace: rb.Namespace, RoleKind: rb.RoleRef.Kind, Role: rb.RoleRef.Name, Subject: subject}
+		var rules []rbacv1.PolicyRule
+		found, known := false, true
+		if rb.RoleRef.Kind == "ClusterRole" {
+			var role *rbacv1.ClusterRole
+			if role, known = clusterRole(rb.RoleRef.Name); role != nil {
+				rules, found = role.Rules, true
+			}
+		} else {
+			role, err := s.clientset.RbacV1().Roles(rb.Namespace).Get(ctx, rb.RoleRef.Name, metav1.GetOptions{})
+			switch {
+			case err == nil:
+				rules, found = role.Rules, true
+			case !apierrors.IsNotFound(err):
+				known = false
+				noteOmitted(ctx, fmt.Sprintf("Role %s/%s rules", rb.Namespace, rb.RoleRef.Name), err)
+			}
+		}
+		switch {
+		case found:
+			addRules(binding, rb.Namespace, rules)
+		case known:
+			binding.RoleMissing = true
+		default:
+			binding.RulesUnknown = true
+		}
+		result.Bindings = append(result.Bindings, binding)
+	}
+
+	result.Findings = append(result.Findings, rbacFindings(ref, result.Bindings, result.Rules)...)
+
+	if check != nil {
+		result.Check = s.checkAccess(ctx, namespace, serviceAccount, *check, result.Rules)
+	}
+
+	sortFindings(result.Findings)
+	result.Summary = summarizeFindings(result.Findings)
+	result.Omitted = omitted.list()
+
+	return result, nil
+}
+
+// rbacFindings flags missing roles and over-privileged grants: cluster-admin,
+// wildcards and read access to secrets
+func rbacFindings(ref ObjectRef, bindings []RBACBinding, rules []EffectiveRule) []Finding {
+	var findings []Finding
+
+	for _, binding := range bindings {
+		name := binding.Kind + " " + binding.Name
+		if binding.Namespace != "" {
+			name = fmt.Sprintf("%s %s/%s", binding.Kind, binding.Namespace, binding.Name)
+		}
+		evidence := map[string]string{"binding": name, "role": binding.RoleKind + " " + binding.Role, "subject": binding.Subject}
+		switch {
+		case binding.RoleMissing:
+			findings = append(findings, newFinding(findingRBACRoleMissing, ref,
+				fmt.Sprintf("%s references %s %s, which does not exist", name, binding.RoleKind, binding.Role), evidence))
+		case binding.Role == "cluster-admin" && binding.Kind == "ClusterRoleBinding":
+			findings = append(findings, newFinding(findingRBACClusterAdmin, ref,
+				fmt.Sprintf("%s grants cluster-admin across the whole cluster (via %s)", name, binding.Subject), evidence))
+		case binding.Role == "cluster-admin":
+			finding := newFinding(findingRBACClusterAdmin, ref,
+				fmt.Sprintf("%s grants cluster-admin in namespace %s (via %s)", name, binding.Namespace, binding.Subject), evidence)
+			finding.Severity = SeverityWarning
+			findings = append(findings, finding)
+		}
+	}
+
+	for _, rule := range rules {
+		// cluster-admin is already reported per binding
+		if strings.HasSuffix(rule.Via, "ClusterRole cluster-admin") {
+			continue
+		}
+		evidence := map[string]string{"via": rule.Via, "scope": rule.Scope, "rule": ruleString(rule)}
+		severity := SeverityWarning
+		where := "namespace " + rule.Scope
+		if rule.Scope == clusterScope {
+			severity = SeverityCritical
+			where = "all namespaces"
+		}
+
+		if containsString(rule.Verbs, "*") || containsString(rule.Resources, "*") {
+			finding := newFinding(findingRBACWildcard, ref,
+				fmt.Sprintf("Wildcard rule %s in %s (%s)", ruleString(rule), where, rule.Via), evidence)
+			finding.Severity = severity
+			findings = append(findings, finding)
+		}
+		if len(rule.ResourceNames) == 0 && ruleGrants(rule, []string{"get", "list", "watch"}, "", "secrets") {
+			finding := newFinding(findingRBACSecretsRead, ref,
+				fmt.Sprintf("Can read all secrets in %s (%s)", where, rule.Via), evidence)
+			finding.Severity = severity
+			findings = append(findings, finding)
+		}
+	}
+
+	return findings
+}
+
+// ruleGrants reports whether a rule allows any of the verbs on a resource in an API group
+func ruleGrants(rule EffectiveRule, verbs []string, apiGroup, resource string) bool {
+	if !containsString(rule.APIGroups, "*") && !containsString(rule.APIGroups, apiGroup) {
+		return false
+	}
+	if !containsString(rule.Resources, "*") && !containsString(rule.Resources, resource) {
+		return false
+	}
+	if containsString(rule.Verbs, "*") {
+		return true
+	}
+	for _, verb := range verbs {
+		if containsString(rule.Verbs, verb) {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleMatches evaluates one RBAC rule against a request, like the RBAC authorizer
+func ruleMatches(rule EffectiveRule, req AccessCheckRequest) bool {
+	if !containsString(rule.Verbs, "*") && !containsString(rule.Verbs, req.Verb) {
+		return false
+	}
+	if !containsString(rule.APIGroups, "*") && !containsString(rule.APIGroups, req.APIGroup) {
+		return false
+	}
+	resource := req.Resource
+	if req.Subresource != "" {
+		resource += "/" + req.Subresource
+	}
+	matched := false
+	for _, r := range rule.Resources {
+		if r == "*" || r == resource || req.Subresource != "" && r == "*/"+req.Subresource {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return false
+	}
+	return len(rule.ResourceNames) == 0 || req.Name != "" && containsString(rule.ResourceNames, req.Name)
+}
+
+// evaluateAccess checks a request against the effective rules; namespaced rules only
+// apply to requests in their namespace
+func evaluateAccess(rules []EffectiveRule, req AccessCheckRequest) *EffectiveRule {
+	for i, rule := range rules {
+		if rule.Scope != clusterScope && rule.Scope != req.Namespace {
+			continue
+		}
+		if ruleMatches(rule, req) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// withDefaults fills in the service account's namespace and splits "pods/log" into resource and subresource
+func (req AccessCheckRequest) withDefaults(namespace string) AccessCheckRequest {
+	if req.Namespace == "" {
+		req.Namespace = namespace
+	}
+	if resource, subresource, found := strings.Cut(req.Resource, "/"); found {
+		req.Resource, req.Subresource = resource, subresource
+	}
+	return req
+}
+
+// checkAccess answers an access check with a SubjectAccessReview, falling back to
+// local evaluation of the rules when the review cannot be created
+func (s *K8sDiagnosticsServer) checkAccess(ctx context.Context, namespace, serviceAccount string, req AccessCheckRequest, rules []EffectiveRule) *AccessCheck {
+	req = req.withDefaults(namespace)
+	reviewNamespace := req.Namespace
+	if reviewNamespace == "all" {
+		reviewNamespace = ""
+	}
+
+	local := req
+	local.Namespace = reviewNamespace
+	check := &AccessCheck{AccessCheckRequest: req, Method: "local"}
+	check.MatchedRule = evaluateAccess(rules, local)
+	check.LocalAllowed = check.MatchedRule != nil
+	check.Allowed = check.LocalAllowed
+
+	review, err := s.clientset.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   serviceAccountUser(namespace, serviceAccount),
+			Groups: serviceAccountGroups(namespace),
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace:   reviewNamespace,
+				Verb:        req.Verb,
+				Group:       req.APIGroup,
+				Resource:    req.Resource,
+				Subresource: req.Subresource,
+				Name:        req.Name,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		check.Reason = fmt.Sprintf("SubjectAccessReview failed (%v); evaluated RBAC rules locally", err)
+		return check
+	}
+
+	check.Method = "SubjectAccessReview"
+	check.Allowed = review.Status.Allowed
+	check.Reason = review.Status.Reason
+	if review.Status.EvaluationError != "" {
+		check.Reason = strings.TrimSpace(check.Reason + " " + review.Status.EvaluationError)
+	}
+	return check
+}
+
+// ruleString renders a rule like "get,list secrets" or "* */*"
+func ruleString(rule EffectiveRule) string {
+	if len(rule.NonResourceURLs) > 0 {
+		return fmt.Sprintf("%s %s", strings.Join(rule.Verbs, ","), strings.Join(rule.NonResourceURLs, ","))
+	}
+	groups := make([]string, 0, len(rule.APIGroups))
+	for _, group := range rule.APIGroups {
+		if group == "" {
+			group = "core"
+		}
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	resources := strings.Join(rule.Resources, ",")
+	if len(rule.ResourceNames) > 0 {
+		resources += "[" + strings.Join(rule.ResourceNames, ",") + "]"
+	}
+	return fmt.Sprintf("%s %s (%s)", strings.Join(rule.Verbs, ","), resources, strings.Join(groups, ","))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAnalyzeRBACRoleReadErrors(t *testing.T) {
+	subjects := []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Namespace: "shop", Name: "api"}}
+	roleBinding := func(name, roleKind, role string) *rbacv1.RoleBinding {
+		return &rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: name},
+			Subjects:   subjects,
+			RoleRef:    rbacv1.RoleRef{Kind: roleKind, Name: role},
+		}
+	}
+	clientset := fake.NewSimpleClientset(
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "api"}},
+		&rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "api-audit"}, Subjects: subjects,
+			RoleRef: rbacv1.RoleRef{Kind: "ClusterRole", Name: "audit-reader"}},
+		roleBinding("api-gone", "Role", "deleted"),
+		roleBinding("api-hidden", "Role", "hidden"),
+		roleBinding("api-view", "ClusterRole", "audit-reader"),
+	)
+	clientset.PrependReactor(forbidGet("roles", "hidden"))
+	clientset.PrependReactor(forbidGet("clusterroles", "audit-reader"))
+
+	s := &K8sDiagnosticsServer{clientset: clientset}
+	result, err := s.analyzeRBAC(context.Background(), "shop", "api", nil)
+	if err != nil {
+		t.Fatalf("analyzeRBAC: %v", err)
+	}
+
+	want := map[string][2]bool{
+		"api-audit":  {false, true},
+		"api-gone":   {true, false},
+		"api-hidden": {false, true},
+		"api-view":   {false, true},
+	}
+	for _, binding := range result.Bindings {
+		if got := [2]bool{binding.RoleMissing, binding.RulesUnknown}; got != want[binding.Name] {
+			t.Errorf("%s: expected role_missing, rules_unknown = %v, got %v", binding.Name, want[binding.Name], got)
+		}
+	}
+	missing := 0
+	for _, finding := range result.Findings {
+		if finding.ID == findingRBACRoleMissing.ID {
+			missing++
+			if !strings.Contains(finding.Message, "api-gone") {
+				t.Errorf("unexpected role-missing finding: %s", finding.Message)
+			}
+		}
+	}
+	if missing != 1 {
+		t.Errorf("expected one role-missing finding, got %d", missing)
+	}
+	if len(result.Omitted) != 2 {
+		t.Errorf("expected omissions for Role shop/hidden and ClusterRole audit-reader, got %v", result.Omitted)
+	}
+}
+
+func TestAnalyzeRBACWithNamespaceRBAC(t *testing.T) {
+	subjects := []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Namespace: "shop", Name: "api"}}
+	clientset := fake.NewSimpleClientset(
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "api"}},
+		&rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "api-admin"}, Subjects: subjects,
+			RoleRef: rbacv1.RoleRef{Kind: "ClusterRole", Name: "cluster-admin"}},
+		&rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "config-reader"},
+			Rules: []rbacv1.PolicyRule{{Verbs: []string{"get"}, Resources: []string{"configmaps"}}}},
+		&rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "api-config"}, Subjects: subjects,
+			RoleRef: rbacv1.RoleRef{Kind: "Role", Name: "config-reader"}},
+		&rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Namespace: "billing", Name: "api-billing"}, Subjects: subjects,
+			RoleRef: rbacv1.RoleRef{Kind: "ClusterRole", Name: "view"}},
+	)
+	clientset.PrependReactor(forbidClusterWide("list", "clusterrolebindings"))
+	clientset.PrependReactor(forbidClusterWide("list", "rolebindings"))
+
+	s := &K8sDiagnosticsServer{clientset: clientset}
+	result, err := s.analyzeRBAC(context.Background(), "shop", "api", nil)
+	if err != nil {
+		t.Fatalf("analyzeRBAC: %v", err)
+	}
+	if len(result.Bindings) != 1 || result.Bindings[0].Name != "api-config" {
+		t.Errorf("expected only the RoleBinding in shop, got %+v", result.Bindings)
+	}
+	if len(result.Rules) != 1 || result.Rules[0].Scope != "shop" {
+		t.Errorf("expected the config-reader rule in shop, got %+v", result.Rules)
+	}
+	if len(result.Omitted) != 2 || !strings.HasPrefix(result.Omitted[0], "ClusterRoleBindings omitted") ||
+		!strings.HasPrefix(result.Omitted[1], "RoleBindings outside namespace shop omitted") {
+		t.Errorf("expected the cluster-wide bindings to be noted as omitted, got %v", result.Omitted)
+	}
+}
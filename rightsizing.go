@@ -0,0 +1,728 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Right-sizing tuning. Requests cover the p95 plus headroom; memory limits
+// cover the observed peak plus headroom because exceeding them is fatal.
+const (
+	cpuRequestHeadroom    = 1.15
+	cpuLimitHeadroom      = 1.5
+	memoryRequestHeadroom = 1.15
+	memoryLimitHeadroom   = 1.25
+	minCPURequestMillis   = 10
+	cpuRoundMillis        = 5
+	minMemoryBytes        = 16 << 20
+	memoryRoundBytes      = 1 << 20
+	// Changes smaller than this fraction of the current value are not worth a rollout
+	rightSizingTolerance = 0.1
+)
+
+// usageStats summarizes observed usage for one container across a workload's pods
+type usageStats struct {
+	Samples      int
+	Window       time.Duration
+	CPUP50Millis int64
+	CPUP95Millis int64
+	CPUMaxMillis int64
+	MemP50Bytes  int64
+	MemP95Bytes  int64
+	MemMaxBytes  int64
+}
+
+// usageHistory is a source of historical container usage
+type usageHistory interface {
+	name() string
+	containerStats(ctx context.Context, namespace string, pods []string, container string) (*usageStats, error)
+}
+
+// newUsageHistoryFromEnv prefers Prometheus when configured and otherwise
+// samples metrics-server in-process when USAGE_SAMPLER=on
+func newUsageHistoryFromEnv(prom *PrometheusClient, s *K8sDiagnosticsServer) (usageHistory, error) {
+	window, err := durationFromEnv("USAGE_HISTORY_WINDOW", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if prom != nil {
+		if window == 0 {
+			window = 7 * 24 * time.Hour
+		}
+		return &prometheusHistory{client: prom, window: window}, nil
+	}
+
+	if os.Getenv("USAGE_SAMPLER") != "on" || s.metrics == nil {
+		return nil, nil
+	}
+	if window == 0 {
+		window = 24 * time.Hour
+	}
+	interval, err := durationFromEnv("USAGE_SAMPLE_INTERVAL", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	maxContainers := defaultMaxSampledContainers
+	if value := os.Getenv("USAGE_SAMPLER_MAX_CONTAINERS"); value != "" {
+		if maxContainers, err = strconv.Atoi(value); err != nil || maxContainers <= 0 {
+			return nil, fmt.Errorf("invalid USAGE_SAMPLER_MAX_CONTAINERS %q", value)
+		}
+	}
+
+	sampler := &metricsSampler{
+		window:        window,
+		access:        s.access,
+		maxContainers: maxContainers,
+		samples:       make(map[containerKey][]usageSample),
+	}
+	go sampler.run(s, interval)
+	return sampler, nil
+}
+
+type containerKey struct {
+	Namespace string
+	Pod       string
+	Container string
+}
+
+type usageSample struct {
+	At          time.Time
+	CPUMillis   int64
+	MemoryBytes int64
+}
+
+// defaultMaxSampledContainers bounds the sampler's memory on large clusters
+const defaultMaxSampledContainers = 5000
+
+// metricsSampler periodically records metrics-server usage so percentiles
+// can be computed without an external time series database. It only tracks
+// namespaces the access policy serves, and at most maxContainers containers.
+type metricsSampler struct {
+	window        time.Duration
+	access        *AccessPolicy
+	maxContainers int
+
+	mu      sync.RWMutex
+	samples map[containerKey][]usageSample
+	// full is set while new containers are being skipped, to log only once
+	full bool
+}
+
+func (m *metricsSampler) name() string {
+	return "metrics-server samples"
+}
+
+func (m *metricsSampler) run(s *K8sDiagnosticsServer, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for ; ; <-ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		usage, err := s.fetchPodUsage(ctx, "")
+		cancel()
+		if err != nil {
+			log.Printf("Usage sampling failed: %v", err)
+			continue
+		}
+		m.record(usage, time.Now())
+	}
+}
+
+func (m *metricsSampler) record(usage map[podUsageKey]map[string]containerUsage, now time.Time) {
+	cutoff := now.Add(-m.window)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Drop samples that fell out of the window, and pods that are gone, first
+	// so their slots can go to new containers
+	for key, samples := range m.samples {
+		i := 0
+		for i < len(samples) && samples[i].At.Before(cutoff) {
+			i++
+		}
+		if i == len(samples) {
+			delete(m.samples, key)
+		} else if i > 0 {
+			m.samples[key] = append([]usageSample(nil), samples[i:]...)
+		}
+	}
+
+	skipped := 0
+	for pod, containers := range usage {
+		if m.access.checkNamespace(pod.Namespace) != nil {
+			continue
+		}
+		for container, u := range containers {
+			key := containerKey{Namespace: pod.Namespace, Pod: pod.Name, Container: container}
+			if _, tracked := m.samples[key]; !tracked && len(m.samples) >= m.maxContainers {
+				skipped++
+				continue
+			}
+			m.samples[key] = append(m.samples[key], usageSample{At: now, CPUMillis: u.CPUMillis, MemoryBytes: u.MemoryBytes})
+		}
+	}
+	if skipped > 0 && !m.full {
+		log.Printf("Usage sampler is tracking its maximum of %d containers (USAGE_SAMPLER_MAX_CONTAINERS); skipping %d new containers",
+			m.maxContainers, skipped)
+	}
+	m.full = skipped > 0
+}
+
+// sampleCount returns the number of samples held across all containers
+func (m *metricsSampler) sampleCount() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	count := 0
+	for _, samples := range m.samples {
+		count += len(samples)
+	}
+	return count
+}
+
+func (m *metricsSampler) containerStats(ctx context.Context, namespace string, pods []string, container string) (*usageStats, error) {
+	m.mu.RLock()
+	var cpu, memory []int64
+	var oldest time.Time
+	for _, pod := range pods {
+		for _, sample := range m.samples[containerKey{Namespace: namespace, Pod: pod, Container: container}] {
+			cpu = append(cpu, sample.CPUMillis)
+			memory = append(memory, sample.MemoryBytes)
+			if oldest.IsZero() || sample.At.Before(oldest) {
+				oldest = sample.At
+			}
+		}
+	}
+	m.mu.RUnlock()
+
+	stats := &usageStats{Samples: len(cpu)}
+	if stats.Samples == 0 {
+		return stats, nil
+	}
+	stats.Window = time.Since(oldest).Truncate(time.Minute)
+	stats.CPUP50Millis = percentile(cpu, 50)
+	stats.CPUP95Millis = percentile(cpu, 95)
+	stats.CPUMaxMillis = percentile(cpu, 100)
+	stats.MemP50Bytes = percentile(memory, 50)
+	stats.MemP95Bytes = percentile(memory, 95)
+	stats.MemMaxBytes = percentile(memory, 100)
+	return stats, nil
+}
+
+// percentile returns the nearest-rank percentile of values
+func percentile(values []int64, p float64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]int64(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// prometheusHistory reads usage percentiles from cAdvisor metrics in Prometheus
+type prometheusHistory struct {
+	client *PrometheusClient
+	window time.Duration
+}
+
+func (p *prometheusHistory) name() string {
+	return "prometheus"
+}
+
+func (p *prometheusHistory) containerStats(ctx context.Context, namespace string, pods []string, container string) (*usageStats, error) {
+	selector := promSelector(namespace, pods) + ",container=" + promQuote(container)
+	window := promDuration(p.window)
+	cpuRate := fmt.Sprintf(`rate(container_cpu_usage_seconds_total{%s}[5m])[%s:5m]`, selector, window)
+	memory := fmt.Sprintf(`container_memory_working_set_bytes{%s}[%s]`, selector, window)
+
+	queries := []struct {
+		query string
+		scale float64
+		dest  *int64
+	}{
+		{fmt.Sprintf(`max(quantile_over_time(0.5, %s))`, cpuRate), 1000, new(int64)},
+		{fmt.Sprintf(`max(quantile_over_time(0.95, %s))`, cpuRate), 1000, new(int64)},
+		{fmt.Sprintf(`max(max_over_time(%s))`, cpuRate), 1000, new(int64)},
+		{fmt.Sprintf(`max(quantile_over_time(0.5, %s))`, memory), 1, new(int64)},
+		{fmt.Sprintf(`max(quantile_over_time(0.95, %s))`, memory), 1, new(int64)},
+		{fmt.Sprintf(`max(max_over_time(%s))`, memory), 1, new(int64)},
+		{fmt.Sprintf(`sum(count_over_time(%s))`, memory), 1, new(int64)},
+	}
+
+	for _, q := range queries {
+		value, ok, err := p.client.QueryScalar(ctx, q.query)
+		if err != nil {
+			return nil, err
+		}
+		if ok && !math.IsNaN(value) {
+			*q.dest = int64(math.Ceil(value * q.scale))
+		}
+	}
+
+	return &usageStats{
+		Samples:      int(*queries[6].dest),
+		Window:       p.window,
+		CPUP50Millis: *queries[0].dest,
+		CPUP95Millis: *queries[1].dest,
+		CPUMaxMillis: *queries[2].dest,
+		MemP50Bytes:  *queries[3].dest,
+		MemP95Bytes:  *queries[4].dest,
+		MemMaxBytes:  *queries[5].dest,
+	}, nil
+}
+
+// RightSizingRecommendation is a per-container resource suggestion
+type RightSizingRecommendation struct {
+	Workload     ObjectRef          `json:"workload"`
+	Container    string             `json:"container"`
+	Replicas     int32              `json:"replicas"`
+	Source       string             `json:"source"`
+	Samples      int                `json:"samples"`
+	Window       string             `json:"window,omitempty"`
+	Observed     *ObservedUsage     `json:"observed,omitempty"`
+	Current      ResourceSettings   `json:"current"`
+	Recommended  *ResourceSettings  `json:"recommended,omitempty"`
+	Impact       *RightSizingImpact `json:"impact,omitempty"`
+	QoS          *QoSImpact         `json:"qos,omitempty"`
+	Patch        string             `json:"patch,omitempty"`
+	KubectlPatch string             `json:"kubectl_patch,omitempty"`
+	Note         string             `json:"note,omitempty"`
+}
+
+// ObservedUsage is the usage the recommendation is based on
+type ObservedUsage struct {
+	CPUP50Millis int64 `json:"cpu_p50_millicores"`
+	CPUP95Millis int64 `json:"cpu_p95_millicores"`
+	CPUMaxMillis int64 `json:"cpu_max_millicores"`
+	MemP50Bytes  int64 `json:"memory_p50_bytes"`
+	MemP95Bytes  int64 `json:"memory_p95_bytes"`
+	MemMaxBytes  int64 `json:"memory_max_bytes"`
+}
+
+// ResourceSettings are requests/limits in kubectl notation ("" when unset)
+type ResourceSettings struct {
+	CPURequest    string `json:"cpu_request,omitempty"`
+	CPULimit      string `json:"cpu_limit,omitempty"`
+	MemoryRequest string `json:"memory_request,omitempty"`
+	MemoryLimit   string `json:"memory_limit,omitempty"`
+}
+
+// RightSizingImpact is the effect of applying the recommendation to every replica.
+// Positive deltas free capacity; negative deltas reserve more.
+type RightSizingImpact struct {
+	CPURequestSavedMillis int64    `json:"cpu_request_saved_millicores"`
+	MemoryRequestSaved    int64    `json:"memory_request_saved_bytes"`
+	Summary               string   `json:"summary"`
+	Risks                 []string `json:"risks,omitempty"`
+}
+
+// QoSImpact shows how the pod QoS class changes
+type QoSImpact struct {
+	Current     corev1.PodQOSClass `json:"current"`
+	Recommended corev1.PodQOSClass `json:"recommended"`
+	Note        string             `json:"note,omitempty"`
+}
+
+// minRightSizingSamples is the minimum number of samples before recommending changes
+func minRightSizingSamples() int {
+	if value, err := strconv.Atoi(os.Getenv("RIGHTSIZING_MIN_SAMPLES")); err == nil && value > 0 {
+		return value
+	}
+	return 30
+}
+
+// rightSizeDeployment builds recommendations for every container of a
+// deployment, with a finding for each container that should change
+func (s *K8sDiagnosticsServer) rightSizeDeployment(ctx context.Context, deployment *appsv1.Deployment) ([]RightSizingRecommendation, []Finding, error) {
+	if s.usage == nil {
+		return nil, nil, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid selector on deployment %s: %w", deployment.Name, err)
+	}
+	pods, err := s.clientset.CoreV1().Pods(deployment.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, nil, err
+	}
+	var podNames []string
+	for _, pod := range pods.Items {
+		podNames = append(podNames, pod.Name)
+	}
+	if len(podNames) == 0 {
+		return nil, nil, nil
+	}
+
+	var recommendations []RightSizingRecommendation
+	var findings []Finding
+	for i, container := range deployment.Spec.Template.Spec.Containers {
+		stats, err := s.usage.containerStats(ctx, deployment.Namespace, podNames, container.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		rec, finding := rightSizeContainer(deployment, i, stats, s.usage.name())
+		recommendations = append(recommendations, rec)
+		if finding != nil {
+			findings = append(findings, *finding)
+		}
+	}
+
+	return recommendations, findings, nil
+}
+
+// rightSizeContainer compares container i of a deployment with its observed
+// usage. The finding is nil when no change is recommended.
+func rightSizeContainer(deployment *appsv1.Deployment, i int, stats *usageStats, source string) (RightSizingRecommendation, *Finding) {
+	template := deployment.Spec.Template.Spec
+	container := template.Containers[i]
+
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+
+	rec := RightSizingRecommendation{
+		Workload:  ObjectRef{Kind: "Deployment", Namespace: deployment.Namespace, Name: deployment.Name},
+		Container: container.Name,
+		Replicas:  replicas,
+		Source:    source,
+		Samples:   stats.Samples,
+		Current:   resourceSettings(container.Resources),
+	}
+	if stats.Window > 0 {
+		rec.Window = stats.Window.String()
+	}
+
+	if minSamples := minRightSizingSamples(); stats.Samples < minSamples {
+		rec.Note = fmt.Sprintf("Insufficient usage data: %d samples, need at least %d", stats.Samples, minSamples)
+		return rec, nil
+	}
+
+	rec.Observed = &ObservedUsage{
+		CPUP50Millis: stats.CPUP50Millis,
+		CPUP95Millis: stats.CPUP95Millis,
+		CPUMaxMillis: stats.CPUMaxMillis,
+		MemP50Bytes:  stats.MemP50Bytes,
+		MemP95Bytes:  stats.MemP95Bytes,
+		MemMaxBytes:  stats.MemMaxBytes,
+	}
+
+	recommended := recommendResources(container.Resources, stats)
+	if !resourcesDiffer(container.Resources, recommended) {
+		rec.Note = "Current requests and limits match observed usage"
+		return rec, nil
+	}
+
+	settings := resourceSettings(recommended)
+	rec.Recommended = &settings
+	rec.Impact = rightSizingImpact(container.Resources, recommended, stats, replicas)
+	rec.QoS = qosImpact(template, i, recommended)
+	rec.Patch, rec.KubectlPatch = resourcePatch(deployment, container.Name, recommended)
+
+	def := findingOverProvisioned
+	if raisesResources(container.Resources, recommended) {
+		def = findingUnderProvisioned
+	}
+	evidence := map[string]string{
+		"cpu_p95":    formatMillis(stats.CPUP95Millis),
+		"memory_p95": formatBytes(stats.MemP95Bytes),
+		"memory_max": formatBytes(stats.MemMaxBytes),
+		"samples":    strconv.Itoa(stats.Samples),
+	}
+	if len(rec.Impact.Risks) > 0 {
+		evidence["risks"] = strings.Join(rec.Impact.Risks, "; ")
+	}
+	ref := rec.Workload
+	ref.Container = container.Name
+	finding := newFinding(def, ref,
+		fmt.Sprintf("Container %s in deployment %s/%s: %s",
+			container.Name, deployment.Namespace, deployment.Name, rec.Impact.Summary),
+		evidence)
+
+	return rec, &finding
+}
+
+// recommendResources derives requests/limits from observed usage. CPU limits
+// are only recommended when the container already has one.
+func recommendResources(current corev1.ResourceRequirements, stats *usageStats) corev1.ResourceRequirements {
+	cpuRequest := roundUp(int64(float64(stats.CPUP95Millis)*cpuRequestHeadroom), cpuRoundMillis, minCPURequestMillis)
+	memRequest := roundUp(int64(float64(stats.MemP95Bytes)*memoryRequestHeadroom), memoryRoundBytes, minMemoryBytes)
+	memLimit := roundUp(int64(float64(stats.MemMaxBytes)*memoryLimitHeadroom), memoryRoundBytes, minMemoryBytes)
+	if memLimit < memRequest {
+		memLimit = memRequest
+	}
+
+	recommended := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    *resource.NewMilliQuantity(cpuRequest, resource.DecimalSI),
+			corev1.ResourceMemory: *resource.NewQuantity(memRequest, resource.BinarySI),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceMemory: *resource.NewQuantity(memLimit, resource.BinarySI),
+		},
+	}
+
+	if _, ok := current.Limits[corev1.ResourceCPU]; ok {
+		cpuLimit := roundUp(int64(float64(stats.CPUMaxMillis)*cpuLimitHeadroom), cpuRoundMillis, minCPURequestMillis)
+		if cpuLimit < cpuRequest {
+			cpuLimit = cpuRequest
+		}
+		recommended.Limits[corev1.ResourceCPU] = *resource.NewMilliQuantity(cpuLimit, resource.DecimalSI)
+	}
+
+	// Keep any other resources (ephemeral-storage, extended resources) untouched
+	for name, q := range current.Requests {
+		if name != corev1.ResourceCPU && name != corev1.ResourceMemory {
+			recommended.Requests[name] = q
+		}
+	}
+	for name, q := range current.Limits {
+		if name != corev1.ResourceCPU && name != corev1.ResourceMemory {
+			recommended.Limits[name] = q
+		}
+	}
+
+	return recommended
+}
+
+func roundUp(value, step, minimum int64) int64 {
+	if value < minimum {
+		return minimum
+	}
+	return (value + step - 1) / step * step
+}
+
+// resourcesDiffer reports whether any CPU/memory value moves by more than the tolerance
+func resourcesDiffer(current, recommended corev1.ResourceRequirements) bool {
+	differs := func(a, b int64) bool {
+		if a == 0 {
+			return b != 0
+		}
+		return math.Abs(float64(b-a))/float64(a) > rightSizingTolerance
+	}
+	return differs(quantityMillis(current.Requests, corev1.ResourceCPU), quantityMillis(recommended.Requests, corev1.ResourceCPU)) ||
+		differs(quantityMillis(current.Limits, corev1.ResourceCPU), quantityMillis(recommended.Limits, corev1.ResourceCPU)) ||
+		differs(quantityValue(current.Requests, corev1.ResourceMemory), quantityValue(recommended.Requests, corev1.ResourceMemory)) ||
+		differs(quantityValue(current.Limits, corev1.ResourceMemory), quantityValue(recommended.Limits, corev1.ResourceMemory))
+}
+
+// raisesResources reports whether the recommendation raises any CPU/memory
+// value by more than the tolerance. A missing request counts as raised; a
+// missing limit does not, since adding one constrains the container.
+func raisesResources(current, recommended corev1.ResourceRequirements) bool {
+	raisesRequest := func(a, b int64) bool {
+		return float64(b) > float64(a)*(1+rightSizingTolerance)
+	}
+	raisesLimit := func(a, b int64) bool {
+		return a > 0 && raisesRequest(a, b)
+	}
+	return raisesRequest(quantityMillis(current.Requests, corev1.ResourceCPU), quantityMillis(recommended.Requests, corev1.ResourceCPU)) ||
+		raisesLimit(quantityMillis(current.Limits, corev1.ResourceCPU), quantityMillis(recommended.Limits, corev1.ResourceCPU)) ||
+		raisesRequest(quantityValue(current.Requests, corev1.ResourceMemory), quantityValue(recommended.Requests, corev1.ResourceMemory)) ||
+		raisesLimit(quantityValue(current.Limits, corev1.ResourceMemory), quantityValue(recommended.Limits, corev1.ResourceMemory))
+}
+
+func resourceSettings(r corev1.ResourceRequirements) ResourceSettings {
+	var settings ResourceSettings
+	if q, ok := r.Requests[corev1.ResourceCPU]; ok {
+		settings.CPURequest = q.String()
+	}
+	if q, ok := r.Limits[corev1.ResourceCPU]; ok {
+		settings.CPULimit = q.String()
+	}
+	if q, ok := r.Requests[corev1.ResourceMemory]; ok {
+		settings.MemoryRequest = q.String()
+	}
+	if q, ok := r.Limits[corev1.ResourceMemory]; ok {
+		settings.MemoryLimit = q.String()
+	}
+	return settings
+}
+
+func rightSizingImpact(current, recommended corev1.ResourceRequirements, stats *usageStats, replicas int32) *RightSizingImpact {
+	cpuDelta := quantityMillis(current.Requests, corev1.ResourceCPU) - quantityMillis(recommended.Requests, corev1.ResourceCPU)
+	memDelta := quantityValue(current.Requests, corev1.ResourceMemory) - quantityValue(recommended.Requests, corev1.ResourceMemory)
+
+	impact := &RightSizingImpact{
+		CPURequestSavedMillis: cpuDelta * int64(replicas),
+		MemoryRequestSaved:    memDelta * int64(replicas),
+	}
+
+	if cpuRequest := quantityMillis(current.Requests, corev1.ResourceCPU); cpuRequest == 0 {
+		impact.Risks = append(impact.Risks, "no CPU request: the pod can be starved under node contention")
+	} else if cpuRequest < stats.CPUP95Millis {
+		impact.Risks = append(impact.Risks, fmt.Sprintf("CPU request %s is below p95 usage %s: expect contention and throttling",
+			formatMillis(cpuRequest), formatMillis(stats.CPUP95Millis)))
+	}
+	if cpuLimit := quantityMillis(current.Limits, corev1.ResourceCPU); cpuLimit > 0 && cpuLimit < stats.CPUMaxMillis {
+		impact.Risks = append(impact.Risks, fmt.Sprintf("CPU limit %s is below peak usage %s: the container is being throttled",
+			formatMillis(cpuLimit), formatMillis(stats.CPUMaxMillis)))
+	}
+	if memRequest := quantityValue(current.Requests, corev1.ResourceMemory); memRequest == 0 {
+		impact.Risks = append(impact.Risks, "no memory request: the pod is first in line for eviction under memory pressure")
+	} else if memRequest < stats.MemP95Bytes {
+		impact.Risks = append(impact.Risks, fmt.Sprintf("memory request %s is below p95 usage %s: eviction risk under node memory pressure",
+			formatBytes(memRequest), formatBytes(stats.MemP95Bytes)))
+	}
+	if memLimit := quantityValue(current.Limits, corev1.ResourceMemory); memLimit == 0 {
+		impact.Risks = append(impact.Risks, "no memory limit: a leak can exhaust node memory")
+	} else if float64(memLimit) < float64(stats.MemMaxBytes)*1.1 {
+		impact.Risks = append(impact.Risks, fmt.Sprintf("memory limit %s is within 10%% of peak usage %s: OOM kill risk",
+			formatBytes(memLimit), formatBytes(stats.MemMaxBytes)))
+	}
+
+	var parts []string
+	switch {
+	case cpuDelta > 0:
+		parts = append(parts, fmt.Sprintf("frees %s CPU", formatMillis(impact.CPURequestSavedMillis)))
+	case cpuDelta < 0:
+		parts = append(parts, fmt.Sprintf("reserves %s more CPU", formatMillis(-impact.CPURequestSavedMillis)))
+	}
+	switch {
+	case memDelta > 0:
+		parts = append(parts, fmt.Sprintf("frees %s memory", formatBytes(impact.MemoryRequestSaved)))
+	case memDelta < 0:
+		parts = append(parts, fmt.Sprintf("reserves %s more memory", formatBytes(-impact.MemoryRequestSaved)))
+	}
+	if len(parts) == 0 {
+		parts = append(parts, "adjusts limits only")
+	}
+	impact.Summary = fmt.Sprintf("right-sizing %s across %d replica(s)", strings.Join(parts, " and "), replicas)
+	if len(impact.Risks) > 0 {
+		impact.Summary += " and removes " + strconv.Itoa(len(impact.Risks)) + " risk(s)"
+	}
+
+	return impact
+}
+
+// qosImpact computes the pod QoS class before and after replacing the
+// resources of container index i
+func qosImpact(template corev1.PodSpec, i int, recommended corev1.ResourceRequirements) *QoSImpact {
+	updated := template.DeepCopy()
+	updated.Containers[i].Resources = recommended
+
+	impact := &QoSImpact{
+		Current:     podQOSClass(&template),
+		Recommended: podQOSClass(updated),
+	}
+	if impact.Current != impact.Recommended {
+		impact.Note = fmt.Sprintf("QoS class changes from %s to %s", impact.Current, impact.Recommended)
+		if impact.Current == corev1.PodQOSGuaranteed {
+			impact.Note += ": pods lose eviction protection; set limits equal to requests to stay Guaranteed"
+		}
+	}
+	return impact
+}
+
+// podQOSClass mirrors the kubelet's QoS classification for CPU and memory
+func podQOSClass(spec *corev1.PodSpec) corev1.PodQOSClass {
+	containers := append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+	anySet := false
+	guaranteed := true
+
+	for _, c := range containers {
+		for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+			request, hasRequest := c.Resources.Requests[name]
+			limit, hasLimit := c.Resources.Limits[name]
+			if hasRequest || hasLimit {
+				anySet = true
+			}
+			if !hasLimit || (hasRequest && request.Cmp(limit) != 0) {
+				guaranteed = false
+			}
+		}
+	}
+
+	switch {
+	case !anySet:
+		return corev1.PodQOSBestEffort
+	case guaranteed:
+		return corev1.PodQOSGuaranteed
+	default:
+		return corev1.PodQOSBurstable
+	}
+}
+
+// resourcePatch renders a strategic merge patch for one container, as YAML and as a kubectl command
+func resourcePatch(deployment *appsv1.Deployment, container string, resources corev1.ResourceRequirements) (string, string) {
+	patch := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []map[string]interface{}{
+						{"name": container, "resources": resources},
+					},
+				},
+			},
+		},
+	}
+	jsonBytes, _ := json.Marshal(patch)
+
+	yamlPatch := fmt.Sprintf(`spec:
+  template:
+    spec:
+      containers:
+      - name: %s
+        resources:
+%s`, container, indentResources(resources))
+
+	kubectl := fmt.Sprintf("kubectl -n %s patch deployment %s --type strategic -p '%s'",
+		deployment.Namespace, deployment.Name, string(jsonBytes))
+	return yamlPatch, kubectl
+}
+
+func indentResources(resources corev1.ResourceRequirements) string {
+	var b strings.Builder
+	write := func(section string, list corev1.ResourceList) {
+		if len(list) == 0 {
+			return
+		}
+		names := make([]string, 0, len(list))
+		for name := range list {
+			names = append(names, string(name))
+		}
+		sort.Strings(names)
+		fmt.Fprintf(&b, "          %s:\n", section)
+		for _, name := range names {
+			q := list[corev1.ResourceName(name)]
+			fmt.Fprintf(&b, "            %s: %q\n", name, q.String())
+		}
+	}
+	write("requests", resources.Requests)
+	write("limits", resources.Limits)
+	return b.String()
+}
+
+// formatMillis renders millicores like kubectl ("250m", "2")
+func formatMillis(millis int64) string {
+	return resource.NewMilliQuantity(millis, resource.DecimalSI).String()
+}
+
+// formatBytes renders bytes like kubectl ("128Mi"), rounded to whole Mi above 1Mi
+func formatBytes(bytes int64) string {
+	if bytes >= 1<<20 {
+		bytes = int64(math.Round(float64(bytes)/(1<<20))) << 20
+	}
+	return resource.NewQuantity(bytes, resource.BinarySI).String()
+}
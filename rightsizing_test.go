@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestRaisesResources(t *testing.T) {
+	tests := []struct {
+		name                 string
+		current, recommended corev1.ResourceRequirements
+		want                 bool
+	}{
+		{
+			name:        "lower requests while adding a missing memory limit",
+			current:     testResources("1", "1Gi", "", ""),
+			recommended: testResources("200m", "256Mi", "", "512Mi"),
+			want:        false,
+		},
+		{
+			name:        "raise the CPU request",
+			current:     testResources("100m", "1Gi", "", "1Gi"),
+			recommended: testResources("400m", "512Mi", "", "768Mi"),
+			want:        true,
+		},
+		{
+			name:        "missing memory request",
+			current:     testResources("500m", "", "", ""),
+			recommended: testResources("200m", "128Mi", "", "256Mi"),
+			want:        true,
+		},
+		{
+			name:        "raise an existing memory limit",
+			current:     testResources("500m", "1Gi", "", "1Gi"),
+			recommended: testResources("200m", "512Mi", "", "2Gi"),
+			want:        true,
+		},
+		{
+			name:        "increase within tolerance",
+			current:     testResources("1", "1Gi", "2", "2Gi"),
+			recommended: testResources("1050m", "512Mi", "2100m", "1Gi"),
+			want:        false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := raisesResources(tt.current, tt.recommended); got != tt.want {
+				t.Errorf("raisesResources() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricsSamplerRecord(t *testing.T) {
+	access, err := newAccessPolicy([]string{"team-*"}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sampler := &metricsSampler{window: time.Hour, access: access, maxContainers: 2, samples: make(map[containerKey][]usageSample)}
+	sample := map[string]containerUsage{"app": {CPUMillis: 100, MemoryBytes: 1 << 20}}
+	now := time.Now()
+
+	sampler.record(map[podUsageKey]map[string]containerUsage{
+		{Namespace: "team-a", Name: "web-1"}:      sample,
+		{Namespace: "payments", Name: "ledger-1"}: sample,
+	}, now)
+	sampler.record(map[podUsageKey]map[string]containerUsage{
+		{Namespace: "team-a", Name: "web-1"}: sample,
+		{Namespace: "team-a", Name: "web-2"}: sample,
+		{Namespace: "team-b", Name: "api-1"}: sample,
+	}, now.Add(time.Minute))
+
+	if _, ok := sampler.samples[containerKey{Namespace: "payments", Pod: "ledger-1", Container: "app"}]; ok {
+		t.Error("sampled a namespace outside ALLOWED_NAMESPACES")
+	}
+	if len(sampler.samples) != 2 {
+		t.Errorf("expected the cap of 2 containers, got %d", len(sampler.samples))
+	}
+	if got := len(sampler.samples[containerKey{Namespace: "team-a", Pod: "web-1", Container: "app"}]); got != 2 {
+		t.Errorf("expected a tracked container to keep sampling at the cap, got %d samples", got)
+	}
+
+	// Once web-1 ages out of the window its slot goes to a new container
+	sampler.record(map[podUsageKey]map[string]containerUsage{
+		{Namespace: "team-b", Name: "api-1"}: sample,
+	}, now.Add(time.Hour+90*time.Second))
+	if _, ok := sampler.samples[containerKey{Namespace: "team-b", Pod: "api-1", Container: "app"}]; !ok {
+		t.Errorf("expected api-1 to be tracked after older containers expired, got %v", sampler.samples)
+	}
+}
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// Rule kinds that the engine can evaluate
+const (
+	RuleKindPod        = "Pod"
+	RuleKindDeployment = "Deployment"
+	RuleKindNode       = "Node"
+)
+
+// Rules may come from a ConfigMap that namespace users can edit, so each
+// evaluation is bounded like kube-apiserver bounds CRD validation rules
+const (
+	// ruleCostLimit is the CEL runtime cost one rule may spend on one object
+	ruleCostLimit = 1000000
+	// ruleInterruptCheckFrequency is how many comprehension iterations run
+	// between checks of the request context
+	ruleInterruptCheckFrequency = 100
+)
+
+// Rule is a declarative diagnostic. The finding fires when Expression
+// evaluates to true against the object.
+type Rule struct {
+	ID         string   `json:"id"`
+	Kind       string   `json:"kind"`
+	Namespaces []string `json:"namespaces,omitempty"`
+	Expression string   `json:"expression"`
+	Severity   Severity `json:"severity,omitempty"`
+	Category   string   `json:"category,omitempty"`
+	Message    string   `json:"message"`
+	Suggestion string   `json:"suggestion,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+}
+
+// RuleSet is the on-disk (or ConfigMap) rules document
+type RuleSet struct {
+	Rules []Rule `json:"rules"`
+}
+
+// RuleEvaluation is the result of running the rules over a namespace
+type RuleEvaluation struct {
+	Namespace        string          `json:"namespace"`
+	RulesLoaded      int             `json:"rules_loaded"`
+	ObjectsEvaluated int             `json:"objects_evaluated"`
+	Findings         []Finding       `json:"findings"`
+	Summary          *FindingSummary `json:"summary,omitempty"`
+	Errors           []string        `json:"errors,omitempty"`
+}
+
+type compiledRule struct {
+	Rule
+	program  cel.Program
+	message  *template.Template
+	usesPDBs bool
+}
+
+// ruleMessageData is what message templates can reference
+type ruleMessageData struct {
+	Kind      string
+	Name      string
+	Namespace string
+	Object    map[string]interface{}
+}
+
+// RuleEngine compiles rules from files and/or a ConfigMap and evaluates them
+type RuleEngine struct {
+	paths     []string
+	configMap string // namespace/name
+
+	env *cel.Env
+
+	mu       sync.RWMutex
+	rules    []*compiledRule
+	loadedAt time.Time
+}
+
+func newRuleEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("object", cel.DynType),
+		cel.Variable("kind", cel.StringType),
+		cel.Variable("namespace", cel.StringType),
+		cel.Variable("pdbs", cel.ListType(cel.DynType)),
+	)
+}
+
+// newRuleEngineFromEnv builds the rule engine from RULES_PATH (a file or a
+// directory of .yaml/.yml/.json files, comma separated) and RULES_CONFIGMAP
+// (namespace/name). Rules are reloaded every RULES_RELOAD_INTERVAL (default: 60s).
+func newRuleEngineFromEnv(ctx context.Context, clientset kubernetes.Interface) (*RuleEngine, error) {
+	engine := &RuleEngine{configMap: os.Getenv("RULES_CONFIGMAP")}
+	for _, p := range strings.Split(os.Getenv("RULES_PATH"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			engine.paths = append(engine.paths, p)
+		}
+	}
+
+	env, err := newRuleEnv()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CEL environment: %w", err)
+	}
+	engine.env = env
+
+	if len(engine.paths) == 0 && engine.configMap == "" {
+		return engine, nil
+	}
+
+	if err := engine.reload(ctx, clientset); err != nil {
+		return nil, err
+	}
+
+	interval, err := durationFromEnv("RULES_RELOAD_INTERVAL", time.Minute)
+	if err != nil {
+		return nil, err
+	}
+	go engine.watch(clientset, interval)
+
+	return engine, nil
+}
+
+func (e *RuleEngine) watch(clientset kubernetes.Interface, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := e.reload(ctx, clientset); err != nil {
+			log.Printf("Rules reload failed, keeping previous rules: %v", err)
+		}
+		cancel()
+	}
+}
+
+// reload reads every rule source and swaps in the new rules only if all of them compile
+func (e *RuleEngine) reload(ctx context.Context, clientset kubernetes.Interface) error {
+	var documents []ruleDocument
+
+	for _, p := range e.paths {
+		docs, err := readRuleFiles(p)
+		if err != nil {
+			return err
+		}
+		documents = append(documents, docs...)
+	}
+
+	if e.configMap != "" {
+		docs, err := readRuleConfigMap(ctx, clientset, e.configMap)
+		if err != nil {
+			return err
+		}
+		documents = append(documents, docs...)
+	}
+
+	var rules []*compiledRule
+	seen := make(map[string]string)
+
+	for _, doc := range documents {
+		var set RuleSet
+		if err := yaml.UnmarshalStrict(doc.data, &set); err != nil {
+			return fmt.Errorf("failed to parse rules from %s: %w", doc.source, err)
+		}
+		for _, rule := range set.Rules {
+			if source, ok := seen[rule.ID]; ok {
+				return fmt.Errorf("duplicate rule id %q in %s (first defined in %s)", rule.ID, doc.source, source)
+			}
+			seen[rule.ID] = doc.source
+
+			compiled, err := e.compile(rule)
+			if err != nil {
+				return fmt.Errorf("rule %q in %s: %w", rule.ID, doc.source, err)
+			}
+			rules = append(rules, compiled)
+		}
+	}
+
+	e.mu.Lock()
+	e.rules = rules
+	e.loadedAt = time.Now()
+	e.mu.Unlock()
+
+	return nil
+}
+
+func (e *RuleEngine) compile(rule Rule) (*compiledRule, error) {
+	if rule.ID == "" {
+		return nil, fmt.Errorf("id is required")
+	}
+	switch rule.Kind {
+	case RuleKindPod, RuleKindDeployment, RuleKindNode:
+	default:
+		return nil, fmt.Errorf("unsupported kind %q (expected Pod, Deployment or Node)", rule.Kind)
+	}
+	switch rule.Severity {
+	case "":
+		rule.Severity = SeverityWarning
+	case SeverityCritical, SeverityWarning, SeverityInfo:
+	default:
+		return nil, fmt.Errorf("unsupported severity %q (expected critical, warning or info)", rule.Severity)
+	}
+	if rule.Category == "" {
+		rule.Category = CategoryCustom
+	}
+	if rule.Message == "" {
+		return nil, fmt.Errorf("message is required")
+	}
+	for _, pattern := range rule.Namespaces {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid namespace pattern %q: %w", pattern, err)
+		}
+	}
+
+	ast, issues := e.env.Compile(rule.Expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("invalid expression: %w", issues.Err())
+	}
+	if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+		return nil, fmt.Errorf("expression must evaluate to bool, got %s", ast.OutputType())
+	}
+	program, err := e.env.Program(ast,
+		cel.CostLimit(ruleCostLimit),
+		cel.InterruptCheckFrequency(ruleInterruptCheckFrequency))
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression: %w", err)
+	}
+
+	message, err := template.New(rule.ID).Option("missingkey=zero").Parse(rule.Message)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message template: %w", err)
+	}
+
+	usesPDBs := false
+	for _, ref := range ast.NativeRep().ReferenceMap() {
+		if ref.Name == "pdbs" {
+			usesPDBs = true
+			break
+		}
+	}
+
+	return &compiledRule{Rule: rule, program: program, message: message, usesPDBs: usesPDBs}, nil
+}
+
+type ruleDocument struct {
+	source string
+	data   []byte
+}
+
+func readRuleFiles(p string) ([]ruleDocument, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules: %w", err)
+	}
+
+	files := []string{p}
+	if info.IsDir() {
+		files = nil
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rules directory: %w", err)
+		}
+		for _, entry := range entries {
+			switch filepath.Ext(entry.Name()) {
+			case ".yaml", ".yml", ".json":
+				if !entry.IsDir() {
+					files = append(files, filepath.Join(p, entry.Name()))
+				}
+			}
+		}
+	}
+
+	var documents []ruleDocument
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rules: %w", err)
+		}
+		documents = append(documents, ruleDocument{source: file, data: data})
+	}
+	return documents, nil
+}
+
+func readRuleConfigMap(ctx context.Context, clientset kubernetes.Interface, ref string) ([]ruleDocument, error) {
+	namespace, name, ok := strings.Cut(ref, "/")
+	if !ok || namespace == "" || name == "" {
+		return nil, fmt.Errorf("invalid RULES_CONFIGMAP %q, expected namespace/name", ref)
+	}
+
+	configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules ConfigMap %s: %w", ref, err)
+	}
+
+	keys := make([]string, 0, len(configMap.Data))
+	for key := range configMap.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var documents []ruleDocument
+	for _, key := range keys {
+		documents = append(documents, ruleDocument{
+			source: fmt.Sprintf("configmap/%s[%s]", ref, key),
+			data:   []byte(configMap.Data[key]),
+		})
+	}
+	return documents, nil
+}
+
+// rulesFor returns the rules that apply to a kind in a namespace
+func (e *RuleEngine) rulesFor(kind, namespace string) []*compiledRule {
+	if e == nil {
+		return nil
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var rules []*compiledRule
+	for _, rule := range e.rules {
+		if rule.Kind != kind {
+			continue
+		}
+		if len(rule.Namespaces) > 0 && !matchesAnyPattern(namespace, rule.Namespaces) {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// RuleCount returns the number of loaded rules
+func (e *RuleEngine) RuleCount() int {
+	if e == nil {
+		return 0
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return len(e.rules)
+}
+
+func matchesAnyPattern(value string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluate runs the given rules against an object. pdbs is only consulted
+// by rules that reference it.
+func (e *RuleEngine) evaluate(ctx context.Context, rules []*compiledRule, kind string, obj metav1.Object, raw runtime.Object, pdbs []interface{}) ([]Finding, []string) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(raw)
+	if err != nil {
+		return nil, []string{fmt.Sprintf("%s %s: %v", kind, obj.GetName(), err)}
+	}
+	if pdbs == nil {
+		pdbs = []interface{}{}
+	}
+
+	activation := map[string]interface{}{
+		"object":    object,
+		"kind":      kind,
+		"namespace": obj.GetNamespace(),
+		"pdbs":      pdbs,
+	}
+
+	var findings []Finding
+	var errs []string
+
+	for _, rule := range rules {
+		out, _, err := rule.program.ContextEval(ctx, activation)
+		if ctx.Err() != nil {
+			errs = append(errs, fmt.Sprintf("rule %s on %s %s: %v", rule.ID, kind, obj.GetName(), ctx.Err()))
+			break
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("rule %s on %s %s: %v", rule.ID, kind, obj.GetName(), err))
+			continue
+		}
+		matched, ok := out.Value().(bool)
+		if !ok {
+			errs = append(errs, fmt.Sprintf("rule %s on %s %s: expression returned %T, not bool", rule.ID, kind, obj.GetName(), out.Value()))
+			continue
+		}
+		if !matched {
+			continue
+		}
+
+		var message bytes.Buffer
+		data := ruleMessageData{Kind: kind, Name: obj.GetName(), Namespace: obj.GetNamespace(), Object: object}
+		if err := rule.message.Execute(&message, data); err != nil {
+			errs = append(errs, fmt.Sprintf("rule %s message: %v", rule.ID, err))
+			message.Reset()
+			message.WriteString(rule.Message)
+		}
+
+		finding := Finding{
+			ID:       rule.ID,
+			Severity: rule.Severity,
+			Category: rule.Category,
+			Object:   ObjectRef{Kind: kind, Namespace: obj.GetNamespace(), Name: obj.GetName()},
+			Message:  message.String(),
+			Tags:     rule.Tags,
+		}
+		if rule.Suggestion != "" {
+			finding.Remediation = &Remediation{ID: rule.ID, Description: rule.Suggestion}
+		}
+		findings = append(findings, finding)
+	}
+
+	return findings, errs
+}
+
+func needsPDBs(rules []*compiledRule) bool {
+	for _, rule := range rules {
+		if rule.usesPDBs {
+			return true
+		}
+	}
+	return false
+}
+
+// matchingPDBs returns the PodDisruptionBudgets in the namespace whose
+// selector matches podLabels, converted for CEL
+func matchingPDBs(pdbs []policyv1.PodDisruptionBudget, podLabels map[string]string) []interface{} {
+	matches := []interface{}{}
+	for i := range pdbs {
+		selector, err := metav1.LabelSelectorAsSelector(pdbs[i].Spec.Selector)
+		if err != nil || selector.Empty() {
+			continue
+		}
+		if !selector.Matches(labels.Set(podLabels)) {
+			continue
+		}
+		object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&pdbs[i])
+		if err == nil {
+			matches = append(matches, object)
+		}
+	}
+	return matches
+}
+
+func (s *K8sDiagnosticsServer) listPDBs(ctx context.Context, namespace string) ([]policyv1.PodDisruptionBudget, error) {
+	pdbs, err := s.clientset.PolicyV1().PodDisruptionBudgets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return pdbs.Items, nil
+}
+
+// evaluatePodRules runs the Pod rules against a single pod
+func (s *K8sDiagnosticsServer) evaluatePodRules(ctx context.Context, pod *corev1.Pod) ([]Finding, []string) {
+	rules := s.rules.rulesFor(RuleKindPod, pod.Namespace)
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	var pdbs []interface{}
+	if needsPDBs(rules) {
+		items, err := s.listPDBs(ctx, pod.Namespace)
+		if err != nil {
+			return nil, []string{fmt.Sprintf("failed to list PodDisruptionBudgets: %v", err)}
+		}
+		pdbs = matchingPDBs(items, pod.Labels)
+	}
+
+	return s.rules.evaluate(ctx, rules, RuleKindPod, pod, pod, pdbs)
+}
+
+// evaluateDeploymentRules runs the Deployment rules for a deployment's
+// namespace, returning the findings and any evaluation errors
+func (s *K8sDiagnosticsServer) evaluateDeploymentRules(ctx context.Context, deployment *appsv1.Deployment) ([]Finding, []string) {
+	rules := s.rules.rulesFor(RuleKindDeployment, deployment.Namespace)
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	var pdbs []interface{}
+	if needsPDBs(rules) {
+		items, err := s.listPDBs(ctx, deployment.Namespace)
+		if err != nil {
+			return nil, []string{fmt.Sprintf("failed to list PodDisruptionBudgets: %v", err)}
+		}
+		pdbs = matchingPDBs(items, deployment.Spec.Template.Labels)
+	}
+
+	return s.rules.evaluate(ctx, rules, RuleKindDeployment, deployment, deployment, pdbs)
+}
+
+// evaluateRules runs every loaded rule against the Pods and Deployments in a
+// namespace ("all" for every namespace, which also includes Nodes)
+func (s *K8sDiagnosticsServer) evaluateRules(ctx context.Context, namespace string) (*RuleEvaluation, error) {
+	if err := s.access.checkNamespace(namespace); err != nil {
+		return nil, err
+	}
+
+	listNamespace := namespace
+	if namespace == "all" {
+		listNamespace = ""
+	}
+
+	result := &RuleEvaluation{
+		Namespace:   namespace,
+		RulesLoaded: s.rules.RuleCount(),
+		Findings:    []Finding{},
+	}
+	if result.RulesLoaded == 0 {
+		return result, nil
+	}
+
+	pdbsByNamespace := make(map[string][]policyv1.PodDisruptionBudget)
+	pdbsFor := func(ns string, podLabels map[string]string) []interface{} {
+		items, ok := pdbsByNamespace[ns]
+		if !ok {
+			var err error
+			items, err = s.listPDBs(ctx, ns)
+			if err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("failed to list PodDisruptionBudgets in %s: %v", ns, err))
+			}
+			pdbsByNamespace[ns] = items
+		}
+		return matchingPDBs(items, podLabels)
+	}
+
+	collect := func(findings []Finding, errs []string) {
+		result.ObjectsEvaluated++
+		result.Findings = append(result.Findings, findings...)
+		result.Errors = append(result.Errors, errs...)
+	}
+
+	pods, err := s.clientset.CoreV1().Pods(listNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pods.Items = s.access.filterPods(pods.Items)
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		rules := s.rules.rulesFor(RuleKindPod, pod.Namespace)
+		var pdbs []interface{}
+		if needsPDBs(rules) {
+			pdbs = pdbsFor(pod.Namespace, pod.Labels)
+		}
+		collect(s.rules.evaluate(ctx, rules, RuleKindPod, pod, pod, pdbs))
+	}
+
+	deployments, err := s.clientset.AppsV1().Deployments(listNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range deployments.Items {
+		deployment := &deployments.Items[i]
+		if !s.access.allows(deployment.Namespace, deployment.Labels) {
+			continue
+		}
+		rules := s.rules.rulesFor(RuleKindDeployment, deployment.Namespace)
+		var pdbs []interface{}
+		if needsPDBs(rules) {
+			pdbs = pdbsFor(deployment.Namespace, deployment.Spec.Template.Labels)
+		}
+		collect(s.rules.evaluate(ctx, rules, RuleKindDeployment, deployment, deployment, pdbs))
+	}
+
+	if listNamespace == "" {
+		nodes, err := s.clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for i := range nodes.Items {
+			node := &nodes.Items[i]
+			collect(s.rules.evaluate(ctx, s.rules.rulesFor(RuleKindNode, ""), RuleKindNode, node, node, nil))
+		}
+	}
+
+	sortFindings(result.Findings)
+	result.Summary = summarizeFindings(result.Findings)
+
+	return result, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestRuleEvaluationIsBounded(t *testing.T) {
+	engine := testRuleEngine(t, `
+rules:
+  - id: quadratic
+    kind: Deployment
+    expression: >-
+      object.spec.template.spec.containers.all(a,
+        object.spec.template.spec.containers.all(b, a.name != b.name || a == b))
+    message: never fires
+`)
+	deployment := testDeployment("shop", "web", "web:1.0.0", 1)
+	containers := make([]corev1.Container, 1000)
+	for i := range containers {
+		containers[i] = corev1.Container{Name: fmt.Sprintf("c%d", i), Image: "web:1.0.0"}
+	}
+	deployment.Spec.Template.Spec.Containers = containers
+	rules := engine.rulesFor(RuleKindDeployment, "shop")
+
+	_, errs := engine.evaluate(context.Background(), rules, RuleKindDeployment, deployment, deployment, nil)
+	if len(errs) != 1 || !strings.Contains(errs[0], "cost limit") {
+		t.Errorf("expected the cost limit to stop the rule, got %v", errs)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, errs = engine.evaluate(ctx, rules, RuleKindDeployment, deployment, deployment, nil)
+	if len(errs) != 1 || !strings.Contains(errs[0], context.Canceled.Error()) {
+		t.Errorf("expected a cancelled request to stop the rule, got %v", errs)
+	}
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Pod Security Standards levels, plus checks that are good practice but not part of any level
+const (
+	PSSPrivileged   = "privileged"
+	PSSBaseline     = "baseline"
+	PSSRestricted   = "restricted"
+	PSSBestPractice = "best-practice"
+)
+
+// Namespace labels read by the Pod Security admission controller
+const (
+	podSecurityEnforceLabel = "pod-security.kubernetes.io/enforce"
+	podSecurityAuditLabel   = "pod-security.kubernetes.io/audit"
+	podSecurityWarnLabel    = "pod-security.kubernetes.io/warn"
+	podSecurityVersionLabel = "pod-security.kubernetes.io/enforce-version"
+)
+
+// baselineCapabilities are the capabilities the baseline profile allows containers to add
+var baselineCapabilities = []string{
+	"AUDIT_WRITE", "CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL", "MKNOD",
+	"NET_BIND_SERVICE", "SETFCAP", "SETGID", "SETPCAP", "SETUID", "SYS_CHROOT",
+}
+
+// baselineSELinuxTypes are the SELinux types the baseline profile allows besides an unset type
+var baselineSELinuxTypes = []string{"container_t", "container_init_t", "container_kvm_t", "container_engine_t"}
+
+// safeSysctls are the sysctls the baseline profile allows pods to set
+var safeSysctls = []string{
+	"kernel.shm_rmid_forced", "net.ipv4.ip_local_port_range", "net.ipv4.ip_unprivileged_port_start",
+	"net.ipv4.tcp_syncookies", "net.ipv4.ping_group_range", "net.ipv4.ip_local_reserved_ports",
+	"net.ipv4.tcp_keepalive_time", "net.ipv4.tcp_fin_timeout", "net.ipv4.tcp_keepalive_intvl",
+	"net.ipv4.tcp_keepalive_probes",
+}
+
+// restrictedVolumeTypes are the volume types the restricted profile allows
+var restrictedVolumeTypes = []string{
+	"configMap", "csi", "downwardAPI", "emptyDir", "ephemeral", "persistentVolumeClaim", "projected", "secret",
+}
+
+// appArmorAnnotationPrefix is the pre-1.30 way to set a container's AppArmor profile
+const appArmorAnnotationPrefix = "container.apparmor.security.beta.kubernetes.io/"
+
+// SecurityAudit is the audit_security result
+type SecurityAudit struct {
+	Namespace   string               `json:"namespace"`
+	PodSecurity NamespacePodSecurity `json:"pod_security"`
+	Workloads   []WorkloadSecurity   `json:"workloads"`
+	Findings    []Finding            `json:"findings"`
+	Summary     *FindingSummary      `json:"summary,omitempty"`
+}
+
+// NamespacePodSecurity holds the namespace's pod-security.kubernetes.io labels
+type NamespacePodSecurity struct {
+	Enforce        string `json:"enforce,omitempty"`
+	EnforceVersion string `json:"enforce_version,omitempty"`
+	Audit          string `json:"audit,omitempty"`
+	Warn           string `json:"warn,omitempty"`
+}
+
+// WorkloadSecurity is the most restrictive Pod Security Standards level a workload satisfies
+type WorkloadSecurity struct {
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Level      string `json:"level"`
+	Violations int    `json:"violations"`
+	// Rejected is set when the namespace enforces a stricter level than the workload meets:
+	// new pods for it are refused by admission
+	Rejected bool `json:"rejected,omitempty"`
+}
+
+// securityViolation is one field of a pod spec that breaks a Pod Security Standards level
+type securityViolation struct {
+	def       findingDef
+	level     string
+	container string
+	field     string
+	message   string
+}
+
+// auditedPodSpec is a pod spec to audit with the object it comes from. The
+// annotations are those of the pod or pod template, which can set AppArmor profiles.
+type auditedPodSpec struct {
+	kind        string
+	name        string
+	spec        *corev1.PodSpec
+	annotations map[string]string
+}
+
+// auditSecurity evaluates pods and workload templates in a namespace against the
+// Pod Security Standards baseline and restricted profiles
+func (s *K8sDiagnosticsServer) auditSecurity(ctx context.Context, namespace string) (*SecurityAudit, error) {
+	if err := s.access.checkNamespace(namespace); err != nil {
+		return nil, err
+	}
+
+	result := &SecurityAudit{
+		Namespace: namespace,
+		Workloads: []WorkloadSecurity{},
+		Findings:  []Finding{},
+	}
+
+	ns, err := s.clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	result.PodSecurity = NamespacePodSecurity{
+		Enforce:        ns.Labels[podSecurityEnforceLabel],
+		EnforceVersion: ns.Labels[podSecurityVersionLabel],
+		Audit:          ns.Labels[podSecurityAuditLabel],
+		Warn:           ns.Labels[podSecurityWarnLabel],
+	}
+	result.Findings = append(result.Findings, namespacePodSecurityFindings(namespace, result.PodSecurity)...)
+
+	specs, err := s.auditedPodSpecs(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, audited := range specs {
+		violations := auditPodSpec(audited.spec, audited.annotations)
+		workload := WorkloadSecurity{Kind: audited.kind, Name: audited.name, Level: PSSRestricted}
+		for _, violation := range violations {
+			switch {
+			case violation.level == PSSBaseline:
+				workload.Level = PSSPrivileged
+			case violation.level == PSSRestricted && workload.Level == PSSRestricted:
+				workload.Level = PSSBaseline
+			}
+			if violation.level != PSSBestPractice {
+				workload.Violations++
+			}
+		}
+		workload.Rejected = levelRank(result.PodSecurity.Enforce) > levelRank(workload.Level)
+		result.Workloads = append(result.Workloads, workload)
+
+		for _, violation := range violations {
+			ref := ObjectRef{Kind: audited.kind, Namespace: namespace, Name: audited.name, Container: violation.container}
+			finding := newFinding(violation.def, ref,
+				fmt.Sprintf("%s %s/%s: %s", audited.kind, namespace, audited.name, violation.message),
+				map[string]string{"field": violation.field, "level": violation.level})
+			// Baseline violations are known privilege escalations; restricted ones are hardening.
+			// Violations of the enforced level block new pods at admission.
+			if violation.level != PSSBaseline {
+				finding.Severity = SeverityInfo
+			}
+			if violation.level != PSSBestPractice && levelRank(result.PodSecurity.Enforce) >= levelRank(violation.level) {
+				finding.Severity = SeverityCritical
+				finding.Evidence["enforced"] = result.PodSecurity.Enforce
+			}
+			result.Findings = append(result.Findings, finding)
+		}
+	}
+
+	sortFindings(result.Findings)
+	result.Summary = summarizeFindings(result.Findings)
+
+	return result, nil
+}
+
+// auditedPodSpecs returns the pod templates of workloads and the specs of pods
+// whose controller is not one of them. Pods created by a workload are covered
+// by its template.
+func (s *K8sDiagnosticsServer) auditedPodSpecs(ctx context.Context, namespace string) ([]auditedPodSpec, error) {
+	var specs []auditedPodSpec
+
+	deployments, err := s.clientset.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	deploymentNames := map[string]bool{}
+	for i := range deployments.Items {
+		deploymentNames[deployments.Items[i].Name] = true
+		if s.access.excludes(deployments.Items[i].Labels) {
+			continue
+		}
+		specs = append(specs, auditedPodSpec{"Deployment", deployments.Items[i].Name, &deployments.Items[i].Spec.Template.Spec, deployments.Items[i].Spec.Template.Annotations})
+	}
+
+	statefulSets, err := s.clientset.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range statefulSets.Items {
+		if s.access.excludes(statefulSets.Items[i].Labels) {
+			continue
+		}
+		specs = append(specs, auditedPodSpec{"StatefulSet", statefulSets.Items[i].Name, &statefulSets.Items[i].Spec.Template.Spec, statefulSets.Items[i].Spec.Template.Annotations})
+	}
+
+	daemonSets, err := s.clientset.AppsV1().DaemonSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range daemonSets.Items {
+		if s.access.excludes(daemonSets.Items[i].Labels) {
+			continue
+		}
+		specs = append(specs, auditedPodSpec{"DaemonSet", daemonSets.Items[i].Name, &daemonSets.Items[i].Spec.Template.Spec, daemonSets.Items[i].Spec.Template.Annotations})
+	}
+
+	cronJobs, err := s.clientset.BatchV1().CronJobs(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range cronJobs.Items {
+		if s.access.excludes(cronJobs.Items[i].Labels) {
+			continue
+		}
+		specs = append(specs, auditedPodSpec{"CronJob", cronJobs.Items[i].Name, &cronJobs.Items[i].Spec.JobTemplate.Spec.Template.Spec, cronJobs.Items[i].Spec.JobTemplate.Spec.Template.Annotations})
+	}
+
+	jobs, err := s.clientset.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range jobs.Items {
+		// Jobs of a CronJob are covered by its template
+		if ownedBy(metav1.GetControllerOf(&jobs.Items[i]), batchv1.SchemeGroupVersion.Group, "CronJob") {
+			continue
+		}
+		if !s.access.excludes(jobs.Items[i].Labels) {
+			specs = append(specs, auditedPodSpec{"Job", jobs.Items[i].Name, &jobs.Items[i].Spec.Template.Spec, jobs.Items[i].Spec.Template.Annotations})
+		}
+	}
+
+	replicaSets, err := s.clientset.AppsV1().ReplicaSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	deploymentReplicaSets := map[string]bool{}
+	for i := range replicaSets.Items {
+		owner := metav1.GetControllerOf(&replicaSets.Items[i])
+		if ownedBy(owner, appsv1.SchemeGroupVersion.Group, "Deployment") && deploymentNames[owner.Name] {
+			deploymentReplicaSets[replicaSets.Items[i].Name] = true
+		}
+	}
+
+	pods, err := s.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range pods.Items {
+		// Bare ReplicaSets, operators and custom controllers have no template audited above
+		owner := metav1.GetControllerOf(&pods.Items[i])
+		covered := ownedBy(owner, appsv1.SchemeGroupVersion.Group, "ReplicaSet") && deploymentReplicaSets[owner.Name] ||
+			ownedBy(owner, appsv1.SchemeGroupVersion.Group, "StatefulSet") ||
+			ownedBy(owner, appsv1.SchemeGroupVersion.Group, "DaemonSet") ||
+			ownedBy(owner, batchv1.SchemeGroupVersion.Group, "Job")
+		if !covered && !s.access.excludes(pods.Items[i].Labels) {
+			specs = append(specs, auditedPodSpec{"Pod", pods.Items[i].Name, &pods.Items[i].Spec, pods.Items[i].Annotations})
+		}
+	}
+
+	return specs, nil
+}
+
+// ownedBy reports whether a controller reference points at a kind of an API group
+func ownedBy(owner *metav1.OwnerReference, group, kind string) bool {
+	if owner == nil || owner.Kind != kind {
+		return false
+	}
+	gv, err := schema.ParseGroupVersion(owner.APIVersion)
+	return err == nil && gv.Group == group
+}
+
+// namespacePodSecurityFindings checks the namespace's Pod Security admission labels
+func namespacePodSecurityFindings(namespace string, labels NamespacePodSecurity) []Finding {
+	var findings []Finding
+	ref := ObjectRef{Kind: "Namespace", Name: namespace}
+
+	for _, label := range []struct{ name, value string }{
+		{podSecurityEnforceLabel, labels.Enforce},
+		{podSecurityAuditLabel, labels.Audit},
+		{podSecurityWarnLabel, labels.Warn},
+	} {
+		if label.value != "" && levelRank(label.value) < 0 {
+			findings = append(findings, newFinding(findingPSSInvalidLabel, ref,
+				fmt.Sprintf("Namespace %s label %s=%q is not a Pod Security Standards level", namespace, label.name, label.value),
+				map[string]string{"label": label.name, "value": label.value}))
+		}
+	}
+
+	switch labels.Enforce {
+	case "":
+		message := fmt.Sprintf("Namespace %s does not enforce a Pod Security Standards level", namespace)
+		if labels.Warn != "" || labels.Audit != "" {
+			message += " (violations are only warned about or audited)"
+		}
+		findings = append(findings, newFinding(findingPSSNotEnforced, ref, message, nil))
+	case PSSPrivileged:
+		findings = append(findings, newFinding(findingPSSNotEnforced, ref,
+			fmt.Sprintf("Namespace %s enforces the privileged level, which allows everything", namespace),
+			map[string]string{"label": podSecurityEnforceLabel, "value": labels.Enforce}))
+	}
+
+	return findings
+}
+
+// levelRank orders Pod Security Standards levels from least to most restrictive;
+// an unset level ranks like privileged and an unknown one is -1
+func levelRank(level string) int {
+	switch level {
+	case "", PSSPrivileged:
+		return 0
+	case PSSBaseline:
+		return 1
+	case PSSRestricted:
+		return 2
+	}
+	return -1
+}
+
+// auditPodSpec checks a pod spec against the baseline and restricted profiles
+func auditPodSpec(spec *corev1.PodSpec, annotations map[string]string) []securityViolation {
+	var violations []securityViolation
+	add := func(def findingDef, level, container, field, message string) {
+		violations = append(violations, securityViolation{def, level, container, field, message})
+	}
+
+	// Pod-level fields
+	if spec.HostNetwork {
+		add(findingPSSHostNamespaces, PSSBaseline, "", "spec.hostNetwork", "uses the host network")
+	}
+	if spec.HostPID {
+		add(findingPSSHostNamespaces, PSSBaseline, "", "spec.hostPID", "shares the host PID namespace")
+	}
+	if spec.HostIPC {
+		add(findingPSSHostNamespaces, PSSBaseline, "", "spec.hostIPC", "shares the host IPC namespace")
+	}
+	for _, volume := range spec.Volumes {
+		if volume.HostPath != nil {
+			add(findingPSSHostPath, PSSBaseline, "", fmt.Sprintf("spec.volumes[%s].hostPath", volume.Name),
+				fmt.Sprintf("volume %s mounts host path %s", volume.Name, volume.HostPath.Path))
+		} else if volumeType := volumeSourceType(volume.VolumeSource); !containsString(restrictedVolumeTypes, volumeType) {
+			add(findingPSSVolumeTypes, PSSRestricted, "", fmt.Sprintf("spec.volumes[%s].%s", volume.Name, volumeType),
+				fmt.Sprintf("volume %s is a %s volume", volume.Name, volumeType))
+		}
+	}
+
+	podContext := spec.SecurityContext
+	if podContext == nil {
+		podContext = &corev1.PodSecurityContext{}
+	}
+	if podContext.SeccompProfile != nil && podContext.SeccompProfile.Type == corev1.SeccompProfileTypeUnconfined {
+		add(findingPSSSeccomp, PSSBaseline, "", "spec.securityContext.seccompProfile.type", "disables seccomp (Unconfined)")
+	}
+	if podContext.WindowsOptions != nil && podContext.WindowsOptions.HostProcess != nil && *podContext.WindowsOptions.HostProcess {
+		add(findingPSSHostProcess, PSSBaseline, "", "spec.securityContext.windowsOptions.hostProcess", "runs as a Windows HostProcess pod")
+	}
+	if message := seLinuxViolation(podContext.SELinuxOptions); message != "" {
+		add(findingPSSSELinux, PSSBaseline, "", "spec.securityContext.seLinuxOptions", message)
+	}
+	if podContext.AppArmorProfile != nil && podContext.AppArmorProfile.Type == corev1.AppArmorProfileTypeUnconfined {
+		add(findingPSSAppArmor, PSSBaseline, "", "spec.securityContext.appArmorProfile.type", "disables AppArmor (Unconfined)")
+	}
+	for _, sysctl := range podContext.Sysctls {
+		if !containsString(safeSysctls, sysctl.Name) {
+			add(findingPSSSysctls, PSSBaseline, "", "spec.securityContext.sysctls",
+				fmt.Sprintf("sets unsafe sysctl %s", sysctl.Name))
+		}
+	}
+	var annotated []string
+	for key := range annotations {
+		if strings.HasPrefix(key, appArmorAnnotationPrefix) {
+			annotated = append(annotated, key)
+		}
+	}
+	sort.Strings(annotated)
+	for _, key := range annotated {
+		if profile := annotations[key]; profile != "runtime/default" && !strings.HasPrefix(profile, "localhost/") {
+			container := strings.TrimPrefix(key, appArmorAnnotationPrefix)
+			add(findingPSSAppArmor, PSSBaseline, container, fmt.Sprintf("metadata.annotations[%s]", key),
+				fmt.Sprintf("container %s uses AppArmor profile %q", container, profile))
+		}
+	}
+	if podContext.RunAsUser != nil && *podContext.RunAsUser == 0 {
+		add(findingPSSRunAsNonRoot, PSSRestricted, "", "spec.securityContext.runAsUser", "runs as UID 0")
+	}
+
+	containers := append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+	for _, container := range containers {
+		field := func(path string) string {
+			return fmt.Sprintf("spec.containers[%s].%s", container.Name, path)
+		}
+		sc := container.SecurityContext
+		if sc == nil {
+			sc = &corev1.SecurityContext{}
+		}
+		name := "container " + container.Name
+
+		// Baseline
+		if sc.Privileged != nil && *sc.Privileged {
+			add(findingPSSPrivileged, PSSBaseline, container.Name, field("securityContext.privileged"), name+" is privileged")
+		}
+		for _, port := range container.Ports {
+			if port.HostPort != 0 {
+				add(findingPSSHostNamespaces, PSSBaseline, container.Name, field("ports.hostPort"),
+					fmt.Sprintf("%s binds host port %d", name, port.HostPort))
+			}
+		}
+		var added, dropped []string
+		if sc.Capabilities != nil {
+			for _, capability := range sc.Capabilities.Add {
+				added = append(added, normalizeCapability(capability))
+			}
+			for _, capability := range sc.Capabilities.Drop {
+				dropped = append(dropped, normalizeCapability(capability))
+			}
+		}
+		for _, capability := range added {
+			if !containsString(baselineCapabilities, capability) {
+				add(findingPSSCapabilities, PSSBaseline, container.Name, field("securityContext.capabilities.add"),
+					fmt.Sprintf("%s adds capability %s", name, capability))
+			}
+		}
+		if sc.SeccompProfile != nil && sc.SeccompProfile.Type == corev1.SeccompProfileTypeUnconfined {
+			add(findingPSSSeccomp, PSSBaseline, container.Name, field("securityContext.seccompProfile.type"),
+				name+" disables seccomp (Unconfined)")
+		}
+		if sc.WindowsOptions != nil && sc.WindowsOptions.HostProcess != nil && *sc.WindowsOptions.HostProcess {
+			add(findingPSSHostProcess, PSSBaseline, container.Name, field("securityContext.windowsOptions.hostProcess"),
+				name+" runs as a Windows HostProcess container")
+		}
+		if message := seLinuxViolation(sc.SELinuxOptions); message != "" {
+			add(findingPSSSELinux, PSSBaseline, container.Name, field("securityContext.seLinuxOptions"), name+" "+message)
+		}
+		if sc.AppArmorProfile != nil && sc.AppArmorProfile.Type == corev1.AppArmorProfileTypeUnconfined {
+			add(findingPSSAppArmor, PSSBaseline, container.Name, field("securityContext.appArmorProfile.type"),
+				name+" disables AppArmor (Unconfined)")
+		}
+		if sc.ProcMount != nil && *sc.ProcMount != corev1.DefaultProcMount {
+			add(findingPSSProcMount, PSSBaseline, container.Name, field("securityContext.procMount"),
+				fmt.Sprintf("%s uses procMount %s", name, *sc.ProcMount))
+		}
+
+		// Restricted
+		if sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation {
+			add(findingPSSPrivilegeEscalation, PSSRestricted, container.Name, field("securityContext.allowPrivilegeEscalation"),
+				name+" does not set allowPrivilegeEscalation: false")
+		}
+		if !containsString(dropped, "ALL") {
+			add(findingPSSDropCapabilities, PSSRestricted, container.Name, field("securityContext.capabilities.drop"),
+				name+" does not drop ALL capabilities")
+		}
+		for _, capability := range added {
+			if capability != "NET_BIND_SERVICE" && containsString(baselineCapabilities, capability) {
+				add(findingPSSCapabilities, PSSRestricted, container.Name, field("securityContext.capabilities.add"),
+					fmt.Sprintf("%s adds capability %s (restricted only allows NET_BIND_SERVICE)", name, capability))
+			}
+		}
+		runAsNonRoot := sc.RunAsNonRoot != nil && *sc.RunAsNonRoot ||
+			sc.RunAsNonRoot == nil && podContext.RunAsNonRoot != nil && *podContext.RunAsNonRoot
+		if !runAsNonRoot {
+			add(findingPSSRunAsNonRoot, PSSRestricted, container.Name, field("securityContext.runAsNonRoot"),
+				name+" does not set runAsNonRoot: true")
+		}
+		if sc.RunAsUser != nil && *sc.RunAsUser == 0 {
+			add(findingPSSRunAsNonRoot, PSSRestricted, container.Name, field("securityContext.runAsUser"), name+" runs as UID 0")
+		}
+		if sc.SeccompProfile == nil && podContext.SeccompProfile == nil {
+			add(findingPSSSeccomp, PSSRestricted, container.Name, field("securityContext.seccompProfile.type"),
+				name+" has no seccomp profile (RuntimeDefault or Localhost required)")
+		}
+
+		// Not part of the standards, but limits what a compromised container can change
+		if sc.ReadOnlyRootFilesystem == nil || !*sc.ReadOnlyRootFilesystem {
+			add(findingWritableRootFilesystem, PSSBestPractice, container.Name, field("securityContext.readOnlyRootFilesystem"),
+				name+" has a writable root filesystem")
+		}
+	}
+
+	return violations
+}
+
+// seLinuxViolation explains SELinux options the baseline profile forbids, or returns ""
+func seLinuxViolation(options *corev1.SELinuxOptions) string {
+	if options == nil {
+		return ""
+	}
+	var problems []string
+	if options.Type != "" && !containsString(baselineSELinuxTypes, options.Type) {
+		problems = append(problems, fmt.Sprintf("type %s", options.Type))
+	}
+	if options.User != "" {
+		problems = append(problems, fmt.Sprintf("user %s", options.User))
+	}
+	if options.Role != "" {
+		problems = append(problems, fmt.Sprintf("role %s", options.Role))
+	}
+	if len(problems) == 0 {
+		return ""
+	}
+	return "sets SELinux " + strings.Join(problems, ", ")
+}
+
+// volumeSourceType returns the name of the volume source that is set, e.g. "nfs"
+func volumeSourceType(source corev1.VolumeSource) string {
+	data, err := json.Marshal(source)
+	if err != nil {
+		return "unknown"
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil || len(fields) != 1 {
+		return "unknown"
+	}
+	for name := range fields {
+		return name
+	}
+	return "unknown"
+}
+
+func normalizeCapability(capability corev1.Capability) string {
+	return strings.TrimPrefix(strings.ToUpper(string(capability)), "CAP_")
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAuditedPodSpecsOwnership(t *testing.T) {
+	controller := func(apiVersion, kind, name string) []metav1.OwnerReference {
+		isController := true
+		return []metav1.OwnerReference{{APIVersion: apiVersion, Kind: kind, Name: name, Controller: &isController}}
+	}
+	ownedPod := func(name string, owners []metav1.OwnerReference) *corev1.Pod {
+		pod := testPod("shop", name, "", nil)
+		pod.OwnerReferences = owners
+		return pod
+	}
+	replicaSet := func(name string, owners []metav1.OwnerReference) *appsv1.ReplicaSet {
+		return &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: name, OwnerReferences: owners}}
+	}
+	job := func(name string, owners []metav1.OwnerReference) *batchv1.Job {
+		return &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: name, OwnerReferences: owners}}
+	}
+
+	objects := []runtime.Object{
+		testDeployment("shop", "web", "web:1", 1),
+		replicaSet("web-7d9f", controller("apps/v1", "Deployment", "web")),
+		replicaSet("legacy", nil),
+		replicaSet("rollout-5c6b", controller("argoproj.io/v1alpha1", "Rollout", "rollout")),
+		job("nightly-2901", controller("batch/v1", "CronJob", "nightly")),
+		job("migrate", controller("example.com/v1", "Migration", "v42")),
+		ownedPod("web-7d9f-x1", controller("apps/v1", "ReplicaSet", "web-7d9f")),
+		ownedPod("legacy-x1", controller("apps/v1", "ReplicaSet", "legacy")),
+		ownedPod("rollout-5c6b-x1", controller("apps/v1", "ReplicaSet", "rollout-5c6b")),
+		ownedPod("db-0", controller("apps/v1", "StatefulSet", "db")),
+		ownedPod("agent-x1", controller("apps/v1", "DaemonSet", "agent")),
+		ownedPod("nightly-2901-x1", controller("batch/v1", "Job", "nightly-2901")),
+		ownedPod("kruise-0", controller("apps.kruise.io/v1beta1", "StatefulSet", "kruise")),
+		ownedPod("operator-x1", controller("example.com/v1", "Cache", "redis")),
+		ownedPod("debug", []metav1.OwnerReference{{APIVersion: "v1", Kind: "ConfigMap", Name: "debug"}}),
+		ownedPod("bare", nil),
+	}
+	s := &K8sDiagnosticsServer{clientset: fake.NewSimpleClientset(objects...)}
+
+	specs, err := s.auditedPodSpecs(context.Background(), "shop")
+	if err != nil {
+		t.Fatalf("auditedPodSpecs: %v", err)
+	}
+	var got []string
+	for _, spec := range specs {
+		got = append(got, spec.kind+" "+spec.name)
+	}
+	sort.Strings(got)
+	want := []string{
+		"Deployment web",
+		"Job migrate",
+		"Pod bare",
+		"Pod debug",
+		"Pod kruise-0",
+		"Pod legacy-x1",
+		"Pod operator-x1",
+		"Pod rollout-5c6b-x1",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("audited specs:\n got  %v\n want %v", got, want)
+	}
+}
+
+// restrictedPodSpec returns a pod spec that meets the restricted profile
+func restrictedPodSpec() *corev1.PodSpec {
+	yes, no := true, false
+	return &corev1.PodSpec{
+		SecurityContext: &corev1.PodSecurityContext{
+			RunAsNonRoot:   &yes,
+			SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+		},
+		Containers: []corev1.Container{{
+			Name: "app",
+			SecurityContext: &corev1.SecurityContext{
+				AllowPrivilegeEscalation: &no,
+				ReadOnlyRootFilesystem:   &yes,
+				Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+			},
+		}},
+		Volumes: []corev1.Volume{{Name: "tmp", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}},
+	}
+}
+
+func TestAuditPodSpecControls(t *testing.T) {
+	yes := true
+	unmasked := corev1.UnmaskedProcMount
+	tests := []struct {
+		name        string
+		mutate      func(spec *corev1.PodSpec)
+		annotations map[string]string
+		wantID      string
+		wantLevel   string
+	}{
+		{name: "compliant"},
+		{name: "SELinux type", wantID: "pss-selinux", wantLevel: PSSBaseline, mutate: func(spec *corev1.PodSpec) {
+			spec.SecurityContext.SELinuxOptions = &corev1.SELinuxOptions{Type: "spc_t"}
+		}},
+		{name: "SELinux user", wantID: "pss-selinux", wantLevel: PSSBaseline, mutate: func(spec *corev1.PodSpec) {
+			spec.Containers[0].SecurityContext.SELinuxOptions = &corev1.SELinuxOptions{User: "system_u"}
+		}},
+		{name: "allowed SELinux type", mutate: func(spec *corev1.PodSpec) {
+			spec.SecurityContext.SELinuxOptions = &corev1.SELinuxOptions{Type: "container_init_t", Level: "s0:c123,c456"}
+		}},
+		{name: "AppArmor field", wantID: "pss-apparmor", wantLevel: PSSBaseline, mutate: func(spec *corev1.PodSpec) {
+			spec.Containers[0].SecurityContext.AppArmorProfile = &corev1.AppArmorProfile{Type: corev1.AppArmorProfileTypeUnconfined}
+		}},
+		{name: "AppArmor annotation", wantID: "pss-apparmor", wantLevel: PSSBaseline,
+			annotations: map[string]string{appArmorAnnotationPrefix + "app": "unconfined"}},
+		{name: "AppArmor runtime default annotation",
+			annotations: map[string]string{appArmorAnnotationPrefix + "app": "runtime/default"}},
+		{name: "unmasked proc", wantID: "pss-proc-mount", wantLevel: PSSBaseline, mutate: func(spec *corev1.PodSpec) {
+			spec.Containers[0].SecurityContext.ProcMount = &unmasked
+		}},
+		{name: "unsafe sysctl", wantID: "pss-unsafe-sysctls", wantLevel: PSSBaseline, mutate: func(spec *corev1.PodSpec) {
+			spec.SecurityContext.Sysctls = []corev1.Sysctl{{Name: "net.ipv4.tcp_syncookies", Value: "1"}, {Name: "kernel.msgmax", Value: "65536"}}
+		}},
+		{name: "Windows HostProcess", wantID: "pss-host-process", wantLevel: PSSBaseline, mutate: func(spec *corev1.PodSpec) {
+			spec.SecurityContext.WindowsOptions = &corev1.WindowsSecurityContextOptions{HostProcess: &yes}
+		}},
+		{name: "volume type", wantID: "pss-volume-type", wantLevel: PSSRestricted, mutate: func(spec *corev1.PodSpec) {
+			spec.Volumes = append(spec.Volumes, corev1.Volume{Name: "shared", VolumeSource: corev1.VolumeSource{
+				NFS: &corev1.NFSVolumeSource{Server: "nfs.internal", Path: "/export"},
+			}})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := restrictedPodSpec()
+			if tt.mutate != nil {
+				tt.mutate(spec)
+			}
+			violations := auditPodSpec(spec, tt.annotations)
+			if tt.wantID == "" {
+				if len(violations) != 0 {
+					t.Errorf("expected no violations, got %+v", violations)
+				}
+				return
+			}
+			if len(violations) != 1 || violations[0].def.ID != tt.wantID || violations[0].level != tt.wantLevel {
+				t.Errorf("expected one %s %s violation, got %+v", tt.wantLevel, tt.wantID, violations)
+			}
+		})
+	}
+}
+
+func TestAuditSecurityRejectsSELinuxSuperPrivilegedType(t *testing.T) {
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop",
+		Labels: map[string]string{podSecurityEnforceLabel: PSSRestricted}}}
+	pod := testPod("shop", "debug", "", nil)
+	pod.Spec = *restrictedPodSpec()
+	pod.Spec.SecurityContext.SELinuxOptions = &corev1.SELinuxOptions{Type: "spc_t"}
+	s := &K8sDiagnosticsServer{clientset: fake.NewSimpleClientset(namespace, pod)}
+
+	result, err := s.auditSecurity(context.Background(), "shop")
+	if err != nil {
+		t.Fatalf("auditSecurity: %v", err)
+	}
+	if len(result.Workloads) != 1 || result.Workloads[0].Level != PSSPrivileged || !result.Workloads[0].Rejected {
+		t.Errorf("expected the pod to be privileged and rejected, got %+v", result.Workloads)
+	}
+}
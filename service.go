@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// ServiceDiagnostic is the diagnose_service result
+type ServiceDiagnostic struct {
+	Name           string                 `json:"name"`
+	Namespace      string                 `json:"namespace"`
+	Type           string                 `json:"type"`
+	ClusterIP      string                 `json:"cluster_ip,omitempty"`
+	ExternalName   string                 `json:"external_name,omitempty"`
+	Selector       map[string]string      `json:"selector,omitempty"`
+	MatchingPods   []string               `json:"matching_pods"`
+	ReadyPods      int                    `json:"ready_pods"`
+	Ports          []ServicePortCheck     `json:"ports"`
+	EndpointSlices []EndpointSliceSummary `json:"endpoint_slices"`
+	ReadyEndpoints int                    `json:"ready_endpoints"`
+	Findings       []Finding              `json:"findings"`
+	Summary        *FindingSummary        `json:"summary,omitempty"`
+}
+
+// ServicePortCheck is how one service port resolves on the selected pods
+type ServicePortCheck struct {
+	Name       string `json:"name,omitempty"`
+	Port       int32  `json:"port"`
+	TargetPort string `json:"target_port"`
+	Protocol   string `json:"protocol"`
+	// ResolvedPods counts selected pods where the target port resolves to a declared container port
+	ResolvedPods int `json:"resolved_pods"`
+}
+
+// EndpointSliceSummary counts the endpoints of one EndpointSlice
+type EndpointSliceSummary struct {
+	Name        string   `json:"name"`
+	AddressType string   `json:"address_type"`
+	Ports       []string `json:"ports"`
+	Ready       int      `json:"ready"`
+	NotReady    int      `json:"not_ready"`
+	Terminating int      `json:"terminating"`
+}
+
+// diagnoseService checks why traffic to a service might not reach its pods:
+// selector mismatches, missing or unready endpoints, target ports that don't
+// match a container port, protocol mismatches and headless/ExternalName quirks
+func (s *K8sDiagnosticsServer) diagnoseService(ctx context.Context, namespace, name string) (*ServiceDiagnostic, error) {
+	if err := s.access.checkNamespace(namespace); err != nil {
+		return nil, err
+	}
+	svc, err := s.clientset.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.access.checkObject("Service", namespace, name, svc.Labels); err != nil {
+		return nil, err
+	}
+
+	diagnostic := &ServiceDiagnostic{
+		Name:           svc.Name,
+		Namespace:      svc.Namespace,
+		Type:           string(svc.Spec.Type),
+		ClusterIP:      svc.Spec.ClusterIP,
+		ExternalName:   svc.Spec.ExternalName,
+		Selector:       svc.Spec.Selector,
+		MatchingPods:   []string{},
+		Ports:          []ServicePortCheck{},
+		EndpointSlices: []EndpointSliceSummary{},
+		Findings:       []Finding{},
+	}
+	ref := ObjectRef{Kind: "Service", Namespace: namespace, Name: name}
+
+	// ExternalName services are a DNS CNAME: no selector, endpoints or ports apply
+	if svc.Spec.Type == corev1.ServiceTypeExternalName {
+		diagnostic.Findings = append(diagnostic.Findings, externalNameFindings(svc, ref)...)
+		diagnostic.Summary = summarizeFindings(diagnostic.Findings)
+		return diagnostic, nil
+	}
+
+	var pods []corev1.Pod
+	if len(svc.Spec.Selector) > 0 {
+		all, err := s.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		// Pods the access policy hides are neither matched nor suggested as the closest pod
+		visible := s.access.filterPods(all.Items)
+		selector := labels.SelectorFromSet(svc.Spec.Selector)
+		for _, pod := range visible {
+			if !selector.Matches(labels.Set(pod.Labels)) || pod.DeletionTimestamp != nil {
+				continue
+			}
+			pods = append(pods, pod)
+			diagnostic.MatchingPods = append(diagnostic.MatchingPods, pod.Name)
+			if podReady(pod) {
+				diagnostic.ReadyPods++
+			}
+		}
+
+		if len(pods) == 0 {
+			evidence := map[string]string{"selector": labels.SelectorFromSet(svc.Spec.Selector).String()}
+			message := fmt.Sprintf("Service %s/%s selects no pods", namespace, name)
+			if closest, diff := closestPod(svc.Spec.Selector, visible); closest != "" {
+				evidence["closest_pod"] = closest
+				evidence["label_mismatch"] = diff
+				message += fmt.Sprintf("; closest is pod %s (%s)", closest, diff)
+			}
+			diagnostic.Findings = append(diagnostic.Findings, newFinding(findingServiceSelectorMismatch, ref, message, evidence))
+		}
+	} else {
+		diagnostic.Findings = append(diagnostic.Findings, newFinding(findingServiceNoSelector, ref,
+			fmt.Sprintf("Service %s/%s has no selector: endpoints must be managed manually", namespace, name), nil))
+	}
+
+	for _, port := range svc.Spec.Ports {
+		check, findings := checkServicePort(svc, port, pods, ref)
+		diagnostic.Ports = append(diagnostic.Ports, check)
+		diagnostic.Findings = append(diagnostic.Findings, findings...)
+	}
+
+	slices, err := s.clientset.DiscoveryV1().EndpointSlices(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: discoveryv1.LabelServiceName + "=" + name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	notReadyPods := map[string]bool{}
+	for _, slice := range slices.Items {
+		summary := EndpointSliceSummary{Name: slice.Name, AddressType: string(slice.AddressType), Ports: []string{}}
+		for _, port := range slice.Ports {
+			summary.Ports = append(summary.Ports, endpointPortString(port))
+		}
+		for _, endpoint := range slice.Endpoints {
+			switch {
+			case endpoint.Conditions.Terminating != nil && *endpoint.Conditions.Terminating:
+				summary.Terminating++
+			case endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready:
+				summary.Ready++
+			default:
+				summary.NotReady++
+				if endpoint.TargetRef != nil && endpoint.TargetRef.Kind == "Pod" {
+					notReadyPods[endpoint.TargetRef.Name] = true
+				}
+			}
+		}
+		diagnostic.ReadyEndpoints += summary.Ready
+		diagnostic.EndpointSlices = append(diagnostic.EndpointSlices, summary)
+	}
+
+	switch {
+	case len(slices.Items) == 0 && len(pods) > 0:
+		diagnostic.Findings = append(diagnostic.Findings, newFinding(findingServiceNoEndpointSlices, ref,
+			fmt.Sprintf("Service %s/%s selects %d pod(s) but has no EndpointSlices: the endpoint slice controller may not be running", namespace, name, len(pods)), nil))
+	case len(slices.Items) == 0 && len(svc.Spec.Selector) == 0:
+		diagnostic.Findings = append(diagnostic.Findings, newFinding(findingServiceNoReadyEndpoints, ref,
+			fmt.Sprintf("Service %s/%s has no selector and no EndpointSlices: connections are refused or time out", namespace, name), nil))
+	case diagnostic.ReadyEndpoints == 0 && (len(pods) > 0 || len(slices.Items) > 0):
+		message := fmt.Sprintf("Service %s/%s has no ready endpoints: connections are refused or time out", namespace, name)
+		evidence := map[string]string{"matching_pods": fmt.Sprint(len(pods)), "ready_pods": fmt.Sprint(diagnostic.ReadyPods)}
+		if len(notReadyPods) > 0 {
+			names := make([]string, 0, len(notReadyPods))
+			for pod := range notReadyPods {
+				names = append(names, pod)
+			}
+			sort.Strings(names)
+			evidence["not_ready_pods"] = strings.Join(names, ", ")
+			message += fmt.Sprintf(" (not ready: %s; check their readiness probes)", strings.Join(names, ", "))
+		}
+		diagnostic.Findings = append(diagnostic.Findings, newFinding(findingServiceNoReadyEndpoints, ref, message, evidence))
+	}
+
+	if svc.Spec.ClusterIP == corev1.ClusterIPNone {
+		diagnostic.Findings = append(diagnostic.Findings, headlessFindings(svc, ref)...)
+	}
+
+	sortFindings(diagnostic.Findings)
+	diagnostic.Summary = summarizeFindings(diagnostic.Findings)
+
+	return diagnostic, nil
+}
+
+// checkServicePort resolves a service port's targetPort on every selected pod
+func checkServicePort(svc *corev1.Service, port corev1.ServicePort, pods []corev1.Pod, ref ObjectRef) (ServicePortCheck, []Finding) {
+	protocol := port.Protocol
+	if protocol == "" {
+		protocol = corev1.ProtocolTCP
+	}
+	target := port.TargetPort
+	if target.Type == intstr.Int && target.IntVal == 0 || target.Type == intstr.String && target.StrVal == "" {
+		target = intstr.FromInt32(port.Port)
+	}
+
+	check := ServicePortCheck{
+		Name:       port.Name,
+		Port:       port.Port,
+		TargetPort: target.String(),
+		Protocol:   string(protocol),
+	}
+	portRef := fmt.Sprintf("port %d (targetPort %s)", port.Port, target.String())
+	if port.Name != "" {
+		portRef = fmt.Sprintf("port %s/%d (targetPort %s)", port.Name, port.Port, target.String())
+	}
+
+	var findings []Finding
+	var unresolved, undeclared, protocolMismatch []string
+	for _, pod := range pods {
+		containerPort, declaresPorts := findContainerPort(pod, target)
+		switch {
+		case containerPort == nil && target.Type == intstr.String:
+			// A named targetPort only resolves against declared container ports
+			unresolved = append(unresolved, pod.Name)
+		case containerPort == nil && declaresPorts:
+			undeclared = append(undeclared, pod.Name)
+		case containerPort == nil:
+			// No ports declared at all: the number is used as-is
+		default:
+			check.ResolvedPods++
+			cp := containerPort.Protocol
+			if cp == "" {
+				cp = corev1.ProtocolTCP
+			}
+			if cp != protocol {
+				protocolMismatch = append(protocolMismatch, fmt.Sprintf("%s (%s)", pod.Name, cp))
+			}
+		}
+	}
+
+	if len(unresolved) > 0 {
+		findings = append(findings, newFinding(findingServiceTargetPortMismatch, ref,
+			fmt.Sprintf("Service %s/%s %s: no container port named %q in %d pod(s), so they get no endpoint for this port",
+				ref.Namespace, ref.Name, portRef, target.StrVal, len(unresolved)),
+			map[string]string{"pods": strings.Join(unresolved, ", ")}))
+	}
+	if len(undeclared) > 0 {
+		findings = append(findings, newFinding(findingServiceTargetPortUndeclared, ref,
+			fmt.Sprintf("Service %s/%s %s: %d pod(s) declare container ports but not %d; connections fail unless the app listens on it anyway",
+				ref.Namespace, ref.Name, portRef, len(undeclared), target.IntVal),
+			map[string]string{"pods": strings.Join(undeclared, ", ")}))
+	}
+	if len(protocolMismatch) > 0 {
+		findings = append(findings, newFinding(findingServiceProtocolMismatch, ref,
+			fmt.Sprintf("Service %s/%s %s uses %s but the container port uses a different protocol", ref.Namespace, ref.Name, portRef, protocol),
+			map[string]string{"pods": strings.Join(protocolMismatch, ", ")}))
+	}
+
+	return check, findings
+}
+
+// findContainerPort returns the container port a targetPort resolves to, and
+// whether the pod declares any container ports at all
+func findContainerPort(pod corev1.Pod, target intstr.IntOrString) (*corev1.ContainerPort, bool) {
+	declares := false
+	for _, container := range pod.Spec.Containers {
+		for i, port := range container.Ports {
+			declares = true
+			if target.Type == intstr.String && port.Name == target.StrVal ||
+				target.Type == intstr.Int && port.ContainerPort == target.IntVal {
+				return &container.Ports[i], true
+			}
+		}
+	}
+	return nil, declares
+}
+
+// closestPod finds the pod whose labels differ least from a selector and describes the difference
+func closestPod(selector map[string]string, pods []corev1.Pod) (string, string) {
+	keys := make([]string, 0, len(selector))
+	for key := range selector {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	best, bestDiff, bestMisses := "", "", len(keys)
+	for _, pod := range pods {
+		var diffs []string
+		for _, key := range keys {
+			value, ok := pod.Labels[key]
+			switch {
+			case !ok:
+				diffs = append(diffs, fmt.Sprintf("%s missing, want %q", key, selector[key]))
+			case value != selector[key]:
+				diffs = append(diffs, fmt.Sprintf("%s=%q, want %q", key, value, selector[key]))
+			}
+		}
+		// Only pods sharing at least one selector label are plausible targets
+		if len(diffs) < bestMisses {
+			best, bestDiff, bestMisses = pod.Name, strings.Join(diffs, "; "), len(diffs)
+		}
+	}
+	return best, bestDiff
+}
+
+func externalNameFindings(svc *corev1.Service, ref ObjectRef) []Finding {
+	var findings []Finding
+	if len(svc.Spec.Selector) > 0 {
+		findings = append(findings, newFinding(findingServiceExternalNameQuirk, ref,
+			fmt.Sprintf("ExternalName service %s/%s has a selector, which is ignored", ref.Namespace, ref.Name), nil))
+	}
+	if net.ParseIP(svc.Spec.ExternalName) != nil {
+		findings = append(findings, newFinding(findingServiceExternalNameQuirk, ref,
+			fmt.Sprintf("ExternalName service %s/%s points at IP %s: externalName must be a DNS name, an IP is served as a CNAME that doesn't resolve",
+				ref.Namespace, ref.Name, svc.Spec.ExternalName), nil))
+	}
+	if len(svc.Spec.Ports) > 0 {
+		findings = append(findings, newFinding(findingServiceExternalNameQuirk, ref,
+			fmt.Sprintf("ExternalName service %s/%s defines ports, but no port mapping happens: clients must connect to the external port directly",
+				ref.Namespace, ref.Name), nil))
+	}
+	return findings
+}
+
+func headlessFindings(svc *corev1.Service, ref ObjectRef) []Finding {
+	var findings []Finding
+	for _, port := range svc.Spec.Ports {
+		if port.TargetPort.Type == intstr.Int && port.TargetPort.IntVal != 0 && port.TargetPort.IntVal != port.Port {
+			findings = append(findings, newFinding(findingServiceHeadlessQuirk, ref,
+				fmt.Sprintf("Headless service %s/%s maps port %d to targetPort %d, but DNS returns pod IPs: clients must connect to %d",
+					ref.Namespace, ref.Name, port.Port, port.TargetPort.IntVal, port.TargetPort.IntVal), nil))
+		}
+	}
+	if svc.Spec.PublishNotReadyAddresses {
+		findings = append(findings, newFinding(findingServiceHeadlessQuirk, ref,
+			fmt.Sprintf("Headless service %s/%s publishes not-ready addresses: DNS returns pods that may not accept connections yet",
+				ref.Namespace, ref.Name), nil))
+	}
+	return findings
+}
+
+// endpointPortString renders an EndpointSlice port as name:port/protocol
+func endpointPortString(port discoveryv1.EndpointPort) string {
+	s := ""
+	if port.Name != nil && *port.Name != "" {
+		s = *port.Name + ":"
+	}
+	if port.Port != nil {
+		s += fmt.Sprint(*port.Port)
+	}
+	if port.Protocol != nil {
+		s += "/" + string(*port.Protocol)
+	}
+	return s
+}
+
+// podReady reports whether a pod's Ready condition is true
+func podReady(pod corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
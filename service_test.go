@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDiagnoseServiceClosestPodHonorsExclusion(t *testing.T) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "api"},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"app": "api", "tier": "backend"},
+			Ports:    []corev1.ServicePort{{Port: 80}},
+		},
+	}
+	hidden := testPod("shop", "vault-agent", "10.0.0.9", map[string]string{"app": "api", "tier": "backend", "secret": "true"})
+	visible := testPod("shop", "api-old", "10.0.0.8", map[string]string{"app": "legacy", "tier": "backend"})
+	access, err := newAccessPolicy(nil, nil, "secret=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &K8sDiagnosticsServer{clientset: fake.NewSimpleClientset(svc, hidden, visible), access: access}
+
+	diagnostic, err := s.diagnoseService(context.Background(), "shop", "api")
+	if err != nil {
+		t.Fatalf("diagnoseService: %v", err)
+	}
+	for _, finding := range diagnostic.Findings {
+		if finding.ID != findingServiceSelectorMismatch.ID {
+			continue
+		}
+		if strings.Contains(finding.Message, "vault-agent") {
+			t.Errorf("excluded pod leaked into the finding: %+v", finding)
+		}
+		if finding.Evidence["closest_pod"] != "api-old" {
+			t.Errorf("expected api-old as the closest visible pod, got %+v", finding.Evidence)
+		}
+		return
+	}
+	t.Fatalf("expected a %s finding, got %+v", findingServiceSelectorMismatch.ID, diagnostic.Findings)
+}
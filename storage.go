@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// defaultStorageClassAnnotation marks the StorageClass used by claims without one
+const defaultStorageClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+
+// Scheduler messages that mean a volume's topology rules out every node
+var volumeTopologyMessages = []string{
+	"volume node affinity conflict",
+	"didn't find available persistent volumes to bind",
+	"node(s) did not have enough free storage",
+}
+
+// StorageDiagnostic is the diagnose_storage result
+type StorageDiagnostic struct {
+	Pod       string          `json:"pod"`
+	Namespace string          `json:"namespace"`
+	Node      string          `json:"node,omitempty"`
+	Volumes   []VolumeReport  `json:"volumes"`
+	Events    []string        `json:"events"`
+	Findings  []Finding       `json:"findings"`
+	Summary   *FindingSummary `json:"summary,omitempty"`
+	Omitted   []string        `json:"omitted,omitempty"`
+}
+
+// VolumeReport follows one pod volume through PVC, PV and StorageClass
+type VolumeReport struct {
+	Volume           string             `json:"volume"`
+	Claim            string             `json:"claim"`
+	ClaimPhase       string             `json:"claim_phase,omitempty"`
+	AccessModes      []string           `json:"access_modes,omitempty"`
+	Requested        string             `json:"requested,omitempty"`
+	Capacity         string             `json:"capacity,omitempty"`
+	PersistentVolume string             `json:"persistent_volume,omitempty"`
+	VolumePhase      string             `json:"volume_phase,omitempty"`
+	StorageClass     string             `json:"storage_class,omitempty"`
+	Provisioner      string             `json:"provisioner,omitempty"`
+	BindingMode      string             `json:"binding_mode,omitempty"`
+	Attachments      []AttachmentReport `json:"attachments,omitempty"`
+	SharedWith       []string           `json:"shared_with,omitempty"`
+}
+
+// AttachmentReport is the state of one VolumeAttachment
+type AttachmentReport struct {
+	Name     string `json:"name"`
+	Node     string `json:"node"`
+	Attached bool   `json:"attached"`
+	Error    string `json:"error,omitempty"`
+}
+
+// diagnoseStorage walks a pod's volumes to their PVCs, PVs and StorageClasses
+// and explains why they block the pod
+func (s *K8sDiagnosticsServer) diagnoseStorage(ctx context.Context, namespace, podName string) (*StorageDiagnostic, error) {
+	if err := s.access.checkNamespace(namespace); err != nil {
+		return nil, err
+	}
+	pod, err := s.clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.access.checkObject("Pod", namespace, podName, pod.Labels); err != nil {
+		return nil, err
+	}
+	return s.podStorage(ctx, pod)
+}
+
+func (s *K8sDiagnosticsServer) podStorage(ctx context.Context, pod *corev1.Pod) (*StorageDiagnostic, error) {
+	ctx, omitted := withOmissions(ctx)
+	result := &StorageDiagnostic{
+		Pod:       pod.Name,
+		Namespace: pod.Namespace,
+		Node:      pod.Spec.NodeName,
+		Volumes:   []VolumeReport{},
+		Events:    []string{},
+		Findings:  []Finding{},
+	}
+	podRef := ObjectRef{Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name}
+
+	claims := podClaims(pod)
+	if len(claims) == 0 {
+		result.Summary = summarizeFindings(result.Findings)
+		return result, nil
+	}
+
+	classes, err := s.clientset.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := s.clientset.StorageV1().VolumeAttachments().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		// VolumeAttachments are cluster-scoped and often not readable; the rest still works
+		noteOmitted(ctx, "VolumeAttachments", err)
+		attachments = &storagev1.VolumeAttachmentList{}
+	}
+	pods, err := s.clientset.CoreV1().Pods(pod.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, volume := range sortedKeys(claims) {
+		report, findings := s.checkClaim(ctx, pod, volume, claims[volume], classes.Items, attachments.Items, s.access.filterPods(pods.Items))
+		result.Volumes = append(result.Volumes, report)
+		result.Findings = append(result.Findings, findings...)
+	}
+
+	// Mount, attach and volume scheduling errors are reported on the pod
+	for _, event := range s.recentEvents(ctx, pod.Namespace, "Pod", pod.Name) {
+		def := findingDef{}
+		switch {
+		case event.Reason == "FailedMount":
+			def = findingStorageMountFailed
+		case event.Reason == "FailedAttachVolume":
+			def = findingStorageAttachFailed
+		case event.Reason == "FailedScheduling" && containsAny(event.Message, volumeTopologyMessages):
+			def = findingStorageTopologyConflict
+		default:
+			continue
+		}
+		result.Events = append(result.Events, fmt.Sprintf("%s: %s", event.Reason, event.Message))
+		result.Findings = append(result.Findings, newFinding(def, podRef,
+			fmt.Sprintf("Pod %s/%s: %s: %s", pod.Namespace, pod.Name, event.Reason, event.Message),
+			map[string]string{"count": fmt.Sprint(event.Count)}))
+	}
+
+	sortFindings(result.Findings)
+	result.Summary = summarizeFindings(result.Findings)
+	result.Omitted = omitted.list()
+
+	return result, nil
+}
+
+func (s *K8sDiagnosticsServer) checkClaim(ctx context.Context, pod *corev1.Pod, volume, claimName string, classes []storagev1.StorageClass, attachments []storagev1.VolumeAttachment, pods []corev1.Pod) (VolumeReport, []Finding) {
+	report := VolumeReport{Volume: volume, Claim: claimName}
+	ref := ObjectRef{Kind: "PersistentVolumeClaim", Namespace: pod.Namespace, Name: claimName}
+	var findings []Finding
+	add := func(def findingDef, message string, evidence map[string]string) {
+		findings = append(findings, newFinding(def, ref, message, evidence))
+	}
+
+	pvc, err := s.clientset.CoreV1().PersistentVolumeClaims(pod.Namespace).Get(ctx, claimName, metav1.GetOptions{})
+	if err != nil {
+		// Only a claim the API server says is gone is missing; other errors
+		// say nothing about the claim
+		if apierrors.IsNotFound(err) {
+			add(findingStoragePVCMissing, fmt.Sprintf("Pod %s/%s volume %s uses PVC %s, which does not exist", pod.Namespace, pod.Name, volume, claimName), nil)
+		} else {
+			noteOmitted(ctx, fmt.Sprintf("PVC %s/%s checks", pod.Namespace, claimName), err)
+		}
+		return report, findings
+	}
+
+	report.ClaimPhase = string(pvc.Status.Phase)
+	for _, mode := range pvc.Spec.AccessModes {
+		report.AccessModes = append(report.AccessModes, string(mode))
+	}
+	if q, ok := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; ok {
+		report.Requested = q.String()
+	}
+	if q, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok {
+		report.Capacity = q.String()
+	}
+	report.PersistentVolume = pvc.Spec.VolumeName
+
+	// StorageClass: explicit, or the default for claims that don't set one
+	var class *storagev1.StorageClass
+	className := ""
+	if pvc.Spec.StorageClassName != nil {
+		className = *pvc.Spec.StorageClassName
+	}
+	for i := range classes {
+		if className != "" && classes[i].Name == className ||
+			pvc.Spec.StorageClassName == nil && classes[i].Annotations[defaultStorageClassAnnotation] == "true" {
+			class = &classes[i]
+			break
+		}
+	}
+	if class != nil {
+		report.StorageClass = class.Name
+		report.Provisioner = class.Provisioner
+		report.BindingMode = string(storagev1.VolumeBindingImmediate)
+		if class.VolumeBindingMode != nil {
+			report.BindingMode = string(*class.VolumeBindingMode)
+		}
+	} else {
+		report.StorageClass = className
+	}
+
+	if pvc.Status.Phase == corev1.ClaimPending {
+		pendingFindings := 0
+		switch {
+		case className != "" && class == nil && pvc.Spec.VolumeName == "":
+			add(findingStorageClassMissing, fmt.Sprintf("PVC %s/%s uses StorageClass %q, which does not exist: it stays Pending", pod.Namespace, claimName, className), nil)
+			pendingFindings++
+		case pvc.Spec.StorageClassName == nil && class == nil && pvc.Spec.VolumeName == "":
+			add(findingStorageClassMissing, fmt.Sprintf("PVC %s/%s has no StorageClass and the cluster has no default StorageClass: it only binds to a matching pre-created PV", pod.Namespace, claimName), nil)
+			pendingFindings++
+		}
+
+		for _, event := range s.recentEvents(ctx, pod.Namespace, "PersistentVolumeClaim", claimName) {
+			if event.Type != corev1.EventTypeWarning {
+				continue
+			}
+			add(findingStorageProvisioningFailed, fmt.Sprintf("PVC %s/%s: %s: %s", pod.Namespace, claimName, event.Reason, event.Message),
+				map[string]string{"reason": event.Reason, "provisioner": report.Provisioner})
+			pendingFindings++
+		}
+
+		waitingForConsumer := report.BindingMode == string(storagev1.VolumeBindingWaitForFirstConsumer)
+		if pendingFindings == 0 && !(waitingForConsumer && pod.Spec.NodeName == "") {
+			add(findingStoragePVCPending, fmt.Sprintf("PVC %s/%s is Pending", pod.Namespace, claimName),
+				map[string]string{"storage_class": report.StorageClass, "binding_mode": report.BindingMode})
+		}
+	}
+
+	if report.Requested != "" && report.Capacity != "" {
+		requested := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+		capacity := pvc.Status.Capacity[corev1.ResourceStorage]
+		if capacity.Cmp(requested) < 0 {
+			message := fmt.Sprintf("PVC %s/%s requests %s but has %s", pod.Namespace, claimName, report.Requested, report.Capacity)
+			for _, condition := range pvc.Status.Conditions {
+				if condition.Status == corev1.ConditionTrue {
+					message += fmt.Sprintf(" (%s)", condition.Type)
+				}
+			}
+			add(findingStorageResizePending, message+": the resize has not completed", nil)
+		}
+	}
+
+	// PersistentVolume and its attachments
+	if pvc.Spec.VolumeName != "" {
+		pv, err := s.clientset.CoreV1().PersistentVolumes().Get(ctx, pvc.Spec.VolumeName, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			add(findingStoragePVUnavailable, fmt.Sprintf("PVC %s/%s is bound to PV %s, which does not exist (claim is Lost)", pod.Namespace, claimName, pvc.Spec.VolumeName), nil)
+		case err != nil:
+			noteOmitted(ctx, "PersistentVolume "+pvc.Spec.VolumeName, err)
+		default:
+			report.VolumePhase = string(pv.Status.Phase)
+			if pv.Status.Phase == corev1.VolumeFailed {
+				add(findingStoragePVUnavailable, fmt.Sprintf("PV %s is Failed: %s", pv.Name, pv.Status.Message), nil)
+			}
+		}
+
+		for _, attachment := range attachments {
+			source := attachment.Spec.Source.PersistentVolumeName
+			if source == nil || *source != pvc.Spec.VolumeName {
+				continue
+			}
+			a := AttachmentReport{Name: attachment.Name, Node: attachment.Spec.NodeName, Attached: attachment.Status.Attached}
+			if attachment.Status.AttachError != nil {
+				a.Error = attachment.Status.AttachError.Message
+				add(findingStorageAttachFailed, fmt.Sprintf("Volume %s failed to attach to node %s: %s", pvc.Spec.VolumeName, a.Node, a.Error),
+					map[string]string{"volume_attachment": attachment.Name})
+			}
+			report.Attachments = append(report.Attachments, a)
+		}
+	}
+
+	// A ReadWriteOnce volume can only be attached to one node at a time
+	if hasAccessMode(pvc, corev1.ReadWriteOnce) || hasAccessMode(pvc, corev1.ReadWriteOncePod) {
+		var otherNodes []string
+		for _, other := range pods {
+			if other.Name == pod.Name || other.Status.Phase == corev1.PodSucceeded || other.Status.Phase == corev1.PodFailed {
+				continue
+			}
+			for _, c := range podClaims(&other) {
+				if c != claimName {
+					continue
+				}
+				report.SharedWith = append(report.SharedWith, other.Name)
+				rwoConflict := hasAccessMode(pvc, corev1.ReadWriteOncePod) ||
+					other.Spec.NodeName != "" && pod.Spec.NodeName != "" && other.Spec.NodeName != pod.Spec.NodeName
+				if rwoConflict {
+					otherNodes = append(otherNodes, fmt.Sprintf("%s on %s", other.Name, other.Spec.NodeName))
+				}
+			}
+		}
+		if len(otherNodes) > 0 {
+			add(findingStorageMultiAttach, fmt.Sprintf("PVC %s/%s is %s but is also used by %s: only one node (or pod) can mount it",
+				pod.Namespace, claimName, strings.Join(report.AccessModes, ","), strings.Join(otherNodes, ", ")),
+				map[string]string{"node": pod.Spec.NodeName})
+		}
+	}
+
+	return report, findings
+}
+
+// recentEvents lists the events of an object within the policy's event window
+func (s *K8sDiagnosticsServer) recentEvents(ctx context.Context, namespace, kind, name string) []corev1.Event {
+	events, err := s.clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fmt.Sprintf("involvedObject.kind=%s,involvedObject.name=%s", kind, name),
+	})
+	if err != nil {
+		noteOmitted(ctx, kind+" "+name+" events", err)
+		return nil
+	}
+	window := s.policy.Thresholds(namespace).EventWindow.Duration
+	var recent []corev1.Event
+	for _, event := range events.Items {
+		if event.InvolvedObject.Kind != kind || event.InvolvedObject.Name != name {
+			continue
+		}
+		last := event.LastTimestamp.Time
+		if last.IsZero() {
+			last = event.EventTime.Time
+		}
+		if time.Since(last) > window {
+			continue
+		}
+		recent = append(recent, event)
+	}
+	return recent
+}
+
+// podClaims maps pod volume names to the PVCs they use, including generic ephemeral volumes
+func podClaims(pod *corev1.Pod) map[string]string {
+	claims := map[string]string{}
+	for _, volume := range pod.Spec.Volumes {
+		switch {
+		case volume.PersistentVolumeClaim != nil:
+			claims[volume.Name] = volume.PersistentVolumeClaim.ClaimName
+		case volume.Ephemeral != nil:
+			claims[volume.Name] = pod.Name + "-" + volume.Name
+		}
+	}
+	return claims
+}
+
+// waitingOnVolumes reports whether a pod uses claims and hasn't started its containers yet
+func waitingOnVolumes(pod *corev1.Pod) bool {
+	if len(podClaims(pod)) == 0 {
+		return false
+	}
+	if pod.Status.Phase == corev1.PodPending {
+		return true
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting != nil && status.State.Waiting.Reason == "ContainerCreating" {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAccessMode(pvc *corev1.PersistentVolumeClaim, mode corev1.PersistentVolumeAccessMode) bool {
+	for _, m := range pvc.Spec.AccessModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(s string, substrings []string) bool {
+	for _, sub := range substrings {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
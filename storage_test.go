@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDiagnoseStorageClaimReadErrors(t *testing.T) {
+	pod := testPod("shop", "db-0", "10.0.0.5", nil)
+	for _, claim := range []string{"gone", "hidden"} {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{Name: claim, VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim}}})
+	}
+	clientset := fake.NewSimpleClientset(pod)
+	clientset.PrependReactor(forbidGet("persistentvolumeclaims", "hidden"))
+
+	s := &K8sDiagnosticsServer{clientset: clientset}
+	result, err := s.diagnoseStorage(context.Background(), "shop", "db-0")
+	if err != nil {
+		t.Fatalf("diagnoseStorage: %v", err)
+	}
+
+	var missing []string
+	for _, finding := range result.Findings {
+		if finding.ID == findingStoragePVCMissing.ID {
+			missing = append(missing, finding.Object.Name)
+		}
+	}
+	if len(missing) != 1 || missing[0] != "gone" {
+		t.Errorf("expected only PVC gone to be reported missing, got %v", missing)
+	}
+	if len(result.Omitted) != 1 || !strings.HasPrefix(result.Omitted[0], "PVC shop/hidden checks omitted") {
+		t.Errorf("expected an omission for PVC hidden, got %v", result.Omitted)
+	}
+}
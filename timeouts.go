@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// ToolTimeouts bounds how long each tool call may run. It is set from the
+// environment and applies over MCP and HTTP alike:
+//
+//	TOOL_TIMEOUT      default timeout of every tool (default: 30s)
+//	TOOL_TIMEOUTS     per-tool overrides as tool=duration pairs, comma separated
+//	MAX_TOOL_TIMEOUT  upper bound for the timeout_seconds argument (default: 90s)
+type ToolTimeouts struct {
+	Default time.Duration
+	Max     time.Duration
+	PerTool map[string]time.Duration
+}
+
+// timeoutArgument is added to every tool so callers can ask for a longer or shorter deadline
+var timeoutArgument = mcp.WithNumber("timeout_seconds",
+	mcp.Description("Maximum seconds the tool may run before returning what it has (default: server setting)"))
+
+func newToolTimeoutsFromEnv() (*ToolTimeouts, error) {
+	timeouts := &ToolTimeouts{PerTool: map[string]time.Duration{}}
+	var err error
+	if timeouts.Default, err = durationFromEnv("TOOL_TIMEOUT", 30*time.Second); err != nil {
+		return nil, err
+	}
+	if timeouts.Max, err = durationFromEnv("MAX_TOOL_TIMEOUT", 90*time.Second); err != nil {
+		return nil, err
+	}
+	for _, pair := range splitList(os.Getenv("TOOL_TIMEOUTS")) {
+		tool, value, found := strings.Cut(pair, "=")
+		duration, err := time.ParseDuration(strings.TrimSpace(value))
+		if !found || err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid TOOL_TIMEOUTS entry %q, want tool=duration", pair)
+		}
+		timeouts.PerTool[strings.TrimSpace(tool)] = duration
+	}
+	return timeouts, nil
+}
+
+// forTool returns a tool's timeout. A positive requested number of seconds
+// overrides the configured one, up to the maximum.
+func (t *ToolTimeouts) forTool(tool string, requestedSeconds float64) time.Duration {
+	if requestedSeconds > 0 {
+		requested := time.Duration(requestedSeconds * float64(time.Second))
+		if requested > t.Max {
+			return t.Max
+		}
+		return requested
+	}
+	if timeout, ok := t.PerTool[tool]; ok {
+		return timeout
+	}
+	return t.Default
+}
+
+// timedOut reports whether ctx ran out of time, as opposed to being cancelled by the caller
+func timedOut(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.DeadlineExceeded)
+}
+
+// markTimedOut adds "partial" and "timed_out" to the start of a JSON object,
+// keeping the rest of the document as it is
+func markTimedOut(document []byte) []byte {
+	trimmed := bytes.TrimLeft(document, " \t\r\n")
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return document
+	}
+	rest := trimmed[1:]
+	separator := ","
+	if len(bytes.TrimSpace(rest)) > 0 && bytes.TrimSpace(rest)[0] == '}' {
+		separator = ""
+	}
+	marked := `{"partial":true,"timed_out":true` + separator
+	if bytes.HasPrefix(rest, []byte("\n")) {
+		// Match json.MarshalIndent output
+		marked = "{\n  \"partial\": true,\n  \"timed_out\": true" + separator
+	}
+	return append([]byte(marked), rest...)
+}
+
+// mcpMiddleware runs each MCP tool call under its timeout. A call that
+// finishes after the deadline is marked partial; one that fails because of it
+// says so instead of returning a bare context error.
+func (t *ToolTimeouts) mcpMiddleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		timeout := t.forTool(req.Params.Name, req.GetFloat("timeout_seconds", 0))
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		result, err := next(ctx, req)
+		if err != nil || result == nil || !timedOut(ctx) {
+			return result, err
+		}
+		if result.IsError {
+			return mcp.NewToolResultError(fmt.Sprintf("%s timed out after %s (raise timeout_seconds, up to %s): %s",
+				req.Params.Name, timeout, t.Max, resultText(result))), nil
+		}
+		for i, content := range result.Content {
+			if text, ok := content.(mcp.TextContent); ok {
+				text.Text = string(markTimedOut([]byte(text.Text)))
+				result.Content[i] = text
+			}
+		}
+		return result, nil
+	}
+}
+
+// resultText joins the text content of a tool result
+func resultText(result *mcp.CallToolResult) string {
+	var texts []string
+	for _, content := range result.Content {
+		if text, ok := content.(mcp.TextContent); ok {
+			texts = append(texts, text.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// bufferedResponse holds a handler's response until its timeout is known
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(data)
+}
+
+// maxRequestBody bounds the JSON bodies the HTTP tools accept
+const maxRequestBody = 1 << 20
+
+// withTimeout runs an HTTP tool under its timeout, derived from the request
+// context so a client disconnect cancels the work. The timeout_seconds body
+// field overrides the configured timeout. A result produced after the deadline
+// is marked partial; a failure caused by it becomes 504 Gateway Timeout.
+func (s *HTTPServer) withTimeout(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tool := strings.TrimPrefix(r.URL.Path, "/")
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+		if err != nil {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		var args struct {
+			TimeoutSeconds float64 `json:"timeout_seconds"`
+		}
+		// Malformed JSON is left for the handler to report
+		_ = json.Unmarshal(body, &args)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		timeout := s.timeouts.forTool(tool, args.TimeoutSeconds)
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		response := &bufferedResponse{header: w.Header()}
+		next(response, r.WithContext(ctx))
+		if response.status == 0 {
+			response.status = http.StatusOK
+		}
+
+		switch {
+		case !timedOut(ctx):
+		case response.status >= http.StatusInternalServerError:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusGatewayTimeout)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":           strings.TrimSpace(response.body.String()),
+				"timed_out":       true,
+				"timeout_seconds": timeout.Seconds(),
+				"max_seconds":     s.timeouts.Max.Seconds(),
+			})
+			return
+		case response.status == http.StatusOK:
+			w.WriteHeader(http.StatusOK)
+			w.Write(markTimedOut(response.body.Bytes()))
+			return
+		}
+		w.WriteHeader(response.status)
+		w.Write(response.body.Bytes())
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestMarkTimedOut(t *testing.T) {
+	indented, _ := json.MarshalIndent(map[string]int{"pod_count": 2}, "", "  ")
+	tests := []struct {
+		name     string
+		document string
+		want     string
+	}{
+		{name: "empty object", document: `{}`, want: `{"partial":true,"timed_out":true}`},
+		{name: "empty object with space", document: `{ }`, want: `{"partial":true,"timed_out":true }`},
+		{name: "compact", document: `{"pod_count":2}`, want: `{"partial":true,"timed_out":true,"pod_count":2}`},
+		{name: "encoder output", document: "{\"pod_count\":2}\n", want: "{\"partial\":true,\"timed_out\":true,\"pod_count\":2}\n"},
+		{name: "indented", document: string(indented),
+			want: "{\n  \"partial\": true,\n  \"timed_out\": true,\n  \"pod_count\": 2\n}"},
+		{name: "leading whitespace", document: "\n {\"a\":1}", want: `{"partial":true,"timed_out":true,"a":1}`},
+		{name: "array", document: `[{"a":1}]`, want: `[{"a":1}]`},
+		{name: "string", document: `"done"`, want: `"done"`},
+		{name: "plain text", document: "Failed to list pods", want: "Failed to list pods"},
+		{name: "empty", document: "", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(markTimedOut([]byte(tt.document)))
+			if got != tt.want {
+				t.Errorf("markTimedOut(%q) = %q, want %q", tt.document, got, tt.want)
+			}
+			if strings.HasPrefix(tt.want, "{") {
+				var decoded map[string]interface{}
+				if err := json.Unmarshal([]byte(got), &decoded); err != nil || decoded["partial"] != true || decoded["timed_out"] != true {
+					t.Errorf("expected valid JSON marked partial, got %q (%v)", got, err)
+				}
+			}
+		})
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	s := &HTTPServer{timeouts: &ToolTimeouts{Default: 20 * time.Millisecond, Max: time.Second}}
+	// afterDeadline waits for the tool's deadline, then responds
+	afterDeadline := func(status int, body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			if status != http.StatusOK {
+				http.Error(w, body, status)
+				return
+			}
+			w.Write([]byte(body))
+		}
+	}
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantStatus int
+		wantBody   map[string]interface{}
+	}{
+		{
+			name:       "in time",
+			handler:    func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(`{"pod_count":2}`)) },
+			wantStatus: http.StatusOK,
+			wantBody:   map[string]interface{}{"pod_count": 2.0},
+		},
+		{
+			name:       "finished after the deadline",
+			handler:    afterDeadline(http.StatusOK, `{"pod_count":2}`),
+			wantStatus: http.StatusOK,
+			wantBody:   map[string]interface{}{"pod_count": 2.0, "partial": true, "timed_out": true},
+		},
+		{
+			name:       "failed because of the deadline",
+			handler:    afterDeadline(http.StatusInternalServerError, "context deadline exceeded"),
+			wantStatus: http.StatusGatewayTimeout,
+			wantBody: map[string]interface{}{"error": "context deadline exceeded", "timed_out": true,
+				"timeout_seconds": 0.02, "max_seconds": 1.0},
+		},
+		{
+			name:       "client error after the deadline",
+			handler:    afterDeadline(http.StatusBadRequest, "pod_name is required"),
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/diagnose_pod", strings.NewReader(`{}`))
+			w := httptest.NewRecorder()
+			s.withTimeout(tt.handler)(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body)
+			}
+			if tt.wantBody == nil {
+				return
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("expected JSON, got %q", w.Body)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.wantBody) {
+				t.Errorf("expected %v, got %v", tt.wantBody, got)
+			}
+		})
+	}
+}
+
+func TestWithTimeoutHonorsRequestedTimeout(t *testing.T) {
+	s := &HTTPServer{timeouts: &ToolTimeouts{Default: time.Hour, Max: time.Second}}
+	var deadline time.Duration
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		at, _ := r.Context().Deadline()
+		deadline = time.Until(at)
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("expected the handler to read the body again: %v", err)
+		}
+	}
+	r := httptest.NewRequest("POST", "/diagnose_pod", strings.NewReader(`{"timeout_seconds": 5}`))
+	s.withTimeout(handler)(httptest.NewRecorder(), r)
+	if deadline <= 0 || deadline > time.Second {
+		t.Errorf("expected the requested timeout capped at the 1s maximum, got %s", deadline)
+	}
+}
+
+func TestMCPMiddleware(t *testing.T) {
+	timeouts := &ToolTimeouts{Default: 20 * time.Millisecond, Max: time.Second}
+	call := func(handler func(ctx context.Context) *mcp.CallToolResult) *mcp.CallToolResult {
+		var req mcp.CallToolRequest
+		req.Params.Name = "list_pods"
+		result, err := timeouts.mcpMiddleware(func(ctx context.Context, _ mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return handler(ctx), nil
+		})(context.Background(), req)
+		if err != nil {
+			t.Fatalf("mcpMiddleware: %v", err)
+		}
+		return result
+	}
+
+	result := call(func(ctx context.Context) *mcp.CallToolResult {
+		return mcp.NewToolResultText("{\n  \"pod_count\": 2\n}")
+	})
+	if text := resultText(result); text != "{\n  \"pod_count\": 2\n}" {
+		t.Errorf("expected a result in time to be unchanged, got %q", text)
+	}
+
+	result = call(func(ctx context.Context) *mcp.CallToolResult {
+		<-ctx.Done()
+		return mcp.NewToolResultText("{\n  \"pod_count\": 2\n}")
+	})
+	if text := resultText(result); !strings.HasPrefix(text, "{\n  \"partial\": true,\n  \"timed_out\": true,") {
+		t.Errorf("expected a late result to be marked partial, got %q", text)
+	}
+
+	result = call(func(ctx context.Context) *mcp.CallToolResult {
+		<-ctx.Done()
+		return mcp.NewToolResultError(ctx.Err().Error())
+	})
+	if text := resultText(result); !result.IsError || !strings.Contains(text, "list_pods timed out after 20ms") {
+		t.Errorf("expected the failure to name the timeout, got %q", text)
+	}
+}